@@ -1,19 +1,23 @@
 package admin
 
 import (
-	"bytes"
-	"crypto/sha256"
 	"fmt"
+	"time"
 
-	uuid "github.com/satori/go.uuid"
 	"github.com/synapse-garden/sg-proto/auth"
 	"github.com/synapse-garden/sg-proto/store"
 
 	"github.com/boltdb/bolt"
 )
 
+// AdminBucket held the single legacy admin token and its salt.  It is
+// only read by Migrate, which moves that token into CredentialBucket.
 var AdminBucket = store.Bucket("admin")
 
+// RootName is the name of the superadmin Credential made from the
+// server's bootstrap admin key.
+const RootName = "root"
+
 type ErrNotFound auth.Token
 
 func (e ErrNotFound) Error() string {
@@ -28,44 +32,62 @@ func IsNotFound(e error) bool {
 	return ok
 }
 
+// NewToken creates or replaces the root superadmin Credential using the
+// given token.  Other Credentials are not affected.
 func NewToken(token auth.Token) func(*bolt.Tx) error {
-	salt := uuid.NewV4()
-	salted := sha256.Sum256(append(token, salt.Bytes()...))
-
-	return store.Wrap(
-		store.Put(AdminBucket, []byte("token"), salted[:]),
-		store.Put(AdminBucket, []byte("salt"), salt.Bytes()),
-	)
+	return NewCredential(&Credential{
+		Name:    RootName,
+		Role:    RoleSuperadmin,
+		Created: time.Now(),
+	}, token)
 }
 
+// CheckExists returns ErrNotFound if there are no admin Credentials.
 func CheckExists(tx *bolt.Tx) error {
-	err := store.CheckExists(AdminBucket, []byte("token"))(tx)
-	if store.IsMissing(err) {
+	var found bool
+	err := store.ForEach(CredentialBucket, func(_, _ []byte) error {
+		found = true
+		return nil
+	})(tx)
+	switch {
+	case err != nil:
+		return err
+	case !found:
 		return ErrNotFound([]byte(""))
 	}
-	return err
+	return nil
 }
 
-func CheckToken(token auth.Token) func(*bolt.Tx) error {
-	return func(tx *bolt.Tx) error {
-		var salt []byte
-		salt, err := store.Get(AdminBucket, []byte("salt"))(tx)
-		if store.IsMissing(err) {
-			return ErrNotFound(token)
-		} else if err != nil {
-			return err
-		}
-		adminToken, err := store.Get(AdminBucket, []byte("token"))(tx)
-		if store.IsMissing(err) {
-			return ErrNotFound(token)
-		} else if err != nil {
-			return err
-		}
+// Migrate moves a legacy admin token from AdminBucket into the root
+// superadmin Credential.  The token was already stored as a salted
+// hash, so it keeps working.  If there is no legacy token, Migrate does
+// nothing.
+func Migrate(tx *bolt.Tx) error {
+	b := tx.Bucket(AdminBucket)
+	if b == nil {
+		return nil
+	}
 
-		salted := sha256.Sum256(append(token, salt...))
-		if !bytes.Equal(adminToken, salted[:]) {
-			return ErrNotFound(token)
-		}
+	salt, hash := b.Get([]byte("salt")), b.Get([]byte("token"))
+	if salt == nil || hash == nil {
 		return nil
 	}
+
+	err := store.Marshal(CredentialBucket, &credential{
+		Credential: Credential{
+			Name:    RootName,
+			Role:    RoleSuperadmin,
+			Created: time.Now(),
+		},
+		Salt: append([]byte(nil), salt...),
+		Hash: append([]byte(nil), hash...),
+	}, []byte(RootName))(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := b.Delete([]byte("salt")); err != nil {
+		return err
+	}
+	return b.Delete([]byte("token"))
 }
@@ -1,10 +1,16 @@
 package admin_test
 
 import (
+	"crypto/sha256"
+	"os"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/synapse-garden/sg-proto/admin"
 	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/store"
+	sgt "github.com/synapse-garden/sg-proto/testing"
 
 	uuid "github.com/satori/go.uuid"
 	. "gopkg.in/check.v1"
@@ -23,3 +29,131 @@ func (s *AdminSuite) TestErrNotFound(c *C) {
 	c.Check(e.Error(), Equals, "no such admin token `"+tok.String()+"`")
 	c.Check(admin.IsNotFound(e), Equals, true)
 }
+
+func (s *AdminSuite) TestCredentials(c *C) {
+	db, tmpDir, err := sgt.TempDB("admin")
+	c.Assert(err, IsNil)
+	defer func() {
+		c.Assert(sgt.CleanupDB(db), IsNil)
+		c.Assert(os.Remove(tmpDir), IsNil)
+	}()
+	c.Assert(db.Update(store.SetupBuckets(
+		admin.AdminBucket,
+		admin.CredentialBucket,
+	)), IsNil)
+
+	var (
+		now     = time.Now()
+		later   = now.Add(time.Hour)
+		rootKey = auth.Token(uuid.NewV4().Bytes())
+		modKey  = auth.Token(uuid.NewV4().Bytes())
+		tempKey = auth.Token(uuid.NewV4().Bytes())
+	)
+
+	c.Log("There are no Credentials at first.")
+	c.Check(admin.IsNotFound(db.View(admin.CheckExists)), Equals, true)
+
+	c.Assert(db.Update(store.Wrap(
+		admin.NewToken(rootKey),
+		admin.NewCredential(&admin.Credential{
+			Name:    "mod",
+			Role:    admin.RoleModerator,
+			Created: now,
+		}, modKey),
+		admin.NewCredential(&admin.Credential{
+			Name:    "temp",
+			Role:    admin.RoleTreasurer,
+			Created: now,
+			Expires: &later,
+		}, tempKey),
+	)), IsNil)
+	c.Check(db.View(admin.CheckExists), IsNil)
+
+	c.Log("Unknown roles are rejected.")
+	err = db.Update(admin.NewCredential(&admin.Credential{
+		Name: "bad", Role: "janitor",
+	}, modKey))
+	c.Check(admin.IsInvalidRole(err), Equals, true)
+
+	for i, test := range []struct {
+		token  auth.Token
+		role   admin.Role
+		at     time.Time
+		expect string
+		expErr string
+	}{
+		{rootKey, admin.RoleTreasurer, now, admin.RootName, ""},
+		{rootKey, admin.RoleSuperadmin, now, admin.RootName, ""},
+		{modKey, admin.RoleModerator, now, "mod", ""},
+		{modKey, admin.RoleAny, now, "mod", ""},
+		{modKey, admin.RoleTicketIssuer, now, "",
+			"admin `mod` does not have role `ticket-issuer`"},
+		{tempKey, admin.RoleTreasurer, now, "temp", ""},
+		{tempKey, admin.RoleTreasurer, later, "",
+			regexp.QuoteMeta("no such admin token `" + tempKey.String() + "`")},
+		{auth.Token(uuid.NewV4().Bytes()), admin.RoleAny, now, "",
+			"no such admin token .*"},
+	} {
+		c.Logf("test %d: %s for %#q", i, test.expect, test.role)
+		cred := new(admin.Credential)
+		err := db.View(admin.CheckToken(test.token, test.role, test.at, cred))
+		if test.expErr != "" {
+			c.Check(err, ErrorMatches, test.expErr)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Check(cred.Name, Equals, test.expect)
+	}
+
+	c.Log("Credentials can be listed.")
+	all := admin.Credentials{}
+	c.Assert(db.View(all.GetAll), IsNil)
+	c.Assert(all, HasLen, 3)
+	c.Check(all[0].Name, Equals, "mod")
+	c.Check(all[1].Name, Equals, admin.RootName)
+	c.Check(all[2].Name, Equals, "temp")
+
+	c.Log("The last superadmin cannot be revoked.")
+	err = db.Update(admin.Revoke(admin.RootName, now))
+	c.Check(admin.IsLastSuperadmin(err), Equals, true)
+	err = db.Update(admin.Revoke("nobody", now))
+	c.Check(admin.IsCredentialMissing(err), Equals, true)
+
+	c.Log("Others can be, and then their tokens are invalid.")
+	c.Assert(db.Update(admin.Revoke("mod", now)), IsNil)
+	err = db.View(admin.CheckToken(modKey, admin.RoleAny, now, nil))
+	c.Check(admin.IsNotFound(err), Equals, true)
+}
+
+func (s *AdminSuite) TestMigrate(c *C) {
+	db, tmpDir, err := sgt.TempDB("admin")
+	c.Assert(err, IsNil)
+	defer func() {
+		c.Assert(sgt.CleanupDB(db), IsNil)
+		c.Assert(os.Remove(tmpDir), IsNil)
+	}()
+	c.Assert(db.Update(store.SetupBuckets(
+		admin.AdminBucket,
+		admin.CredentialBucket,
+	)), IsNil)
+
+	c.Log("A legacy admin token becomes the root Credential.")
+	key := auth.Token(uuid.NewV4().Bytes())
+	salt := uuid.NewV4().Bytes()
+	hash := sha256.Sum256(append(append([]byte(nil), key...), salt...))
+	c.Assert(db.Update(store.Wrap(
+		store.Put(admin.AdminBucket, []byte("token"), hash[:]),
+		store.Put(admin.AdminBucket, []byte("salt"), salt),
+	)), IsNil)
+
+	c.Assert(db.Update(admin.Migrate), IsNil)
+	cred := new(admin.Credential)
+	c.Assert(db.View(admin.CheckToken(
+		key, admin.RoleSuperadmin, time.Now(), cred,
+	)), IsNil)
+	c.Check(cred.Name, Equals, admin.RootName)
+
+	c.Check(store.IsMissing(db.View(store.CheckExists(
+		admin.AdminBucket, []byte("token"),
+	))), Equals, true)
+}
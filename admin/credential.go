@@ -0,0 +1,270 @@
+package admin
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	uuid "github.com/satori/go.uuid"
+)
+
+// CredentialBucket holds named admin Credentials by Name.
+var CredentialBucket = store.Bucket("admin-credentials")
+
+// Role is what an admin Credential is allowed to do.
+type Role string
+
+// Admin roles.  A RoleSuperadmin Credential may do anything, including
+// managing other Credentials.  RoleAny is required by routes which any
+// valid Credential may use.
+const (
+	RoleAny          Role = ""
+	RoleTicketIssuer Role = "ticket-issuer"
+	RoleModerator    Role = "moderator"
+	RoleTreasurer    Role = "treasurer"
+	RoleSuperadmin   Role = "superadmin"
+)
+
+// Roles are all the Roles a Credential may have.
+var Roles = []Role{
+	RoleTicketIssuer,
+	RoleModerator,
+	RoleTreasurer,
+	RoleSuperadmin,
+}
+
+// Valid returns true if r is one of Roles.
+func (r Role) Valid() bool {
+	for _, v := range Roles {
+		if r == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows returns true if a Credential with Role r may use a route which
+// requires the given Role.
+func (r Role) Allows(need Role) bool {
+	return need == RoleAny || r == need || r == RoleSuperadmin
+}
+
+// ErrInvalidRole is returned when a Credential is given an unknown Role.
+type ErrInvalidRole Role
+
+func (e ErrInvalidRole) Error() string {
+	return fmt.Sprintf("invalid admin role %#q", string(e))
+}
+
+// IsInvalidRole indicates whether the given error is an ErrInvalidRole.
+func IsInvalidRole(e error) bool {
+	if e == nil {
+		return false
+	}
+	_, ok := e.(ErrInvalidRole)
+	return ok
+}
+
+// ErrForbidden is returned when a Credential does not have the Role
+// needed for what it was used for.
+type ErrForbidden struct {
+	Name string
+	Need Role
+}
+
+func (e ErrForbidden) Error() string {
+	return fmt.Sprintf("admin %#q does not have role %#q",
+		e.Name, string(e.Need))
+}
+
+// IsForbidden indicates whether the given error is an ErrForbidden.
+func IsForbidden(e error) bool {
+	if e == nil {
+		return false
+	}
+	_, ok := e.(ErrForbidden)
+	return ok
+}
+
+// ErrCredentialMissing is returned when there is no Credential by the
+// given name.
+type ErrCredentialMissing string
+
+func (e ErrCredentialMissing) Error() string {
+	return fmt.Sprintf("no such admin credential %#q", string(e))
+}
+
+// IsCredentialMissing indicates whether the given error is an
+// ErrCredentialMissing.
+func IsCredentialMissing(e error) bool {
+	if e == nil {
+		return false
+	}
+	_, ok := e.(ErrCredentialMissing)
+	return ok
+}
+
+// ErrLastSuperadmin is returned when revoking a Credential would leave
+// no valid superadmin Credential to manage the others.
+type ErrLastSuperadmin string
+
+func (e ErrLastSuperadmin) Error() string {
+	return fmt.Sprintf("cannot revoke %#q: it is the last superadmin",
+		string(e))
+}
+
+// IsLastSuperadmin indicates whether the given error is an
+// ErrLastSuperadmin.
+func IsLastSuperadmin(e error) bool {
+	if e == nil {
+		return false
+	}
+	_, ok := e.(ErrLastSuperadmin)
+	return ok
+}
+
+// Credential is a named admin token with a Role.  Only a salted hash of
+// the token is stored, so it cannot be recovered after creation.  If
+// Expires is set, the Credential is not valid after that time.
+type Credential struct {
+	Name    string     `json:"name"`
+	Role    Role       `json:"role"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Resource implements store.Resourcer on Credential.
+func (Credential) Resource() store.Resource { return "admin-credentials" }
+
+// Expired returns true if the Credential has expired at the given time.
+func (c *Credential) Expired(now time.Time) bool {
+	return c.Expires != nil && !now.Before(*c.Expires)
+}
+
+// credential is a Credential as it is stored.
+type credential struct {
+	Credential
+	Salt []byte `json:"salt"`
+	Hash []byte `json:"hash"`
+}
+
+func (c *credential) matches(token auth.Token) bool {
+	return bytes.Equal(c.Hash, salted(token, c.Salt))
+}
+
+func salted(token auth.Token, salt []byte) []byte {
+	sum := sha256.Sum256(append(append([]byte(nil), token...), salt...))
+	return sum[:]
+}
+
+// NewCredential stores the given Credential with a salted hash of the
+// given token, replacing any Credential with the same Name.
+func NewCredential(c *Credential, token auth.Token) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if !c.Role.Valid() {
+			return ErrInvalidRole(c.Role)
+		}
+
+		salt := uuid.NewV4()
+		return store.Marshal(CredentialBucket, &credential{
+			Credential: *c,
+			Salt:       salt.Bytes(),
+			Hash:       salted(token, salt.Bytes()),
+		}, []byte(c.Name))(tx)
+	}
+}
+
+// CheckToken finds the Credential for the given token and checks that
+// it has not expired at the given time and allows the given Role.  An
+// unknown or expired token is ErrNotFound; a Credential without the
+// Role is ErrForbidden.  If into is not nil, the Credential is loaded
+// into it.
+func CheckToken(
+	token auth.Token,
+	need Role,
+	now time.Time,
+	into *Credential,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var found *credential
+		err := store.ForEach(CredentialBucket, func(_, v []byte) error {
+			c := new(credential)
+			if err := json.Unmarshal(v, c); err != nil {
+				return err
+			}
+			if c.matches(token) {
+				found = c
+			}
+			return nil
+		})(tx)
+		switch {
+		case err != nil:
+			return err
+		case found == nil, found.Expired(now):
+			return ErrNotFound(token)
+		case !found.Role.Allows(need):
+			return ErrForbidden{Name: found.Name, Need: need}
+		}
+
+		if into != nil {
+			*into = found.Credential
+		}
+		return nil
+	}
+}
+
+// Credentials is a slice of Credential.
+type Credentials []Credential
+
+// GetAll is a store.View which loads every Credential, without its
+// token hash.
+func (cs *Credentials) GetAll(tx *bolt.Tx) error {
+	return store.ForEach(CredentialBucket, func(_, v []byte) error {
+		c := new(credential)
+		if err := json.Unmarshal(v, c); err != nil {
+			return err
+		}
+
+		*cs = append(*cs, c.Credential)
+		return nil
+	})(tx)
+}
+
+// Revoke deletes the Credential with the given name.  It will not
+// revoke the last superadmin Credential which is valid at the given
+// time.
+func Revoke(name string, now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var all Credentials
+		if err := all.GetAll(tx); err != nil {
+			return err
+		}
+
+		var target *Credential
+		supers := 0
+		for i, c := range all {
+			if c.Name == name {
+				target = &all[i]
+			}
+			if c.Role == RoleSuperadmin && !c.Expired(now) {
+				supers++
+			}
+		}
+
+		switch {
+		case target == nil:
+			return ErrCredentialMissing(name)
+		case target.Role == RoleSuperadmin &&
+			!target.Expired(now) && supers < 2:
+			return ErrLastSuperadmin(name)
+		}
+
+		return store.Delete(CredentialBucket, []byte(name))(tx)
+	}
+}
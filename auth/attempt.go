@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+)
+
+// AttemptBucket is a bucket for failed authentication attempts.
+var AttemptBucket = store.Bucket("attempts")
+
+// Attempt limits.  A key may fail FreeAttempts times in a row before it
+// is locked out.  Each failure after that locks it for AttemptBackoff,
+// doubling with every further failure, up to MaxLockout.  Failures
+// older than AttemptWindow are forgotten.
+var (
+	FreeAttempts   = 5
+	AttemptBackoff = 2 * time.Second
+	MaxLockout     = time.Hour
+	AttemptWindow  = 24 * time.Hour
+)
+
+// AttemptKey identifies something whose failed attempts are tracked,
+// such as a user account or a client address.
+type AttemptKey string
+
+// UserAttempts returns the AttemptKey for the given user account.
+func UserAttempts(name string) AttemptKey {
+	return AttemptKey("user:" + name)
+}
+
+// AddrAttempts returns the AttemptKey for the given client address.
+func AddrAttempts(addr string) AttemptKey {
+	return AttemptKey("addr:" + addr)
+}
+
+// Attempt is a record of consecutive failed attempts for a key.
+type Attempt struct {
+	Key         AttemptKey `json:"key"`
+	Failures    int        `json:"failures"`
+	LastFailure time.Time  `json:"lastFailure"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+}
+
+// Resource implements store.Resourcer on Attempt.
+func (Attempt) Resource() store.Resource { return "attempts" }
+
+// Locked returns true if the Attempt is locked out at the given time.
+func (a *Attempt) Locked(now time.Time) bool {
+	return a.LockedUntil != nil && now.Before(*a.LockedUntil)
+}
+
+// fail records a failure at the given time and computes any lockout.
+func (a *Attempt) fail(now time.Time) {
+	if now.Sub(a.LastFailure) > AttemptWindow {
+		a.Failures = 0
+		a.LockedUntil = nil
+	}
+
+	a.Failures++
+	a.LastFailure = now
+
+	over := a.Failures - FreeAttempts
+	if over <= 0 {
+		return
+	}
+
+	wait := MaxLockout
+	if over < 32 {
+		if next := AttemptBackoff << uint(over-1); next > 0 && next < MaxLockout {
+			wait = next
+		}
+	}
+
+	until := now.Add(wait)
+	a.LockedUntil = &until
+}
+
+// ErrLockedOut is returned when a key has failed too many times and is
+// locked out until the given time.
+type ErrLockedOut struct {
+	Key   AttemptKey
+	Until time.Time
+}
+
+func (e ErrLockedOut) Error() string {
+	return fmt.Sprintf("too many failed attempts for %#q, try again after %s",
+		string(e.Key), e.Until.UTC().Format(time.RFC3339))
+}
+
+// IsLockedOut indicates whether the given error is an ErrLockedOut.
+func IsLockedOut(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := err.(ErrLockedOut)
+	return ok
+}
+
+// CheckAttempts returns ErrLockedOut for the first of the given keys
+// which is locked out at the given time.
+func CheckAttempts(now time.Time, keys ...AttemptKey) store.View {
+	return func(tx *bolt.Tx) error {
+		for _, k := range keys {
+			a := new(Attempt)
+			err := store.Unmarshal(AttemptBucket, a, []byte(k))(tx)
+			switch {
+			case store.IsMissing(err):
+				continue
+			case err != nil:
+				return err
+			case a.Locked(now):
+				return ErrLockedOut{Key: k, Until: *a.LockedUntil}
+			}
+		}
+
+		return nil
+	}
+}
+
+// FailAttempt records a failed attempt at the given time for each of
+// the given keys.  Records of other keys older than AttemptWindow, and
+// no longer locked out, are deleted.
+func FailAttempt(now time.Time, keys ...AttemptKey) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		if err := pruneAttempts(now)(tx); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			a := &Attempt{Key: k}
+			err := store.Unmarshal(AttemptBucket, a, []byte(k))(tx)
+			if err != nil && !store.IsMissing(err) {
+				return err
+			}
+
+			a.fail(now)
+			if err := store.Marshal(AttemptBucket, a, []byte(k))(tx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// BeginAttempt returns ErrLockedOut for the first of the given keys
+// which is locked out at the given time.  Otherwise, it counts a failed
+// attempt for each key in the same transaction, so concurrent attempts
+// can't all pass the check before any failure is recorded.  An attempt
+// which turns out not to have failed should be undone with
+// ForgiveAttempt.
+func BeginAttempt(now time.Time, keys ...AttemptKey) store.Mutation {
+	return store.Wrap(
+		CheckAttempts(now, keys...),
+		FailAttempt(now, keys...),
+	)
+}
+
+// ForgiveAttempt undoes one failure counted by BeginAttempt for each of
+// the given keys.  Since the attempt passed the check, the key was not
+// locked out, so any lockout it caused is lifted.
+func ForgiveAttempt(keys ...AttemptKey) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		for _, k := range keys {
+			a := new(Attempt)
+			err := store.Unmarshal(AttemptBucket, a, []byte(k))(tx)
+			switch {
+			case store.IsMissing(err):
+				continue
+			case err != nil:
+				return err
+			}
+
+			a.Failures--
+			a.LockedUntil = nil
+			if a.Failures <= 0 {
+				err = store.Delete(AttemptBucket, []byte(k))(tx)
+			} else {
+				err = store.Marshal(AttemptBucket, a, []byte(k))(tx)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// pruneAttempts deletes every Attempt whose last failure is older than
+// AttemptWindow at the given time, and which is not locked out.
+func pruneAttempts(now time.Time) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		var stale [][]byte
+		err := store.ForEach(AttemptBucket, func(k, v []byte) error {
+			a := new(Attempt)
+			if err := json.Unmarshal(v, a); err != nil {
+				return err
+			}
+			if now.Sub(a.LastFailure) > AttemptWindow && !a.Locked(now) {
+				stale = append(stale, append([]byte(nil), k...))
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range stale {
+			if err := store.Delete(AttemptBucket, k)(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ClearAttempts forgets any failed attempts for the given keys.
+func ClearAttempts(keys ...AttemptKey) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		for _, k := range keys {
+			if err := store.Delete(AttemptBucket, []byte(k))(tx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// Attempts is a slice of Attempt records.
+type Attempts []Attempt
+
+// GetAll is a store.View which loads every tracked Attempt.
+func (as *Attempts) GetAll(tx *bolt.Tx) error {
+	return store.ForEach(AttemptBucket, func(k, v []byte) error {
+		var next Attempt
+		if err := json.Unmarshal(v, &next); err != nil {
+			return err
+		}
+
+		*as = append(*as, next)
+		return nil
+	})(tx)
+}
@@ -0,0 +1,114 @@
+package auth_test
+
+import (
+	"os"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/store"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *AuthSuite) TestAttempts(c *C) {
+	db, tmpDir, err := sgt.TempDB("auth")
+	c.Assert(err, IsNil)
+	defer func() {
+		c.Assert(sgt.CleanupDB(db), IsNil)
+		c.Assert(os.Remove(tmpDir), IsNil)
+	}()
+	c.Assert(db.Update(store.SetupBuckets(auth.AttemptBucket)), IsNil)
+
+	var (
+		now  = time.Now()
+		bob  = auth.UserAttempts("bob")
+		addr = auth.AddrAttempts("10.0.0.1")
+	)
+
+	c.Log("Nothing is locked out at first.")
+	c.Check(db.View(auth.CheckAttempts(now, bob, addr)), IsNil)
+
+	c.Log("FreeAttempts failures do not lock out.")
+	for i := 0; i < auth.FreeAttempts; i++ {
+		c.Assert(db.Update(auth.FailAttempt(now, bob, addr)), IsNil)
+	}
+	c.Check(db.View(auth.CheckAttempts(now, bob, addr)), IsNil)
+
+	c.Log("The next failure locks out for AttemptBackoff.")
+	c.Assert(db.Update(auth.FailAttempt(now, bob)), IsNil)
+	err = db.View(auth.CheckAttempts(now, addr, bob))
+	c.Assert(auth.IsLockedOut(err), Equals, true)
+	c.Check(err.(auth.ErrLockedOut).Key, Equals, bob)
+	c.Check(err.(auth.ErrLockedOut).Until.Equal(
+		now.Add(auth.AttemptBackoff),
+	), Equals, true)
+	c.Check(db.View(auth.CheckAttempts(
+		now.Add(auth.AttemptBackoff), bob,
+	)), IsNil)
+
+	c.Log("Each further failure doubles the lockout.")
+	c.Assert(db.Update(auth.FailAttempt(now, bob)), IsNil)
+	err = db.View(auth.CheckAttempts(now, bob))
+	c.Assert(auth.IsLockedOut(err), Equals, true)
+	c.Check(err.(auth.ErrLockedOut).Until.Equal(
+		now.Add(2*auth.AttemptBackoff),
+	), Equals, true)
+
+	c.Log("Lockouts never exceed MaxLockout.")
+	for i := 0; i < 40; i++ {
+		c.Assert(db.Update(auth.FailAttempt(now, bob)), IsNil)
+	}
+	err = db.View(auth.CheckAttempts(now, bob))
+	c.Assert(auth.IsLockedOut(err), Equals, true)
+	c.Check(err.(auth.ErrLockedOut).Until.Equal(
+		now.Add(auth.MaxLockout),
+	), Equals, true)
+
+	c.Log("All tracked attempts can be listed.")
+	all := auth.Attempts{}
+	c.Assert(db.View(all.GetAll), IsNil)
+	c.Assert(len(all), Equals, 2)
+	c.Check(all[0].Key, Equals, addr)
+	c.Check(all[0].Failures, Equals, auth.FreeAttempts)
+	c.Check(all[1].Key, Equals, bob)
+	c.Check(all[1].Failures, Equals, auth.FreeAttempts+42)
+
+	c.Log("Failures outside AttemptWindow are forgotten.")
+	later := now.Add(auth.AttemptWindow + time.Second)
+	c.Assert(db.Update(auth.FailAttempt(later, addr)), IsNil)
+	c.Check(db.View(auth.CheckAttempts(later, addr)), IsNil)
+
+	c.Log("ClearAttempts lifts the lockout.")
+	c.Assert(db.Update(auth.ClearAttempts(bob)), IsNil)
+	c.Check(db.View(auth.CheckAttempts(now, bob)), IsNil)
+
+	c.Log("Old records are deleted when a failure is recorded.")
+	c.Assert(db.Update(auth.FailAttempt(now, bob)), IsNil)
+	much := later.Add(auth.AttemptWindow + time.Second)
+	c.Assert(db.Update(auth.FailAttempt(much, addr)), IsNil)
+	all = auth.Attempts{}
+	c.Assert(db.View(all.GetAll), IsNil)
+	c.Assert(all, HasLen, 1)
+	c.Check(all[0].Key, Equals, addr)
+	c.Check(all[0].Failures, Equals, 1)
+	c.Assert(db.Update(auth.ClearAttempts(addr)), IsNil)
+
+	c.Log("BeginAttempt counts a failure up front.")
+	for i := 0; i < auth.FreeAttempts; i++ {
+		c.Assert(db.Update(auth.BeginAttempt(now, bob)), IsNil)
+	}
+	c.Assert(db.Update(auth.BeginAttempt(now, bob)), IsNil)
+	err = db.Update(auth.BeginAttempt(now, bob))
+	c.Check(auth.IsLockedOut(err), Equals, true)
+
+	c.Log("ForgiveAttempt undoes it and lifts its lockout.")
+	c.Assert(db.Update(auth.ForgiveAttempt(bob)), IsNil)
+	c.Check(db.View(auth.CheckAttempts(now, bob)), IsNil)
+	for i := 0; i < auth.FreeAttempts; i++ {
+		c.Assert(db.Update(auth.ForgiveAttempt(bob, addr)), IsNil)
+	}
+	all = auth.Attempts{}
+	c.Assert(db.View(all.GetAll), IsNil)
+	c.Check(all, HasLen, 0)
+}
@@ -145,6 +145,7 @@ func Disable(userID string) store.Mutation {
 	all := new(tokens)
 	return store.Wrap(
 		disableLogin(userID),
+		disableExternal(userID),
 		all.Find(userID),
 		all.DeleteRefresh,
 		all.DeleteSessions,
@@ -13,6 +13,10 @@ const (
 	CtxToken CtxField = iota
 	CtxRefreshToken
 	CtxUserID
+
+	// CtxAdmin holds the name of the admin Credential which
+	// authorized a request.
+	CtxAdmin
 )
 
 type CtxField int
@@ -0,0 +1,172 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+)
+
+// ExternalBucket links identities from external Authenticators to
+// Users.  Keys are "<provider>:<subject>".
+var ExternalBucket = store.Bucket("external-logins")
+
+// Credentials are what a client POSTs to /tokens to log in using an
+// external Authenticator instead of a Login.  Which fields are used
+// depends on the Authenticator: a directory bind uses Name and
+// Password, and an authorization-code flow uses Code and State.
+type Credentials struct {
+	Provider string `json:"provider"`
+	Name     string `json:"name,omitempty"`
+	Password string `json:"password,omitempty"`
+	Code     string `json:"code,omitempty"`
+	State    string `json:"state,omitempty"`
+}
+
+// Identity is a user identity verified by an Authenticator.  Subject
+// must be stable and unique within the Provider; Name is the username
+// to provision the User with on first login.
+type Identity struct {
+	Provider string `json:"provider"`
+	Subject  string `json:"subject"`
+	Name     string `json:"name"`
+	Email    string `json:"email,omitempty"`
+}
+
+// Authenticator verifies Credentials against an external identity
+// source.  It returns ErrInvalid if the Credentials were rejected.
+type Authenticator interface {
+	// Name is the provider name clients use in Credentials.
+	Name() string
+
+	Authenticate(*Credentials) (*Identity, error)
+}
+
+// Redirecter is an Authenticator which is logged into on a third-party
+// web page.  AuthURL returns the page to send the user to, and the State
+// it will send the user back with, along with the Code to POST.
+type Redirecter interface {
+	Authenticator
+
+	AuthURL() (authURL, state string, err error)
+}
+
+// ErrUnknownProvider is returned for Credentials naming a provider
+// which is not configured.
+type ErrUnknownProvider string
+
+func (e ErrUnknownProvider) Error() string {
+	return fmt.Sprintf("unknown login provider %#q", string(e))
+}
+
+// IsUnknownProvider indicates whether the given error is an
+// ErrUnknownProvider.
+func IsUnknownProvider(e error) bool {
+	if e == nil {
+		return false
+	}
+	_, ok := e.(ErrUnknownProvider)
+	return ok
+}
+
+// External is a link from an external Identity to a User.
+type External struct {
+	Provider string    `json:"provider"`
+	Subject  string    `json:"subject"`
+	UserID   string    `json:"userID"`
+	Created  time.Time `json:"created"`
+	Disabled bool      `json:"disabled,omitempty"`
+}
+
+func externalKey(provider, subject string) []byte {
+	return []byte(provider + ":" + subject)
+}
+
+// Provision finds the User linked to the given Identity, and sets
+// userID to its name.  If there is no such User, it is created with the
+// Identity's Name, unless a User by that name already exists, in which
+// case it returns users.ErrExists rather than taking over the account.
+// A disabled link returns ErrDisabled.
+func Provision(id *Identity, now time.Time, userID *string) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		key := externalKey(id.Provider, id.Subject)
+		ext := new(External)
+		err := store.Unmarshal(ExternalBucket, ext, key)(tx)
+		switch {
+		case err == nil && ext.Disabled:
+			return ErrDisabled(ext.UserID)
+		case err == nil:
+			*userID = ext.UserID
+			return nil
+		case !store.IsMissing(err):
+			return err
+		}
+
+		u := &users.User{Name: id.Name}
+		if err := users.ValidateNew(u); err != nil {
+			return err
+		}
+
+		err = store.Wrap(
+			users.CheckNotExist(u.Name),
+			CheckLoginNotExist(&Login{User: *u}),
+			users.Create(u),
+			store.Marshal(ExternalBucket, &External{
+				Provider: id.Provider,
+				Subject:  id.Subject,
+				UserID:   u.Name,
+				Created:  now,
+			}, key),
+		)(tx)
+		if IsExists(err) {
+			return users.ErrExists(u.Name)
+		} else if err != nil {
+			return err
+		}
+
+		*userID = u.Name
+		return nil
+	}
+}
+
+// disableExternal disables every external identity linked to the given
+// User, so that it cannot be provisioned again by logging in.
+func disableExternal(userID string) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		b := tx.Bucket(ExternalBucket)
+		if b == nil {
+			return nil
+		}
+
+		var disable []*External
+		err := b.ForEach(func(_, v []byte) error {
+			ext := new(External)
+			if err := json.Unmarshal(v, ext); err != nil {
+				return err
+			}
+			if ext.UserID == userID && !ext.Disabled {
+				disable = append(disable, ext)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, ext := range disable {
+			ext.Disabled = true
+			err := store.Marshal(ExternalBucket, ext,
+				externalKey(ext.Provider, ext.Subject),
+			)(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
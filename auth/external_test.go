@@ -0,0 +1,78 @@
+package auth_test
+
+import (
+	"os"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/store"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *AuthSuite) TestProvision(c *C) {
+	db, tmpDir, err := sgt.TempDB("auth")
+	c.Assert(err, IsNil)
+	defer func() {
+		c.Assert(sgt.CleanupDB(db), IsNil)
+		c.Assert(os.Remove(tmpDir), IsNil)
+	}()
+	c.Assert(db.Update(store.SetupBuckets(
+		users.UserBucket,
+		auth.LoginBucket,
+		auth.SessionBucket,
+		auth.RefreshBucket,
+		auth.ContextBucket,
+		auth.ExternalBucket,
+	)), IsNil)
+
+	var (
+		now = time.Now()
+		bob = &auth.Identity{
+			Provider: "ldap",
+			Subject:  "uid=bob,dc=example",
+			Name:     "bob",
+		}
+		userID string
+	)
+
+	c.Log("The first login creates the User.")
+	c.Assert(db.Update(auth.Provision(bob, now, &userID)), IsNil)
+	c.Check(userID, Equals, "bob")
+	c.Check(db.View(users.CheckUsersExist("bob")), IsNil)
+
+	c.Log("Later logins find the same User.")
+	userID = ""
+	c.Assert(db.Update(auth.Provision(bob, now, &userID)), IsNil)
+	c.Check(userID, Equals, "bob")
+
+	c.Log("Another identity cannot take over an existing User.")
+	err = db.Update(auth.Provision(&auth.Identity{
+		Provider: "oidc",
+		Subject:  "1234",
+		Name:     "bob",
+	}, now, &userID))
+	c.Check(users.IsExists(err), Equals, true)
+
+	c.Assert(db.Update(auth.Create(&auth.Login{
+		User:   users.User{Name: "bodie"},
+		PWHash: sgt.Sha256("some-password"),
+	}, uuid.NewV4())), IsNil)
+	err = db.Update(auth.Provision(&auth.Identity{
+		Provider: "oidc",
+		Subject:  "5678",
+		Name:     "bodie",
+	}, now, &userID))
+	c.Check(users.IsExists(err), Equals, true)
+
+	c.Log("Once the User is disabled, the identity is too.")
+	c.Assert(db.Update(store.Wrap(
+		users.Delete("bob"),
+		auth.Disable("bob"),
+	)), IsNil)
+	err = db.Update(auth.Provision(bob, now, &userID))
+	c.Check(auth.IsDisabled(err), Equals, true)
+}
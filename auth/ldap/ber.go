@@ -0,0 +1,152 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// BER identifiers used by simple binds.
+const (
+	tagInteger    byte = 0x02
+	tagOctets     byte = 0x04
+	tagEnumerated byte = 0x0a
+	tagSequence   byte = 0x30
+
+	tagBindRequest  byte = 0x60
+	tagBindResponse byte = 0x61
+	tagUnbind       byte = 0x42
+	tagSimpleAuth   byte = 0x80
+)
+
+// maxPacket is the largest LDAP message this package will read.
+const maxPacket = 1 << 16
+
+// element is a BER-encoded value.  Constructed elements hold their
+// Children; primitive ones hold their Value.
+type element struct {
+	Tag      byte
+	Value    []byte
+	Children []*element
+}
+
+func (e *element) constructed() bool { return e.Tag&0x20 != 0 }
+
+// Int returns a primitive INTEGER or ENUMERATED element's value.
+func (e *element) Int() int64 {
+	var v int64
+	for i, b := range e.Value {
+		if i == 0 && b&0x80 != 0 {
+			v = -1
+		}
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+// Encode returns the BER encoding of the element.
+func (e *element) Encode() []byte {
+	body := e.Value
+	if e.constructed() {
+		body = nil
+		for _, c := range e.Children {
+			body = append(body, c.Encode()...)
+		}
+	}
+
+	out := []byte{e.Tag}
+	switch n := len(body); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	default:
+		var lb []byte
+		for ; n > 0; n >>= 8 {
+			lb = append([]byte{byte(n)}, lb...)
+		}
+		out = append(out, 0x80|byte(len(lb)))
+		out = append(out, lb...)
+	}
+	return append(out, body...)
+}
+
+// seq returns a constructed element with the given tag and Children.
+func seq(tag byte, children ...*element) *element {
+	return &element{Tag: tag, Children: children}
+}
+
+// integer returns an INTEGER-like element with the given tag.
+func integer(tag byte, v int64) *element {
+	var bs []byte
+	for {
+		bs = append([]byte{byte(v)}, bs...)
+		v >>= 8
+		if (v == 0 && bs[0]&0x80 == 0) || (v == -1 && bs[0]&0x80 != 0) {
+			break
+		}
+	}
+	return &element{Tag: tag, Value: bs}
+}
+
+// octets returns an OCTET STRING-like element with the given tag.
+func octets(tag byte, s string) *element {
+	return &element{Tag: tag, Value: []byte(s)}
+}
+
+// readElement reads one BER element from r.
+func readElement(r *bufio.Reader) (*element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if tag&0x1f == 0x1f {
+		return nil, errors.New("multi-byte BER tags are not supported")
+	}
+
+	lb, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	n := int(lb)
+	if lb&0x80 != 0 {
+		count := int(lb &^ 0x80)
+		if count == 0 || count > 3 {
+			return nil, errors.Errorf("unsupported BER length of %d bytes", count)
+		}
+		n = 0
+		for i := 0; i < count; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			n = n<<8 | int(b)
+		}
+	}
+	if n > maxPacket {
+		return nil, errors.Errorf("BER element too long (%d bytes)", n)
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+
+	e := &element{Tag: tag, Value: body}
+	if !e.constructed() {
+		return e, nil
+	}
+
+	br := bufio.NewReader(bytes.NewReader(body))
+	for {
+		child, err := readElement(br)
+		switch {
+		case err == io.EOF:
+			e.Value = nil
+			return e, nil
+		case err != nil:
+			return nil, err
+		}
+		e.Children = append(e.Children, child)
+	}
+}
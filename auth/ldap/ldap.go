@@ -0,0 +1,169 @@
+// Package ldap implements auth.Authenticator using a simple bind to an
+// LDAP directory.  Only the small part of LDAPv3 needed to bind is
+// implemented.
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultProvider is the provider name of an Authenticator which does
+// not set one.
+const DefaultProvider = "ldap"
+
+// DefaultTimeout is used for an Authenticator without a Timeout.
+const DefaultTimeout = 10 * time.Second
+
+// LDAP result codes.
+const (
+	resultSuccess            = 0
+	resultInvalidCredentials = 49
+)
+
+// Authenticator authenticates users by binding to an LDAP directory as
+// them.  The bind DN is made by putting the username into DNTemplate,
+// for example "uid=%s,ou=people,dc=example,dc=com".
+type Authenticator struct {
+	Provider   string
+	Addr       string
+	DNTemplate string
+
+	// TLS, if not nil, is used to connect using LDAPS.
+	TLS     *tls.Config
+	Timeout time.Duration
+}
+
+var _ = auth.Authenticator(new(Authenticator))
+
+// Name implements auth.Authenticator.Name on Authenticator.
+func (a *Authenticator) Name() string {
+	if a.Provider == "" {
+		return DefaultProvider
+	}
+	return a.Provider
+}
+
+// ValidName returns true if the given username may be used in a bind
+// DN.  Only letters, digits, '.', '_' and '-' are allowed, so that a
+// username cannot change the DN's structure.
+func ValidName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case '0' <= r && r <= '9':
+		case strings.ContainsRune("._-", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// DN returns the bind DN for the given username.
+func (a *Authenticator) DN(name string) string {
+	return fmt.Sprintf(a.DNTemplate, name)
+}
+
+// Authenticate implements auth.Authenticator.Authenticate on
+// Authenticator.  It binds to the directory using the Credentials'
+// Name and Password.  An empty Password is always rejected, since LDAP
+// treats it as an anonymous bind.
+func (a *Authenticator) Authenticate(c *auth.Credentials) (*auth.Identity, error) {
+	if !ValidName(c.Name) || c.Password == "" {
+		return nil, auth.ErrInvalid(c.Name)
+	}
+
+	timeout := a.Timeout
+	if timeout == 0 {
+		timeout = DefaultTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: timeout}
+	var (
+		conn net.Conn
+		err  error
+	)
+	if a.TLS != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", a.Addr, a.TLS)
+	} else {
+		conn, err = dialer.Dial("tcp", a.Addr)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to LDAP server")
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	dn := a.DN(c.Name)
+	err = bind(conn, dn, c.Password)
+	if _, ok := err.(auth.ErrInvalid); ok {
+		return nil, auth.ErrInvalid(c.Name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &auth.Identity{
+		Provider: a.Name(),
+		Subject:  dn,
+		Name:     c.Name,
+	}, nil
+}
+
+// bind makes a simple bind on conn, and unbinds if it worked.
+func bind(conn net.Conn, dn, password string) error {
+	req := seq(tagSequence,
+		integer(tagInteger, 1),
+		seq(tagBindRequest,
+			integer(tagInteger, 3),
+			octets(tagOctets, dn),
+			octets(tagSimpleAuth, password),
+		),
+	)
+	if _, err := conn.Write(req.Encode()); err != nil {
+		return errors.Wrap(err, "failed to send LDAP bind")
+	}
+
+	resp, err := readElement(bufio.NewReader(conn))
+	if err != nil {
+		return errors.Wrap(err, "failed to read LDAP bind response")
+	}
+
+	if resp.Tag != tagSequence || len(resp.Children) < 2 ||
+		resp.Children[1].Tag != tagBindResponse ||
+		len(resp.Children[1].Children) < 3 {
+		return errors.New("malformed LDAP bind response")
+	}
+
+	result := resp.Children[1].Children
+	switch code := result[0].Int(); code {
+	case resultSuccess:
+	case resultInvalidCredentials:
+		return auth.ErrInvalid(dn)
+	default:
+		return errors.Errorf("LDAP bind failed with result %d: %s",
+			code, result[2].Value)
+	}
+
+	unbind := seq(tagSequence,
+		integer(tagInteger, 2),
+		&element{Tag: tagUnbind},
+	)
+	// The bind already worked, so a failed unbind does not matter.
+	conn.Write(unbind.Encode())
+	return nil
+}
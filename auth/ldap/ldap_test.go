@@ -0,0 +1,87 @@
+package ldap_test
+
+import (
+	"testing"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/auth/ldap"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type LDAPSuite struct{}
+
+var _ = Suite(&LDAPSuite{})
+
+func (s *LDAPSuite) TestAuthenticate(c *C) {
+	srv, err := sgt.NewLDAPServer(map[string]string{
+		"uid=bob,ou=people,dc=example,dc=com": "hunter2",
+	})
+	c.Assert(err, IsNil)
+	defer srv.Close()
+
+	a := &ldap.Authenticator{
+		Addr:       srv.Addr(),
+		DNTemplate: "uid=%s,ou=people,dc=example,dc=com",
+	}
+	c.Check(a.Name(), Equals, ldap.DefaultProvider)
+
+	for i, test := range []struct {
+		should         string
+		name, password string
+		expectErr      string
+	}{{
+		should: "reject a wrong password",
+		name:   "bob", password: "hunter3",
+		expectErr: "invalid login for user `bob`",
+	}, {
+		should: "reject an empty password",
+		name:   "bob", password: "",
+		expectErr: "invalid login for user `bob`",
+	}, {
+		should: "reject an unknown user",
+		name:   "bodie", password: "hunter2",
+		expectErr: "invalid login for user `bodie`",
+	}, {
+		should: "reject a name which would change the DN",
+		name:   "bob,ou=people", password: "hunter2",
+		expectErr: "invalid login for user `bob,ou=people`",
+	}, {
+		should: "bind with the right password",
+		name:   "bob", password: "hunter2",
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		id, err := a.Authenticate(&auth.Credentials{
+			Provider: a.Name(),
+			Name:     test.name,
+			Password: test.password,
+		})
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+			continue
+		}
+
+		c.Assert(err, IsNil)
+		c.Check(id, DeepEquals, &auth.Identity{
+			Provider: ldap.DefaultProvider,
+			Subject:  "uid=bob,ou=people,dc=example,dc=com",
+			Name:     "bob",
+		})
+	}
+}
+
+func (s *LDAPSuite) TestAuthenticateNoServer(c *C) {
+	srv, err := sgt.NewLDAPServer(nil)
+	c.Assert(err, IsNil)
+	addr := srv.Addr()
+	c.Assert(srv.Close(), IsNil)
+
+	a := &ldap.Authenticator{Addr: addr, DNTemplate: "uid=%s"}
+	_, err = a.Authenticate(&auth.Credentials{
+		Name: "bob", Password: "hunter2",
+	})
+	c.Check(err, ErrorMatches, "failed to connect to LDAP server: .*")
+}
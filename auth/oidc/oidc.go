@@ -0,0 +1,371 @@
+// Package oidc implements auth.Authenticator using the OpenID Connect
+// authorization code flow.
+//
+// The ID token is received directly from the provider's token endpoint
+// using the client secret, so, as OpenID Connect Core 3.1.3.7 allows,
+// its issuer, audience, expiry and nonce are checked but its signature
+// is not.  The token endpoint must therefore be reached over TLS.
+package oidc
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultProvider is the provider name of an Authenticator which does
+// not set one.
+const DefaultProvider = "oidc"
+
+// Defaults for an Authenticator which does not set them.
+var (
+	DefaultNameClaim = "preferred_username"
+	DefaultStateTTL  = 10 * time.Minute
+	DefaultMaxStates = 1024
+	DefaultScopes    = []string{"openid", "profile", "email"}
+)
+
+// Authenticator logs users in with an OpenID Connect provider.  Clients
+// first GET an AuthURL and send the user there, then POST the Code and
+// State the provider redirects back with.  If AuthEndpoint and
+// TokenEndpoint are empty, they are discovered from the Issuer.
+type Authenticator struct {
+	Provider     string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+
+	AuthEndpoint  string
+	TokenEndpoint string
+
+	// NameClaim is the ID token claim used as the username.
+	NameClaim string
+	StateTTL  time.Duration
+	// MaxStates is how many logins may be pending at once.  When it is
+	// reached, the oldest pending login is forgotten.
+	MaxStates int
+	Client    *http.Client
+
+	mu      sync.Mutex
+	pending map[string]pending
+}
+
+var _ = auth.Redirecter(new(Authenticator))
+
+// pending is an authorization which has not been completed yet.
+type pending struct {
+	nonce   string
+	expires time.Time
+}
+
+// Claims are the ID token claims the Authenticator uses.
+type Claims struct {
+	Issuer   string   `json:"iss"`
+	Subject  string   `json:"sub"`
+	Audience audience `json:"aud"`
+	Expires  int64    `json:"exp"`
+	Nonce    string   `json:"nonce"`
+	Email    string   `json:"email,omitempty"`
+}
+
+// audience is a JWT "aud" claim, which may be a string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(bs []byte) error {
+	var one string
+	if err := json.Unmarshal(bs, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(bs, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (a audience) contains(id string) bool {
+	for _, v := range a {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Name implements auth.Authenticator.Name on Authenticator.
+func (a *Authenticator) Name() string {
+	if a.Provider == "" {
+		return DefaultProvider
+	}
+	return a.Provider
+}
+
+func (a *Authenticator) client() *http.Client {
+	if a.Client == nil {
+		return http.DefaultClient
+	}
+	return a.Client
+}
+
+// discover fills in any missing endpoints from the Issuer's discovery
+// document.
+func (a *Authenticator) discover() error {
+	a.mu.Lock()
+	done := a.AuthEndpoint != "" && a.TokenEndpoint != ""
+	a.mu.Unlock()
+	if done {
+		return nil
+	}
+
+	resp, err := a.client().Get(strings.TrimSuffix(a.Issuer, "/") +
+		"/.well-known/openid-configuration")
+	if err != nil {
+		return errors.Wrap(err, "failed to get OpenID configuration")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("failed to get OpenID configuration: %s",
+			resp.Status)
+	}
+
+	var conf struct {
+		Issuer        string `json:"issuer"`
+		AuthEndpoint  string `json:"authorization_endpoint"`
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&conf); err != nil {
+		return errors.Wrap(err, "failed to decode OpenID configuration")
+	}
+	if conf.Issuer != a.Issuer {
+		return errors.Errorf("OpenID configuration is for issuer %#q, "+
+			"expected %#q", conf.Issuer, a.Issuer)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.AuthEndpoint == "" {
+		a.AuthEndpoint = conf.AuthEndpoint
+	}
+	if a.TokenEndpoint == "" {
+		a.TokenEndpoint = conf.TokenEndpoint
+	}
+	return nil
+}
+
+func random() (string, error) {
+	bs := make([]byte, 24)
+	if _, err := rand.Read(bs); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bs), nil
+}
+
+// AuthURL implements auth.Redirecter.AuthURL on Authenticator.  It
+// returns the provider's authorization URL with a new state and nonce,
+// which are valid for StateTTL.
+func (a *Authenticator) AuthURL() (string, string, error) {
+	if err := a.discover(); err != nil {
+		return "", "", err
+	}
+
+	state, err := random()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := random()
+	if err != nil {
+		return "", "", err
+	}
+
+	ttl := a.StateTTL
+	if ttl == 0 {
+		ttl = DefaultStateTTL
+	}
+	max := a.MaxStates
+	if max <= 0 {
+		max = DefaultMaxStates
+	}
+
+	now := time.Now()
+	a.mu.Lock()
+	if a.pending == nil {
+		a.pending = make(map[string]pending)
+	}
+	for s, p := range a.pending {
+		if now.After(p.expires) {
+			delete(a.pending, s)
+		}
+	}
+	for len(a.pending) >= max {
+		a.dropOldest()
+	}
+	a.pending[state] = pending{nonce: nonce, expires: now.Add(ttl)}
+	endpoint := a.AuthEndpoint
+	a.mu.Unlock()
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", errors.Wrap(err, "invalid authorization endpoint")
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", a.ClientID)
+	q.Set("redirect_uri", a.RedirectURL)
+	q.Set("scope", strings.Join(DefaultScopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	u.RawQuery = q.Encode()
+	return u.String(), state, nil
+}
+
+// dropOldest forgets the pending authorization which expires first.
+// The caller must hold a.mu.
+func (a *Authenticator) dropOldest() {
+	var (
+		oldest string
+		first  time.Time
+	)
+	for s, p := range a.pending {
+		if first.IsZero() || p.expires.Before(first) {
+			oldest, first = s, p.expires
+		}
+	}
+	delete(a.pending, oldest)
+}
+
+// take removes and returns the pending authorization for the state.
+func (a *Authenticator) take(state string) (pending, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	p, ok := a.pending[state]
+	delete(a.pending, state)
+	if !ok || time.Now().After(p.expires) {
+		return pending{}, false
+	}
+	return p, true
+}
+
+// Authenticate implements auth.Authenticator.Authenticate on
+// Authenticator.  It exchanges the Credentials' Code for an ID token,
+// which must match the State's nonce.
+func (a *Authenticator) Authenticate(c *auth.Credentials) (*auth.Identity, error) {
+	if c.Code == "" || c.State == "" {
+		return nil, auth.ErrInvalid(c.Name)
+	}
+
+	p, ok := a.take(c.State)
+	if !ok {
+		return nil, auth.ErrInvalid(c.Name)
+	}
+
+	if err := a.discover(); err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {c.Code},
+		"redirect_uri": {a.RedirectURL},
+	}
+	req, err := http.NewRequest("POST", a.TokenEndpoint,
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.ClientID), url.QueryEscape(a.ClientSecret))
+
+	resp, err := a.client().Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to exchange authorization code")
+	}
+	defer resp.Body.Close()
+
+	var tok struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, errors.Wrap(err, "failed to decode token response")
+	}
+	switch {
+	case tok.Error == "invalid_grant":
+		return nil, auth.ErrInvalid(c.Name)
+	case resp.StatusCode != http.StatusOK:
+		return nil, errors.Errorf("token endpoint returned %s: %s",
+			resp.Status, tok.Error)
+	}
+
+	claims, name, err := a.parse(tok.IDToken)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case claims.Issuer != a.Issuer:
+		return nil, errors.Errorf("ID token has wrong issuer %#q",
+			claims.Issuer)
+	case !claims.Audience.contains(a.ClientID):
+		return nil, errors.New("ID token is not for this client")
+	case time.Now().Unix() >= claims.Expires:
+		return nil, errors.New("ID token expired")
+	case claims.Nonce != p.nonce:
+		return nil, errors.New("ID token has wrong nonce")
+	case claims.Subject == "":
+		return nil, errors.New("ID token has no subject")
+	}
+
+	return &auth.Identity{
+		Provider: a.Name(),
+		Subject:  claims.Subject,
+		Name:     name,
+		Email:    claims.Email,
+	}, nil
+}
+
+// parse decodes the claims of the given JWT, and the username claim.
+func (a *Authenticator) parse(jwt string) (*Claims, string, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, "", errors.New("malformed ID token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, "", errors.Wrap(err, "malformed ID token")
+	}
+
+	claims := new(Claims)
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, "", errors.Wrap(err, "malformed ID token claims")
+	}
+
+	all := make(map[string]interface{})
+	if err := json.Unmarshal(payload, &all); err != nil {
+		return nil, "", errors.Wrap(err, "malformed ID token claims")
+	}
+
+	nameClaim := a.NameClaim
+	if nameClaim == "" {
+		nameClaim = DefaultNameClaim
+	}
+	name, _ := all[nameClaim].(string)
+	if name == "" {
+		return nil, "", errors.Errorf("ID token has no %#q claim",
+			nameClaim)
+	}
+
+	return claims, name, nil
+}
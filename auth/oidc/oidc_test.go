@@ -0,0 +1,153 @@
+package oidc_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/auth/oidc"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type OIDCSuite struct{}
+
+var _ = Suite(&OIDCSuite{})
+
+func (s *OIDCSuite) TestAuthenticate(c *C) {
+	p := sgt.NewOIDCProvider("sg", "secret")
+	defer p.Close()
+
+	a := &oidc.Authenticator{
+		Issuer:       p.Issuer(),
+		ClientID:     "sg",
+		ClientSecret: "secret",
+		RedirectURL:  "https://sg.example.com/login",
+	}
+	c.Check(a.Name(), Equals, oidc.DefaultProvider)
+
+	login := func(claims map[string]interface{}) (*auth.Credentials, error) {
+		u, state, err := a.AuthURL()
+		if err != nil {
+			return nil, err
+		}
+		code, sent, err := p.Authorize(u, "subject-1", claims)
+		switch {
+		case err != nil:
+			return nil, err
+		case sent != state:
+			return nil, fmt.Errorf("provider sent state %#q, "+
+				"expected %#q", sent, state)
+		}
+		return &auth.Credentials{
+			Provider: a.Name(),
+			Code:     code,
+			State:    state,
+		}, nil
+	}
+
+	c.Log("A user can log in.")
+	creds, err := login(map[string]interface{}{
+		"preferred_username": "bob",
+		"email":              "bob@example.com",
+	})
+	c.Assert(err, IsNil)
+	id, err := a.Authenticate(creds)
+	c.Assert(err, IsNil)
+	c.Check(id, DeepEquals, &auth.Identity{
+		Provider: oidc.DefaultProvider,
+		Subject:  "subject-1",
+		Name:     "bob",
+		Email:    "bob@example.com",
+	})
+
+	c.Log("The same code and state cannot be used twice.")
+	_, err = a.Authenticate(creds)
+	c.Check(err, ErrorMatches, "invalid login for user ``")
+
+	c.Log("An unknown state is rejected.")
+	creds, err = login(map[string]interface{}{"preferred_username": "bob"})
+	c.Assert(err, IsNil)
+	creds.State = "something-else"
+	_, err = a.Authenticate(creds)
+	c.Check(err, ErrorMatches, "invalid login for user ``")
+
+	c.Log("A code the provider doesn't know is rejected.")
+	creds, err = login(map[string]interface{}{"preferred_username": "bob"})
+	c.Assert(err, IsNil)
+	creds.Code = "bogus"
+	_, err = a.Authenticate(creds)
+	c.Check(err, ErrorMatches, "invalid login for user ``")
+
+	c.Log("An ID token with the wrong nonce is rejected.")
+	creds, err = login(map[string]interface{}{"preferred_username": "bob"})
+	c.Assert(err, IsNil)
+	other, err := login(map[string]interface{}{"preferred_username": "bob"})
+	c.Assert(err, IsNil)
+	creds.State = other.State
+	_, err = a.Authenticate(creds)
+	c.Check(err, ErrorMatches, "ID token has wrong nonce")
+
+	c.Log("An ID token without a username is rejected.")
+	creds, err = login(nil)
+	c.Assert(err, IsNil)
+	_, err = a.Authenticate(creds)
+	c.Check(err, ErrorMatches, "ID token has no `preferred_username` claim")
+
+	c.Log("An ID token for another audience is rejected.")
+	creds, err = login(map[string]interface{}{
+		"preferred_username": "bob",
+		"aud":                []string{"someone-else"},
+	})
+	c.Assert(err, IsNil)
+	_, err = a.Authenticate(creds)
+	c.Check(err, ErrorMatches, "ID token is not for this client")
+
+	c.Log("A wrong client secret is an error, not a bad login.")
+	a.ClientSecret = "wrong"
+	creds, err = login(map[string]interface{}{"preferred_username": "bob"})
+	c.Assert(err, IsNil)
+	_, err = a.Authenticate(creds)
+	c.Check(err, ErrorMatches, "token endpoint returned 401 .*: invalid_client")
+}
+
+func (s *OIDCSuite) TestAuthURLMaxStates(c *C) {
+	p := sgt.NewOIDCProvider("sg", "secret")
+	defer p.Close()
+
+	a := &oidc.Authenticator{
+		Issuer:       p.Issuer(),
+		ClientID:     "sg",
+		ClientSecret: "secret",
+		RedirectURL:  "https://sg.example.com/login",
+		MaxStates:    2,
+	}
+
+	var creds []*auth.Credentials
+	for i := 0; i < 3; i++ {
+		u, _, err := a.AuthURL()
+		c.Assert(err, IsNil)
+		code, state, err := p.Authorize(u, "subject-1",
+			map[string]interface{}{"preferred_username": "bob"},
+		)
+		c.Assert(err, IsNil)
+		creds = append(creds, &auth.Credentials{
+			Provider: a.Name(),
+			Code:     code,
+			State:    state,
+		})
+	}
+
+	c.Log("The oldest pending login was forgotten.")
+	_, err := a.Authenticate(creds[0])
+	c.Check(err, ErrorMatches, "invalid login for user ``")
+
+	c.Log("The newer ones still work.")
+	for _, cr := range creds[1:] {
+		_, err = a.Authenticate(cr)
+		c.Check(err, IsNil)
+	}
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ResetBucket holds pending password Resets by the SHA-256 hash of
+// their token, so a leaked database can't be used to reset passwords.
+var ResetBucket = store.Bucket("password-resets")
+
+// ResetExpiration is how long a Reset may be used for.
+var ResetExpiration = time.Hour
+
+// Reset is a pending password reset for a user.
+type Reset struct {
+	UserID  string    `json:"userID"`
+	Expires time.Time `json:"expires"`
+}
+
+// ErrResetMissing is returned when a reset token does not exist or has
+// expired.
+type ErrResetMissing string
+
+func (e ErrResetMissing) Error() string {
+	return fmt.Sprintf("no such password reset %#q", string(e))
+}
+
+// IsResetMissing returns true if the error is an ErrResetMissing.
+func IsResetMissing(err error) bool {
+	_, ok := err.(ErrResetMissing)
+	return ok
+}
+
+func resetKey(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// NewReset returns a function which stores a Reset for the given user
+// which expires ResetExpiration after now, and sets token to its new
+// token.  The user must have a Login which is not disabled.
+func NewReset(userID string, now time.Time, token *string) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		l := new(Login)
+		err := store.Unmarshal(LoginBucket, l, []byte(userID))(tx)
+		switch {
+		case store.IsMissing(err):
+			return ErrMissing(userID)
+		case err != nil:
+			return err
+		case l.Disabled:
+			return ErrDisabled(userID)
+		}
+
+		if err := clearResets(now)(tx); err != nil {
+			return err
+		}
+
+		tok := uuid.NewV4().String()
+		if err := store.Marshal(ResetBucket, &Reset{
+			UserID:  userID,
+			Expires: now.Add(ResetExpiration),
+		}, resetKey(tok))(tx); err != nil {
+			return err
+		}
+		*token = tok
+		return nil
+	}
+}
+
+// UseReset returns a function which sets the password of the user the
+// given reset token is for, and deletes the token and all of the user's
+// sessions.  The Reset is loaded into r.  If the token does not exist
+// or has expired, it returns ErrResetMissing.
+func UseReset(
+	token string,
+	pwhash []byte,
+	now time.Time,
+	r *Reset,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		key := resetKey(token)
+		err := store.Unmarshal(ResetBucket, r, key)(tx)
+		switch {
+		case store.IsMissing(err):
+			return ErrResetMissing(token)
+		case err != nil:
+			return err
+		case !now.Before(r.Expires):
+			return ErrResetMissing(token)
+		}
+
+		l := new(Login)
+		err = store.Unmarshal(LoginBucket, l, []byte(r.UserID))(tx)
+		switch {
+		case store.IsMissing(err):
+			return ErrMissing(r.UserID)
+		case err != nil:
+			return err
+		case l.Disabled:
+			return ErrDisabled(r.UserID)
+		}
+
+		all := new(tokens)
+		return store.Wrap(
+			Create(&Login{User: l.User, PWHash: pwhash}, uuid.NewV4()),
+			store.Delete(ResetBucket, key),
+			all.Find(r.UserID),
+			all.DeleteRefresh,
+			all.DeleteSessions,
+			all.DeleteContexts,
+		)(tx)
+	}
+}
+
+// clearResets deletes expired Resets.
+func clearResets(now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var expired [][]byte
+		err := store.ForEach(ResetBucket, func(k, v []byte) error {
+			var r Reset
+			if err := json.Unmarshal(v, &r); err != nil {
+				return err
+			}
+			if !now.Before(r.Expires) {
+				expired = append(expired, k)
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket(ResetBucket)
+		for _, k := range expired {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
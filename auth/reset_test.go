@@ -0,0 +1,96 @@
+package auth_test
+
+import (
+	"os"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/store"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *AuthSuite) TestReset(c *C) {
+	db, tmpDir, err := sgt.TempDB("auth")
+	c.Assert(err, IsNil)
+	defer func() {
+		c.Assert(sgt.CleanupDB(db), IsNil)
+		c.Assert(os.Remove(tmpDir), IsNil)
+	}()
+	c.Assert(db.Update(store.SetupBuckets(
+		users.UserBucket,
+		auth.LoginBucket,
+		auth.SessionBucket,
+		auth.RefreshBucket,
+		auth.ContextBucket,
+		auth.ResetBucket,
+	)), IsNil)
+
+	now := time.Now()
+	c.Assert(db.Update(auth.Create(&auth.Login{
+		User:   users.User{Name: "bob"},
+		PWHash: sgt.Sha256("old"),
+	}, uuid.NewV4())), IsNil)
+
+	sesh := new(auth.Session)
+	c.Assert(sgt.GetSession("bob", sesh, db), IsNil)
+
+	c.Log("Only users with a Login can reset their password.")
+	var token string
+	err = db.Update(auth.NewReset("alice", now, &token))
+	c.Check(err, FitsTypeOf, auth.ErrMissing(""))
+	c.Check(token, Equals, "")
+
+	c.Assert(db.Update(auth.NewReset("bob", now, &token)), IsNil)
+	c.Check(token, Not(Equals), "")
+
+	c.Log("An unknown or expired token can't be used.")
+	r := new(auth.Reset)
+	err = db.Update(auth.UseReset("bogus", sgt.Sha256("new"), now, r))
+	c.Check(auth.IsResetMissing(err), Equals, true)
+	err = db.Update(auth.UseReset(
+		token, sgt.Sha256("new"),
+		now.Add(auth.ResetExpiration), r,
+	))
+	c.Check(auth.IsResetMissing(err), Equals, true)
+
+	c.Log("Using a token sets the password and ends all sessions.")
+	c.Assert(db.Update(auth.UseReset(
+		token, sgt.Sha256("new"),
+		now.Add(time.Minute), r,
+	)), IsNil)
+	c.Check(r.UserID, Equals, "bob")
+	c.Check(db.View(auth.Check(&auth.Login{
+		User:   users.User{Name: "bob"},
+		PWHash: sgt.Sha256("new"),
+	})), IsNil)
+	err = db.View(auth.Check(&auth.Login{
+		User:   users.User{Name: "bob"},
+		PWHash: sgt.Sha256("old"),
+	}))
+	c.Check(err, FitsTypeOf, auth.ErrInvalid(""))
+	err = db.View(auth.CheckToken(sesh.Token))
+	c.Check(err, FitsTypeOf, auth.ErrMissingSession(nil))
+
+	c.Log("A token can only be used once.")
+	err = db.Update(auth.UseReset(
+		token, sgt.Sha256("newer"),
+		now.Add(time.Minute), r,
+	))
+	c.Check(auth.IsResetMissing(err), Equals, true)
+
+	c.Log("Expired resets are swept when a new one is made.")
+	c.Assert(db.Update(auth.NewReset("bob", now, &token)), IsNil)
+	c.Assert(db.Update(auth.NewReset(
+		"bob", now.Add(2*auth.ResetExpiration), &token,
+	)), IsNil)
+	c.Check(db.View(func(tx *bolt.Tx) error {
+		keys, _, err := sgt.FindAll(tx, auth.ResetBucket)
+		c.Check(len(keys), Equals, 1)
+		return err
+	}), IsNil)
+}
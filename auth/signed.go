@@ -0,0 +1,476 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Buckets for signed sessions.  SigningKeyBucket holds the keys used to
+// sign stateless Bearer tokens, and RevokedBucket holds the IDs of
+// signed sessions which were logged out before they expired.
+var (
+	SigningKeyBucket = store.Bucket("signing-keys")
+	RevokedBucket    = store.Bucket("revoked")
+)
+
+// KeyRotation is how long a signing key is used to sign new tokens
+// before a new one replaces it.  Old keys are kept for verification
+// until every token they signed has expired.
+var KeyRotation = 24 * time.Hour
+
+// UserScope is the scope granted to a Bearer token for a normal login.
+const UserScope = "user"
+
+var signedPrefix = []byte("sg1.")
+
+// SigningKey is a secret used to sign and verify stateless tokens.
+type SigningKey struct {
+	ID      uint64     `json:"id"`
+	Secret  []byte     `json:"secret"`
+	Created time.Time  `json:"created"`
+	Retired *time.Time `json:"retired,omitempty"`
+}
+
+// Claims are the contents of a signed Bearer token.  SessionID is kept
+// across refreshes, so revoking it logs out the whole session.
+type Claims struct {
+	SessionID string    `json:"sid"`
+	UserID    string    `json:"sub"`
+	Expires   time.Time `json:"exp"`
+	Scopes    []string  `json:"scopes,omitempty"`
+}
+
+// HasScope returns true if the Claims grant the given scope.
+func (c *Claims) HasScope(scope string) bool {
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshClaims is stored in RefreshBucket for a signed session, so the
+// refresh token can be exchanged for a new signed token.
+type refreshClaims struct {
+	SessionID string `json:"sid"`
+	UserID    string `json:"sub"`
+}
+
+// IsSigned returns true if the given Token is a signed token rather
+// than a stored session token.
+func IsSigned(t Token) bool {
+	return len(t) > len(uuid.Nil) && bytes.HasPrefix(t, signedPrefix)
+}
+
+// KeyRing signs and verifies stateless tokens.  It keeps its signing
+// keys and revoked session IDs in memory, so verification does not
+// touch the database.  Load it from the database before use.
+type KeyRing struct {
+	mu      sync.RWMutex
+	keys    map[uint64]SigningKey
+	current SigningKey
+	revoked map[string]time.Time
+}
+
+// NewKeyRing returns an empty KeyRing.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{
+		keys:    make(map[uint64]SigningKey),
+		revoked: make(map[string]time.Time),
+	}
+}
+
+// Load is a store.View which loads the stored signing keys and revoked
+// sessions into the KeyRing.
+func (k *KeyRing) Load(tx *bolt.Tx) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	err := store.ForEach(SigningKeyBucket, func(_, v []byte) error {
+		var key SigningKey
+		if err := json.Unmarshal(v, &key); err != nil {
+			return err
+		}
+		k.keys[key.ID] = key
+		if key.Retired == nil {
+			k.current = key
+		}
+		return nil
+	})(tx)
+	if err != nil {
+		return err
+	}
+
+	return store.ForEach(RevokedBucket, func(sid, v []byte) error {
+		var until time.Time
+		if err := json.Unmarshal(v, &until); err != nil {
+			return err
+		}
+		k.revoked[string(sid)] = until
+		return nil
+	})(tx)
+}
+
+// Rotate returns a store.Mutation which makes a new current signing key
+// if the current one is older than KeyRotation, and purges keys and
+// revocations which can no longer apply to any unexpired token.  It
+// works from the stored keys, and the KeyRing only changes once the
+// transaction commits, so it never signs with a key which was not
+// stored.
+func (k *KeyRing) Rotate(now time.Time) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		var (
+			stored  []SigningKey
+			current *SigningKey
+		)
+		err := store.ForEach(SigningKeyBucket, func(_, v []byte) error {
+			var key SigningKey
+			if err := json.Unmarshal(v, &key); err != nil {
+				return err
+			}
+			stored = append(stored, key)
+			if key.Retired == nil {
+				current = &stored[len(stored)-1]
+			}
+			return nil
+		})(tx)
+		switch {
+		case err != nil:
+			return err
+		case current != nil && now.Sub(current.Created) < KeyRotation:
+			return nil
+		}
+
+		secret := make([]byte, sha256.Size)
+		if _, err := rand.Read(secret); err != nil {
+			return errors.Wrap(err, "failed to make signing key")
+		}
+
+		b := tx.Bucket(SigningKeyBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		key := SigningKey{ID: id, Secret: secret, Created: now}
+		if err := store.Marshal(SigningKeyBucket, key, store.Itob(int(id)))(tx); err != nil {
+			return err
+		}
+
+		// Keys which stopped signing before the oldest live token
+		// was issued are no longer needed.
+		var (
+			retired []SigningKey
+			purged  []uint64
+		)
+		for _, old := range stored {
+			switch {
+			case old.Retired == nil:
+				old.Retired = &now
+				err := store.Marshal(SigningKeyBucket, old, store.Itob(int(old.ID)))(tx)
+				if err != nil {
+					return err
+				}
+				retired = append(retired, old)
+			case now.Sub(*old.Retired) > Expiration:
+				if err := b.Delete(store.Itob(int(old.ID))); err != nil {
+					return err
+				}
+				purged = append(purged, old.ID)
+			}
+		}
+
+		var expired [][]byte
+		err = store.ForEach(RevokedBucket, func(sid, v []byte) error {
+			var until time.Time
+			if err := json.Unmarshal(v, &until); err != nil {
+				return err
+			}
+			if until.Before(now) {
+				expired = append(expired, append([]byte(nil), sid...))
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+		rb := tx.Bucket(RevokedBucket)
+		for _, sid := range expired {
+			if err := rb.Delete(sid); err != nil {
+				return err
+			}
+		}
+
+		tx.OnCommit(func() {
+			k.mu.Lock()
+			defer k.mu.Unlock()
+
+			for _, old := range retired {
+				k.keys[old.ID] = old
+			}
+			for _, oldID := range purged {
+				delete(k.keys, oldID)
+			}
+			for _, sid := range expired {
+				if until, ok := k.revoked[string(sid)]; ok && until.Before(now) {
+					delete(k.revoked, string(sid))
+				}
+			}
+			k.keys[id] = key
+			k.current = key
+		})
+		return nil
+	}
+}
+
+// Sign returns a signed Token for the given Claims using the current
+// signing key.
+func (k *KeyRing) Sign(c *Claims) (Token, error) {
+	k.mu.RLock()
+	key := k.current
+	k.mu.RUnlock()
+
+	if len(key.Secret) == 0 {
+		return nil, errors.New("no signing key available")
+	}
+
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := base64.RawURLEncoding
+	body := append([]byte(nil), signedPrefix...)
+	body = strconv.AppendUint(body, key.ID, 10)
+	body = append(body, '.')
+	body = append(body, enc.EncodeToString(payload)...)
+
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write(body)
+
+	body = append(body, '.')
+	return Token(append(body, enc.EncodeToString(mac.Sum(nil))...)), nil
+}
+
+// Verify checks the given signed Token's signature, expiry and
+// revocation, and returns its Claims.  An invalid or revoked token is
+// ErrMissingSession; an expired one is ErrTokenExpired.
+func (k *KeyRing) Verify(t Token, now time.Time) (*Claims, error) {
+	if !IsSigned(t) {
+		return nil, ErrMissingSession(t)
+	}
+
+	fields := bytes.Split(t[len(signedPrefix):], []byte("."))
+	if len(fields) != 3 {
+		return nil, ErrMissingSession(t)
+	}
+
+	id, err := strconv.ParseUint(string(fields[0]), 10, 64)
+	if err != nil {
+		return nil, ErrMissingSession(t)
+	}
+
+	enc := base64.RawURLEncoding
+	sig, err := enc.DecodeString(string(fields[2]))
+	if err != nil {
+		return nil, ErrMissingSession(t)
+	}
+
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, ErrMissingSession(t)
+	}
+
+	mac := hmac.New(sha256.New, key.Secret)
+	mac.Write(t[:len(t)-len(fields[2])-1])
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrMissingSession(t)
+	}
+
+	payload, err := enc.DecodeString(string(fields[1]))
+	if err != nil {
+		return nil, ErrMissingSession(t)
+	}
+
+	c := new(Claims)
+	if err := json.Unmarshal(payload, c); err != nil {
+		return nil, ErrMissingSession(t)
+	}
+
+	switch {
+	case now.Before(k.revoked[c.SessionID]):
+		return nil, ErrMissingSession(t)
+	case c.Expires.Before(now):
+		return c, ErrTokenExpired(t)
+	}
+
+	return c, nil
+}
+
+// Revoke returns a store.Mutation which revokes the given signed
+// session until the given time, after which none of its tokens can be
+// valid anyway.  It also deletes the session's refresh tokens.  The
+// KeyRing rejects the session once the transaction commits.
+func (k *KeyRing) Revoke(sessionID string, until time.Time) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		err := store.Marshal(RevokedBucket, until, []byte(sessionID))(tx)
+		if err != nil {
+			return err
+		}
+
+		var stale [][]byte
+		err = store.ForEach(RefreshBucket, func(r, v []byte) error {
+			var rc refreshClaims
+			if len(v) > 0 && json.Unmarshal(v, &rc) == nil &&
+				rc.SessionID == sessionID {
+				stale = append(stale, r)
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		b := tx.Bucket(RefreshBucket)
+		for _, r := range stale {
+			if err := b.Delete(r); err != nil {
+				return err
+			}
+		}
+
+		tx.OnCommit(func() {
+			k.mu.Lock()
+			k.revoked[sessionID] = until
+			k.mu.Unlock()
+		})
+		return nil
+	}
+}
+
+// RevokeUser returns a store.Mutation which revokes every signed
+// session belonging to the given user.
+func (k *KeyRing) RevokeUser(userID string, until time.Time) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		var sids []string
+		err := store.ForEach(RefreshBucket, func(_, v []byte) error {
+			var rc refreshClaims
+			if len(v) > 0 && json.Unmarshal(v, &rc) == nil &&
+				rc.UserID == userID {
+				sids = append(sids, rc.SessionID)
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, sid := range sids {
+			if err := k.Revoke(sid, until)(tx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// NewSignedSession prepares the given Session with a new signed Bearer
+// token for the given user, and stores its refresh token.  The Bearer
+// token itself is not stored.
+func NewSignedSession(
+	k *KeyRing,
+	s *Session,
+	expiration time.Time,
+	validFor time.Duration,
+	refresh Token,
+	userID string,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		sid := uuid.NewV4().String()
+		token, err := k.Sign(&Claims{
+			SessionID: sid,
+			UserID:    userID,
+			Expires:   expiration,
+			Scopes:    []string{UserScope},
+		})
+		if err != nil {
+			return err
+		}
+
+		err = store.Marshal(RefreshBucket, &refreshClaims{
+			SessionID: sid,
+			UserID:    userID,
+		}, refresh)(tx)
+		if err != nil {
+			return err
+		}
+
+		s.Token, s.RefreshToken = token, refresh
+		s.TokenType = BearerType
+		s.Expiration, s.ExpiresIn = expiration, validFor
+		return nil
+	}
+}
+
+// RefreshSigned exchanges the given Session's RefreshToken for a new
+// signed Bearer token in the same session.  If the refresh token is
+// unknown or revoked, it returns ErrMissingSession.
+func RefreshSigned(
+	k *KeyRing,
+	s *Session,
+	expiration time.Time,
+	validFor time.Duration,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var rc refreshClaims
+		err := store.Unmarshal(RefreshBucket, &rc, s.RefreshToken)(tx)
+		switch {
+		case store.IsMissing(err):
+			return ErrMissingSession(s.RefreshToken)
+		case err != nil:
+			return err
+		case rc.SessionID == "":
+			// This refresh token belongs to a stored session.
+			return ErrMissingSession(s.RefreshToken)
+		}
+
+		k.mu.RLock()
+		revoked := time.Now().Before(k.revoked[rc.SessionID])
+		k.mu.RUnlock()
+		if revoked {
+			return ErrMissingSession(s.RefreshToken)
+		}
+
+		token, err := k.Sign(&Claims{
+			SessionID: rc.SessionID,
+			UserID:    rc.UserID,
+			Expires:   expiration,
+			Scopes:    []string{UserScope},
+		})
+		if err != nil {
+			return err
+		}
+
+		s.Token = token
+		s.TokenType = BearerType
+		s.Expiration, s.ExpiresIn = expiration, validFor
+		return nil
+	}
+}
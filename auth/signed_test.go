@@ -0,0 +1,166 @@
+package auth_test
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/store"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+
+	"github.com/boltdb/bolt"
+	. "gopkg.in/check.v1"
+)
+
+func prepSigned(c *C) (*bolt.DB, func()) {
+	db, tmpDir, err := sgt.TempDB("auth")
+	c.Assert(err, IsNil)
+	c.Assert(db.Update(store.SetupBuckets(
+		auth.RefreshBucket,
+		auth.SigningKeyBucket,
+		auth.RevokedBucket,
+	)), IsNil)
+	return db, func() {
+		c.Assert(sgt.CleanupDB(db), IsNil)
+		c.Assert(os.Remove(tmpDir), IsNil)
+	}
+}
+
+func (s *AuthSuite) TestKeyRingSignVerify(c *C) {
+	db, cleanup := prepSigned(c)
+	defer cleanup()
+
+	var (
+		now  = time.Now()
+		keys = auth.NewKeyRing()
+	)
+
+	c.Log("An empty KeyRing cannot sign.")
+	_, err := keys.Sign(&auth.Claims{UserID: "bob"})
+	c.Check(err, ErrorMatches, "no signing key available")
+
+	c.Assert(db.Update(keys.Rotate(now)), IsNil)
+	claims := &auth.Claims{
+		SessionID: "some-session",
+		UserID:    "bob",
+		Expires:   now.Add(auth.Expiration),
+		Scopes:    []string{auth.UserScope},
+	}
+	tok, err := keys.Sign(claims)
+	c.Assert(err, IsNil)
+	c.Check(auth.IsSigned(tok), Equals, true)
+	c.Check(auth.IsSigned(auth.NewToken(auth.BearerType)), Equals, false)
+
+	c.Log("A signed token verifies to its claims.")
+	got, err := keys.Verify(tok, now)
+	c.Assert(err, IsNil)
+	c.Check(got.UserID, Equals, "bob")
+	c.Check(got.SessionID, Equals, "some-session")
+	c.Check(got.Scopes, DeepEquals, []string{auth.UserScope})
+	c.Check(got.Expires.Equal(claims.Expires), Equals, true)
+
+	c.Log("A tampered token does not verify.")
+	bad := append(auth.Token(nil), tok...)
+	bad[len(auth.Token("sg1.1.a"))] ^= 1
+	_, err = keys.Verify(bad, now)
+	c.Check(auth.IsMissingSession(err), Equals, true)
+
+	c.Log("An expired token is ErrTokenExpired.")
+	_, err = keys.Verify(tok, now.Add(2*auth.Expiration))
+	c.Check(auth.IsTokenExpired(err), Equals, true)
+
+	c.Log("Another KeyRing loaded from the DB verifies the token.")
+	loaded := auth.NewKeyRing()
+	c.Assert(db.View(loaded.Load), IsNil)
+	_, err = loaded.Verify(tok, now)
+	c.Check(err, IsNil)
+
+	c.Log("A rotation which is rolled back leaves the KeyRing as it was.")
+	later := now.Add(auth.KeyRotation)
+	err = db.Update(store.Wrap(
+		keys.Rotate(later),
+		func(*bolt.Tx) error { return errors.New("rolled back") },
+	))
+	c.Check(err, ErrorMatches, "rolled back")
+	sameTok, err := keys.Sign(claims)
+	c.Assert(err, IsNil)
+	c.Check(string(sameTok[:6]), Equals, "sg1.1.")
+
+	c.Log("After rotation, old tokens verify and new ones are signed.")
+	c.Assert(db.Update(keys.Rotate(later)), IsNil)
+	_, err = keys.Verify(tok, now)
+	c.Check(err, IsNil)
+	newTok, err := keys.Sign(claims)
+	c.Assert(err, IsNil)
+	c.Check(string(newTok[:6]), Equals, "sg1.2.")
+
+	c.Log("Once its tokens have expired, a retired key is purged.")
+	c.Assert(db.Update(keys.Rotate(
+		later.Add(auth.KeyRotation),
+	)), IsNil)
+	_, err = keys.Verify(tok, now)
+	c.Check(auth.IsMissingSession(err), Equals, true)
+}
+
+func (s *AuthSuite) TestSignedSession(c *C) {
+	db, cleanup := prepSigned(c)
+	defer cleanup()
+
+	var (
+		now  = time.Now()
+		keys = auth.NewKeyRing()
+		sess = new(auth.Session)
+	)
+	c.Assert(db.Update(keys.Rotate(now)), IsNil)
+	c.Assert(db.Update(auth.NewSignedSession(keys, sess,
+		now.Add(-time.Second),
+		auth.Expiration,
+		auth.NewToken(auth.RefreshType),
+		"bob",
+	)), IsNil)
+
+	c.Log("The new token has expired, but can be refreshed.")
+	_, err := keys.Verify(sess.Token, now)
+	c.Assert(auth.IsTokenExpired(err), Equals, true)
+	old := sess.Token
+	c.Assert(db.Update(auth.RefreshSigned(keys, sess,
+		now.Add(auth.Expiration),
+		auth.Expiration,
+	)), IsNil)
+	c.Check(sess.Token, Not(DeepEquals), old)
+	oldClaims, _ := keys.Verify(old, now)
+	claims, err := keys.Verify(sess.Token, now)
+	c.Assert(err, IsNil)
+	c.Check(claims.UserID, Equals, "bob")
+	c.Check(claims.SessionID, Equals, oldClaims.SessionID)
+
+	c.Log("A revocation which is rolled back does not apply.")
+	err = db.Update(store.Wrap(
+		keys.RevokeUser("bob", now.Add(auth.Expiration)),
+		func(*bolt.Tx) error { return errors.New("rolled back") },
+	))
+	c.Check(err, ErrorMatches, "rolled back")
+	_, err = keys.Verify(sess.Token, now)
+	c.Check(err, IsNil)
+
+	c.Log("Revoking the user's sessions invalidates the token.")
+	c.Assert(db.Update(keys.RevokeUser(
+		"bob", now.Add(auth.Expiration),
+	)), IsNil)
+	_, err = keys.Verify(sess.Token, now)
+	c.Check(auth.IsMissingSession(err), Equals, true)
+
+	c.Log("The refresh token was deleted too.")
+	err = db.Update(auth.RefreshSigned(keys, sess,
+		now.Add(auth.Expiration),
+		auth.Expiration,
+	))
+	c.Check(auth.IsMissingSession(err), Equals, true)
+
+	c.Log("A reloaded KeyRing still knows about the revocation.")
+	loaded := auth.NewKeyRing()
+	c.Assert(db.View(loaded.Load), IsNil)
+	_, err = loaded.Verify(sess.Token, now)
+	c.Check(auth.IsMissingSession(err), Equals, true)
+}
@@ -107,3 +107,43 @@ func GetMessages(convoID string, tx *bolt.Tx) ([]Message, error) {
 	then := now.Add(-7 * 24 * time.Hour)
 	return GetMessageRange(convoID, then, now, 50, tx)
 }
+
+// GetAllMessages returns a function which loads every Message in the
+// given Convo, oldest first, for which keep returns true.  A nil keep
+// keeps them all.
+func GetAllMessages(
+	convoID string,
+	keep func(Message) bool,
+	into *[]Message,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		b, err := store.GetNestedBucket(
+			tx.Bucket(MessageBucket),
+			store.Bucket(convoID),
+		)
+		switch {
+		case store.IsMissingBucket(err):
+			return errMissing(err.(store.ErrMissingBucket))
+		case err != nil:
+			return err
+		}
+
+		result := []Message{}
+		err = b.ForEach(func(_, v []byte) error {
+			next := Message{}
+			if err := json.Unmarshal(v, &next); err != nil {
+				return err
+			}
+			if keep == nil || keep(next) {
+				result = append(result, next)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		*into = result
+		return nil
+	}
+}
@@ -67,3 +67,21 @@ func (s *ConvoSuite) TestGetMessageRange(c *C) {
 	c.Check(err, IsNil)
 	c.Check(got, DeepEquals, msgs)
 }
+
+func (s *ConvoSuite) TestGetAllMessages(c *C) {
+	tStart, _ := time.Parse(time.Kitchen, "2:00AM")
+	msgs := prepareMessages(c, s.db, tStart, tStart, time.Hour)
+
+	var got []convo.Message
+	c.Check(s.db.View(convo.GetAllMessages("hello", nil, &got)), IsNil)
+	c.Check(got, DeepEquals, msgs)
+
+	c.Check(s.db.View(convo.GetAllMessages("hello",
+		func(m convo.Message) bool { return m.Content == "hello3" },
+		&got,
+	)), IsNil)
+	c.Check(got, DeepEquals, msgs[3:4])
+
+	c.Check(s.db.View(convo.GetAllMessages("goodbye", nil, &got)),
+		DeepEquals, convo.MakeMissingErr([]byte("goodbye")))
+}
@@ -15,6 +15,9 @@ type Connected ConnectionNotif
 // Resource implements Resourcer.Resource on Connected.
 func (Connected) Resource() store.Resource { return "convo-connected" }
 
+// Actor implements notif.Actor on Connected.
+func (c Connected) Actor() string { return c.UserID }
+
 // Disconnected is a Resourcer which can notify that a user has
 // disconnected.
 type Disconnected ConnectionNotif
@@ -22,6 +25,9 @@ type Disconnected ConnectionNotif
 // Resource implements Resourcer.Resource on Disconnected.
 func (Disconnected) Resource() store.Resource { return "convo-disconnected" }
 
+// Actor implements notif.Actor on Disconnected.
+func (d Disconnected) Actor() string { return d.UserID }
+
 // Connected returns a store.Resourcer which can notify that a user
 // has connected.
 func (c *Convo) Connected(user string) store.Resourcer {
@@ -40,6 +46,17 @@ func (c *Convo) Disconnected(user string) store.Resourcer {
 	}
 }
 
+// Changed is a Resourcer which sends a created or updated Convo along
+// with the user who changed it.
+type Changed struct {
+	*Convo
+
+	By string `json:"-"`
+}
+
+// Actor implements notif.Actor on Changed.
+func (c Changed) Actor() string { return c.By }
+
 // Deleted is a Resourcer which can notify that the convo has been
 // deleted.
 type Deleted string
@@ -0,0 +1,198 @@
+package export
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+
+	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/stream"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// avatarNames are the names of Avatar files in an archive, by content
+// type.
+var avatarNames = map[string]string{
+	"image/png":  "avatar.png",
+	"image/jpeg": "avatar.jpg",
+	"image/gif":  "avatar.gif",
+	"image/webp": "avatar.webp",
+}
+
+// ConvoArchive is a Convo in an archive, with its Messages.  If the
+// user can no longer read the Convo, only its ID and the Messages they
+// sent are included.
+type ConvoArchive struct {
+	ID       string          `json:"id"`
+	Convo    *convo.Convo    `json:"convo,omitempty"`
+	Messages []convo.Message `json:"messages"`
+}
+
+// Write returns a function which writes a zip archive of everything
+// the given user owns or can read to w.  It contains:
+//
+//   - profile.json: their users.User, including their coin
+//   - avatar.png (or .jpg, .gif, .webp): their Avatar, if any
+//   - circles.json, blocks.json: their Circles and Blocks
+//   - streams.json: the Streams they are in
+//   - tasks.json: the Tasks they are in, with their notes
+//   - convos/<id>.json: a ConvoArchive of each Convo they are in or
+//     sent Messages to
+func Write(owner string, w io.Writer) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		z := zip.NewWriter(w)
+		if err := writeAll(owner, z)(tx); err != nil {
+			return err
+		}
+		return z.Close()
+	}
+}
+
+func writeAll(owner string, z *zip.Writer) func(*bolt.Tx) error {
+	put := func(name string, v interface{}) error {
+		f, err := z.Create(name)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		return errors.Wrapf(enc.Encode(v), "failed to write %s", name)
+	}
+
+	return func(tx *bolt.Tx) error {
+		u := new(users.User)
+		err := store.Unmarshal(users.UserBucket, u, []byte(owner))(tx)
+		switch {
+		case store.IsMissing(err):
+			return users.ErrMissing(owner)
+		case err != nil:
+			return err
+		}
+		if err := put("profile.json", u); err != nil {
+			return err
+		}
+
+		l := new(users.Ledger)
+		if err := users.GetLedger(owner, l)(tx); err != nil {
+			return err
+		}
+		if err := put("ledger.json", l); err != nil {
+			return err
+		}
+
+		a := new(users.Avatar)
+		switch err := users.GetAvatar(owner, a)(tx); {
+		case users.IsNoAvatar(err):
+		case err != nil:
+			return err
+		default:
+			name, ok := avatarNames[a.ContentType]
+			if !ok {
+				name = "avatar"
+			}
+			f, err := z.Create(name)
+			if err != nil {
+				return err
+			}
+			if _, err := f.Write(a.Data); err != nil {
+				return err
+			}
+		}
+
+		var circles []*users.Circle
+		if err := users.GetCircles(owner, &circles)(tx); err != nil {
+			return err
+		}
+		if err := put("circles.json", circles); err != nil {
+			return err
+		}
+
+		blocks := new(users.Blocks)
+		if err := users.GetBlocks(owner, blocks)(tx); err != nil {
+			return err
+		}
+		if err := put("blocks.json", blocks); err != nil {
+			return err
+		}
+
+		strs, err := stream.GetAll(owner)(tx)
+		if err != nil {
+			return err
+		}
+		if err := put("streams.json", strs); err != nil {
+			return err
+		}
+
+		tasks, err := task.GetAll(owner)(tx)
+		if err != nil {
+			return err
+		}
+		if err := put("tasks.json", tasks); err != nil {
+			return err
+		}
+
+		convos, err := getConvos(owner)(tx)
+		if err != nil {
+			return err
+		}
+		for _, c := range convos {
+			if err := put("convos/"+c.ID+".json", c); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// getConvos returns a function which gets a ConvoArchive of each Convo
+// the user is in, and of each other Convo they sent Messages to.
+func getConvos(owner string) func(*bolt.Tx) ([]*ConvoArchive, error) {
+	sent := func(m convo.Message) bool { return m.Sender == owner }
+	return func(tx *bolt.Tx) ([]*ConvoArchive, error) {
+		convos, err := convo.GetAll(owner)(tx)
+		if err != nil {
+			return nil, err
+		}
+		in := make(map[string]bool)
+		var result []*ConvoArchive
+		for _, c := range convos {
+			in[c.ID] = true
+			next := &ConvoArchive{Convo: c, ID: c.ID}
+			err := convo.GetAllMessages(c.ID, nil, &next.Messages)(tx)
+			if err != nil && !convo.IsMissing(err) {
+				return nil, err
+			}
+			result = append(result, next)
+		}
+
+		var others []string
+		err = tx.Bucket(convo.MessageBucket).ForEach(func(k, v []byte) error {
+			// Only nested buckets have nil values.
+			if v == nil && !in[string(k)] {
+				others = append(others, string(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range others {
+			next := &ConvoArchive{ID: id}
+			err := convo.GetAllMessages(id, sent, &next.Messages)(tx)
+			switch {
+			case err != nil:
+				return nil, err
+			case len(next.Messages) > 0:
+				result = append(result, next)
+			}
+		}
+
+		return result, nil
+	}
+}
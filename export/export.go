@@ -0,0 +1,255 @@
+package export
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ExportBucket holds Exports, and ArchiveBucket their archives, by the
+// SHA-256 hash of their token, so a leaked database can't be used to
+// download them.
+var (
+	ExportBucket  = store.Bucket("exports")
+	ArchiveBucket = store.Bucket("export-archives")
+)
+
+// Expiration is how long an Export's archive may be downloaded for.
+var Expiration = 24 * time.Hour
+
+// Export is a user's request for an archive of their data.  Its Token
+// is the secret part of the download link, and is never stored.
+type Export struct {
+	Token   string    `json:"token,omitempty"`
+	Owner   string    `json:"owner"`
+	Ready   bool      `json:"ready"`
+	Error   string    `json:"error,omitempty"`
+	Size    int       `json:"size,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+}
+
+// Resource implements store.Resourcer on *Export.
+func (*Export) Resource() store.Resource { return "exports" }
+
+// ErrMissing is returned when an export token does not exist or has
+// expired.
+type ErrMissing string
+
+func (e ErrMissing) Error() string {
+	return fmt.Sprintf("no such export %#q", string(e))
+}
+
+// IsMissing returns true if the error is an ErrMissing.
+func IsMissing(err error) bool {
+	_, ok := err.(ErrMissing)
+	return ok
+}
+
+// ErrNotReady is returned when an Export's archive is still being
+// built.
+type ErrNotReady string
+
+func (e ErrNotReady) Error() string {
+	return fmt.Sprintf("export %#q is not ready yet", string(e))
+}
+
+// IsNotReady returns true if the error is an ErrNotReady.
+func IsNotReady(err error) bool {
+	_, ok := err.(ErrNotReady)
+	return ok
+}
+
+// ErrPending is returned when a user asks for an Export while another
+// of theirs is being built.
+type ErrPending string
+
+func (e ErrPending) Error() string {
+	return fmt.Sprintf("user %#q already has an export in progress", string(e))
+}
+
+// IsPending returns true if the error is an ErrPending.
+func IsPending(err error) bool {
+	_, ok := err.(ErrPending)
+	return ok
+}
+
+func key(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// Begin returns a function which stores a new pending Export for the
+// given user, which expires Expiration after now, and loads it with its
+// new Token into e.  Expired Exports are deleted first.  If the user
+// has an unexpired Export which is not Ready, it returns ErrPending.
+func Begin(owner string, now time.Time, e *Export) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if err := clearExpired(now)(tx); err != nil {
+			return err
+		}
+
+		err := store.ForEach(ExportBucket, func(_, v []byte) error {
+			var old Export
+			if err := json.Unmarshal(v, &old); err != nil {
+				return err
+			}
+			if old.Owner == owner && !old.Ready {
+				return ErrPending(owner)
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		tok := uuid.NewV4().String()
+		*e = Export{
+			Owner:   owner,
+			Created: now,
+			Expires: now.Add(Expiration),
+		}
+		if err := store.Marshal(ExportBucket, e, key(tok))(tx); err != nil {
+			return err
+		}
+		e.Token = tok
+		return nil
+	}
+}
+
+// Finish returns a function which stores the given archive for the
+// pending Export with the given token and marks it Ready.  The Export
+// is loaded into e.
+func Finish(token string, archive []byte, e *Export) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		k := key(token)
+		err := store.Unmarshal(ExportBucket, e, k)(tx)
+		switch {
+		case store.IsMissing(err):
+			return ErrMissing(token)
+		case err != nil:
+			return err
+		}
+
+		e.Ready, e.Size = true, len(archive)
+		if err := store.Put(ArchiveBucket, k, archive)(tx); err != nil {
+			return err
+		}
+		if err := store.Marshal(ExportBucket, e, k)(tx); err != nil {
+			return err
+		}
+		e.Token = token
+		return nil
+	}
+}
+
+// Cancel returns a function which deletes the Export with the given
+// token and its archive, if any.
+func Cancel(token string) func(*bolt.Tx) error {
+	k := key(token)
+	return store.Wrap(
+		store.Delete(ArchiveBucket, k),
+		store.Delete(ExportBucket, k),
+	)
+}
+
+// CancelPending deletes every Export which is not Ready.  Exports are
+// built in the background by the server, so when it starts, any which
+// are still pending were abandoned.
+func CancelPending(tx *bolt.Tx) error {
+	var pending [][]byte
+	err := store.ForEach(ExportBucket, func(k, v []byte) error {
+		var e Export
+		if err := json.Unmarshal(v, &e); err != nil {
+			return err
+		}
+		if !e.Ready {
+			pending = append(pending, k)
+		}
+		return nil
+	})(tx)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range pending {
+		if err := store.Wrap(
+			store.Delete(ArchiveBucket, k),
+			store.Delete(ExportBucket, k),
+		)(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns a function which loads the Export with the given token
+// into e, and its archive into archive.  If it does not exist or has
+// expired, it returns ErrMissing.  If its archive is not built yet, it
+// returns ErrNotReady.
+func Get(
+	token string,
+	now time.Time,
+	e *Export,
+	archive *[]byte,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		k := key(token)
+		err := store.Unmarshal(ExportBucket, e, k)(tx)
+		switch {
+		case store.IsMissing(err):
+			return ErrMissing(token)
+		case err != nil:
+			return err
+		case !now.Before(e.Expires):
+			return ErrMissing(token)
+		case !e.Ready:
+			return ErrNotReady(token)
+		}
+
+		bs, err := store.Get(ArchiveBucket, k)(tx)
+		if err != nil {
+			return err
+		}
+		// Bolt's bytes are only valid during the transaction.
+		*archive = append([]byte(nil), bs...)
+		e.Token = token
+		return nil
+	}
+}
+
+// clearExpired deletes expired Exports and their archives.
+func clearExpired(now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var expired [][]byte
+		err := store.ForEach(ExportBucket, func(k, v []byte) error {
+			var e Export
+			if err := json.Unmarshal(v, &e); err != nil {
+				return err
+			}
+			if !now.Before(e.Expires) {
+				expired = append(expired, k)
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			if err := store.Wrap(
+				store.Delete(ArchiveBucket, k),
+				store.Delete(ExportBucket, k),
+			)(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,247 @@
+package export_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	tt "testing"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/export"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/stream"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/text"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+type ExportSuite struct {
+	*bolt.DB
+
+	tmpDir string
+}
+
+var _ = Suite(&ExportSuite{})
+
+func Test(t *tt.T) { TestingT(t) }
+
+func (s *ExportSuite) SetUpTest(c *C) {
+	var err error
+	s.DB, s.tmpDir, err = testing.TempDB("export")
+	c.Assert(err, IsNil)
+	c.Assert(s.Update(store.SetupBuckets(
+		export.ExportBucket,
+		export.ArchiveBucket,
+		users.UserBucket,
+		users.AvatarBucket,
+		users.CircleBucket,
+		users.BlockBucket,
+		users.LedgerBucket,
+		stream.StreamBucket,
+		convo.ConvoBucket,
+		convo.MessageBucket,
+		task.TaskBucket,
+		text.TextBucket,
+	)), IsNil)
+}
+
+func (s *ExportSuite) TearDownTest(c *C) {
+	c.Assert(testing.CleanupDB(s.DB), IsNil)
+	c.Assert(os.Remove(s.tmpDir), IsNil)
+}
+
+func (s *ExportSuite) TestExport(c *C) {
+	var (
+		now     = time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+		e, got  = new(export.Export), new(export.Export)
+		archive []byte
+	)
+
+	c.Log("bob begins an export")
+	c.Assert(s.Update(export.Begin("bob", now, e)), IsNil)
+	c.Check(e.Token, Not(Equals), "")
+	c.Check(e, DeepEquals, &export.Export{
+		Token:   e.Token,
+		Owner:   "bob",
+		Created: now,
+		Expires: now.Add(export.Expiration),
+	})
+	tok := e.Token
+
+	c.Log("he can't begin another until it's ready")
+	err := s.Update(export.Begin("bob", now, new(export.Export)))
+	c.Check(export.IsPending(err), Equals, true)
+	c.Check(s.Update(export.Begin("alice", now, new(export.Export))), IsNil)
+
+	c.Log("it can't be downloaded yet")
+	err = s.View(export.Get(tok, now, got, &archive))
+	c.Check(export.IsNotReady(err), Equals, true)
+
+	c.Log("once finished, it can")
+	c.Assert(s.Update(export.Finish(tok, []byte("zip"), e)), IsNil)
+	c.Check(e.Ready, Equals, true)
+	c.Check(e.Size, Equals, 3)
+	c.Check(e.Token, Equals, tok)
+	c.Assert(s.View(export.Get(tok, now, got, &archive)), IsNil)
+	c.Check(got, DeepEquals, e)
+	c.Check(string(archive), Equals, "zip")
+
+	c.Log("unknown tokens are missing")
+	err = s.View(export.Get("some-token", now, got, &archive))
+	c.Check(err, Equals, export.ErrMissing("some-token"))
+
+	c.Log("expired exports are missing, and cleared on the next Begin")
+	later := now.Add(export.Expiration)
+	err = s.View(export.Get(tok, later, got, &archive))
+	c.Check(export.IsMissing(err), Equals, true)
+	c.Assert(s.Update(export.Begin("bob", later, e)), IsNil)
+	c.Check(s.View(func(tx *bolt.Tx) error {
+		ks, _, err := testing.FindAll(tx, export.ArchiveBucket)
+		c.Check(ks, HasLen, 0)
+		return err
+	}), IsNil)
+
+	c.Log("cancelled exports are missing")
+	c.Assert(s.Update(export.Cancel(e.Token)), IsNil)
+	err = s.View(export.Get(e.Token, later, got, &archive))
+	c.Check(export.IsMissing(err), Equals, true)
+
+	c.Log("pending exports are cancelled when the server starts")
+	ready := new(export.Export)
+	c.Assert(s.Update(export.Begin("bob", later, e)), IsNil)
+	c.Assert(s.Update(export.Begin("alice", later, ready)), IsNil)
+	c.Assert(s.Update(export.Finish(ready.Token, []byte("zip"), ready)), IsNil)
+	c.Assert(s.Update(export.CancelPending), IsNil)
+	err = s.View(export.Get(e.Token, later, got, &archive))
+	c.Check(export.IsMissing(err), Equals, true)
+	c.Check(s.View(export.Get(ready.Token, later, got, &archive)), IsNil)
+	c.Check(s.Update(export.Begin("bob", later, e)), IsNil)
+}
+
+func (s *ExportSuite) TestWrite(c *C) {
+	var (
+		shared, left = uuid.NewV4().String(), uuid.NewV4().String()
+		private      = uuid.NewV4().String()
+		tskID        = task.ID(uuid.NewV4())
+		then         = time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	)
+
+	message := func(id, sender, content string, at time.Time) func(*bolt.Tx) error {
+		return func(tx *bolt.Tx) error {
+			bs, err := json.Marshal(convo.Message{
+				Sender: sender, Content: content, Timestamp: at,
+			})
+			if err != nil {
+				return err
+			}
+			b := tx.Bucket(convo.MessageBucket).Bucket([]byte(id))
+			return b.Put(at.AppendFormat(nil, time.RFC3339), bs)
+		}
+	}
+
+	c.Assert(s.Update(store.Wrap(
+		users.Create(&users.User{Name: "bob"}),
+		users.Create(&users.User{Name: "alice"}),
+		users.Post(&users.Transaction{
+			To: "bob", Amount: 5, Reason: users.Grant, Time: then,
+		}),
+		users.SetAvatar(&users.User{Name: "bob"}, &users.Avatar{
+			ContentType: "image/png", Data: []byte("png"),
+		}),
+		users.SetBlocked("bob", "alice", true, new(users.Blocks)),
+		stream.Upsert(&stream.Stream{ID: private, Group: users.Group{
+			Owner: "bob",
+		}}),
+		convo.Upsert(&convo.Convo{ID: shared, Group: users.Group{
+			Owner:   "alice",
+			Readers: map[string]bool{"bob": true},
+		}}),
+		convo.InitMessages(shared),
+		convo.InitMessages(left),
+		message(shared, "alice", "hi bob", then),
+		message(left, "alice", "bye bob", then),
+		message(left, "bob", "bye alice", then.Add(time.Second)),
+		tskID.Store(&task.Task{
+			Name:  "do stuff",
+			Group: users.Group{Owner: "bob"},
+			Notes: []string{"some notes"},
+		}),
+	)), IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(s.View(export.Write("bob", &buf)), IsNil)
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	c.Assert(err, IsNil)
+
+	files := make(map[string][]byte)
+	var names []string
+	for _, f := range z.File {
+		r, err := f.Open()
+		c.Assert(err, IsNil)
+		bs, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(r.Close(), IsNil)
+		files[f.Name] = bs
+		names = append(names, f.Name)
+	}
+	expect := []string{
+		"avatar.png",
+		"blocks.json",
+		"circles.json",
+		"convos/" + left + ".json",
+		"convos/" + shared + ".json",
+		"ledger.json",
+		"profile.json",
+		"streams.json",
+		"tasks.json",
+	}
+	sort.Strings(names)
+	sort.Strings(expect)
+	c.Check(names, DeepEquals, expect)
+
+	u := new(users.User)
+	c.Assert(json.Unmarshal(files["profile.json"], u), IsNil)
+	c.Check(u.Coin, Equals, int64(5))
+	l := new(users.Ledger)
+	c.Assert(json.Unmarshal(files["ledger.json"], l), IsNil)
+	c.Check(l.Balance, Equals, int64(5))
+	c.Check(l.Transactions, HasLen, 1)
+	c.Check(string(files["avatar.png"]), Equals, "png")
+
+	var tasks []*task.Task
+	c.Assert(json.Unmarshal(files["tasks.json"], &tasks), IsNil)
+	c.Assert(tasks, HasLen, 1)
+	c.Check(tasks[0].Notes, DeepEquals, []string{"some notes"})
+
+	var strs []*stream.Stream
+	c.Assert(json.Unmarshal(files["streams.json"], &strs), IsNil)
+	c.Assert(strs, HasLen, 1)
+	c.Check(strs[0].ID, Equals, private)
+
+	c.Log("bob gets all of a convo he is in")
+	ca := new(export.ConvoArchive)
+	c.Assert(json.Unmarshal(files["convos/"+shared+".json"], ca), IsNil)
+	c.Check(ca.Convo.Owner, Equals, "alice")
+	c.Assert(ca.Messages, HasLen, 1)
+	c.Check(ca.Messages[0].Content, Equals, "hi bob")
+
+	c.Log("but only his own messages in one he left")
+	ca = new(export.ConvoArchive)
+	c.Assert(json.Unmarshal(files["convos/"+left+".json"], ca), IsNil)
+	c.Check(ca.Convo, IsNil)
+	c.Assert(ca.Messages, HasLen, 1)
+	c.Check(ca.Messages[0].Content, Equals, "bye alice")
+
+	c.Log("missing users can't be exported")
+	err = s.View(export.Write("carol", new(bytes.Buffer)))
+	c.Check(users.IsMissing(err), Equals, true)
+}
@@ -33,3 +33,11 @@ func assertTicketsExist(c *C, ts ...incept.Ticket) func(*bolt.Tx) error {
 		return nil
 	}
 }
+
+func assertNoTicket(c *C, t incept.Ticket) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		err := incept.CheckTicketExist(t)(tx)
+		c.Check(err, FitsTypeOf, incept.ErrTicketMissing(""))
+		return nil
+	}
+}
@@ -3,6 +3,7 @@ package incept
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/synapse-garden/sg-proto/auth"
 	"github.com/synapse-garden/sg-proto/store"
@@ -58,13 +59,19 @@ func NewTicket(t Ticket) func(*bolt.Tx) error {
 	return store.Put(TicketBucket, t.Bytes(), nil)
 }
 
+// CheckTicketExist returns ErrTicketMissing unless the given Ticket
+// exists and has not been redeemed.
 func CheckTicketExist(key Ticket) func(*bolt.Tx) error {
 	return func(tx *bolt.Tx) error {
-		err := store.CheckExists(TicketBucket, key.Bytes())(tx)
-		if store.IsMissing(err) {
+		var info TicketInfo
+		err := GetTicketInfo(key, &info)(tx)
+		switch {
+		case err != nil:
+			return err
+		case info.Redeemed != nil:
 			return ErrTicketMissing(key.String())
 		}
-		return err
+		return nil
 	}
 }
 
@@ -80,20 +87,35 @@ func DeleteTickets(ts ...Ticket) func(*bolt.Tx) error {
 	}
 }
 
-func PunchTicket(key Ticket) func(*bolt.Tx) error {
-	return store.Delete(TicketBucket, key.Bytes())
+// PunchTicket marks the given Ticket as redeemed at the given time.  It
+// is kept as a record, but can no longer be used.
+func PunchTicket(key Ticket, now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var info TicketInfo
+		if err := GetTicketInfo(key, &info)(tx); err != nil {
+			return err
+		}
+		info.Redeemed = &now
+		return putTicketInfo(key, &info)(tx)
+	}
 }
 
-// Incept checks that the given Ticket exists, and that the given User
-// does not (by name.)  Then it tries to create the given user,
-// and delete the given key.  Any error will cause this to roll back.
+// Incept checks that the given Ticket exists and may be used, and that
+// the given User does not exist (by name.)  Then it tries to create the
+// given user with the Ticket's starting coin and memberships, and
+// records the use of the key, punching it if it has no uses left.  Any
+// error will cause this to roll back.
 func Incept(
 	key Ticket,
 	l *auth.Login,
 	db *bolt.DB,
 ) error {
-	user := &(l.User)
-	name := user.Name
+	var (
+		user = &(l.User)
+		name = user.Name
+		now  = time.Now()
+		info = new(TicketInfo)
+	)
 	// Check if given ticket exists (nil => it exists)
 	if err := db.View(store.Wrap(
 		CheckTicketExist(key),
@@ -104,12 +126,13 @@ func Incept(
 	}
 	// Create user or fail
 	if err := db.Update(store.Wrap(
-		CheckTicketExist(key),
 		users.CheckNotExist(name),
 		auth.CheckLoginNotExist(l),
-		PunchTicket(key),
+		useTicket(key, user, now, info),
 		users.Create(user),
 		auth.Create(l, uuid.NewV4()),
+		joinTicketGroups(info, user, now),
+		redeemInvite(key, info, name, now),
 	)); err != nil {
 		return err
 	}
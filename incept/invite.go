@@ -0,0 +1,200 @@
+package incept
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// InviteBucket holds Invites by Ticket.
+var InviteBucket = store.Bucket("invites")
+
+// Invite records a Ticket issued by a user rather than an admin, and who
+// used it.
+type Invite struct {
+	Ticket   Ticket     `json:"ticket"`
+	Inviter  string     `json:"inviter"`
+	Invitee  string     `json:"invitee,omitempty"`
+	Price    int64      `json:"price,omitempty"`
+	Created  time.Time  `json:"created"`
+	Redeemed *time.Time `json:"redeemed,omitempty"`
+}
+
+// NewInvite returns a function which charges the Invite's Inviter its
+// Price as a users.Invite Transaction, and issues its Ticket.  If the
+// Inviter can't afford it, it returns users.ErrInsufficientCoin.
+func NewInvite(inv *Invite) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if inv.Price < 0 {
+			return errors.New("invite price must not be negative")
+		}
+		if inv.Price > 0 {
+			if err := users.Spend(&users.Transaction{
+				From:   inv.Inviter,
+				Amount: inv.Price,
+				Reason: users.Invite,
+				Time:   inv.Created,
+			})(tx); err != nil {
+				return err
+			}
+		} else if err := users.CheckUsersExist(inv.Inviter)(tx); err != nil {
+			return err
+		}
+
+		return store.Wrap(
+			IssueTickets(&TicketInfo{
+				Inviter: inv.Inviter,
+				Created: inv.Created,
+			}, inv.Ticket),
+			store.Marshal(InviteBucket, inv, inv.Ticket.Bytes()),
+		)(tx)
+	}
+}
+
+// redeemInvite records the given user as the invitee of the Invite for
+// the given Ticket, if the Ticket's TicketInfo has an Inviter.
+func redeemInvite(
+	key Ticket,
+	info *TicketInfo,
+	user string,
+	now time.Time,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if info.Inviter == "" {
+			return nil
+		}
+		inv := new(Invite)
+		if err := store.Unmarshal(InviteBucket, inv, key.Bytes())(tx); err != nil {
+			return errors.Wrapf(err, "failed to get invite %#q", key)
+		}
+		inv.Invitee = user
+		inv.Redeemed = &now
+		return store.Marshal(InviteBucket, inv, key.Bytes())(tx)
+	}
+}
+
+// GetInvites returns a function which loads all Invites made by the
+// given user.
+func GetInvites(inviter string, into *[]*Invite) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var result []*Invite
+		err := store.ForEach(InviteBucket, func(k, v []byte) error {
+			next := new(Invite)
+			if err := json.Unmarshal(v, next); err != nil {
+				return errors.Wrapf(err,
+					"failed to unmarshal invite %x", k)
+			}
+			if next.Inviter == inviter {
+				result = append(result, next)
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+		*into = result
+		return nil
+	}
+}
+
+// RevokeInvites returns a function which deletes the unused Invites of
+// the given user and their Tickets, and loads them into revoked.  Their
+// Price is not refunded.
+func RevokeInvites(inviter string, revoked *[]*Invite) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var all []*Invite
+		if err := GetInvites(inviter, &all)(tx); err != nil {
+			return err
+		}
+
+		var result []*Invite
+		for _, inv := range all {
+			if inv.Redeemed != nil {
+				continue
+			}
+			key := inv.Ticket.Bytes()
+			if err := store.Wrap(
+				DeleteTickets(inv.Ticket),
+				store.Delete(InviteBucket, key),
+			)(tx); err != nil {
+				return err
+			}
+			result = append(result, inv)
+		}
+		*revoked = result
+		return nil
+	}
+}
+
+// InviteNode is a user in the invite tree, with the users they invited.
+type InviteNode struct {
+	Name    string        `json:"name"`
+	Invited []*InviteNode `json:"invited,omitempty"`
+}
+
+// GetInviteTree returns a function which loads the tree of users who
+// invited each other.  If root is given, only the tree of users invited
+// by root, and those they invited, is loaded.  Otherwise, each user who
+// invited someone without being invited by a user is a root.
+func GetInviteTree(root string, into *[]*InviteNode) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var (
+			invited   = make(map[string][]string)
+			wasInvite = make(map[string]bool)
+			inviters  []string
+		)
+		err := store.ForEach(InviteBucket, func(k, v []byte) error {
+			var inv Invite
+			if err := json.Unmarshal(v, &inv); err != nil {
+				return errors.Wrapf(err,
+					"failed to unmarshal invite %x", k)
+			}
+			if inv.Invitee == "" {
+				return nil
+			}
+			if _, ok := invited[inv.Inviter]; !ok {
+				inviters = append(inviters, inv.Inviter)
+			}
+			invited[inv.Inviter] = append(invited[inv.Inviter], inv.Invitee)
+			wasInvite[inv.Invitee] = true
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		// seen guards against cycles, which a deleted user's name
+		// being reused could make.
+		seen := make(map[string]bool)
+		var grow func(string) *InviteNode
+		grow = func(name string) *InviteNode {
+			seen[name] = true
+			node := &InviteNode{Name: name}
+			for _, next := range invited[name] {
+				if !seen[next] {
+					node.Invited = append(node.Invited, grow(next))
+				}
+			}
+			return node
+		}
+
+		if root != "" {
+			*into = []*InviteNode{grow(root)}
+			return nil
+		}
+
+		var result []*InviteNode
+		for _, name := range inviters {
+			if !wasInvite[name] {
+				result = append(result, grow(name))
+			}
+		}
+		*into = result
+		return nil
+	}
+}
@@ -0,0 +1,93 @@
+package incept_test
+
+import (
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/incept"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *InceptSuite) TestInvites(c *C) {
+	c.Assert(s.db.Update(store.SetupBuckets(
+		users.UserBucket,
+		users.LedgerBucket,
+		auth.LoginBucket,
+		incept.InviteBucket,
+	)), IsNil)
+	c.Assert(s.db.Update(users.Create(&users.User{
+		Name: "bob", Coin: 15,
+	})), IsNil)
+
+	invite := func(inviter string, price int64) (*incept.Invite, error) {
+		inv := &incept.Invite{
+			Ticket:  incept.Ticket(uuid.NewV4()),
+			Inviter: inviter,
+			Price:   price,
+			Created: time.Now(),
+		}
+		return inv, s.db.Update(incept.NewInvite(inv))
+	}
+
+	c.Log("a missing user can't invite anyone")
+	_, err := invite("nobody", 0)
+	c.Check(err, DeepEquals, users.ErrMissing("nobody"))
+
+	c.Log("bob pays for his invites")
+	toAmy, err := invite("bob", 10)
+	c.Assert(err, IsNil)
+	_, err = invite("bob", 10)
+	c.Check(err, DeepEquals, users.ErrInsufficientCoin{
+		Name: "bob", Have: 5, Need: 10,
+	})
+	unused, err := invite("bob", 5)
+	c.Assert(err, IsNil)
+
+	c.Log("amy uses bob's invite, then invites ann")
+	c.Assert(incept.Incept(toAmy.Ticket, login("amy"), s.db), IsNil)
+	toAnn, err := invite("amy", 0)
+	c.Assert(err, IsNil)
+	c.Assert(incept.Incept(toAnn.Ticket, login("ann"), s.db), IsNil)
+
+	var invs []*incept.Invite
+	c.Assert(s.db.View(incept.GetInvites("bob", &invs)), IsNil)
+	c.Assert(invs, HasLen, 2)
+	for _, inv := range invs {
+		if inv.Ticket == toAmy.Ticket {
+			c.Check(inv.Invitee, Equals, "amy")
+			c.Check(inv.Redeemed, NotNil)
+		} else {
+			c.Check(inv.Invitee, Equals, "")
+			c.Check(inv.Redeemed, IsNil)
+		}
+	}
+
+	c.Log("the invite tree shows who invited whom")
+	var tree []*incept.InviteNode
+	c.Assert(s.db.View(incept.GetInviteTree("", &tree)), IsNil)
+	c.Check(tree, DeepEquals, []*incept.InviteNode{{
+		Name: "bob",
+		Invited: []*incept.InviteNode{{
+			Name:    "amy",
+			Invited: []*incept.InviteNode{{Name: "ann"}},
+		}},
+	}})
+	c.Assert(s.db.View(incept.GetInviteTree("amy", &tree)), IsNil)
+	c.Check(tree, DeepEquals, []*incept.InviteNode{{
+		Name:    "amy",
+		Invited: []*incept.InviteNode{{Name: "ann"}},
+	}})
+
+	c.Log("revoking bob's invites only removes the unused one")
+	var revoked []*incept.Invite
+	c.Assert(s.db.Update(incept.RevokeInvites("bob", &revoked)), IsNil)
+	c.Assert(revoked, HasLen, 1)
+	c.Check(revoked[0].Ticket, Equals, unused.Ticket)
+	c.Check(s.db.View(assertNoTicket(c, unused.Ticket)), IsNil)
+	c.Assert(s.db.View(incept.GetInvites("bob", &invs)), IsNil)
+	c.Check(invs, HasLen, 1)
+}
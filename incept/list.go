@@ -0,0 +1,209 @@
+package incept
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// TicketStatus describes whether a Ticket can still be used.
+type TicketStatus string
+
+// TicketStatus values.  StatusAny matches any Ticket in a TicketFilter.
+const (
+	StatusAny         TicketStatus = ""
+	StatusOutstanding TicketStatus = "outstanding"
+	StatusExpired     TicketStatus = "expired"
+	StatusRedeemed    TicketStatus = "redeemed"
+)
+
+// Valid returns true if the TicketStatus is known.
+func (s TicketStatus) Valid() bool {
+	switch s {
+	case StatusAny, StatusOutstanding, StatusExpired, StatusRedeemed:
+		return true
+	}
+	return false
+}
+
+// TicketRecord is a Ticket with its TicketInfo and TicketStatus.
+type TicketRecord struct {
+	Ticket Ticket       `json:"ticket"`
+	Status TicketStatus `json:"status"`
+
+	TicketInfo
+}
+
+// Status returns the TicketStatus of the TicketInfo at the given time.
+func (t *TicketInfo) Status(now time.Time) TicketStatus {
+	switch {
+	case t.Redeemed != nil:
+		return StatusRedeemed
+	case t.Expired(now):
+		return StatusExpired
+	}
+	return StatusOutstanding
+}
+
+// TicketFilter selects TicketRecords.  Its zero value selects all of
+// them.
+type TicketFilter struct {
+	Status  TicketStatus
+	Creator string
+	Inviter string
+
+	CreatedBefore *time.Time
+	CreatedAfter  *time.Time
+}
+
+// Empty returns true if the TicketFilter selects every TicketRecord.
+func (f *TicketFilter) Empty() bool {
+	return f.Status == StatusAny && f.Creator == "" && f.Inviter == "" &&
+		f.CreatedBefore == nil && f.CreatedAfter == nil
+}
+
+// Match returns true if the TicketRecord is selected by the filter.
+func (f *TicketFilter) Match(r *TicketRecord) bool {
+	switch {
+	case f.Status != StatusAny && r.Status != f.Status:
+		return false
+	case f.Creator != "" && r.Creator != f.Creator:
+		return false
+	case f.Inviter != "" && r.Inviter != f.Inviter:
+		return false
+	case f.CreatedBefore != nil && !r.Created.Before(*f.CreatedBefore):
+		return false
+	case f.CreatedAfter != nil && !r.Created.After(*f.CreatedAfter):
+		return false
+	}
+	return true
+}
+
+// GetTicket returns a function which loads the TicketRecord of the
+// given Ticket as of now.
+func GetTicket(key Ticket, now time.Time, into *TicketRecord) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if err := GetTicketInfo(key, &into.TicketInfo)(tx); err != nil {
+			return err
+		}
+		into.Ticket = key
+		into.Status = into.TicketInfo.Status(now)
+		return nil
+	}
+}
+
+// GetTickets returns a function which loads the TicketRecords matching
+// the given TicketFilter as of now, oldest first.
+func GetTickets(
+	f *TicketFilter,
+	now time.Time,
+	into *[]*TicketRecord,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var result []*TicketRecord
+		err := store.ForEach(TicketBucket, func(k, v []byte) error {
+			next := new(TicketRecord)
+			uu, err := uuid.FromBytes(k)
+			if err != nil {
+				return errors.Wrapf(err, "invalid ticket %x", k)
+			}
+			next.Ticket = Ticket(uu)
+			if len(v) > 0 {
+				if err := json.Unmarshal(v, &next.TicketInfo); err != nil {
+					return errors.Wrapf(err,
+						"failed to unmarshal ticket %#q",
+						next.Ticket)
+				}
+			}
+			next.Status = next.TicketInfo.Status(now)
+			if f.Match(next) {
+				result = append(result, next)
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Created.Before(result[j].Created)
+		})
+		*into = result
+		return nil
+	}
+}
+
+// ErrRedeemed is returned when a redeemed Ticket would be revoked.
+type ErrRedeemed string
+
+func (e ErrRedeemed) Error() string {
+	return fmt.Sprintf("ticket %#q has been redeemed", string(e))
+}
+
+// RevokeTickets returns a function which deletes the Tickets matching
+// the given TicketFilter as of now, and loads them into revoked.
+// Redeemed Tickets are kept as records, and their Invites, if any, are
+// deleted.
+func RevokeTickets(
+	f *TicketFilter,
+	now time.Time,
+	revoked *[]*TicketRecord,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if f.Status == StatusRedeemed {
+			return errors.New("redeemed tickets cannot be revoked")
+		}
+
+		var all []*TicketRecord
+		if err := GetTickets(f, now, &all)(tx); err != nil {
+			return err
+		}
+
+		var result []*TicketRecord
+		for _, r := range all {
+			if r.Status == StatusRedeemed {
+				continue
+			}
+			if err := revoke(r)(tx); err != nil {
+				return err
+			}
+			result = append(result, r)
+		}
+		*revoked = result
+		return nil
+	}
+}
+
+// RevokeTicket returns a function which deletes the given Ticket, or
+// returns ErrRedeemed if it has been redeemed.
+func RevokeTicket(key Ticket) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		r := &TicketRecord{Ticket: key}
+		if err := GetTicketInfo(key, &r.TicketInfo)(tx); err != nil {
+			return err
+		}
+		if r.Redeemed != nil {
+			return ErrRedeemed(key.String())
+		}
+		return revoke(r)(tx)
+	}
+}
+
+func revoke(r *TicketRecord) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if err := DeleteTickets(r.Ticket)(tx); err != nil {
+			return err
+		}
+		if r.Inviter == "" {
+			return nil
+		}
+		return store.Delete(InviteBucket, r.Ticket.Bytes())(tx)
+	}
+}
@@ -0,0 +1,118 @@
+package incept_test
+
+import (
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/incept"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *InceptSuite) TestGetRevokeTickets(c *C) {
+	c.Assert(s.db.Update(store.SetupBuckets(
+		users.UserBucket,
+		users.LedgerBucket,
+		auth.LoginBucket,
+		incept.InviteBucket,
+	)), IsNil)
+
+	var (
+		start    = time.Now()
+		later    = start.Add(time.Hour)
+		legacy   = incept.Ticket(uuid.NewV4())
+		rootTkt  = incept.Ticket(uuid.NewV4())
+		used     = incept.Ticket(uuid.NewV4())
+		expiring = incept.Ticket(uuid.NewV4())
+	)
+
+	c.Assert(s.db.Update(store.Wrap(
+		incept.NewTicket(legacy),
+		incept.IssueTickets(&incept.TicketInfo{
+			Creator: "root", Created: start,
+		}, rootTkt),
+		incept.IssueTickets(&incept.TicketInfo{
+			Creator: "mod", Created: start.Add(time.Second),
+		}, used),
+		incept.IssueTickets(&incept.TicketInfo{
+			Creator: "mod", Created: start.Add(2 * time.Second),
+			Expires: &later,
+		}, expiring),
+	)), IsNil)
+	c.Assert(incept.Incept(used, login("bob"), s.db), IsNil)
+
+	ids := func(rs []*incept.TicketRecord) []incept.Ticket {
+		var result []incept.Ticket
+		for _, r := range rs {
+			result = append(result, r.Ticket)
+		}
+		return result
+	}
+
+	now := later.Add(time.Minute)
+	for i, test := range []struct {
+		should string
+		filter incept.TicketFilter
+		expect []incept.Ticket
+	}{{
+		should: "get all tickets oldest first",
+		expect: []incept.Ticket{legacy, rootTkt, used, expiring},
+	}, {
+		should: "get outstanding tickets",
+		filter: incept.TicketFilter{Status: incept.StatusOutstanding},
+		expect: []incept.Ticket{legacy, rootTkt},
+	}, {
+		should: "get redeemed tickets",
+		filter: incept.TicketFilter{Status: incept.StatusRedeemed},
+		expect: []incept.Ticket{used},
+	}, {
+		should: "get expired tickets",
+		filter: incept.TicketFilter{Status: incept.StatusExpired},
+		expect: []incept.Ticket{expiring},
+	}, {
+		should: "get tickets by creator",
+		filter: incept.TicketFilter{Creator: "mod"},
+		expect: []incept.Ticket{used, expiring},
+	}, {
+		should: "get tickets by creation time",
+		filter: incept.TicketFilter{CreatedAfter: &start},
+		expect: []incept.Ticket{used, expiring},
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		var got []*incept.TicketRecord
+		c.Assert(s.db.View(incept.GetTickets(&test.filter, now, &got)), IsNil)
+		c.Check(ids(got), DeepEquals, test.expect)
+	}
+
+	c.Log("a redeemed ticket records who used it")
+	rec := new(incept.TicketRecord)
+	c.Assert(s.db.View(incept.GetTicket(used, now, rec)), IsNil)
+	c.Check(rec.Status, Equals, incept.StatusRedeemed)
+	c.Check(rec.RedeemedBy, DeepEquals, []string{"bob"})
+	c.Check(rec.Uses, Equals, 1)
+
+	c.Log("a redeemed ticket can't be revoked")
+	err := s.db.Update(incept.RevokeTicket(used))
+	c.Check(err, FitsTypeOf, incept.ErrRedeemed(""))
+
+	c.Log("revoking mod's tickets keeps the redeemed one")
+	var revoked []*incept.TicketRecord
+	c.Assert(s.db.Update(incept.RevokeTickets(&incept.TicketFilter{
+		Creator: "mod",
+	}, now, &revoked)), IsNil)
+	c.Check(ids(revoked), DeepEquals, []incept.Ticket{expiring})
+
+	var left []*incept.TicketRecord
+	c.Assert(s.db.View(incept.GetTickets(
+		new(incept.TicketFilter), now, &left,
+	)), IsNil)
+	c.Check(ids(left), DeepEquals, []incept.Ticket{legacy, rootTkt, used})
+
+	c.Log("a single ticket can be revoked")
+	c.Assert(s.db.Update(incept.RevokeTicket(legacy)), IsNil)
+	err = s.db.View(incept.GetTicket(legacy, now, rec))
+	c.Check(err, FitsTypeOf, incept.ErrTicketMissing(""))
+}
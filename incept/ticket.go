@@ -0,0 +1,290 @@
+package incept
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/stream"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// TicketInfo is the metadata stored with a Ticket.  A Ticket with no
+// TicketInfo, or with a zero MaxUses, may be used once and never
+// expires.
+type TicketInfo struct {
+	// Creator is the name of the admin credential which issued the
+	// Ticket.
+	Creator string     `json:"creator,omitempty"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+
+	// Inviter is the name of the user who issued the Ticket as an
+	// Invite, if a user did.
+	Inviter string `json:"inviter,omitempty"`
+
+	MaxUses int `json:"maxUses,omitempty"`
+	Uses    int `json:"uses"`
+
+	// Username, if set, is the only name which may be used with the
+	// Ticket.  No other Ticket may be used to take it.
+	Username string `json:"username,omitempty"`
+
+	// Coin is given to each user incepted with the Ticket.
+	Coin int64 `json:"coin,omitempty"`
+
+	// Streams and Convos are the IDs of the streams and convos which
+	// each user incepted with the Ticket joins as a reader and writer.
+	Streams []string `json:"streams,omitempty"`
+	Convos  []string `json:"convos,omitempty"`
+
+	// RedeemedBy lists the users incepted with the Ticket.  Redeemed
+	// is set when it has no uses left.
+	RedeemedBy []string   `json:"redeemedBy,omitempty"`
+	Redeemed   *time.Time `json:"redeemed,omitempty"`
+}
+
+// Expired returns true if the TicketInfo has expired by now.
+func (t *TicketInfo) Expired(now time.Time) bool {
+	return t.Expires != nil && !now.Before(*t.Expires)
+}
+
+// Remaining returns the number of times the Ticket may still be used.
+func (t *TicketInfo) Remaining() int {
+	max := t.MaxUses
+	if max < 1 {
+		max = 1
+	}
+	return max - t.Uses
+}
+
+// Validate returns an error if the TicketInfo cannot be issued.
+func (t *TicketInfo) Validate() error {
+	switch {
+	case t.MaxUses < 0:
+		return errors.New("maxUses must not be negative")
+	case t.Coin < 0:
+		return errors.New("coin must not be negative")
+	case t.Username != "" && t.MaxUses > 1:
+		return errors.New("a ticket with a reserved username " +
+			"can only be used once")
+	case t.Username != "":
+		return users.ValidateNew(&users.User{Name: t.Username})
+	}
+	return nil
+}
+
+// ErrTicketExpired is returned when an expired Ticket is used.
+type ErrTicketExpired string
+
+func (e ErrTicketExpired) Error() string {
+	return fmt.Sprintf("ticket %#q has expired", string(e))
+}
+
+// IsTicketExpired returns true if the error is an ErrTicketExpired.
+func IsTicketExpired(err error) bool {
+	_, ok := err.(ErrTicketExpired)
+	return ok
+}
+
+// ErrReserved is returned when a username is reserved by a Ticket
+// other than the one being used, or when a Ticket reserving a username
+// is used with a different one.
+type ErrReserved string
+
+func (e ErrReserved) Error() string {
+	return fmt.Sprintf("username %#q is reserved", string(e))
+}
+
+// IsReserved returns true if the error is an ErrReserved.
+func IsReserved(err error) bool {
+	_, ok := err.(ErrReserved)
+	return ok
+}
+
+// IssueTickets returns a function which stores the given Tickets with a
+// copy of the given TicketInfo.  The TicketInfo is validated, and its
+// Streams and Convos must exist.  If it reserves a Username, that name
+// must not be taken or reserved, and only one Ticket may be issued.
+func IssueTickets(info *TicketInfo, ts ...Ticket) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if err := info.Validate(); err != nil {
+			return err
+		}
+
+		for _, id := range info.Streams {
+			if err := stream.CheckExists(id)(tx); err != nil {
+				return err
+			}
+		}
+		for _, id := range info.Convos {
+			if err := convo.CheckExists(id)(tx); err != nil {
+				return err
+			}
+		}
+
+		if name := info.Username; name != "" {
+			if len(ts) > 1 {
+				return errors.New("only one ticket may reserve a username")
+			}
+			if err := store.Wrap(
+				users.CheckNotExist(name),
+				checkNotReserved(name, nil),
+			)(tx); err != nil {
+				return err
+			}
+		}
+
+		for _, t := range ts {
+			if err := putTicketInfo(t, info)(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// GetTicketInfo returns a function which loads the TicketInfo of the
+// given Ticket.
+func GetTicketInfo(key Ticket, into *TicketInfo) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		bs := tx.Bucket(TicketBucket).Get(key.Bytes())
+		switch {
+		case bs == nil:
+			return ErrTicketMissing(key.String())
+		case len(bs) == 0:
+			// Tickets issued without metadata.
+			*into = TicketInfo{}
+			return nil
+		}
+		*into = TicketInfo{}
+		return json.Unmarshal(bs, into)
+	}
+}
+
+func putTicketInfo(key Ticket, info *TicketInfo) func(*bolt.Tx) error {
+	return store.Marshal(TicketBucket, info, key.Bytes())
+}
+
+// checkNotReserved returns ErrReserved if any Ticket but the given one
+// reserves the given username.
+func checkNotReserved(name string, except []byte) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		return store.ForEach(TicketBucket, func(k, v []byte) error {
+			if len(v) == 0 || string(k) == string(except) {
+				return nil
+			}
+			var info TicketInfo
+			if err := json.Unmarshal(v, &info); err != nil {
+				return errors.Wrapf(err,
+					"failed to unmarshal ticket %x", k)
+			}
+			if info.Redeemed == nil && info.Username == name {
+				return ErrReserved(name)
+			}
+			return nil
+		})(tx)
+	}
+}
+
+// useTicket returns a function which checks that the given Ticket can
+// be used by the given User, and records the use, marking the Ticket
+// redeemed when it has none left.  The Ticket's TicketInfo is loaded into info.
+func useTicket(
+	key Ticket,
+	user *users.User,
+	now time.Time,
+	info *TicketInfo,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if err := GetTicketInfo(key, info)(tx); err != nil {
+			return err
+		}
+
+		switch {
+		case info.Redeemed != nil, info.Remaining() < 1:
+			return ErrTicketMissing(key.String())
+		case info.Expired(now):
+			return ErrTicketExpired(key.String())
+		case info.Username != "" && info.Username != user.Name:
+			return ErrReserved(info.Username)
+		}
+
+		if info.Username == "" {
+			err := checkNotReserved(user.Name, key.Bytes())(tx)
+			if err != nil {
+				return err
+			}
+		}
+
+		info.Uses++
+		info.RedeemedBy = append(info.RedeemedBy, user.Name)
+		if info.Remaining() < 1 {
+			info.Redeemed = &now
+		}
+		return putTicketInfo(key, info)(tx)
+	}
+}
+
+// joinTicketGroups returns a function which gives the TicketInfo's
+// Coin to the given User as a users.Ticket Transaction, and adds them
+// to its Streams and Convos.
+func joinTicketGroups(
+	info *TicketInfo,
+	user *users.User,
+	now time.Time,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if info.Coin != 0 {
+			if err := users.Post(&users.Transaction{
+				To:     user.Name,
+				Amount: info.Coin,
+				Reason: users.Ticket,
+				Time:   now,
+			})(tx); err != nil {
+				return err
+			}
+			user.Coin += info.Coin
+		}
+
+		for _, id := range info.Streams {
+			s := new(stream.Stream)
+			if err := stream.Get(s, id)(tx); err != nil {
+				return err
+			}
+			join(&s.Group, user.Name)
+			if err := stream.Upsert(s)(tx); err != nil {
+				return err
+			}
+		}
+
+		for _, id := range info.Convos {
+			c := new(convo.Convo)
+			if err := convo.Get(c, id)(tx); err != nil {
+				return err
+			}
+			join(&c.Group, user.Name)
+			if err := convo.Upsert(c)(tx); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+func join(g *users.Group, name string) {
+	if g.Readers == nil {
+		g.Readers = make(map[string]bool)
+	}
+	if g.Writers == nil {
+		g.Writers = make(map[string]bool)
+	}
+	g.Readers[name] = true
+	g.Writers[name] = true
+}
@@ -0,0 +1,194 @@
+package incept_test
+
+import (
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/incept"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/stream"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func login(name string) *auth.Login {
+	return &auth.Login{
+		User:   users.User{Name: name},
+		PWHash: sgt.Sha256("password"),
+	}
+}
+
+func (s *InceptSuite) TestIssueTickets(c *C) {
+	c.Assert(s.db.Update(store.SetupBuckets(
+		users.UserBucket,
+		users.LedgerBucket,
+		stream.StreamBucket,
+		convo.ConvoBucket,
+	)), IsNil)
+	c.Assert(s.db.Update(users.Create(&users.User{Name: "bob"})), IsNil)
+
+	one, two := incept.Ticket(uuid.NewV4()), incept.Ticket(uuid.NewV4())
+
+	for i, test := range []struct {
+		should string
+		info   incept.TicketInfo
+		tkts   []incept.Ticket
+		expect string
+	}{{
+		should: "reject negative uses",
+		info:   incept.TicketInfo{MaxUses: -1},
+		tkts:   []incept.Ticket{one},
+		expect: "maxUses must not be negative",
+	}, {
+		should: "reject negative coin",
+		info:   incept.TicketInfo{Coin: -5},
+		tkts:   []incept.Ticket{one},
+		expect: "coin must not be negative",
+	}, {
+		should: "reject a reserved name with many uses",
+		info:   incept.TicketInfo{Username: "jim", MaxUses: 2},
+		tkts:   []incept.Ticket{one},
+		expect: "a ticket with a reserved username can only be used once",
+	}, {
+		should: "reject a reserved name on many tickets",
+		info:   incept.TicketInfo{Username: "jim"},
+		tkts:   []incept.Ticket{one, two},
+		expect: "only one ticket may reserve a username",
+	}, {
+		should: "reject a taken name",
+		info:   incept.TicketInfo{Username: "bob"},
+		tkts:   []incept.Ticket{one},
+		expect: "user `bob` already exists",
+	}, {
+		should: "reject a missing stream",
+		info:   incept.TicketInfo{Streams: []string{"nope"}},
+		tkts:   []incept.Ticket{one},
+		expect: "no such stream `nope`",
+	}, {
+		should: "reject a missing convo",
+		info:   incept.TicketInfo{Convos: []string{"nope"}},
+		tkts:   []incept.Ticket{one},
+		expect: "no such convo `nope`",
+	}, {
+		should: "issue a ticket reserving a name",
+		info:   incept.TicketInfo{Username: "jim"},
+		tkts:   []incept.Ticket{one},
+	}, {
+		should: "reject a name reserved by another ticket",
+		info:   incept.TicketInfo{Username: "jim"},
+		tkts:   []incept.Ticket{two},
+		expect: "username `jim` is reserved",
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		err := s.db.Update(incept.IssueTickets(&test.info, test.tkts...))
+		if test.expect != "" {
+			c.Check(err, ErrorMatches, test.expect)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Check(s.db.View(assertTicketsExist(c, test.tkts...)), IsNil)
+	}
+}
+
+func (s *InceptSuite) TestInceptTicketInfo(c *C) {
+	c.Assert(s.db.Update(store.SetupBuckets(
+		users.UserBucket,
+		users.LedgerBucket,
+		auth.LoginBucket,
+		stream.StreamBucket,
+		convo.ConvoBucket,
+	)), IsNil)
+
+	str := &stream.Stream{
+		ID:    "str",
+		Group: users.Group{Owner: "bob"},
+	}
+	cnv := &convo.Convo{
+		ID:    "cnv",
+		Group: users.Group{Owner: "bob"},
+	}
+	c.Assert(s.db.Update(store.Wrap(
+		stream.Upsert(str),
+		convo.Upsert(cnv),
+	)), IsNil)
+
+	var (
+		past     = time.Now().Add(-time.Hour)
+		future   = time.Now().Add(time.Hour)
+		expired  = incept.Ticket(uuid.NewV4())
+		reserved = incept.Ticket(uuid.NewV4())
+		multi    = incept.Ticket(uuid.NewV4())
+	)
+
+	c.Assert(s.db.Update(store.Wrap(
+		incept.IssueTickets(&incept.TicketInfo{
+			Expires: &future,
+		}, expired),
+		incept.IssueTickets(&incept.TicketInfo{
+			Username: "jim",
+		}, reserved),
+		incept.IssueTickets(&incept.TicketInfo{
+			Creator: "root",
+			Expires: &future,
+			MaxUses: 2,
+			Coin:    10,
+			Streams: []string{"str"},
+			Convos:  []string{"cnv"},
+		}, multi),
+	)), IsNil)
+
+	// Expire the first ticket.
+	info := new(incept.TicketInfo)
+	c.Assert(s.db.View(incept.GetTicketInfo(expired, info)), IsNil)
+	info.Expires = &past
+	c.Assert(s.db.Update(store.Marshal(
+		incept.TicketBucket, info, expired.Bytes(),
+	)), IsNil)
+
+	c.Log("an expired ticket can't be used")
+	err := incept.Incept(expired, login("amy"), s.db)
+	c.Check(err, FitsTypeOf, incept.ErrTicketExpired(""))
+
+	c.Log("a reserved name can't be taken with another ticket")
+	err = incept.Incept(multi, login("jim"), s.db)
+	c.Check(err, FitsTypeOf, incept.ErrReserved(""))
+
+	c.Log("a reserving ticket can't be used for another name")
+	err = incept.Incept(reserved, login("amy"), s.db)
+	c.Check(err, FitsTypeOf, incept.ErrReserved(""))
+
+	c.Log("a reserving ticket can be used for its name")
+	c.Assert(incept.Incept(reserved, login("jim"), s.db), IsNil)
+	c.Check(s.db.View(assertNoTicket(c, reserved)), IsNil)
+
+	c.Log("a multi-use ticket gives coin and memberships")
+	amy := login("amy")
+	c.Assert(incept.Incept(multi, amy, s.db), IsNil)
+	c.Check(amy.Coin, Equals, int64(10))
+	c.Assert(s.db.View(incept.GetTicketInfo(multi, info)), IsNil)
+	c.Check(info.Uses, Equals, 1)
+	c.Check(info.Creator, Equals, "root")
+
+	c.Assert(incept.Incept(multi, login("ann"), s.db), IsNil)
+	c.Check(s.db.View(assertNoTicket(c, multi)), IsNil)
+
+	got := new(users.User)
+	c.Assert(s.db.View(store.Unmarshal(
+		users.UserBucket, got, []byte("ann"),
+	)), IsNil)
+	c.Check(got.Coin, Equals, int64(10))
+
+	c.Assert(s.db.View(stream.Get(str, "str")), IsNil)
+	c.Check(str.Readers, DeepEquals, map[string]bool{"amy": true, "ann": true})
+	c.Check(str.Writers, DeepEquals, map[string]bool{"amy": true, "ann": true})
+	c.Assert(s.db.View(convo.Get(cnv, "cnv")), IsNil)
+	c.Check(cnv.Readers, DeepEquals, map[string]bool{"amy": true, "ann": true})
+
+	c.Log("a used up ticket is gone")
+	err = incept.Incept(multi, login("art"), s.db)
+	c.Check(err, FitsTypeOf, incept.ErrTicketMissing(""))
+}
@@ -0,0 +1,62 @@
+package mail
+
+import (
+	"encoding/json"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+)
+
+// AddressBucket holds users' Addresses by user ID.
+var AddressBucket = store.Bucket("mail-addresses")
+
+// Address is where a user gets mail, and which mail they want.
+type Address struct {
+	Email string `json:"email"`
+
+	// Digest is true if the user wants notif digests.
+	Digest bool `json:"digest"`
+}
+
+// SetAddress returns a function which sets the given user's Address.
+// An empty Email deletes it.
+func SetAddress(userID string, a *Address) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if a.Email == "" {
+			return store.Delete(AddressBucket, []byte(userID))(tx)
+		}
+		if err := ValidAddress(a.Email); err != nil {
+			return err
+		}
+		return store.Marshal(AddressBucket, a, []byte(userID))(tx)
+	}
+}
+
+// GetAddress returns a function which loads the given user's Address.
+// If they have none, into is left empty.
+func GetAddress(userID string, into *Address) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		err := store.Unmarshal(AddressBucket, into, []byte(userID))(tx)
+		if store.IsMissing(err) {
+			*into = Address{}
+			return nil
+		}
+		return err
+	}
+}
+
+// getDigestUsers returns a function which loads the Addresses of users
+// who want digests.
+func getDigestUsers(into map[string]*Address) func(*bolt.Tx) error {
+	return store.ForEach(AddressBucket, func(k, v []byte) error {
+		a := new(Address)
+		if err := json.Unmarshal(v, a); err != nil {
+			return err
+		}
+		if a.Digest {
+			into[string(k)] = a
+		}
+		return nil
+	})
+}
@@ -0,0 +1,131 @@
+package mail
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/notif"
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+)
+
+// Digest limits.  A user's digest lists at most MaxDigestItems notifs,
+// each summarized in at most MaxSummary bytes.
+var (
+	MaxDigestItems = 50
+	MaxSummary     = 120
+)
+
+// DigestItem is a notif in a digest.
+type DigestItem struct {
+	At       time.Time
+	Resource store.Resource
+	Summary  string
+}
+
+// Digester collects the notifs of users who want digests, and queues
+// them as digest Messages when flushed.  Use Observe with notif.Observe.
+type Digester struct {
+	*bolt.DB
+	*Mailer
+
+	mu      sync.Mutex
+	since   time.Time
+	users   map[notif.UserTopic]string
+	addrs   map[string]*Address
+	pending map[string][]DigestItem
+}
+
+// NewDigester returns a Digester for the users who want digests as of
+// now.
+func NewDigester(db *bolt.DB, m *Mailer, now time.Time) (*Digester, error) {
+	d := &Digester{DB: db, Mailer: m, since: now}
+	if err := d.load(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// load reloads which users want digests.  Pending items of users who no
+// longer do are dropped.
+func (d *Digester) load() error {
+	addrs := make(map[string]*Address)
+	if err := d.View(getDigestUsers(addrs)); err != nil {
+		return err
+	}
+
+	users := make(map[notif.UserTopic]string, len(addrs))
+	for u := range addrs {
+		users[notif.MakeUserTopic(u)] = u
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users, d.addrs = users, addrs
+	for u := range d.pending {
+		if addrs[u] == nil {
+			delete(d.pending, u)
+		}
+	}
+	return nil
+}
+
+// summarize returns a short description of a notif.
+func summarize(val store.Resourcer) string {
+	bs, err := json.Marshal(val)
+	if err != nil {
+		return string(val.Resource())
+	}
+	s := fmt.Sprintf("%s %s", val.Resource(), bs)
+	if len(s) > MaxSummary {
+		s = s[:MaxSummary-3] + "..."
+	}
+	return s
+}
+
+// Observe implements notif.Observer on Digester.
+func (d *Digester) Observe(t notif.UserTopic, val store.Resourcer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	u, ok := d.users[t]
+	if !ok || len(d.pending[u]) >= MaxDigestItems {
+		return
+	}
+	if d.pending == nil {
+		d.pending = make(map[string][]DigestItem)
+	}
+	d.pending[u] = append(d.pending[u], DigestItem{
+		At:       time.Now(),
+		Resource: val.Resource(),
+		Summary:  summarize(val),
+	})
+}
+
+// Flush queues a digest for each user with pending notifs, and reloads
+// which users want digests.
+func (d *Digester) Flush(now time.Time) error {
+	d.mu.Lock()
+	pending, addrs, since := d.pending, d.addrs, d.since
+	d.pending, d.since = nil, now
+	d.mu.Unlock()
+
+	var queue []func(*bolt.Tx) error
+	for u, items := range pending {
+		a := addrs[u]
+		if a == nil || len(items) == 0 {
+			continue
+		}
+		queue = append(queue, d.Digest(a.Email, u, since, items, now))
+	}
+	if len(queue) > 0 {
+		if err := d.Update(store.Wrap(queue...)); err != nil {
+			return err
+		}
+	}
+
+	return d.load()
+}
@@ -0,0 +1,165 @@
+// Package mail delivers templated messages, such as invite links and
+// password resets, through an SMTP relay.  Messages are queued in the
+// database and retried with backoff until they are sent.
+package mail
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	netmail "net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// QueueBucket holds Messages waiting to be sent, by ID.
+var QueueBucket = store.Bucket("mail-queue")
+
+// Delivery limits.  A Message which fails to send is retried after
+// RetryDelay, doubling with each failure up to MaxRetryDelay, until it
+// has been tried MaxAttempts times.
+var (
+	MaxAttempts   = 8
+	RetryDelay    = time.Minute
+	MaxRetryDelay = 6 * time.Hour
+)
+
+// Message is an email waiting to be sent.
+type Message struct {
+	ID      string   `json:"id"`
+	To      []string `json:"to"`
+	Subject string   `json:"subject"`
+	Body    string   `json:"body"`
+
+	Created   time.Time `json:"created"`
+	Attempts  int       `json:"attempts"`
+	NextTry   time.Time `json:"nextTry"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// ValidAddress returns an error if the given string is not a single
+// bare email address, such as "bob@example.com".
+func ValidAddress(addr string) error {
+	a, err := netmail.ParseAddress(addr)
+	switch {
+	case err != nil:
+		return errors.Wrapf(err, "invalid email address %#q", addr)
+	case a.Name != "" || a.Address != addr:
+		return errors.Errorf("invalid email address %#q: "+
+			"must be a bare address", addr)
+	}
+	return nil
+}
+
+// Enqueue returns a function which validates the given Message and
+// queues it to be sent as soon as possible.  Its ID is set.
+func Enqueue(m *Message, now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if len(m.To) == 0 {
+			return errors.New("message has no recipients")
+		}
+		for _, to := range m.To {
+			if err := ValidAddress(to); err != nil {
+				return err
+			}
+		}
+		if strings.ContainsAny(m.Subject, "\r\n") {
+			return errors.New("message subject must be one line")
+		}
+
+		m.ID = uuid.NewV4().String()
+		m.Created = now
+		m.NextTry = now
+		return store.Marshal(QueueBucket, m, []byte(m.ID))(tx)
+	}
+}
+
+// GetQueue returns a function which loads all queued Messages, oldest
+// first.
+func GetQueue(into *[]*Message) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var result []*Message
+		err := store.ForEach(QueueBucket, func(k, v []byte) error {
+			next := new(Message)
+			if err := json.Unmarshal(v, next); err != nil {
+				return errors.Wrapf(err,
+					"failed to unmarshal message %#q", k)
+			}
+			result = append(result, next)
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(result, func(i, j int) bool {
+			return result[i].Created.Before(result[j].Created)
+		})
+		*into = result
+		return nil
+	}
+}
+
+// Permanent returns true if the given error from a Sender means the
+// Message will never be accepted, such as an SMTP 5xx reply.
+func Permanent(err error) bool {
+	e, ok := errors.Cause(err).(*textproto.Error)
+	return ok && e.Code >= 500
+}
+
+// failed records a failed attempt to send m at the given time, and
+// returns true if it should be retried.
+func (m *Message) failed(err error, now time.Time) bool {
+	m.Attempts++
+	m.LastError = err.Error()
+	if Permanent(err) || m.Attempts >= MaxAttempts {
+		return false
+	}
+
+	delay := RetryDelay << uint(m.Attempts-1)
+	if delay > MaxRetryDelay || delay <= 0 {
+		delay = MaxRetryDelay
+	}
+	m.NextTry = now.Add(delay)
+	return true
+}
+
+// Format returns the Message as an RFC 5322 message from the given
+// address, sent at the given time.
+func (m *Message) Format(from string, now time.Time) []byte {
+	var buf bytes.Buffer
+	header := func(k, v string) { fmt.Fprintf(&buf, "%s: %s\r\n", k, v) }
+
+	header("From", from)
+	header("To", strings.Join(m.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", "<"+m.ID+"@"+domain(from)+">")
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "8bit")
+	buf.WriteString("\r\n")
+
+	body := strings.Replace(m.Body, "\r\n", "\n", -1)
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	if !strings.HasSuffix(body, "\n") {
+		buf.WriteString("\r\n")
+	}
+	return buf.Bytes()
+}
+
+func domain(addr string) string {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		return strings.TrimSuffix(addr[i+1:], ">")
+	}
+	return "localhost"
+}
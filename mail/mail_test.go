@@ -0,0 +1,262 @@
+package mail_test
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/mail"
+	"github.com/synapse-garden/sg-proto/notif"
+	"github.com/synapse-garden/sg-proto/store"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+
+	"github.com/boltdb/bolt"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MailSuite struct {
+	tmpDir string
+	db     *bolt.DB
+}
+
+var _ = Suite(&MailSuite{})
+
+func (s *MailSuite) SetUpTest(c *C) {
+	db, tmpDir, err := sgt.TempDB("sg-test")
+	c.Assert(err, IsNil)
+	c.Assert(db.Update(store.SetupBuckets(
+		mail.QueueBucket,
+		mail.AddressBucket,
+	)), IsNil)
+	s.db, s.tmpDir = db, tmpDir
+}
+
+func (s *MailSuite) TearDownTest(c *C) {
+	if db := s.db; db != nil {
+		c.Assert(sgt.CleanupDB(db), IsNil)
+		c.Assert(os.Remove(s.tmpDir), IsNil)
+	}
+}
+
+func (s *MailSuite) getQueue(c *C) []*mail.Message {
+	var queue []*mail.Message
+	c.Assert(s.db.View(mail.GetQueue(&queue)), IsNil)
+	return queue
+}
+
+func (s *MailSuite) TestValidAddress(c *C) {
+	for i, test := range []struct {
+		given     string
+		expectErr string
+	}{{
+		given: "bob@example.com",
+	}, {
+		given:     "",
+		expectErr: "invalid email address ``: mail: no address",
+	}, {
+		given:     "bob",
+		expectErr: "invalid email address `bob`: mail: missing '@' or angle-addr",
+	}, {
+		given: "Bob <bob@example.com>",
+		expectErr: "invalid email address `Bob <bob@example.com>`: " +
+			"must be a bare address",
+	}} {
+		c.Logf("test %d: %#q", i, test.given)
+		err := mail.ValidAddress(test.given)
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+		} else {
+			c.Check(err, IsNil)
+		}
+	}
+}
+
+func (s *MailSuite) TestEnqueue(c *C) {
+	now := time.Now()
+	for i, test := range []struct {
+		should    string
+		given     *mail.Message
+		expectErr string
+	}{{
+		should:    "require a recipient",
+		given:     &mail.Message{Subject: "hi"},
+		expectErr: "message has no recipients",
+	}, {
+		should: "require valid recipients",
+		given: &mail.Message{
+			To:      []string{"bob@example.com", "alice"},
+			Subject: "hi",
+		},
+		expectErr: "invalid email address `alice`: .*",
+	}, {
+		should: "reject a multi-line subject",
+		given: &mail.Message{
+			To:      []string{"bob@example.com"},
+			Subject: "hi\r\nBcc: eve@example.com",
+		},
+		expectErr: "message subject must be one line",
+	}, {
+		should: "queue a valid message",
+		given: &mail.Message{
+			To:      []string{"bob@example.com"},
+			Subject: "hi",
+			Body:    "hello",
+		},
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		err := s.db.Update(mail.Enqueue(test.given, now))
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Check(test.given.ID, Not(Equals), "")
+	}
+
+	queue := s.getQueue(c)
+	c.Assert(queue, HasLen, 1)
+	c.Check(queue[0].Subject, Equals, "hi")
+	c.Check(queue[0].NextTry.Equal(now), Equals, true)
+}
+
+func (s *MailSuite) TestDeliver(c *C) {
+	srv, err := mail.NewServer()
+	c.Assert(err, IsNil)
+	defer func() { c.Check(srv.Close(), IsNil) }()
+
+	var (
+		relay = &mail.Relay{Addr: srv.Addr(), From: "sg@example.com"}
+		now   = time.Now()
+		msg   = &mail.Message{
+			To:      []string{"bob@example.com"},
+			Subject: "héllo",
+			Body:    "line one\nline two\n",
+		}
+	)
+	c.Assert(s.db.Update(mail.Enqueue(msg, now)), IsNil)
+
+	c.Log("A temporary failure is retried after RetryDelay.")
+	srv.Fail(451)
+	sent, err := mail.Deliver(s.db, relay, now)
+	c.Assert(err, IsNil)
+	c.Check(sent, Equals, 0)
+	queue := s.getQueue(c)
+	c.Assert(queue, HasLen, 1)
+	c.Check(queue[0].Attempts, Equals, 1)
+	c.Check(queue[0].NextTry.Equal(now.Add(mail.RetryDelay)), Equals, true)
+	c.Check(queue[0].LastError, Matches, ".*451.*")
+
+	c.Log("It isn't retried before then.")
+	sent, err = mail.Deliver(s.db, relay, now.Add(time.Second))
+	c.Assert(err, IsNil)
+	c.Check(sent, Equals, 0)
+	c.Check(srv.Received(), HasLen, 0)
+
+	c.Log("Once due, it is sent and removed from the queue.")
+	sent, err = mail.Deliver(s.db, relay, now.Add(mail.RetryDelay))
+	c.Assert(err, IsNil)
+	c.Check(sent, Equals, 1)
+	c.Check(s.getQueue(c), HasLen, 0)
+
+	got := srv.Received()
+	c.Assert(got, HasLen, 1)
+	c.Check(got[0].From, Equals, "sg@example.com")
+	c.Check(got[0].To, DeepEquals, []string{"bob@example.com"})
+	c.Check(strings.Contains(got[0].Data,
+		"Subject: =?utf-8?q?h=C3=A9llo?=\n"), Equals, true)
+	c.Check(strings.HasSuffix(got[0].Data,
+		"\n\nline one\nline two\n"), Equals, true)
+
+	c.Log("A permanent failure is dropped.")
+	c.Assert(s.db.Update(mail.Enqueue(&mail.Message{
+		To:      []string{"nobody@example.com"},
+		Subject: "hi",
+	}, now)), IsNil)
+	srv.Fail(550)
+	sent, err = mail.Deliver(s.db, relay, now)
+	c.Assert(err, IsNil)
+	c.Check(sent, Equals, 0)
+	c.Check(s.getQueue(c), HasLen, 0)
+	c.Check(srv.Received(), HasLen, 1)
+}
+
+func (s *MailSuite) TestMailer(c *C) {
+	var (
+		m       = &mail.Mailer{BaseURL: "https://sg.example.com/"}
+		now     = time.Date(2017, 1, 2, 3, 4, 5, 0, time.UTC)
+		expires = now.Add(time.Hour)
+	)
+
+	c.Check(m.Link("incept", "abc"), Equals,
+		"https://sg.example.com/incept/abc")
+
+	c.Assert(s.db.Update(store.Wrap(
+		m.Reset("bob@example.com", "bob", "tok", expires, now),
+		m.Digest("bob@example.com", "bob", now, []mail.DigestItem{{
+			At:      expires,
+			Summary: "convo-message hello",
+		}}, expires),
+	)), IsNil)
+
+	queue := s.getQueue(c)
+	c.Assert(queue, HasLen, 2)
+
+	c.Check(queue[0].Subject, Equals, "Reset your SG password")
+	c.Check(strings.Contains(queue[0].Body,
+		"https://sg.example.com/resets/tok"), Equals, true)
+	c.Check(strings.Contains(queue[0].Body,
+		expires.Format(time.RFC1123)), Equals, true)
+
+	c.Check(queue[1].Subject, Equals, "1 new notification on SG")
+	c.Check(strings.Contains(queue[1].Body,
+		"convo-message hello"), Equals, true)
+}
+
+type testNotif string
+
+func (testNotif) Resource() store.Resource { return "test" }
+
+func (s *MailSuite) TestDigester(c *C) {
+	var (
+		m   = &mail.Mailer{BaseURL: "https://sg.example.com"}
+		now = time.Now()
+	)
+	c.Assert(s.db.Update(store.Wrap(
+		mail.SetAddress("bob", &mail.Address{
+			Email:  "bob@example.com",
+			Digest: true,
+		}),
+		mail.SetAddress("alice", &mail.Address{
+			Email: "alice@example.com",
+		}),
+	)), IsNil)
+
+	d, err := mail.NewDigester(s.db, m, now)
+	c.Assert(err, IsNil)
+
+	c.Log("Only users who want digests get them.")
+	d.Observe(notif.MakeUserTopic("bob"), testNotif("one"))
+	d.Observe(notif.MakeUserTopic("bob"), testNotif("two"))
+	d.Observe(notif.MakeUserTopic("alice"), testNotif("three"))
+	c.Assert(d.Flush(now), IsNil)
+
+	queue := s.getQueue(c)
+	c.Assert(queue, HasLen, 1)
+	c.Check(queue[0].To, DeepEquals, []string{"bob@example.com"})
+	c.Check(queue[0].Subject, Equals, "2 new notifications on SG")
+	c.Check(strings.Contains(queue[0].Body, `test "two"`), Equals, true)
+
+	c.Log("Nothing is queued without new notifs.")
+	c.Assert(d.Flush(now), IsNil)
+	c.Check(s.getQueue(c), HasLen, 1)
+
+	c.Log("Flushing picks up changed preferences.")
+	c.Assert(s.db.Update(mail.SetAddress("bob", &mail.Address{})), IsNil)
+	c.Assert(d.Flush(now), IsNil)
+	d.Observe(notif.MakeUserTopic("bob"), testNotif("four"))
+	c.Assert(d.Flush(now), IsNil)
+	c.Check(s.getQueue(c), HasLen, 1)
+}
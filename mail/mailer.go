@@ -0,0 +1,120 @@
+package mail
+
+import (
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/incept"
+
+	"github.com/boltdb/bolt"
+)
+
+// Mailer renders templated Messages and queues them.  It is safe to
+// use a Mailer from many goroutines.
+type Mailer struct {
+	// BaseURL is what links in Messages are relative to, such as
+	// "https://sg.example.com".
+	BaseURL string
+
+	// Templates, if set, are used instead of DefaultTemplates.
+	Templates *template.Template
+}
+
+// InviteData is the data for the invite template.
+type InviteData struct {
+	Inviter string
+	Link    string
+	Expires time.Time
+}
+
+// ResetData is the data for the reset template.
+type ResetData struct {
+	Name    string
+	Link    string
+	Expires time.Time
+}
+
+// DigestData is the data for the digest template.
+type DigestData struct {
+	Name  string
+	Since time.Time
+	Items []DigestItem
+}
+
+func (m *Mailer) templates() *template.Template {
+	if m.Templates == nil {
+		return DefaultTemplates
+	}
+	return m.Templates
+}
+
+// Link returns the absolute URL of the given path.
+func (m *Mailer) Link(path ...string) string {
+	return strings.TrimSuffix(m.BaseURL, "/") + "/" + strings.Join(path, "/")
+}
+
+// queue returns a function which renders the named template with the
+// given data and queues it for the given address.
+func (m *Mailer) queue(
+	to, name string,
+	data interface{},
+	now time.Time,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		subject, body, err := Render(m.templates(), name, data)
+		if err != nil {
+			return err
+		}
+		return Enqueue(&Message{
+			To:      []string{to},
+			Subject: subject,
+			Body:    body,
+		}, now)(tx)
+	}
+}
+
+// Invite returns a function which queues an invite with a link to
+// incept using the given Ticket.  The inviter is optional.
+func (m *Mailer) Invite(
+	to, inviter string,
+	t incept.Ticket,
+	expires *time.Time,
+	now time.Time,
+) func(*bolt.Tx) error {
+	data := &InviteData{
+		Inviter: inviter,
+		Link:    m.Link("incept", t.String()),
+	}
+	if expires != nil {
+		data.Expires = *expires
+	}
+	return m.queue(to, InviteTemplate, data, now)
+}
+
+// Reset returns a function which queues a password reset link for the
+// given reset token.
+func (m *Mailer) Reset(
+	to, name, token string,
+	expires, now time.Time,
+) func(*bolt.Tx) error {
+	return m.queue(to, ResetTemplate, &ResetData{
+		Name:    name,
+		Link:    m.Link("resets", token),
+		Expires: expires,
+	}, now)
+}
+
+// Digest returns a function which queues a digest of the given items.
+func (m *Mailer) Digest(
+	to, name string,
+	since time.Time,
+	items []DigestItem,
+	now time.Time,
+) func(*bolt.Tx) error {
+	return m.queue(to, DigestTemplate, &DigestData{
+		Name:  name,
+		Since: since,
+		Items: items,
+	}, now)
+}
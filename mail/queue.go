@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"log"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+)
+
+// Deliver tries to send each queued Message which is due at the given
+// time using s, and returns how many were sent.  Sent Messages are
+// removed from the queue.  Failed Messages are retried later, unless
+// the failure was permanent or they have been tried MaxAttempts times,
+// in which case they are dropped and logged.
+func Deliver(db *bolt.DB, s Sender, now time.Time) (int, error) {
+	var queue []*Message
+	if err := db.View(GetQueue(&queue)); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, m := range queue {
+		if m.NextTry.After(now) {
+			continue
+		}
+
+		// Don't hold a transaction open while talking to the relay.
+		err := s.Send(m)
+		var update func(*bolt.Tx) error
+		switch {
+		case err == nil:
+			sent++
+			update = store.Delete(QueueBucket, []byte(m.ID))
+		case m.failed(err, now):
+			update = store.Marshal(QueueBucket, m, []byte(m.ID))
+		default:
+			log.Printf("dropping mail %#q to %v after %d attempts: %s",
+				m.ID, m.To, m.Attempts, err.Error())
+			update = store.Delete(QueueBucket, []byte(m.ID))
+		}
+
+		if err := db.Update(update); err != nil {
+			return sent, err
+		}
+	}
+
+	return sent, nil
+}
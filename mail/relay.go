@@ -0,0 +1,29 @@
+package mail
+
+import (
+	"net/smtp"
+	"time"
+)
+
+// Sender sends a Message.
+type Sender interface {
+	Send(*Message) error
+}
+
+// Relay is a Sender using an SMTP relay.  STARTTLS is used if the relay
+// offers it, and Auth, if set, is only used over TLS or to localhost.
+type Relay struct {
+	// Addr is the relay's host:port.
+	Addr string
+	// From is the address Messages are sent from.
+	From string
+	Auth smtp.Auth
+}
+
+var _ = Sender(new(Relay))
+
+// Send implements Sender.Send on Relay.
+func (r *Relay) Send(m *Message) error {
+	return smtp.SendMail(r.Addr, r.Auth, r.From, m.To,
+		m.Format(r.From, time.Now()))
+}
@@ -0,0 +1,163 @@
+package mail
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Received is a message received by a Server.
+type Received struct {
+	From string
+	To   []string
+	Data string
+}
+
+// Server is a minimal in-process SMTP server which keeps the messages
+// it receives.  It is meant for tests and local development.
+type Server struct {
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu       sync.Mutex
+	received []Received
+	failures []int
+}
+
+// NewServer starts a Server on a local port.
+func NewServer() (*Server, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{ln: ln}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the Server's host:port.
+func (s *Server) Addr() string { return s.ln.Addr().String() }
+
+// Close stops the Server and waits for its connections to finish.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Received returns the messages the Server has received.
+func (s *Server) Received() []Received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Received(nil), s.received...)
+}
+
+// Fail makes the Server reject the next messages' data with the given
+// SMTP reply codes, in order.
+func (s *Server) Fail(codes ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, codes...)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(textproto.NewConn(conn))
+		}()
+	}
+}
+
+func (s *Server) handle(c *textproto.Conn) {
+	reply := func(code int, msg string) error {
+		return c.PrintfLine("%d %s", code, msg)
+	}
+
+	if reply(220, "localhost ESMTP") != nil {
+		return
+	}
+
+	var msg Received
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+
+		switch strings.ToUpper(verb) {
+		case "HELO", "EHLO":
+			err = reply(250, "localhost")
+		case "MAIL":
+			msg = Received{From: path(arg)}
+			err = reply(250, "OK")
+		case "RCPT":
+			msg.To = append(msg.To, path(arg))
+			err = reply(250, "OK")
+		case "DATA":
+			if err = reply(354, "go ahead"); err != nil {
+				return
+			}
+			var data []byte
+			if data, err = c.ReadDotBytes(); err != nil {
+				return
+			}
+			msg.Data = string(data)
+			err = s.accept(msg, reply)
+			msg = Received{}
+		case "RSET":
+			msg = Received{}
+			err = reply(250, "OK")
+		case "NOOP":
+			err = reply(250, "OK")
+		case "QUIT":
+			reply(221, "bye")
+			return
+		default:
+			err = reply(502, "not implemented")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// accept keeps msg, unless a failure is pending.
+func (s *Server) accept(msg Received, reply func(int, string) error) error {
+	s.mu.Lock()
+	if len(s.failures) > 0 {
+		code := s.failures[0]
+		s.failures = s.failures[1:]
+		s.mu.Unlock()
+		return reply(code, "rejected")
+	}
+	s.received = append(s.received, msg)
+	s.mu.Unlock()
+	return reply(250, "OK")
+}
+
+// path returns the address in a "FROM:<addr>" or "TO:<addr>" argument.
+func path(arg string) string {
+	if i := strings.IndexByte(arg, '<'); i >= 0 {
+		arg = arg[i+1:]
+	}
+	if i := strings.IndexByte(arg, '>'); i >= 0 {
+		arg = arg[:i]
+	}
+	return arg
+}
@@ -0,0 +1,50 @@
+package mail
+
+import (
+	"log"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// Service delivers queued Messages every Interval.  If it has a
+// Digester, it also flushes it every DigestInterval.
+type Service struct {
+	*bolt.DB
+	Sender
+
+	Interval time.Duration
+
+	Digester       *Digester
+	DigestInterval time.Duration
+}
+
+// Run runs the Service until stop is closed.
+func (s *Service) Run(stop <-chan struct{}) {
+	deliver := time.NewTicker(s.Interval)
+	defer deliver.Stop()
+
+	var digest <-chan time.Time
+	if s.Digester != nil && s.DigestInterval > 0 {
+		t := time.NewTicker(s.DigestInterval)
+		defer t.Stop()
+		digest = t.C
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-digest:
+			if err := s.Digester.Flush(now); err != nil {
+				log.Printf("ERROR: failed to flush mail digests: %s",
+					err.Error())
+			}
+		case now := <-deliver.C:
+			if _, err := Deliver(s.DB, s.Sender, now); err != nil {
+				log.Printf("ERROR: failed to deliver mail: %s",
+					err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package mail
+
+import (
+	"bytes"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Template names.  Each has a "<name>.subject" and a "<name>.body"
+// template.
+const (
+	InviteTemplate = "invite"
+	ResetTemplate  = "reset"
+	DigestTemplate = "digest"
+)
+
+// DefaultTemplates are used by a Mailer without Templates.
+var DefaultTemplates = template.Must(template.New("mail").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format(time.RFC1123) },
+}).Parse(`
+{{- define "invite.subject"}}You're invited to SG{{end}}
+
+{{- define "invite.body" -}}
+{{if .Inviter}}{{.Inviter}} has invited you{{else}}You have been invited{{end}} to join SG.
+
+Use this link to create your account:
+
+  {{.Link}}
+{{if not .Expires.IsZero}}
+The invitation expires {{date .Expires}}.
+{{end -}}
+{{end}}
+
+{{- define "reset.subject"}}Reset your SG password{{end}}
+
+{{- define "reset.body" -}}
+Someone asked to reset the password of your SG account, {{.Name}}.
+
+If it was you, use this link to choose a new password:
+
+  {{.Link}}
+
+The link expires {{date .Expires}}.  If you didn't ask for this, you
+can ignore this message.
+{{end}}
+
+{{- define "digest.subject"}}{{len .Items}} new notification{{if ne (len .Items) 1}}s{{end}} on SG{{end}}
+
+{{- define "digest.body" -}}
+Hi {{.Name}}, here's what happened since {{date .Since}}:
+{{range .Items}}
+  - {{date .At}}: {{.Summary}}
+{{- end}}
+{{end}}
+`))
+
+// Render executes the subject and body templates of the given name with
+// the given data.
+func Render(t *template.Template, name string, data interface{}) (subject, body string, err error) {
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name+".subject", data); err != nil {
+		return "", "", errors.Wrapf(err, "failed to render %s subject", name)
+	}
+	subject = strings.TrimSpace(buf.String())
+
+	buf.Reset()
+	if err := t.ExecuteTemplate(&buf, name+".body", data); err != nil {
+		return "", "", errors.Wrapf(err, "failed to render %s body", name)
+	}
+	return subject, buf.String(), nil
+}
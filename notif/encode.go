@@ -2,6 +2,7 @@ package notif
 
 import (
 	js "encoding/json"
+	"sync"
 
 	"github.com/synapse-garden/sg-proto/store"
 	"github.com/synapse-garden/sg-proto/stream/river"
@@ -32,8 +33,85 @@ func (json) Encode(r river.Pub, val store.Resourcer, t UserTopic) error {
 	return r.Send(river.BytesFor(t, boxBs))
 }
 
+// Observer is told about each notif sent using Encode.  It must not
+// block.
+type Observer func(UserTopic, store.Resourcer)
+
+var (
+	observersMu sync.RWMutex
+	observers   = make(map[*Observer]bool)
+)
+
+// Observe calls o with each notif sent using Encode until the returned
+// cancel func is called.
+func Observe(o Observer) (cancel func()) {
+	key := &o
+	observersMu.Lock()
+	observers[key] = true
+	observersMu.Unlock()
+
+	return func() {
+		observersMu.Lock()
+		delete(observers, key)
+		observersMu.Unlock()
+	}
+}
+
+// Actor is implemented by notifs which were caused by a user, so that
+// users who don't want to hear from them can Drop them.
+type Actor interface {
+	Actor() string
+}
+
+// Dropper returns true if the given notif should not be sent on the
+// given Topic.
+type Dropper func(UserTopic, store.Resourcer) bool
+
+var (
+	droppersMu sync.RWMutex
+	droppers   = make(map[*Dropper]bool)
+)
+
+// Drop makes Encode drop each notif d returns true for, until the
+// returned cancel func is called.
+func Drop(d Dropper) (cancel func()) {
+	key := &d
+	droppersMu.Lock()
+	droppers[key] = true
+	droppersMu.Unlock()
+
+	return func() {
+		droppersMu.Lock()
+		delete(droppers, key)
+		droppersMu.Unlock()
+	}
+}
+
+// dropped returns true if any Dropper drops the notif.
+func dropped(t UserTopic, val store.Resourcer) bool {
+	droppersMu.RLock()
+	defer droppersMu.RUnlock()
+	for d := range droppers {
+		if (*d)(t, val) {
+			return true
+		}
+	}
+	return false
+}
+
 // Encode uses the default (JSON) Encoder to send the given value on r,
-// prefixed with t.
+// prefixed with t.  Any Observers are told about it.  If it is dropped
+// by a Dropper, nothing is sent and Observers are not told.
 func Encode(r river.Pub, val store.Resourcer, t UserTopic) error {
+	if dropped(t, val) {
+		return nil
+	}
+
+	observersMu.RLock()
+	for o := range observers {
+		(*o)(t, val)
+	}
+	observersMu.RUnlock()
+
 	return DefaultEncoder.Encode(r, val, t)
 }
@@ -67,3 +67,40 @@ func (s *NotifSuite) TestEncode(c *C) {
 
 	c.Check(notif.Encode(t, badResourcer{}, top), ErrorMatches, "json: .*oops")
 }
+
+type actedResourcer string
+
+func (actedResourcer) Resource() store.Resource { return store.Resource("acted") }
+
+func (a actedResourcer) Actor() string { return string(a) }
+
+func (s *NotifSuite) TestDrop(c *C) {
+	var (
+		t        = &testRiver{}
+		bob      = notif.MakeUserTopic("bob")
+		observed []store.Resourcer
+	)
+	defer notif.Observe(func(_ notif.UserTopic, val store.Resourcer) {
+		observed = append(observed, val)
+	})()
+
+	cancel := notif.Drop(func(to notif.UserTopic, val store.Resourcer) bool {
+		a, ok := val.(notif.Actor)
+		return to == bob && ok && a.Actor() == "alice"
+	})
+
+	c.Log("bob hears nothing from alice, but others can")
+	c.Assert(notif.Encode(t, actedResourcer("alice"), bob), IsNil)
+	c.Check(t.sends, HasLen, 0)
+	c.Check(observed, HasLen, 0)
+	c.Assert(notif.Encode(t, actedResourcer("carol"), bob), IsNil)
+	c.Assert(notif.Encode(t, actedResourcer("alice"),
+		notif.MakeUserTopic("carol")), IsNil)
+	c.Check(t.sends, HasLen, 2)
+	c.Check(observed, HasLen, 2)
+
+	c.Log("once cancelled, nothing is dropped")
+	cancel()
+	c.Assert(notif.Encode(t, actedResourcer("alice"), bob), IsNil)
+	c.Check(t.sends, HasLen, 3)
+}
@@ -0,0 +1,266 @@
+package presence
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/util"
+)
+
+// DefaultIdle is how long a connected user may do nothing before they
+// are Idle.
+const DefaultIdle = 5 * time.Minute
+
+// Status is whether a user is connected, and if so whether they have
+// been active lately.
+type Status string
+
+// Statuses a user may have.
+const (
+	Online  Status = "online"
+	Idle    Status = "idle"
+	Offline Status = "offline"
+)
+
+// Kind is the kind of thing a user is connected to.
+type Kind string
+
+// Kinds of Location.
+const (
+	Notifs Kind = "notifs"
+	Stream Kind = "stream"
+	Convo  Kind = "convo"
+)
+
+// Location is something a user is connected to, such as their notifs or
+// a Convo by ID.
+type Location struct {
+	Kind Kind
+	ID   string
+}
+
+// Presence is what is known about whether a user is around.
+type Presence struct {
+	User   string `json:"user"`
+	Status Status `json:"status"`
+
+	// LastActive is when the user last connected or did something,
+	// if they have since the Tracker started.
+	LastActive *time.Time `json:"lastActive,omitempty"`
+
+	// Streams and Convos are the IDs of those the user is connected
+	// to, in order.
+	Streams []string `json:"streams,omitempty"`
+	Convos  []string `json:"convos,omitempty"`
+}
+
+// Resource implements store.Resourcer on *Presence.
+func (*Presence) Resource() store.Resource { return "presence" }
+
+// Actor implements notif.Actor on *Presence.
+func (p *Presence) Actor() string { return p.User }
+
+// state is what a Tracker knows about one user.
+type state struct {
+	conns      map[Location]int
+	lastActive time.Time
+
+	// reported is the last Presence given to OnChange.
+	reported *Presence
+}
+
+// Tracker keeps each user's connections and last activity in memory.
+// OnChange, if set, is called with a user's new Presence when their
+// Status or Locations change.  Users becoming Idle are only noticed by
+// Sweep.
+type Tracker struct {
+	util.Timer
+	IdleAfter time.Duration
+	OnChange  func(*Presence)
+
+	mu    sync.Mutex
+	users map[string]*state
+}
+
+// NewTracker returns a new Tracker using the given Timer, which makes
+// users Idle after idleAfter.
+func NewTracker(timer util.Timer, idleAfter time.Duration) *Tracker {
+	return &Tracker{
+		Timer:     timer,
+		IdleAfter: idleAfter,
+		users:     make(map[string]*state),
+	}
+}
+
+// get returns the state of the given user, creating it if needed.  The
+// Tracker must be locked.
+func (t *Tracker) get(user string) *state {
+	st, ok := t.users[user]
+	if !ok {
+		st = &state{conns: make(map[Location]int)}
+		t.users[user] = st
+	}
+	return st
+}
+
+// presence computes the given user's Presence at now.  The Tracker must
+// be locked.
+func (t *Tracker) presence(user string, now time.Time) *Presence {
+	p := &Presence{User: user, Status: Offline}
+	st, ok := t.users[user]
+	if !ok {
+		return p
+	}
+
+	if !st.lastActive.IsZero() {
+		last := st.lastActive
+		p.LastActive = &last
+	}
+	if len(st.conns) == 0 {
+		return p
+	}
+
+	p.Status = Online
+	if now.Sub(st.lastActive) >= t.IdleAfter {
+		p.Status = Idle
+	}
+	for loc := range st.conns {
+		switch loc.Kind {
+		case Stream:
+			p.Streams = append(p.Streams, loc.ID)
+		case Convo:
+			p.Convos = append(p.Convos, loc.ID)
+		}
+	}
+	sort.Strings(p.Streams)
+	sort.Strings(p.Convos)
+	return p
+}
+
+// changed returns the given user's Presence if it differs from what
+// was last reported, and marks it reported.  The Tracker must be locked.
+func (t *Tracker) changed(user string, now time.Time) *Presence {
+	st := t.get(user)
+	p := t.presence(user, now)
+	if r := st.reported; r != nil && r.Status == p.Status &&
+		equal(r.Streams, p.Streams) && equal(r.Convos, p.Convos) {
+		return nil
+	}
+	st.reported = p
+	return p
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// update applies f to the given user's state under lock, and reports
+// any change to OnChange.
+func (t *Tracker) update(user string, f func(*state, time.Time)) {
+	t.mu.Lock()
+	if t.users == nil {
+		t.users = make(map[string]*state)
+	}
+	now := t.Now()
+	f(t.get(user), now)
+	p := t.changed(user, now)
+	onChange := t.OnChange
+	t.mu.Unlock()
+
+	if p != nil && onChange != nil {
+		onChange(p)
+	}
+}
+
+// Connect records that the user connected to the given Location, which
+// also counts as activity.
+func (t *Tracker) Connect(user string, loc Location) {
+	t.update(user, func(st *state, now time.Time) {
+		st.conns[loc]++
+		st.lastActive = now
+	})
+}
+
+// Disconnect records that one of the user's connections to the given
+// Location ended.
+func (t *Tracker) Disconnect(user string, loc Location) {
+	t.update(user, func(st *state, _ time.Time) {
+		switch n := st.conns[loc]; {
+		case n > 1:
+			st.conns[loc] = n - 1
+		case n == 1:
+			delete(st.conns, loc)
+		default:
+			log.Printf("presence: user %q disconnected from "+
+				"%s %q without connecting", user, loc.Kind, loc.ID)
+		}
+	})
+}
+
+// Touch records that the user did something.
+func (t *Tracker) Touch(user string) {
+	t.update(user, func(st *state, now time.Time) {
+		st.lastActive = now
+	})
+}
+
+// Get returns the Presence of each of the given users, in order.
+func (t *Tracker) Get(users ...string) []*Presence {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.Now()
+	result := make([]*Presence, len(users))
+	for i, u := range users {
+		result[i] = t.presence(u, now)
+	}
+	return result
+}
+
+// Sweep reports users whose Presence changed without them doing
+// anything, such as by becoming Idle, to OnChange.
+func (t *Tracker) Sweep() {
+	t.mu.Lock()
+	now := t.Now()
+	var changes []*Presence
+	for u := range t.users {
+		if p := t.changed(u, now); p != nil {
+			changes = append(changes, p)
+		}
+	}
+	onChange := t.OnChange
+	t.mu.Unlock()
+
+	if onChange == nil {
+		return
+	}
+	for _, p := range changes {
+		onChange(p)
+	}
+}
+
+// Run calls Sweep every interval until stop is closed.
+func (t *Tracker) Run(interval time.Duration, stop <-chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			t.Sweep()
+		}
+	}
+}
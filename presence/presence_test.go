@@ -0,0 +1,94 @@
+package presence_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/presence"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type PresenceSuite struct{}
+
+var _ = Suite(&PresenceSuite{})
+
+func (s *PresenceSuite) TestTracker(c *C) {
+	var (
+		start   = time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+		later   = start.Add(presence.DefaultIdle)
+		trk     = presence.NewTracker(sgt.Timer(start), presence.DefaultIdle)
+		changes []*presence.Presence
+		convo   = presence.Location{Kind: presence.Convo, ID: "c1"}
+		notifs  = presence.Location{Kind: presence.Notifs}
+	)
+	trk.OnChange = func(p *presence.Presence) {
+		changes = append(changes, p)
+	}
+	take := func() []*presence.Presence {
+		result := changes
+		changes = nil
+		return result
+	}
+
+	c.Log("unknown users are offline")
+	c.Check(trk.Get("bob"), DeepEquals, []*presence.Presence{{
+		User: "bob", Status: presence.Offline,
+	}})
+
+	c.Log("connecting makes bob online")
+	trk.Connect("bob", notifs)
+	online := &presence.Presence{
+		User: "bob", Status: presence.Online, LastActive: &start,
+	}
+	c.Check(take(), DeepEquals, []*presence.Presence{online})
+	c.Check(trk.Get("bob", "alice"), DeepEquals, []*presence.Presence{
+		online, {User: "alice", Status: presence.Offline},
+	})
+
+	c.Log("joining a convo twice is one change")
+	trk.Connect("bob", convo)
+	trk.Connect("bob", convo)
+	inConvo := &presence.Presence{
+		User: "bob", Status: presence.Online, LastActive: &start,
+		Convos: []string{"c1"},
+	}
+	c.Check(take(), DeepEquals, []*presence.Presence{inConvo})
+
+	c.Log("a sweep before idling changes nothing")
+	trk.Sweep()
+	c.Check(take(), HasLen, 0)
+
+	c.Log("a sweep after idling makes bob idle")
+	trk.Timer = sgt.Timer(later)
+	trk.Sweep()
+	idle := *inConvo
+	idle.Status = presence.Idle
+	c.Check(take(), DeepEquals, []*presence.Presence{&idle})
+
+	c.Log("activity makes him online again")
+	trk.Touch("bob")
+	c.Check(take(), DeepEquals, []*presence.Presence{{
+		User: "bob", Status: presence.Online, LastActive: &later,
+		Convos: []string{"c1"},
+	}})
+
+	c.Log("he is in the convo until both connections leave")
+	trk.Disconnect("bob", convo)
+	c.Check(take(), HasLen, 0)
+	trk.Disconnect("bob", convo)
+	c.Check(take(), DeepEquals, []*presence.Presence{{
+		User: "bob", Status: presence.Online, LastActive: &later,
+	}})
+
+	c.Log("offline users keep their last activity")
+	trk.Disconnect("bob", notifs)
+	offline := &presence.Presence{
+		User: "bob", Status: presence.Offline, LastActive: &later,
+	}
+	c.Check(take(), DeepEquals, []*presence.Presence{offline})
+	c.Check(trk.Get("bob"), DeepEquals, []*presence.Presence{offline})
+}
@@ -0,0 +1,176 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/synapse-garden/sg-proto/notif"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// bindActivity binds the timeline and comment endpoints of the Task API.
+func (t *Task) bindActivity(r *htr.Router) {
+	r.GET("/tasks/:id/timeline", t.AuthUser(
+		t.GetTimeline,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+	r.GET("/tasks/:id/comments", t.AuthUser(
+		t.GetComments,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+	r.POST("/tasks/:id/comments", t.AuthUser(
+		t.PostComment,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+}
+
+// logChanges returns a function which sets the Changes of act to those
+// from before to after, and records it on the log of the Task.  It must
+// run after the Task was changed, with its Notes loaded.
+func logChanges(act *task.Activity, before, after *task.Task) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) (err error) {
+		if act.Changes, err = task.Diff(before, after); err != nil {
+			return err
+		}
+		return task.RecordActivity(after.ID, act)(tx)
+	}
+}
+
+// notifyEntry notifies every user of the Task of a new Activity or
+// Comment on it.  Activities which were not recorded are ignored.
+func (t *Task) notifyEntry(tsk *task.Task, val store.Resourcer) {
+	if a, ok := val.(*task.Activity); ok && !a.Created && len(a.Changes) == 0 {
+		return
+	}
+	for u := range users.AllUsers(tsk.Group) {
+		notif.Encode(t.Pub, val, notif.MakeUserTopic(u))
+	}
+}
+
+// loadReadable loads the Task with the ID in the request path.  It
+// writes an error and returns false if the Task does not exist, or if
+// the user is not one of its users.
+func (t *Task) loadReadable(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps htr.Params,
+) (*task.Task, bool) {
+	tUUID, err := uuid.FromString(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "invalid task ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	tsk := new(task.Task)
+	err = t.View(store.Unmarshal(task.TaskBucket, tsk, tUUID[:]))
+	switch {
+	case store.IsMissing(err):
+		http.Error(w, "no such task", http.StatusNotFound)
+		return nil, false
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to find task",
+		).Error(), http.StatusInternalServerError)
+		return nil, false
+	case !users.AllUsers(tsk.Group)[mw.CtxGetUserID(r)]:
+		code := http.StatusUnauthorized
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+	tsk.ID = task.ID(tUUID)
+
+	return tsk, true
+}
+
+// GetTimeline returns the task.Activities and task.Comments of the Task
+// as task.Entries, oldest first.
+func (t *Task) GetTimeline(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	tsk, ok := t.loadReadable(w, r, ps)
+	if !ok {
+		return
+	}
+
+	var timeline []*task.Entry
+	if err := t.View(task.GetTimeline(tsk.ID, &timeline)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get timeline",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(timeline)
+}
+
+// GetComments returns the task.Comments of the Task as task.Threads.
+func (t *Task) GetComments(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	tsk, ok := t.loadReadable(w, r, ps)
+	if !ok {
+		return
+	}
+
+	var comments []*task.Comment
+	if err := t.View(task.GetComments(tsk.ID, &comments)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get comments",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(task.Threads(comments))
+}
+
+// PostComment is a Handle which adds a {"text", "parent"} Comment to the
+// Task, replying to the Comment with the given parent ID if it is set.
+// Any user of the Task may comment on it, and each of them is notified.
+func (t *Task) PostComment(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	var body struct {
+		Text   string `json:"text"`
+		Parent uint64 `json:"parent"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "bad body",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	tsk, ok := t.loadReadable(w, r, ps)
+	if !ok {
+		return
+	}
+
+	comment := &task.Comment{
+		Parent: body.Parent,
+		By:     mw.CtxGetUserID(r),
+		Text:   body.Text,
+		Time:   t.Now().UTC(),
+	}
+	err := t.Update(task.AddComment(tsk.ID, comment))
+	switch {
+	case task.IsComment(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case task.IsCommentMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to add comment",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t.notifyEntry(tsk, comment)
+	json.NewEncoder(w).Encode(comment)
+}
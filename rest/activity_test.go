@@ -0,0 +1,163 @@
+package rest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/rest"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	htr "github.com/julienschmidt/httprouter"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RESTSuite) TestTaskActivity(c *C) {
+	var (
+		r   = htr.New()
+		now = time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+		api = &rest.Task{DB: s.db, Timer: sgt.Timer(now)}
+
+		srv, tokens = prepTaskAPI(c, r, api, "bodie", "bob", "alice")
+	)
+	defer srv.Close()
+	defer cleanupTaskAPI(c, api)
+
+	seen := observeNotifs()
+	defer seen.Close()
+
+	send := func(method, path, user string, body interface{}) *htt.ResponseRecorder {
+		bs, err := json.Marshal(body)
+		c.Assert(err, IsNil)
+		req := htt.NewRequest(method, path, bytes.NewBuffer(bs))
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	c.Log("creating a task is the first entry on its timeline")
+	w := send("POST", "/tasks", "bodie", &task.Task{
+		Group: users.Group{
+			Owner:   "bodie",
+			Readers: map[string]bool{"bob": true},
+			Writers: map[string]bool{},
+		},
+		Name: "write docs",
+	})
+	c.Assert(w.Code, Equals, http.StatusOK, Commentf(w.Body.String()))
+	tsk := new(task.Task)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), tsk), IsNil)
+	path := "/tasks/" + tsk.ID.String()
+	c.Check(seen.takeResources("bob"), DeepEquals, []store.Resource{
+		"tasks", "task-activity",
+	})
+
+	c.Log("updates are recorded with who made them")
+	tsk.Name = "write the docs"
+	tsk.Writers["alice"] = true
+	w = send("PUT", path, "bodie", tsk)
+	c.Assert(w.Code, Equals, http.StatusOK, Commentf(w.Body.String()))
+	c.Check(seen.takeResources("alice"), DeepEquals, []store.Resource{
+		"tasks", "task-activity",
+	})
+
+	c.Log("users of the task can comment and reply")
+	w = send("POST", path+"/comments", "alice", map[string]interface{}{
+		"text": "which docs?",
+	})
+	c.Assert(w.Code, Equals, http.StatusOK, Commentf(w.Body.String()))
+	question := new(task.Comment)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), question), IsNil)
+	c.Check(seen.takeResources("bob"), DeepEquals, []store.Resource{
+		"tasks", "task-activity", "task-comment",
+	})
+	w = send("POST", path+"/comments", "bob", map[string]interface{}{
+		"text": "the API docs", "parent": question.ID,
+	})
+	c.Assert(w.Code, Equals, http.StatusOK, Commentf(w.Body.String()))
+
+	for i, test := range []struct {
+		should, user string
+		body         interface{}
+		expectStatus int
+		expectResp   string
+	}{{
+		should: "let readers comment",
+		user:   "bob", body: map[string]string{"text": "hi"},
+		expectStatus: http.StatusOK,
+	}, {
+		should: "reject empty comments",
+		user:   "bodie", body: map[string]string{"text": " "},
+		expectStatus: http.StatusBadRequest,
+		expectResp:   "invalid comment: text must not be empty\n",
+	}, {
+		should: "not reply to missing comments",
+		user:   "bodie", body: map[string]interface{}{"text": "hi", "parent": 7},
+		expectStatus: http.StatusNotFound,
+		expectResp:   "no such comment 7\n",
+	}, {
+		should:       "reject bad bodies",
+		user:         "bodie",
+		body:         "hi",
+		expectStatus: http.StatusBadRequest,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		w := send("POST", path+"/comments", test.user, test.body)
+		c.Check(w.Code, Equals, test.expectStatus)
+		if test.expectResp != "" {
+			c.Check(w.Body.String(), Equals, test.expectResp)
+		}
+	}
+
+	c.Log("comments are threaded")
+	w = send("GET", path+"/comments", "alice", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	var threads []*task.Thread
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &threads), IsNil)
+	c.Assert(threads, HasLen, 2)
+	c.Check(threads[0].Text, Equals, "which docs?")
+	c.Assert(threads[0].Replies, HasLen, 1)
+	c.Check(threads[0].Replies[0].Text, Equals, "the API docs")
+	c.Check(threads[0].Replies[0].By, Equals, "bob")
+	c.Check(threads[1].Text, Equals, "hi")
+
+	c.Log("the timeline shows activity and comments")
+	w = send("GET", path+"/timeline", "bob", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	var timeline []*task.Entry
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &timeline), IsNil)
+	c.Assert(timeline, HasLen, 5)
+	c.Check(timeline[0].Activity.Created, Equals, true)
+	c.Check(timeline[1].Activity.By, Equals, "bodie")
+	var fields []string
+	for _, ch := range timeline[1].Activity.Changes {
+		fields = append(fields, ch.Field)
+	}
+	c.Check(fields, DeepEquals, []string{"name", "readers", "writers"})
+	c.Check(timeline[2].Comment.By, Equals, "alice")
+
+	c.Log("only users of the task can see its timeline")
+	tsk.Readers = map[string]bool{}
+	tsk.Writers = map[string]bool{}
+	w = send("PUT", path, "bodie", tsk)
+	c.Assert(w.Code, Equals, http.StatusOK, Commentf(w.Body.String()))
+	for _, p := range []string{"/timeline", "/comments"} {
+		w = send("GET", path+p, "bob", nil)
+		c.Check(w.Code, Equals, http.StatusUnauthorized)
+	}
+	w = send("POST", path+"/comments", "bob", map[string]string{"text": "hi"})
+	c.Check(w.Code, Equals, http.StatusUnauthorized)
+
+	c.Log("deleting the task deletes its timeline")
+	w = send("DELETE", path, "bodie", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	var entries []*task.Entry
+	c.Assert(s.db.View(task.GetTimeline(tsk.ID, &entries)), IsNil)
+	c.Check(entries, HasLen, 0)
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
@@ -13,6 +14,7 @@ import (
 	"github.com/synapse-garden/sg-proto/auth"
 	"github.com/synapse-garden/sg-proto/convo"
 	"github.com/synapse-garden/sg-proto/incept"
+	"github.com/synapse-garden/sg-proto/mail"
 	"github.com/synapse-garden/sg-proto/notif"
 	mw "github.com/synapse-garden/sg-proto/rest/middleware"
 	"github.com/synapse-garden/sg-proto/store"
@@ -32,7 +34,11 @@ var AdminNotifs = "admin"
 type Admin struct {
 	auth.Token
 	*bolt.DB
+	mw.Sessions
 	river.Pub
+
+	// Mailer, if set, is used to mail invites for new tickets.
+	Mailer *mail.Mailer
 }
 
 // Bind implements API.Bind on Admin.
@@ -50,8 +56,12 @@ func (a *Admin) Bind(r *htr.Router) error {
 		return err
 	}
 
+	if err := db.Update(admin.Migrate); err != nil {
+		return errors.Wrap(err, "failed to migrate legacy admin key")
+	}
+
 	if a.Token != nil {
-		// User wants to create a new token.
+		// User wants to create a new root token.
 		err := db.Update(admin.NewToken(a.Token))
 		if err != nil {
 			return err
@@ -71,15 +81,29 @@ func (a *Admin) Bind(r *htr.Router) error {
 		}
 	}
 
-	r.GET("/admin/verify", mw.AuthAdmin(a.Verify, db))
-	r.POST("/admin/tickets", mw.AuthAdmin(a.NewTicket, db))
-	r.GET("/admin/profiles", mw.AuthAdmin(a.GetAllProfiles, db))
+	r.GET("/admin/verify", mw.AuthAdmin(a.Verify, db, admin.RoleAny))
+	r.POST("/admin/tickets", mw.AuthAdmin(a.NewTicket, db, admin.RoleTicketIssuer))
+	// GET /admin/tickets?status=outstanding&creator=root
+	r.GET("/admin/tickets", mw.AuthAdmin(a.GetTickets, db, admin.RoleTicketIssuer))
+	r.GET("/admin/tickets/:ticket", mw.AuthAdmin(a.GetTicket, db, admin.RoleTicketIssuer))
+	// DELETE /admin/tickets?status=expired (or ?all=true)
+	r.DELETE("/admin/tickets", mw.AuthAdmin(a.RevokeTickets, db, admin.RoleTicketIssuer))
+	r.GET("/admin/profiles", mw.AuthAdmin(a.GetAllProfiles, db, admin.RoleModerator))
 	// PATCH /admin/profiles/bodie?addCoin=1000 (or -1000)
-	r.PATCH("/admin/profiles/:id", mw.AuthAdmin(a.PatchProfile, db))
+	r.PATCH("/admin/profiles/:id", mw.AuthAdmin(a.PatchProfile, db, admin.RoleTreasurer))
 	// POST a new Login with corresponding User.
-	r.POST("/admin/logins", mw.AuthAdmin(a.NewLogin, db))
-	r.DELETE("/admin/tickets/:ticket", mw.AuthAdmin(a.DeleteTicket, db))
-	r.DELETE("/admin/users/:user_id", mw.AuthAdmin(a.DeleteUser, db))
+	r.POST("/admin/logins", mw.AuthAdmin(a.NewLogin, db, admin.RoleTicketIssuer))
+	r.DELETE("/admin/tickets/:ticket", mw.AuthAdmin(a.DeleteTicket, db, admin.RoleTicketIssuer))
+	r.DELETE("/admin/users/:user_id", mw.AuthAdmin(a.DeleteUser, db, admin.RoleModerator))
+	r.GET("/admin/lockouts", mw.AuthAdmin(a.GetLockouts, db, admin.RoleModerator))
+	r.DELETE("/admin/lockouts/:key", mw.AuthAdmin(a.DeleteLockout, db, admin.RoleModerator))
+	r.POST("/admin/credentials", mw.AuthAdmin(a.NewCredential, db, admin.RoleSuperadmin))
+	r.GET("/admin/credentials", mw.AuthAdmin(a.GetCredentials, db, admin.RoleSuperadmin))
+	r.DELETE("/admin/credentials/:name", mw.AuthAdmin(a.DeleteCredential, db, admin.RoleSuperadmin))
+	// GET /admin/invites?root=bodie for the tree of bodie's invitees.
+	r.GET("/admin/invites", mw.AuthAdmin(a.GetInviteTree, db, admin.RoleModerator))
+	r.GET("/admin/invites/:user_id", mw.AuthAdmin(a.GetInvites, db, admin.RoleModerator))
+	r.DELETE("/admin/invites/:user_id", mw.AuthAdmin(a.RevokeInvites, db, admin.RoleModerator))
 
 	return nil
 }
@@ -111,6 +135,51 @@ func (a Admin) NewTicket(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		}
 	}
 
+	// The body is optional; an empty body makes plain single-use
+	// tickets.
+	info := new(incept.TicketInfo)
+	err = json.NewDecoder(r.Body).Decode(info)
+	switch {
+	case err == io.EOF:
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to decode ticket info",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	email := r.FormValue("email")
+	if email != "" {
+		switch err := mail.ValidAddress(email); {
+		case a.Mailer == nil:
+			http.Error(w, "mail is not configured", http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case count != 1:
+			http.Error(w, `only one ticket may be mailed, "count" must be 1`,
+				http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	if err := info.Validate(); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "invalid ticket info",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+	if info.Expired(now) {
+		http.Error(w, "ticket expiry must be in the future",
+			http.StatusBadRequest)
+		return
+	}
+	info.Creator = mw.CtxGetAdmin(r)
+	info.Created = now
+	info.Uses = 0
+
 	tkts := make([]incept.Ticket, count)
 	result := make([]string, count)
 	for i := range result {
@@ -119,7 +188,23 @@ func (a Admin) NewTicket(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		result[i] = tkt.String()
 	}
 
-	if err := db.Update(incept.NewTickets(tkts...)); err != nil {
+	issue := incept.IssueTickets(info, tkts...)
+	if email != "" {
+		issue = store.Wrap(issue, a.Mailer.Invite(
+			email, "", tkts[0], info.Expires, now,
+		))
+	}
+
+	err = db.Update(issue)
+	switch {
+	case err == nil:
+	case stream.IsMissing(err), convo.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case users.IsExists(err), incept.IsReserved(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
 		result = nil
 		http.Error(w, errors.Wrap(err, "failed to insert new tickets").Error(), http.StatusInternalServerError)
 		return
@@ -160,8 +245,9 @@ func (a Admin) GetAllProfiles(w http.ResponseWriter, r *http.Request, _ htr.Para
 }
 
 // PatchProfile is a PATCH handler for an Admin to add Coin to a given
-// user's Profile.  The User is notified with the updated Profile value.
-// The caller should use the URL parameter addCoin=<int64 coin amount>.
+// user's Profile, recorded in the ledger as a users.Grant.  The User is
+// notified with the updated Profile value.  The caller should use the
+// URL parameter addCoin=<int64 coin amount>, which may be negative.
 func (a Admin) PatchProfile(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	userID := ps.ByName("id")
 	if err := a.View(users.CheckUsersExist(userID)); err != nil {
@@ -186,11 +272,25 @@ func (a Admin) PatchProfile(w http.ResponseWriter, r *http.Request, ps htr.Param
 		)
 		return
 	}
+	if coin == 0 {
+		http.Error(w, "addCoin must not be zero", http.StatusBadRequest)
+		return
+	}
 
+	t := &users.Transaction{
+		To:     userID,
+		Amount: coin,
+		Reason: users.Grant,
+		Time:   time.Now(),
+	}
+	if coin < 0 {
+		t.From, t.To, t.Amount = userID, users.Mint, -coin
+	}
 	u := &users.User{Name: userID}
 	err = a.Update(store.Wrap(
 		users.CheckUsersExist(userID),
-		users.AddCoin(u, coin),
+		users.Post(t),
+		store.Unmarshal(users.UserBucket, u, []byte(userID)),
 	))
 	switch {
 	case users.IsMissing(err):
@@ -232,6 +332,8 @@ func (a Admin) NewLogin(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 	json.NewEncoder(w).Encode(l.User)
 }
 
+// DeleteTicket is a Handle which revokes the given Ticket.  Redeemed
+// Tickets are kept as records and cannot be revoked.
 func (a Admin) DeleteTicket(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	db := a.DB
 	tStr := ps.ByName("ticket")
@@ -243,8 +345,16 @@ func (a Admin) DeleteTicket(w http.ResponseWriter, r *http.Request, ps htr.Param
 		return
 	}
 
-	err = db.Update(incept.DeleteTickets(incept.Ticket(ticket)))
-	if err != nil {
+	err = db.Update(incept.RevokeTicket(incept.Ticket(ticket)))
+	switch err.(type) {
+	case nil:
+	case incept.ErrTicketMissing:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case incept.ErrRedeemed:
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	default:
 		http.Error(w, errors.Wrapf(err, fmt.Sprintf(
 			"failed to delete ticket %#q", tStr,
 		)).Error(), http.StatusInternalServerError)
@@ -252,6 +362,135 @@ func (a Admin) DeleteTicket(w http.ResponseWriter, r *http.Request, ps htr.Param
 	}
 }
 
+// ticketFilter parses an incept.TicketFilter from the request's
+// "status", "creator", "inviter", "createdBefore" and "createdAfter"
+// parameters.  Times are in RFC 3339 format.
+func ticketFilter(r *http.Request) (*incept.TicketFilter, error) {
+	f := &incept.TicketFilter{
+		Status:  incept.TicketStatus(r.FormValue("status")),
+		Creator: r.FormValue("creator"),
+		Inviter: r.FormValue("inviter"),
+	}
+	if !f.Status.Valid() {
+		return nil, errors.Errorf("invalid status %#q", f.Status)
+	}
+
+	for param, into := range map[string]**time.Time{
+		"createdBefore": &f.CreatedBefore,
+		"createdAfter":  &f.CreatedAfter,
+	} {
+		v := r.FormValue(param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %#q value", param)
+		}
+		*into = &t
+	}
+
+	return f, nil
+}
+
+// GetTickets is a Handle which returns the TicketRecords matching the
+// filter given in the request parameters, oldest first.
+func (a Admin) GetTickets(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	f, err := ticketFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var tkts []*incept.TicketRecord
+	if err := a.View(incept.GetTickets(f, time.Now(), &tkts)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get tickets",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if tkts == nil {
+		tkts = []*incept.TicketRecord{}
+	}
+	if err := json.NewEncoder(w).Encode(tkts); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write tickets",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetTicket is a Handle which returns the TicketRecord of the given
+// Ticket.
+func (a Admin) GetTicket(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	tStr := ps.ByName("ticket")
+	ticket, err := uuid.FromString(tStr)
+	if err != nil {
+		http.Error(w, errors.Wrapf(err,
+			"invalid ticket %#q", tStr,
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	rec := new(incept.TicketRecord)
+	err = a.View(incept.GetTicket(incept.Ticket(ticket), time.Now(), rec))
+	switch err.(type) {
+	case nil:
+	case incept.ErrTicketMissing:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		http.Error(w, errors.Wrapf(err,
+			"failed to get ticket %#q", tStr,
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(rec); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write ticket",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// RevokeTickets is a Handle which revokes the Tickets matching the
+// filter given in the request parameters, and returns their records.
+// Redeemed Tickets are never revoked.  To revoke every outstanding or
+// expired Ticket, the "all" parameter must be "true".
+func (a Admin) RevokeTickets(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	f, err := ticketFilter(r)
+	switch {
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case f.Status == incept.StatusRedeemed:
+		http.Error(w, "redeemed tickets cannot be revoked",
+			http.StatusBadRequest)
+		return
+	case f.Empty() && r.FormValue("all") != "true":
+		http.Error(w, "a filter or all=true is required to revoke tickets",
+			http.StatusBadRequest)
+		return
+	}
+
+	var revoked []*incept.TicketRecord
+	if err := a.Update(incept.RevokeTickets(f, time.Now(), &revoked)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to revoke tickets",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if revoked == nil {
+		revoked = []*incept.TicketRecord{}
+	}
+	if err := json.NewEncoder(w).Encode(revoked); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write revoked tickets",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
 func (a Admin) DeleteUser(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	userID := ps.ByName("user_id")
 
@@ -432,6 +671,7 @@ hangups:
 	err = a.Update(store.Wrap(
 		users.Delete(userID),
 		auth.Disable(userID),
+		revokeSigned(a.Keys, userID),
 	))
 
 	if err != nil {
@@ -442,6 +682,143 @@ hangups:
 	}
 }
 
+// GetLockouts returns every tracked failed-attempt record, including
+// whether and until when it is locked out.
+func (a Admin) GetLockouts(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	all := auth.Attempts{}
+	if err := a.View(all.GetAll); err != nil {
+		http.Error(w, errors.Wrap(err,
+			"failed to get lockouts",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(all)
+}
+
+// DeleteLockout clears the failed attempts for the given key, such as
+// "user:bob" or "addr:10.0.0.1", lifting any lockout.
+func (a Admin) DeleteLockout(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	key := auth.AttemptKey(ps.ByName("key"))
+	if err := a.Update(auth.ClearAttempts(key)); err != nil {
+		http.Error(w, errors.Wrapf(err,
+			"failed to clear lockout %#q", key,
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// NewCredentialRequest is the body of a POST to /admin/credentials.
+type NewCredentialRequest struct {
+	Name    string     `json:"name"`
+	Role    admin.Role `json:"role"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// NewCredentialResponse is the response to a POST to
+// /admin/credentials.  Key is the new admin token, base64-encoded.  It
+// is only returned once, and cannot be retrieved later.
+type NewCredentialResponse struct {
+	admin.Credential
+	Key string `json:"key"`
+}
+
+// NewCredential creates a new named admin Credential with the given
+// Role and optional expiry, and returns its token.
+func (a Admin) NewCredential(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	req := new(NewCredentialRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to parse credential request",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	switch {
+	case req.Name == "":
+		http.Error(w, "credential name must not be empty", http.StatusBadRequest)
+		return
+	case !req.Role.Valid():
+		http.Error(w, admin.ErrInvalidRole(req.Role).Error(), http.StatusBadRequest)
+		return
+	case req.Expires != nil && !req.Expires.After(now):
+		http.Error(w, "credential expiry must be in the future", http.StatusBadRequest)
+		return
+	}
+
+	var (
+		token = auth.Token(uuid.NewV4().Bytes())
+		cred  = &admin.Credential{
+			Name:    req.Name,
+			Role:    req.Role,
+			Created: now,
+			Expires: req.Expires,
+		}
+	)
+
+	err := a.Update(store.Wrap(
+		store.CheckNotExist(admin.CredentialBucket, []byte(cred.Name)),
+		admin.NewCredential(cred, token),
+	))
+	switch {
+	case store.IsExists(err):
+		http.Error(w, fmt.Sprintf(
+			"admin credential %#q already exists", cred.Name,
+		), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to create admin credential",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("admin %#q created admin credential %#q with role %#q",
+		mw.CtxGetAdmin(r), cred.Name, string(cred.Role))
+
+	json.NewEncoder(w).Encode(&NewCredentialResponse{
+		Credential: *cred,
+		Key:        base64.StdEncoding.EncodeToString(token),
+	})
+}
+
+// GetCredentials lists every admin Credential, without its token.
+func (a Admin) GetCredentials(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	all := admin.Credentials{}
+	if err := a.View(all.GetAll); err != nil {
+		http.Error(w, errors.Wrap(err,
+			"failed to get admin credentials",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(all)
+}
+
+// DeleteCredential revokes the named admin Credential.  The last valid
+// superadmin Credential cannot be revoked.
+func (a Admin) DeleteCredential(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	name := ps.ByName("name")
+	err := a.Update(admin.Revoke(name, time.Now()))
+	switch {
+	case admin.IsCredentialMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case admin.IsLastSuperadmin(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrapf(err,
+			"failed to revoke admin credential %#q", name,
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("admin %#q revoked admin credential %#q",
+		mw.CtxGetAdmin(r), name)
+}
+
 type survErr struct {
 	e    error
 	bkts []store.Bucket
@@ -449,3 +826,70 @@ type survErr struct {
 
 func (s survErr) Error() string { return s.e.Error() }
 func (s survErr) Cause() error  { return s.e }
+
+// GetInviteTree is a Handle which returns the tree of users who invited
+// each other.  If the "root" parameter is given, only the tree of that
+// user's invitees is returned.
+func (a Admin) GetInviteTree(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	var tree []*incept.InviteNode
+	err := a.View(incept.GetInviteTree(r.FormValue("root"), &tree))
+	if err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get invite tree",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if tree == nil {
+		tree = []*incept.InviteNode{}
+	}
+	if err := json.NewEncoder(w).Encode(tree); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write invite tree",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetInvites is a Handle which returns all of a user's Invites.
+func (a Admin) GetInvites(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	var invs []*incept.Invite
+	err := a.View(incept.GetInvites(ps.ByName("user_id"), &invs))
+	if err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get invites",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if invs == nil {
+		invs = []*incept.Invite{}
+	}
+	if err := json.NewEncoder(w).Encode(invs); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write invites",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// RevokeInvites is a Handle which deletes a user's unused Invites and
+// their Tickets, and returns the revoked Invites.  Their price is not
+// refunded.
+func (a Admin) RevokeInvites(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	var revoked []*incept.Invite
+	err := a.Update(incept.RevokeInvites(ps.ByName("user_id"), &revoked))
+	if err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to revoke invites",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if revoked == nil {
+		revoked = []*incept.Invite{}
+	}
+	if err := json.NewEncoder(w).Encode(revoked); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write revoked invites",
+		).Error(), http.StatusInternalServerError)
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"net/http"
 	htt "net/http/httptest"
 	"reflect"
+	"time"
 
+	"github.com/synapse-garden/sg-proto/admin"
 	"github.com/synapse-garden/sg-proto/auth"
 	"github.com/synapse-garden/sg-proto/convo"
 	"github.com/synapse-garden/sg-proto/incept"
@@ -44,8 +46,8 @@ func prepAdminAPI(c *C,
 	}
 
 	c.Assert(api.Bind(r), IsNil)
-	c.Assert(rest.Token{api.DB}.Bind(r), IsNil)
-	c.Assert(rest.Profile{api.DB}.Bind(r), IsNil)
+	c.Assert(rest.Token{DB: api.DB}.Bind(r), IsNil)
+	c.Assert(rest.Profile{DB: api.DB}.Bind(r), IsNil)
 
 	// Make a testing server to run it.
 	return htt.NewServer(r), tokens
@@ -612,3 +614,347 @@ func (s *RESTSuite) TestAdminDeleteUser(c *C) {
 	c.Assert(conn2.Close(), IsNil)
 	c.Assert(bobNotif.Close(), IsNil)
 }
+
+func (s *RESTSuite) TestAdminCredentials(c *C) {
+	var (
+		tokenUUID = uuid.NewV4()
+		adminKey  = auth.Token(tokenUUID[:])
+		api       = &rest.Admin{Token: adminKey, DB: s.db}
+		r         = htr.New()
+		srv, _    = prepAdminAPI(c, r, api, "bob")
+	)
+	defer srv.Close()
+	defer cleanupAdminAPI(c, api)
+
+	c.Log("a superadmin can create a moderator credential")
+	created := new(rest.NewCredentialResponse)
+	req := htt.NewRequest("POST", "/admin/credentials",
+		bytes.NewBufferString(`{"name":"mod","role":"moderator"}`))
+	req.Header = sgt.Admin(adminKey)
+	w := htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), created), IsNil)
+	c.Check(created.Name, Equals, "mod")
+	c.Check(created.Role, Equals, admin.RoleModerator)
+	modKey, err := base64.StdEncoding.DecodeString(created.Key)
+	c.Assert(err, IsNil)
+
+	for i, test := range []struct {
+		should string
+
+		verb, path       string
+		header           http.Header
+		body             interface{}
+		expectStatus     int
+		into, expectResp interface{}
+	}{{
+		should: "reject a duplicate name",
+		verb:   "POST", path: "/admin/credentials",
+		header: sgt.Admin(adminKey),
+		body: &rest.NewCredentialRequest{
+			Name: "mod", Role: admin.RoleTreasurer,
+		},
+		expectStatus: http.StatusConflict,
+		into:         new(string),
+		expectResp:   "admin credential `mod` already exists\n",
+	}, {
+		should: "reject an unknown role",
+		verb:   "POST", path: "/admin/credentials",
+		header: sgt.Admin(adminKey),
+		body: &rest.NewCredentialRequest{
+			Name: "janitor", Role: "janitor",
+		},
+		expectStatus: http.StatusBadRequest,
+		into:         new(string),
+		expectResp:   "invalid admin role `janitor`\n",
+	}, {
+		should: "allow the moderator to verify",
+		verb:   "GET", path: "/admin/verify",
+		header:       sgt.Admin(modKey),
+		expectStatus: http.StatusOK,
+		into:         new(bool),
+		expectResp:   func() *bool { b := true; return &b }(),
+	}, {
+		should: "allow the moderator to list profiles",
+		verb:   "GET", path: "/admin/profiles",
+		header:       sgt.Admin(modKey),
+		expectStatus: http.StatusOK,
+		into:         new([]users.User),
+		expectResp:   &([]users.User{{Name: "bob"}}),
+	}, {
+		should: "forbid the moderator to add coin",
+		verb:   "PATCH", path: "/admin/profiles/bob?addCoin=5",
+		header:       sgt.Admin(modKey),
+		expectStatus: http.StatusForbidden,
+		into:         new(string),
+		expectResp:   "admin `mod` does not have role `treasurer`\n",
+	}, {
+		should: "forbid the moderator to manage credentials",
+		verb:   "GET", path: "/admin/credentials",
+		header:       sgt.Admin(modKey),
+		expectStatus: http.StatusForbidden,
+		into:         new(string),
+		expectResp:   "admin `mod` does not have role `superadmin`\n",
+	}, {
+		should: "not revoke the last superadmin",
+		verb:   "DELETE", path: "/admin/credentials/root",
+		header:       sgt.Admin(adminKey),
+		expectStatus: http.StatusConflict,
+		into:         new(string),
+		expectResp:   "cannot revoke `root`: it is the last superadmin\n",
+	}, {
+		should: "revoke the moderator",
+		verb:   "DELETE", path: "/admin/credentials/mod",
+		header:       sgt.Admin(adminKey),
+		expectStatus: http.StatusOK,
+		into:         new(string),
+		expectResp:   "",
+	}, {
+		should: "reject the revoked moderator",
+		verb:   "GET", path: "/admin/verify",
+		header:       sgt.Admin(modKey),
+		expectStatus: http.StatusUnauthorized,
+		into:         new(string),
+		expectResp: "no such admin token `" +
+			auth.Token(modKey).String() + "`\n",
+	}} {
+		c.Logf("test %d: %s on %s should %s", i,
+			test.verb, test.path,
+			test.should,
+		)
+		c.Assert(sgt.ExpectResponse(r,
+			test.path, test.verb, test.body,
+			test.into, test.expectResp,
+			test.expectStatus,
+			test.header,
+		), IsNil)
+	}
+
+	c.Log("only the root credential is left")
+	req = htt.NewRequest("GET", "/admin/credentials", nil)
+	req.Header = sgt.Admin(adminKey)
+	w = htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	var creds []admin.Credential
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &creds), IsNil)
+	c.Assert(creds, HasLen, 1)
+	c.Check(creds[0].Name, Equals, admin.RootName)
+	c.Check(creds[0].Role, Equals, admin.RoleSuperadmin)
+}
+
+func (s *RESTSuite) TestAdminNewTicket(c *C) {
+	var (
+		tokenUUID = uuid.NewV4()
+		adminKey  = auth.Token(tokenUUID[:])
+		api       = &rest.Admin{Token: adminKey, DB: s.db}
+		r         = htr.New()
+		srv, _    = prepAdminAPI(c, r, api, "bob")
+	)
+	defer srv.Close()
+	defer cleanupAdminAPI(c, api)
+
+	c.Assert(s.db.Update(stream.Upsert(&stream.Stream{
+		ID:    "str",
+		Group: users.Group{Owner: "bob"},
+	})), IsNil)
+
+	past := time.Now().Add(-time.Hour)
+
+	for i, test := range []struct {
+		should string
+
+		path         string
+		body         interface{}
+		expectStatus int
+		expectResp   string
+	}{{
+		should:       "reject an expiry in the past",
+		path:         "/admin/tickets",
+		body:         &incept.TicketInfo{Expires: &past},
+		expectStatus: http.StatusBadRequest,
+		expectResp:   "ticket expiry must be in the future\n",
+	}, {
+		should:       "reject invalid ticket info",
+		path:         "/admin/tickets",
+		body:         &incept.TicketInfo{MaxUses: -1},
+		expectStatus: http.StatusBadRequest,
+		expectResp:   "invalid ticket info: maxUses must not be negative\n",
+	}, {
+		should:       "reject a missing stream",
+		path:         "/admin/tickets",
+		body:         &incept.TicketInfo{Streams: []string{"nope"}},
+		expectStatus: http.StatusNotFound,
+		expectResp:   "no such stream `nope`\n",
+	}, {
+		should:       "reject a taken username",
+		path:         "/admin/tickets",
+		body:         &incept.TicketInfo{Username: "bob"},
+		expectStatus: http.StatusConflict,
+		expectResp:   "user `bob` already exists\n",
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		c.Check(sgt.ExpectResponse(r, test.path, "POST", test.body,
+			new(string), test.expectResp, test.expectStatus,
+			sgt.Admin(adminKey)), IsNil)
+	}
+
+	c.Log("tickets are issued with their info and creator")
+	var tkts []string
+	req := htt.NewRequest("POST", "/admin/tickets?count=2",
+		bytes.NewBufferString(`{"maxUses":3,"coin":5,"streams":["str"]}`))
+	req.Header = sgt.Admin(adminKey)
+	w := htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &tkts), IsNil)
+	c.Assert(tkts, HasLen, 2)
+
+	for _, t := range tkts {
+		id, err := uuid.FromString(t)
+		c.Assert(err, IsNil)
+		info := new(incept.TicketInfo)
+		c.Assert(s.db.View(incept.GetTicketInfo(
+			incept.Ticket(id), info,
+		)), IsNil)
+		c.Check(info.Creator, Equals, admin.RootName)
+		c.Check(info.MaxUses, Equals, 3)
+		c.Check(info.Coin, Equals, int64(5))
+		c.Check(info.Streams, DeepEquals, []string{"str"})
+	}
+
+	c.Log("a bodyless request still makes plain tickets")
+	req = htt.NewRequest("POST", "/admin/tickets", nil)
+	req.Header = sgt.Admin(adminKey)
+	w = htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+}
+
+func (s *RESTSuite) TestAdminTickets(c *C) {
+	var (
+		tokenUUID = uuid.NewV4()
+		adminKey  = auth.Token(tokenUUID[:])
+		api       = &rest.Admin{Token: adminKey, DB: s.db}
+		r         = htr.New()
+		srv, _    = prepAdminAPI(c, r, api, "bob")
+	)
+	defer srv.Close()
+	defer cleanupAdminAPI(c, api)
+	c.Assert(rest.Incept{DB: s.db}.Bind(r), IsNil)
+
+	// Revoke the suite's outstanding tickets to start fresh.
+	req := htt.NewRequest("DELETE", "/admin/tickets?all=true", nil)
+	req.Header = sgt.Admin(adminKey)
+	w := htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+
+	var tkts []string
+	req = htt.NewRequest("POST", "/admin/tickets?count=2", nil)
+	req.Header = sgt.Admin(adminKey)
+	w = htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &tkts), IsNil)
+
+	c.Log("one of the new tickets is used")
+	c.Assert(sgt.ExpectResponse(r, "/incept/"+tkts[0], "POST",
+		&auth.Login{
+			User:   users.User{Name: "amy"},
+			PWHash: sgt.Sha256("password"),
+		},
+		new(users.User), &users.User{Name: "amy"}, http.StatusOK,
+		nil,
+	), IsNil)
+
+	get := func(path string) ([]*incept.TicketRecord, int) {
+		var recs []*incept.TicketRecord
+		req := htt.NewRequest("GET", path, nil)
+		req.Header = sgt.Admin(adminKey)
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code == http.StatusOK {
+			c.Assert(json.Unmarshal(w.Body.Bytes(), &recs), IsNil)
+		}
+		return recs, w.Code
+	}
+
+	c.Log("all tickets are listed with their status")
+	recs, code := get("/admin/tickets")
+	c.Assert(code, Equals, http.StatusOK)
+	got := make(map[string]incept.TicketStatus)
+	for _, rec := range recs {
+		got[rec.Ticket.String()] = rec.Status
+	}
+	c.Check(got[tkts[0]], Equals, incept.StatusRedeemed)
+	c.Check(got[tkts[1]], Equals, incept.StatusOutstanding)
+
+	c.Log("tickets can be filtered")
+	recs, code = get("/admin/tickets?status=redeemed&creator=root")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(recs, HasLen, 1)
+	c.Check(recs[0].Ticket.String(), Equals, tkts[0])
+	c.Check(recs[0].RedeemedBy, DeepEquals, []string{"amy"})
+
+	_, code = get("/admin/tickets?status=lost")
+	c.Check(code, Equals, http.StatusBadRequest)
+
+	for i, test := range []struct {
+		should string
+
+		verb, path   string
+		expectStatus int
+		expectResp   string
+	}{{
+		should: "get one ticket",
+		verb:   "GET", path: "/admin/tickets/" + tkts[1],
+		expectStatus: http.StatusOK,
+	}, {
+		should: "404 for a missing ticket",
+		verb:   "GET", path: "/admin/tickets/" + uuid.NewV4().String(),
+		expectStatus: http.StatusNotFound,
+	}, {
+		should: "refuse to revoke a redeemed ticket",
+		verb:   "DELETE", path: "/admin/tickets/" + tkts[0],
+		expectStatus: http.StatusConflict,
+		expectResp:   "ticket `" + tkts[0] + "` has been redeemed\n",
+	}, {
+		should: "refuse to revoke everything without all=true",
+		verb:   "DELETE", path: "/admin/tickets",
+		expectStatus: http.StatusBadRequest,
+		expectResp:   "a filter or all=true is required to revoke tickets\n",
+	}, {
+		should: "refuse to revoke redeemed tickets",
+		verb:   "DELETE", path: "/admin/tickets?status=redeemed",
+		expectStatus: http.StatusBadRequest,
+		expectResp:   "redeemed tickets cannot be revoked\n",
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		req := htt.NewRequest(test.verb, test.path, nil)
+		req.Header = sgt.Admin(adminKey)
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		c.Check(w.Code, Equals, test.expectStatus)
+		if test.expectResp != "" {
+			c.Check(w.Body.String(), Equals, test.expectResp)
+		}
+	}
+
+	c.Log("bulk revocation keeps redeemed tickets")
+	req = htt.NewRequest("DELETE", "/admin/tickets?creator=root", nil)
+	req.Header = sgt.Admin(adminKey)
+	w = htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	var revoked []*incept.TicketRecord
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &revoked), IsNil)
+	c.Assert(revoked, HasLen, 1)
+	c.Check(revoked[0].Ticket.String(), Equals, tkts[1])
+
+	recs, code = get("/admin/tickets?creator=root")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(recs, HasLen, 1)
+	c.Check(recs[0].Ticket.String(), Equals, tkts[0])
+}
@@ -0,0 +1,187 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/notif"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/stream/river"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// Block implements API.  It lets users block and mute other users.
+// Once bound, notifs caused by a user are dropped for the users who
+// blocked or muted them, until Close is called.
+type Block struct {
+	*bolt.DB
+	mw.Sessions
+
+	mu     sync.RWMutex
+	blocks map[notif.UserTopic]*users.Blocks
+	cancel func()
+}
+
+// Bind implements API.Bind on Block.
+func (b *Block) Bind(r *htr.Router) error {
+	if b.DB == nil {
+		return errors.New("Block DB handle must not be nil")
+	}
+
+	all := make(map[string]*users.Blocks)
+	if err := b.View(users.GetAllBlocks(all)); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.blocks = make(map[notif.UserTopic]*users.Blocks, len(all))
+	for u, bs := range all {
+		b.blocks[notif.MakeUserTopic(u)] = bs
+	}
+	b.mu.Unlock()
+	if b.cancel == nil {
+		b.cancel = notif.Drop(b.drop)
+	}
+
+	r.GET("/blocks", b.AuthUser(b.Get, b.DB, mw.CtxSetUserID))
+	r.PUT("/blocks/:user_id", b.AuthUser(
+		b.set(users.SetBlocked, true),
+		b.DB, mw.CtxSetUserID,
+	))
+	r.DELETE("/blocks/:user_id", b.AuthUser(
+		b.set(users.SetBlocked, false),
+		b.DB, mw.CtxSetUserID,
+	))
+	r.PUT("/mutes/:user_id", b.AuthUser(
+		b.set(users.SetMuted, true),
+		b.DB, mw.CtxSetUserID,
+	))
+	r.DELETE("/mutes/:user_id", b.AuthUser(
+		b.set(users.SetMuted, false),
+		b.DB, mw.CtxSetUserID,
+	))
+
+	return nil
+}
+
+// Close stops dropping notifs.
+func (b *Block) Close() error {
+	if b.cancel != nil {
+		b.cancel()
+		b.cancel = nil
+	}
+	return nil
+}
+
+// drop is a notif.Dropper which drops notifs caused by users the
+// recipient blocked or muted.
+func (b *Block) drop(t notif.UserTopic, val store.Resourcer) bool {
+	a, ok := val.(notif.Actor)
+	if !ok {
+		return false
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	bs, ok := b.blocks[t]
+	return ok && bs.Silences(a.Actor())
+}
+
+// Get is a Handle which writes the user's users.Blocks.
+func (b *Block) Get(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	bs := new(users.Blocks)
+	if err := b.View(users.GetBlocks(mw.CtxGetUserID(r), bs)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get blocks",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(bs); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write blocks",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// set returns a Handle which uses the given setter, such as
+// users.SetBlocked, to add or remove the user in the path from one of
+// the user's lists, and writes the new users.Blocks.
+func (b *Block) set(
+	setter func(user, other string, set bool, into *users.Blocks) func(*bolt.Tx) error,
+	set bool,
+) htr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+		userID, other := mw.CtxGetUserID(r), ps.ByName("user_id")
+		if userID == other {
+			http.Error(w, "users cannot block or mute themselves",
+				http.StatusBadRequest)
+			return
+		}
+
+		bs := new(users.Blocks)
+		err := b.Update(setter(userID, other, set, bs))
+		switch {
+		case users.IsMissing(err):
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, errors.Wrap(
+				err, "failed to update blocks",
+			).Error(), http.StatusInternalServerError)
+			return
+		}
+
+		b.mu.Lock()
+		b.blocks[notif.MakeUserTopic(userID)] = bs
+		b.mu.Unlock()
+
+		if err := json.NewEncoder(w).Encode(bs); err != nil {
+			http.Error(w, errors.Wrap(
+				err, "failed to write blocks",
+			).Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+// unblocked wraps a convo Bus, dropping Messages from users the
+// recipient blocked.
+type unblocked struct {
+	river.Bus
+	blocked map[string]bool
+}
+
+// Recv implements river.River.Recv on unblocked.
+func (u unblocked) Recv() ([]byte, error) {
+	for {
+		bs, err := u.Bus.Recv()
+		if err != nil || len(u.blocked) == 0 {
+			return bs, err
+		}
+		msg := new(convo.Message)
+		if json.Unmarshal(bs, msg) == nil && u.blocked[msg.Sender] {
+			continue
+		}
+		return bs, nil
+	}
+}
+
+// withoutBlocked returns the Messages not sent by blocked users.
+func withoutBlocked(msgs []convo.Message, blocked map[string]bool) []convo.Message {
+	if len(blocked) == 0 {
+		return msgs
+	}
+	result := make([]convo.Message, 0, len(msgs))
+	for _, m := range msgs {
+		if !blocked[m.Sender] {
+			result = append(result, m)
+		}
+	}
+	return result
+}
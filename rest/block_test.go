@@ -0,0 +1,133 @@
+package rest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/rest"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RESTSuite) TestBlockBind(c *C) {
+	c.Check(new(rest.Block).Bind(htr.New()), ErrorMatches,
+		"Block DB handle must not be nil")
+
+	api := &rest.Block{DB: s.db}
+	c.Assert(api.Bind(htr.New()), IsNil)
+	c.Check(api.Close(), IsNil)
+}
+
+func (s *RESTSuite) TestBlock(c *C) {
+	var (
+		convos      = &rest.Convo{DB: s.db}
+		api         = &rest.Block{DB: s.db}
+		r           = htr.New()
+		srv, tokens = prepConvoAPI(c, r, convos, "bob", "alice", "carol")
+	)
+	defer srv.Close()
+	defer cleanupConvoAPI(c, *convos)
+	c.Assert(api.Bind(r), IsNil)
+	defer api.Close()
+
+	seen := observeNotifs()
+	defer seen.Close()
+	send := func(method, path string, body interface{}, user string) *htt.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			c.Assert(json.NewEncoder(&buf).Encode(body), IsNil)
+		}
+		req := htt.NewRequest(method, path, &buf)
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	newConvo := func(owner string, readers ...string) *htt.ResponseRecorder {
+		rs := map[string]bool{owner: true}
+		for _, u := range readers {
+			rs[u] = true
+		}
+		return send("POST", "/convos", &convo.Convo{Group: users.Group{
+			Owner:   owner,
+			Readers: rs,
+			Writers: rs,
+		}}, owner)
+	}
+
+	c.Log("bob can't block himself or unknown users")
+	c.Check(send("PUT", "/blocks/bob", nil, "bob").Code,
+		Equals, http.StatusBadRequest)
+	c.Check(send("PUT", "/blocks/dave", nil, "bob").Code,
+		Equals, http.StatusNotFound)
+
+	c.Log("bob blocks alice and mutes carol")
+	c.Check(send("PUT", "/blocks/alice", nil, "bob").Code, Equals, http.StatusOK)
+	c.Check(send("PUT", "/mutes/carol", nil, "bob").Code, Equals, http.StatusOK)
+	c.Check(sgt.ExpectResponse(r, "/blocks", "GET", nil,
+		new(users.Blocks), &users.Blocks{
+			Blocked: map[string]bool{"alice": true},
+			Muted:   map[string]bool{"carol": true},
+		}, http.StatusOK,
+		sgt.Bearer(tokens["bob"]),
+	), IsNil)
+
+	c.Log("alice can't add bob to a convo")
+	w := newConvo("alice", "bob")
+	c.Check(w.Code, Equals, http.StatusForbidden)
+	c.Check(w.Body.String(), Equals, "failed to create Convo: "+
+		"user `alice` cannot add user `bob`\n")
+	seen.take("alice")
+
+	c.Log("carol can, but bob hears nothing of it")
+	w = newConvo("carol", "bob", "alice")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	conv := new(convo.Convo)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), conv), IsNil)
+	c.Check(seen.take("bob"), HasLen, 0)
+	c.Check(seen.take("alice"), HasLen, 1)
+
+	c.Log("bob doesn't see alice's messages")
+	then := time.Now().Add(-time.Minute)
+	c.Assert(s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(convo.MessageBucket).Bucket([]byte(conv.ID))
+		for _, m := range []convo.Message{{
+			Sender: "alice", Content: "hi bob", Timestamp: then,
+		}, {
+			Sender: "carol", Content: "hi all",
+			Timestamp: then.Add(time.Second),
+		}} {
+			bs, err := json.Marshal(m)
+			if err != nil {
+				return err
+			}
+			key := m.Timestamp.AppendFormat(nil, time.RFC3339)
+			if err := b.Put(key, bs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}), IsNil)
+	var msgs []convo.Message
+	w = send("GET", "/convos/"+conv.ID+"/messages", nil, "bob")
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &msgs), IsNil)
+	c.Assert(msgs, HasLen, 1)
+	c.Check(msgs[0].Sender, Equals, "carol")
+	w = send("GET", "/convos/"+conv.ID+"/messages", nil, "carol")
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &msgs), IsNil)
+	c.Check(msgs, HasLen, 2)
+
+	c.Log("once unblocked and unmuted, things are back to normal")
+	c.Check(send("DELETE", "/blocks/alice", nil, "bob").Code, Equals, http.StatusOK)
+	c.Check(send("DELETE", "/mutes/carol", nil, "bob").Code, Equals, http.StatusOK)
+	c.Check(newConvo("alice", "bob").Code, Equals, http.StatusOK)
+	c.Check(seen.take("bob"), HasLen, 1)
+}
@@ -0,0 +1,391 @@
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/notif"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/stream"
+	"github.com/synapse-garden/sg-proto/stream/river"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+	"github.com/synapse-garden/sg-proto/util"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+const CircleNotifs = "circles"
+
+// Circle implements API.  It lets users manage their users.Circles.
+// Changing or deleting a Circle changes the Readers and Writers of the
+// owner's Streams, Convos and Tasks which refer to it.
+type Circle struct {
+	*bolt.DB
+	mw.Sessions
+	river.Pub
+	util.Timer
+}
+
+// Bind implements API.Bind on Circle.
+func (c *Circle) Bind(r *htr.Router) error {
+	if c.DB == nil {
+		return errors.New("Circle DB handle must not be nil")
+	}
+	if c.Timer == nil {
+		c.Timer = util.SimpleTimer{}
+	}
+
+	err := c.Update(func(tx *bolt.Tx) (e error) {
+		c.Pub, e = river.NewPub(CircleNotifs, NotifStream, tx)
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	r.GET("/circles", c.AuthUser(c.GetAll, c.DB, mw.CtxSetUserID))
+	r.POST("/circles", c.AuthUser(c.Create, c.DB, mw.CtxSetUserID))
+	r.GET("/circles/:circle_id", c.AuthUser(c.Get, c.DB, mw.CtxSetUserID))
+	r.PUT("/circles/:circle_id", c.AuthUser(c.Put, c.DB, mw.CtxSetUserID))
+	r.DELETE("/circles/:circle_id", c.AuthUser(c.Delete, c.DB, mw.CtxSetUserID))
+
+	return nil
+}
+
+// circleChange is a Group resource whose users changed because of a
+// Circle.  Users mapped to false were removed and are told so with
+// removed.  If hangup is set, removed users are hung up from the Stream
+// or Convo with that ID.  The Activity logged on a changed Task is sent
+// to its users the same way.
+type circleChange struct {
+	resource store.Resourcer
+	removed  store.Resourcer
+	users    map[string]bool
+	hangup   string
+}
+
+// syncCircle returns a function which resyncs the owner's Streams,
+// Convos and Tasks referring to the given Circle, dropping the Circle
+// from them if deleted is true.  Changes to Tasks are logged as made by
+// the owner at now.  Changes are appended to the given slice so users
+// can be notified once the transaction is committed.
+func syncCircle(
+	owner, id string,
+	deleted bool,
+	now time.Time,
+	changes *[]circleChange,
+) func(*bolt.Tx) error {
+	resync := func(g *users.Group) func(*bolt.Tx) (map[string]bool, error) {
+		return func(tx *bolt.Tx) (map[string]bool, error) {
+			was := users.AllUsers(*g)
+			if deleted {
+				delete(g.ReadCircles, id)
+				delete(g.WriteCircles, id)
+			}
+			if err := users.ResolveCircles(g, nil)(tx); err != nil {
+				return nil, err
+			}
+
+			// Like DiffGroups, removed users map to false.
+			diff := users.AllUsers(*g)
+			for u := range was {
+				diff[u] = diff[u]
+			}
+			return diff, nil
+		}
+	}
+
+	return func(tx *bolt.Tx) error {
+		strs, err := stream.GetAll(owner,
+			users.ByOwner(owner), users.ByCircle(id),
+		)(tx)
+		if err != nil {
+			return err
+		}
+		for _, str := range strs {
+			diff, err := resync(&str.Group)(tx)
+			if err != nil {
+				return err
+			}
+			if err := stream.Upsert(str)(tx); err != nil {
+				return err
+			}
+			*changes = append(*changes, circleChange{
+				stream.Changed{Stream: str, By: owner},
+				stream.Removed(str.ID), diff, str.ID,
+			})
+		}
+
+		convos, err := convo.GetAll(owner,
+			users.ByOwner(owner), users.ByCircle(id),
+		)(tx)
+		if err != nil {
+			return err
+		}
+		for _, cv := range convos {
+			diff, err := resync(&cv.Group)(tx)
+			if err != nil {
+				return err
+			}
+			if err := convo.Upsert(cv)(tx); err != nil {
+				return err
+			}
+			*changes = append(*changes, circleChange{
+				convo.Changed{Convo: cv, By: owner},
+				convo.Removed(cv.ID), diff, cv.ID,
+			})
+		}
+
+		tasks, err := task.GetAll(owner,
+			task.ByOwner(owner), task.ByCircle(id),
+		)(tx)
+		if err != nil {
+			return err
+		}
+		for _, tsk := range tasks {
+			// resync changes the users in place, so the
+			// Task is loaded again to log its changes.
+			before := new(task.Task)
+			if err := tsk.ID.Load(before)(tx); err != nil {
+				return err
+			}
+			diff, err := resync(&tsk.Group)(tx)
+			if err != nil {
+				return err
+			}
+			notes := tsk.Notes
+			if err := tsk.ID.Store(tsk)(tx); err != nil {
+				return err
+			}
+			tsk.Notes = notes
+			act := &task.Activity{By: owner, Time: now}
+			if err := logChanges(act, before, tsk)(tx); err != nil {
+				return err
+			}
+			*changes = append(*changes, circleChange{
+				tsk, task.Removed(tsk.ID), diff, "",
+			})
+			if len(act.Changes) > 0 {
+				*changes = append(*changes, circleChange{
+					act, nil, users.AllUsers(tsk.Group), "",
+				})
+			}
+		}
+
+		return nil
+	}
+}
+
+// hangup hangs up the given user's connections to the Stream or Convo
+// with the given ID, if they have any.
+func hangup(db *bolt.DB, id, user string) error {
+	var surv river.Surveyor
+	err := db.View(func(tx *bolt.Tx) (e error) {
+		surv, e = river.NewSurvey(tx,
+			river.DefaultTimeout,
+			river.HangupBucket,
+			store.Bucket(id),
+			store.Bucket(user),
+		)
+		return
+	})
+	switch {
+	case river.IsStreamMissing(err):
+		// The user is not connected.
+		return nil
+	case err != nil:
+		return err
+	}
+
+	// NOTE: The Survey is used OUTSIDE of the View.  Otherwise, a
+	//       lethal deadlock will occur.
+	err = river.MakeSurvey(surv, river.HUP, river.OK)
+	if river.IsMissing(err) {
+		// Maybe some hung up on their own.
+		return db.View(river.CheckMissing(
+			river.HangupBucket,
+			store.Bucket(id),
+			store.Bucket(user),
+		))
+	}
+	return err
+}
+
+// notify hangs up the users removed from Streams and Convos, and
+// notifies the users of each change.
+func (c *Circle) notify(changes []circleChange) {
+	for _, ch := range changes {
+		for u, ok := range ch.users {
+			if !ok && ch.hangup != "" {
+				if err := hangup(c.DB, ch.hangup, u); err != nil {
+					log.Printf("failed to hang up user %q "+
+						"removed by circle update: %s", u, err)
+				}
+			}
+			var err error
+			topic := notif.MakeUserTopic(u)
+			if ok {
+				err = notif.Encode(c.Pub, ch.resource, topic)
+			} else {
+				err = notif.Encode(c.Pub, ch.removed, topic)
+			}
+			if err != nil {
+				log.Printf("failed to notify user %q of circle update", u)
+			}
+		}
+	}
+}
+
+// GetAll is a Handle which writes all the user's Circles, by name.
+func (c *Circle) GetAll(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	var circles []*users.Circle
+	err := c.View(users.GetCircles(mw.CtxGetUserID(r), &circles))
+	if err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get circles",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(circles); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write circles",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// Create is a Handle which creates the POSTed Circle, owned by the user.
+func (c *Circle) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	circle := new(users.Circle)
+	if err := json.NewDecoder(r.Body).Decode(circle); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "malformed Circle",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	circle.ID = uuid.NewV4().String()
+	circle.Owner = mw.CtxGetUserID(r)
+	if err := circle.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err := c.Update(users.PutCircle(circle))
+	switch {
+	case users.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to create circle",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(circle); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write circle",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// Get is a Handle which writes the user's Circle by ID.
+func (c *Circle) Get(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	circle := new(users.Circle)
+	id := ps.ByName("circle_id")
+	err := c.View(users.GetCircle(mw.CtxGetUserID(r), id, circle))
+	switch {
+	case users.IsCircleMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrapf(
+			err, "failed to get circle %#q", id,
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(circle); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write circle",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// Put is a Handle which replaces the user's Circle by ID, and updates
+// the Groups which refer to it.
+func (c *Circle) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	circle := new(users.Circle)
+	if err := json.NewDecoder(r.Body).Decode(circle); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "malformed Circle",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, id := mw.CtxGetUserID(r), ps.ByName("circle_id")
+	circle.ID, circle.Owner = id, userID
+	if err := circle.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var changes []circleChange
+	err := c.Update(store.Wrap(
+		users.GetCircle(userID, id, new(users.Circle)),
+		users.PutCircle(circle),
+		syncCircle(userID, id, false, c.Now().UTC(), &changes),
+	))
+	switch {
+	case users.IsCircleMissing(err), users.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrapf(
+			err, "failed to update circle %#q", id,
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(circle); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write circle",
+		).Error(), http.StatusInternalServerError)
+	}
+
+	c.notify(changes)
+}
+
+// Delete is a Handle which deletes the user's Circle by ID, removing it
+// from the Groups which refer to it.
+func (c *Circle) Delete(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	userID, id := mw.CtxGetUserID(r), ps.ByName("circle_id")
+
+	var changes []circleChange
+	err := c.Update(store.Wrap(
+		users.GetCircle(userID, id, new(users.Circle)),
+		users.DeleteCircle(id),
+		syncCircle(userID, id, true, c.Now().UTC(), &changes),
+	))
+	switch {
+	case users.IsCircleMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrapf(
+			err, "failed to delete circle %#q", id,
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.notify(changes)
+}
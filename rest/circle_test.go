@@ -0,0 +1,229 @@
+package rest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/rest"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/stream"
+	"github.com/synapse-garden/sg-proto/stream/river"
+	"github.com/synapse-garden/sg-proto/task"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func cleanupCircleAPI(c *C, api *rest.Circle) {
+	c.Assert(api.Pub.Close(), IsNil)
+	c.Assert(api.Update(func(tx *bolt.Tx) error {
+		return river.DeletePub(rest.CircleNotifs, rest.NotifStream, tx)
+	}), IsNil)
+}
+
+func (s *RESTSuite) TestCircleBind(c *C) {
+	c.Check(new(rest.Circle).Bind(htr.New()), ErrorMatches,
+		"Circle DB handle must not be nil")
+
+	api := &rest.Circle{DB: s.db}
+	c.Assert(api.Bind(htr.New()), IsNil)
+	cleanupCircleAPI(c, api)
+}
+
+func (s *RESTSuite) TestCircle(c *C) {
+	var (
+		tasks        = &rest.Task{DB: s.db}
+		api          = &rest.Circle{DB: s.db}
+		r            = htr.New()
+		srv, tokens  = prepTaskAPI(c, r, tasks, "bob", "alice", "carol")
+		strID, tskID = uuid.NewV4().String(), new(task.ID)
+	)
+	defer srv.Close()
+	defer cleanupTaskAPI(c, tasks)
+	c.Assert(api.Bind(r), IsNil)
+	defer cleanupCircleAPI(c, api)
+
+	seen := observeNotifs()
+	defer seen.Close()
+
+	send := func(method, path string, body interface{}, user string) *htt.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			c.Assert(json.NewEncoder(&buf).Encode(body), IsNil)
+		}
+		req := htt.NewRequest(method, path, &buf)
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	readers := func() (map[string]bool, map[string]bool) {
+		str, tsk := new(stream.Stream), new(task.Task)
+		c.Assert(s.db.View(store.Wrap(
+			stream.Get(str, strID),
+			tskID.Load(tsk),
+		)), IsNil)
+		return str.Readers, tsk.Readers
+	}
+
+	c.Log("bob can't put unknown users in a circle")
+	w := send("POST", "/circles", &users.Circle{
+		Name: "team", Members: map[string]bool{"dave": true},
+	}, "bob")
+	c.Check(w.Code, Equals, http.StatusNotFound)
+	w = send("POST", "/circles", &users.Circle{}, "bob")
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+
+	c.Log("bob makes a circle with alice")
+	w = send("POST", "/circles", &users.Circle{
+		Name: "team", Members: map[string]bool{"alice": true},
+	}, "bob")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	team := new(users.Circle)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), team), IsNil)
+	c.Check(team.Owner, Equals, "bob")
+
+	c.Log("only bob can see it")
+	c.Check(sgt.ExpectResponse(r, "/circles", "GET", nil,
+		new([]*users.Circle), &[]*users.Circle{team}, http.StatusOK,
+		sgt.Bearer(tokens["bob"]),
+	), IsNil)
+	c.Check(sgt.ExpectResponse(r, "/circles", "GET", nil,
+		new([]*users.Circle), &[]*users.Circle{}, http.StatusOK,
+		sgt.Bearer(tokens["alice"]),
+	), IsNil)
+	w = send("GET", "/circles/"+team.ID, nil, "alice")
+	c.Check(w.Code, Equals, http.StatusNotFound)
+
+	c.Log("alice can't grant access to bob's circle")
+	w = send("POST", "/tasks", &task.Task{Group: users.Group{
+		Owner:       "alice",
+		Readers:     map[string]bool{},
+		Writers:     map[string]bool{},
+		ReadCircles: map[string]bool{team.ID: true},
+	}}, "alice")
+	c.Check(w.Code, Equals, http.StatusNotFound)
+
+	c.Log("bob shares a task and a stream with the circle")
+	w = send("POST", "/tasks", &task.Task{Name: "work", Group: users.Group{
+		Owner:       "bob",
+		Readers:     map[string]bool{},
+		Writers:     map[string]bool{},
+		ReadCircles: map[string]bool{team.ID: true},
+	}}, "bob")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	tsk := new(task.Task)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), tsk), IsNil)
+	*tskID = tsk.ID
+
+	str := &stream.Stream{ID: strID, Group: users.Group{
+		Owner:        "bob",
+		WriteCircles: map[string]bool{team.ID: true},
+	}}
+	c.Assert(s.db.Update(store.Wrap(
+		users.ResolveCircles(&str.Group, nil),
+		stream.Upsert(str),
+	)), IsNil)
+
+	strReaders, tskReaders := readers()
+	c.Check(strReaders, DeepEquals, map[string]bool{"alice": true})
+	c.Check(tskReaders, DeepEquals, map[string]bool{
+		"bob": true, "alice": true,
+	})
+	seen.take("alice")
+	seen.take("bob")
+
+	c.Log("adding carol to the circle adds her to both")
+	team.Members["carol"] = true
+	c.Check(sgt.ExpectResponse(r, "/circles/"+team.ID, "PUT", team,
+		new(users.Circle), team, http.StatusOK,
+		sgt.Bearer(tokens["bob"]),
+	), IsNil)
+	strReaders, tskReaders = readers()
+	c.Check(strReaders, DeepEquals, map[string]bool{
+		"alice": true, "carol": true,
+	})
+	c.Check(tskReaders, DeepEquals, map[string]bool{
+		"bob": true, "alice": true, "carol": true,
+	})
+	carolSeen := seen.take("carol")
+	c.Assert(carolSeen, HasLen, 3)
+	act, ok := carolSeen[2].(*task.Activity)
+	c.Assert(ok, Equals, true)
+	c.Check(act.By, Equals, "bob")
+	c.Check(act.Task, Equals, tsk.ID)
+	c.Assert(act.Changes, HasLen, 1)
+	c.Check(act.Changes[0].Field, Equals, "readers")
+	c.Check(seen.take("alice"), HasLen, 3)
+
+	c.Log("removing alice removes her from both, and hangs her up")
+	var rsp river.Responder
+	c.Assert(s.db.Update(func(tx *bolt.Tx) (e error) {
+		rsp, e = river.NewResponder(tx,
+			river.HangupBucket,
+			store.Bucket(strID),
+			store.Bucket("alice"),
+		)
+		return
+	}), IsNil)
+	hungUp := make(chan error, 1)
+	go func() { hungUp <- river.AwaitHangup(rsp) }()
+	delete(team.Members, "alice")
+	w = send("PUT", "/circles/"+team.ID, team, "bob")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	select {
+	case err := <-hungUp:
+		c.Check(err, IsNil)
+	case <-time.After(time.Second):
+		c.Fatal("alice was not hung up")
+	}
+	c.Assert(s.db.Update(func(tx *bolt.Tx) error {
+		return river.DeleteResp(tx, rsp.ID(),
+			river.HangupBucket,
+			store.Bucket(strID),
+			store.Bucket("alice"),
+		)
+	}), IsNil)
+	c.Assert(rsp.Close(), IsNil)
+	strReaders, tskReaders = readers()
+	c.Check(strReaders, DeepEquals, map[string]bool{"carol": true})
+	c.Check(tskReaders, DeepEquals, map[string]bool{
+		"bob": true, "carol": true,
+	})
+	c.Check(seen.take("alice"), DeepEquals, []store.Resourcer{
+		stream.Removed(strID), task.Removed(tsk.ID),
+	})
+	seen.take("carol")
+
+	c.Log("a direct reader stays when the circle is deleted")
+	c.Assert(s.db.Update(func(tx *bolt.Tx) error {
+		if err := stream.Get(str, strID)(tx); err != nil {
+			return err
+		}
+		delete(str.CircleReaders, "carol")
+		return stream.Upsert(str)(tx)
+	}), IsNil)
+	w = send("DELETE", "/circles/"+team.ID, nil, "alice")
+	c.Check(w.Code, Equals, http.StatusNotFound)
+	w = send("DELETE", "/circles/"+team.ID, nil, "bob")
+	c.Assert(w.Code, Equals, http.StatusOK)
+
+	strReaders, tskReaders = readers()
+	c.Check(strReaders, DeepEquals, map[string]bool{"carol": true})
+	c.Check(tskReaders, DeepEquals, map[string]bool{"bob": true})
+	carolSeen = seen.take("carol")
+	c.Assert(carolSeen, HasLen, 2)
+	c.Assert(carolSeen[0], FitsTypeOf, stream.Changed{})
+	c.Check(carolSeen[0].(stream.Changed).ID, Equals, strID)
+	c.Check(carolSeen[0].(stream.Changed).Actor(), Equals, "bob")
+	c.Check(carolSeen[1], Equals, task.Removed(tsk.ID))
+	w = send("GET", "/circles/"+team.ID, nil, "bob")
+	c.Check(w.Code, Equals, http.StatusNotFound)
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/synapse-garden/sg-proto/convo"
 	"github.com/synapse-garden/sg-proto/notif"
+	"github.com/synapse-garden/sg-proto/presence"
 	mw "github.com/synapse-garden/sg-proto/rest/middleware"
 	"github.com/synapse-garden/sg-proto/rest/ws"
 	"github.com/synapse-garden/sg-proto/store"
@@ -28,7 +29,11 @@ const ConvoNotifs = "convos"
 // Convo implements API.  It manages Convos.
 type Convo struct {
 	*bolt.DB
+	mw.Sessions
 	river.Pub
+
+	// Presence, if set, tracks who is connected to each Convo.
+	Presence *presence.Tracker
 }
 
 // Bind implements API.Bind on Convo.
@@ -46,37 +51,37 @@ func (c *Convo) Bind(r *htr.Router) error {
 		return err
 	}
 
-	r.GET("/convos/:convo_id/start", mw.AuthWSUser(
+	r.GET("/convos/:convo_id/start", c.AuthWSUser(
 		c.Connect,
 		db, mw.CtxSetUserID,
 	))
 
-	r.GET("/convos/:convo_id/messages", mw.AuthUser(
+	r.GET("/convos/:convo_id/messages", c.AuthUser(
 		c.GetMessages,
 		db, mw.CtxSetUserID,
 	))
 
-	r.POST("/convos", mw.AuthUser(
+	r.POST("/convos", c.AuthUser(
 		c.Create,
 		db, mw.CtxSetUserID,
 	))
 
-	r.GET("/convos", mw.AuthUser(
+	r.GET("/convos", c.AuthUser(
 		c.GetAll,
 		db, mw.CtxSetUserID,
 	))
 
-	r.GET("/convos/:convo_id", mw.AuthUser(
+	r.GET("/convos/:convo_id", c.AuthUser(
 		c.Get,
 		db, mw.CtxSetUserID,
 	))
 
-	r.PUT("/convos/:convo_id", mw.AuthUser(
+	r.PUT("/convos/:convo_id", c.AuthUser(
 		c.Put,
 		db, mw.CtxSetUserID,
 	))
 
-	r.DELETE("/convos/:convo_id", mw.AuthUser(
+	r.DELETE("/convos/:convo_id", c.AuthUser(
 		c.Delete,
 		db, mw.CtxSetUserID,
 	))
@@ -203,12 +208,26 @@ func (c Convo) Connect(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 		}
 	}
 
+	// Messages from users blocked before connecting are dropped.
+	blocks := new(users.Blocks)
+	if err := c.View(users.GetBlocks(userID, blocks)); err != nil {
+		log.Printf("failed to get blocks of user %q: %s",
+			userID, err.Error())
+	}
+
+	untrack := track(c.Presence, userID, presence.Location{
+		Kind: presence.Convo, ID: conv.ID,
+	})
 	xws.Server{
 		Handshake: ws.Check,
 		// Use the HangupSender.Read to hang up the
 		// river if a hangup survey is received.
-		Handler: ws.Bind(rv, h.Read),
+		Handler: ws.Bind(
+			unblocked{rv, blocks.Blocked},
+			touching(c.Presence, userID, h.Read),
+		),
 	}.ServeHTTP(w, r)
+	untrack()
 
 	var last bool
 	err = c.Update(func(tx *bolt.Tx) (e error) {
@@ -334,7 +353,11 @@ func (c Convo) GetMessages(w http.ResponseWriter, r *http.Request, ps htr.Params
 		return
 	}
 
+	blocks := new(users.Blocks)
 	err = c.View(func(tx *bolt.Tx) (e error) {
+		if e = users.GetBlocks(userID, blocks)(tx); e != nil {
+			return
+		}
 		result, e = convo.GetMessages(convoID, tx)
 		return
 	})
@@ -349,6 +372,7 @@ func (c Convo) GetMessages(w http.ResponseWriter, r *http.Request, ps htr.Params
 		return
 	}
 
+	result = withoutBlocked(result, blocks.Blocked)
 	if err := json.NewEncoder(w).Encode(result); err != nil {
 		http.Error(w, errors.Wrap(
 			err, "failed to write Convo to user",
@@ -410,6 +434,8 @@ func (c Convo) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 	err = c.Update(store.Wrap(
 		convo.CheckNotExist(id),
 		users.CheckUsersExist(allUsers...),
+		users.CheckNotBlocked(userID, allUsers...),
+		users.ResolveCircles(&str.Group, nil),
 		convo.Upsert(str),
 		convo.InitMessages(str.ID),
 	))
@@ -419,8 +445,10 @@ func (c Convo) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		switch {
 		case convo.IsExists(err):
 			code = http.StatusConflict
-		case users.IsMissing(err):
+		case users.IsMissing(err), users.IsCircleMissing(err):
 			code = http.StatusNotFound
+		case users.IsBlocked(err):
+			code = http.StatusForbidden
 		default:
 			code = http.StatusInternalServerError
 		}
@@ -430,7 +458,7 @@ func (c Convo) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 
 	// Notify convo members that they have been added.
 	for u := range str.Readers {
-		err = notif.Encode(c.Pub, str, notif.MakeUserTopic(u))
+		err = notif.Encode(c.Pub, convo.Changed{Convo: str, By: userID}, notif.MakeUserTopic(u))
 		if err != nil {
 			log.Printf("failed to notify user %q of convo add", u)
 		}
@@ -514,6 +542,26 @@ func (c Convo) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 		return
 	}
 
+	err = c.View(store.Wrap(
+		users.CheckNotBlocked(userID,
+			users.Added(existing.Group, str.Group)...,
+		),
+		users.ResolveCircles(&str.Group, &existing.Group),
+	))
+	switch {
+	case users.IsCircleMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case users.IsBlocked(err):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to resolve circles",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
 	updateUsers := users.DiffGroups(existing.Group, str.Group)
 
 	// Hang up Convo users.  If this fails, don't delete the convo.
@@ -609,7 +657,7 @@ hangupRemoved:
 	for u, ok := range updateUsers {
 		topic := notif.MakeUserTopic(u)
 		if ok {
-			err = notif.Encode(c.Pub, str, topic)
+			err = notif.Encode(c.Pub, convo.Changed{Convo: str, By: userID}, topic)
 		} else {
 			err = notif.Encode(c.Pub, convo.Removed(id), topic)
 		}
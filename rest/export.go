@@ -0,0 +1,140 @@
+package rest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/synapse-garden/sg-proto/export"
+	"github.com/synapse-garden/sg-proto/notif"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/stream/river"
+	"github.com/synapse-garden/sg-proto/util"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+const ExportNotifs = "exports"
+
+// Export implements API.  It builds archives of users' data in the
+// background, and notifies them with an export.Export when it is ready
+// to be downloaded from GET /exports/:token.  The link needs no
+// session, so it still works after the user deletes their profile,
+// until it expires.
+type Export struct {
+	*bolt.DB
+	mw.Sessions
+	river.Pub
+	util.Timer
+}
+
+// Bind implements API.Bind on Export.
+func (e *Export) Bind(r *htr.Router) error {
+	if e.DB == nil {
+		return errors.New("Export DB handle must not be nil")
+	}
+	if e.Timer == nil {
+		e.Timer = util.SimpleTimer{}
+	}
+
+	// Exports which were being built when the server stopped will
+	// never be finished, so their owners may begin new ones.
+	err := e.Update(func(tx *bolt.Tx) (err error) {
+		if err = export.CancelPending(tx); err != nil {
+			return
+		}
+		e.Pub, err = river.NewPub(ExportNotifs, NotifStream, tx)
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	r.POST("/profile/export", e.AuthUser(e.Create, e.DB, mw.CtxSetUserID))
+	r.GET("/exports/:token", e.Get)
+
+	return nil
+}
+
+// Create is a Handle which begins building an archive of the user's
+// data, and writes the pending export.Export with its token.
+func (e *Export) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	userID := mw.CtxGetUserID(r)
+	ex := new(export.Export)
+	err := e.Update(export.Begin(userID, e.Now(), ex))
+	switch {
+	case export.IsPending(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to begin export",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	go e.build(*ex)
+
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(ex); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write export",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// build writes the archive for the given pending export.Export and
+// notifies its owner.  If it fails, the Export is cancelled and its
+// owner is notified of the Error.
+func (e *Export) build(ex export.Export) {
+	var buf bytes.Buffer
+	err := e.View(export.Write(ex.Owner, &buf))
+	if err == nil {
+		err = e.Update(export.Finish(ex.Token, buf.Bytes(), &ex))
+	}
+	if err != nil {
+		log.Printf("failed to export user %#q: %s", ex.Owner, err)
+		if err := e.Update(export.Cancel(ex.Token)); err != nil {
+			log.Printf("failed to cancel export: %s", err)
+		}
+		ex.Token, ex.Error = "", err.Error()
+	}
+
+	notif.Encode(e.Pub, &ex, notif.MakeUserTopic(ex.Owner))
+}
+
+// Get is a Handle which serves the zip archive of the export.Export
+// with the given token.
+func (e *Export) Get(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	var (
+		ex      = new(export.Export)
+		archive []byte
+	)
+	err := e.View(export.Get(ps.ByName("token"), e.Now(), ex, &archive))
+	switch {
+	case export.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case export.IsNotReady(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to get export",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "application/zip")
+	h.Set("Content-Length", strconv.Itoa(len(archive)))
+	h.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		ex.Owner+"-"+ex.Created.UTC().Format("20060102")+".zip",
+	))
+	w.Write(archive)
+}
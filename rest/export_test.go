@@ -0,0 +1,111 @@
+package rest_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/export"
+	"github.com/synapse-garden/sg-proto/rest"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/stream/river"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	. "gopkg.in/check.v1"
+)
+
+func cleanupExportAPI(c *C, api *rest.Export) {
+	c.Assert(api.Pub.Close(), IsNil)
+	c.Assert(api.Update(func(tx *bolt.Tx) error {
+		return river.DeletePub(rest.ExportNotifs, rest.NotifStream, tx)
+	}), IsNil)
+}
+
+func (s *RESTSuite) TestExportBind(c *C) {
+	c.Check(new(rest.Export).Bind(htr.New()), ErrorMatches,
+		"Export DB handle must not be nil")
+
+	c.Log("exports left pending by a restart are cancelled")
+	abandoned := new(export.Export)
+	c.Assert(s.db.Update(export.Begin("bob", time.Now(), abandoned)), IsNil)
+
+	api := &rest.Export{DB: s.db}
+	c.Assert(api.Bind(htr.New()), IsNil)
+	c.Check(api.Timer, NotNil)
+	c.Check(s.db.Update(export.Begin("bob", time.Now(), abandoned)), IsNil)
+	c.Assert(s.db.Update(export.Cancel(abandoned.Token)), IsNil)
+	cleanupExportAPI(c, api)
+}
+
+func (s *RESTSuite) TestExport(c *C) {
+	var (
+		now = time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+		api = &rest.Export{DB: s.db, Timer: sgt.Timer(now)}
+		r   = htr.New()
+	)
+	_, err := sgt.MakeLogin("bob", "some-password", s.db)
+	c.Assert(err, IsNil)
+	sesh := new(auth.Session)
+	c.Assert(sgt.GetSession("bob", sesh, s.db), IsNil)
+	c.Assert(api.Bind(r), IsNil)
+	defer cleanupExportAPI(c, api)
+
+	seen := observeNotifs()
+	defer seen.Close()
+
+	send := func(method, path string, header http.Header) *htt.ResponseRecorder {
+		req := htt.NewRequest(method, path, nil)
+		if header != nil {
+			req.Header = header
+		}
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	c.Log("POST /profile/export needs a session")
+	c.Check(send("POST", "/profile/export", nil).Code,
+		Equals, http.StatusBadRequest)
+
+	c.Log("bob begins an export, and is notified when it's ready")
+	w := send("POST", "/profile/export", sgt.Bearer(sesh.Token))
+	c.Assert(w.Code, Equals, http.StatusAccepted)
+	pending := new(export.Export)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), pending), IsNil)
+	c.Check(pending.Ready, Equals, false)
+	c.Check(pending.Expires, Equals, now.Add(export.Expiration))
+
+	got := seen.await("bob", func(val store.Resourcer) bool {
+		_, ok := val.(*export.Export)
+		return ok
+	}, 5*time.Second)
+	c.Assert(got, NotNil, Commentf("timed out waiting for export"))
+	ex := got.(*export.Export)
+	c.Check(ex.Token, Equals, pending.Token)
+	c.Check(ex.Ready, Equals, true)
+	c.Check(ex.Error, Equals, "")
+
+	c.Log("anyone with the link can download it")
+	w = send("GET", "/exports/"+ex.Token, nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Check(w.Header().Get("Content-Type"), Equals, "application/zip")
+	c.Check(w.Header().Get("Content-Disposition"), Equals,
+		`attachment; filename="bob-20170101.zip"`)
+	c.Check(w.Body.Len(), Equals, ex.Size)
+	z, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	c.Assert(err, IsNil)
+	c.Check(z.File[0].Name, Equals, "profile.json")
+
+	c.Log("unknown and expired links are not found")
+	c.Check(send("GET", "/exports/some-token", nil).Code,
+		Equals, http.StatusNotFound)
+	api.Timer = sgt.Timer(now.Add(export.Expiration))
+	c.Check(send("GET", "/exports/"+ex.Token, nil).Code,
+		Equals, http.StatusNotFound)
+}
@@ -4,9 +4,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/synapse-garden/sg-proto/auth"
 	"github.com/synapse-garden/sg-proto/incept"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
 	"github.com/synapse-garden/sg-proto/users"
 
 	"github.com/boltdb/bolt"
@@ -47,6 +49,26 @@ func (i Incept) Incept(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 		return
 	}
 
+	var (
+		now  = time.Now()
+		addr = auth.AddrAttempts(mw.ClientAddr(r))
+	)
+
+	// The attempt is counted as failed until it succeeds, so that
+	// concurrent guesses can't all pass the check.
+	err := i.Update(auth.BeginAttempt(now, addr))
+	switch e := err.(type) {
+	case nil:
+	case auth.ErrLockedOut:
+		lockedOut(w, e, now)
+		return
+	default:
+		http.Error(w, errors.Wrap(
+			err, "failed to check incept attempts",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
 	key := ps.ByName("key")
 	tkt, err := uuid.FromString(key)
 	if err != nil {
@@ -55,12 +77,18 @@ func (i Incept) Incept(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 	}
 
 	err = incept.Incept(incept.Ticket(tkt), l, i.DB)
+	if _, ok := err.(incept.ErrTicketMissing); !ok {
+		// Only missing tickets might be guesses.
+		forgiveAttempt(i.DB, addr)
+	}
 	if err != nil {
 		var status int
 		switch err.(type) {
 		case incept.ErrTicketMissing:
 			status = http.StatusNotFound
-		case users.ErrExists:
+		case incept.ErrTicketExpired:
+			status = http.StatusGone
+		case users.ErrExists, incept.ErrReserved:
 			status = http.StatusConflict
 		case auth.ErrExists:
 			status = http.StatusConflict
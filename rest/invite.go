@@ -0,0 +1,136 @@
+package rest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/incept"
+	"github.com/synapse-garden/sg-proto/mail"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Invite implements API.  It lets users invite others by issuing
+// Tickets, each of which costs Price coin.
+type Invite struct {
+	*bolt.DB
+	mw.Sessions
+
+	Price int64
+
+	// Mailer, if set, lets users have their invites mailed.
+	Mailer *mail.Mailer
+}
+
+// InviteRequest is the optional body of a POST /invites.  If Email is
+// set, the invite is mailed there.
+type InviteRequest struct {
+	Email string `json:"email,omitempty"`
+}
+
+// Bind implements API.Bind on Invite.
+func (i Invite) Bind(r *htr.Router) error {
+	db := i.DB
+	if db == nil {
+		return errors.New("Invite DB handle must not be nil")
+	}
+	if i.Price < 0 {
+		return errors.New("Invite Price must not be negative")
+	}
+
+	r.POST("/invites", i.AuthUser(i.Create, db, mw.CtxSetUserID))
+	r.GET("/invites", i.AuthUser(i.GetAll, db, mw.CtxSetUserID))
+
+	return nil
+}
+
+// Create is a Handle which charges the user Price coin and returns a
+// new Invite, mailing it if an InviteRequest with an Email is given.
+// If the user can't afford it, the response is 402 Payment Required.
+func (i Invite) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	req := new(InviteRequest)
+	switch err := json.NewDecoder(r.Body).Decode(req); {
+	case err == io.EOF:
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to decode invite request",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Email != "" {
+		if i.Mailer == nil {
+			http.Error(w, "mail is not configured", http.StatusBadRequest)
+			return
+		}
+		if err := mail.ValidAddress(req.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now()
+	inv := &incept.Invite{
+		Ticket:  incept.Ticket(uuid.NewV4()),
+		Inviter: mw.CtxGetUserID(r),
+		Price:   i.Price,
+		Created: now,
+	}
+
+	create := incept.NewInvite(inv)
+	if req.Email != "" {
+		create = store.Wrap(create, i.Mailer.Invite(
+			req.Email, inv.Inviter, inv.Ticket, nil, now,
+		))
+	}
+
+	err := i.Update(create)
+	switch {
+	case users.IsInsufficientCoin(err):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	case users.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to create invite",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(inv); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write invite",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetAll is a Handle which returns the user's Invites.
+func (i Invite) GetAll(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	var invs []*incept.Invite
+	err := i.View(incept.GetInvites(mw.CtxGetUserID(r), &invs))
+	if err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get invites",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if invs == nil {
+		invs = []*incept.Invite{}
+	}
+	if err := json.NewEncoder(w).Encode(invs); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write invites",
+		).Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,119 @@
+package rest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/incept"
+	"github.com/synapse-garden/sg-proto/rest"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	htr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RESTSuite) TestInviteBind(c *C) {
+	r := htr.New()
+	c.Check(rest.Invite{}.Bind(r), ErrorMatches, ".*not be nil")
+	c.Check(rest.Invite{DB: s.db, Price: -1}.Bind(r),
+		ErrorMatches, ".*not be negative")
+	c.Check(rest.Invite{DB: s.db}.Bind(r), IsNil)
+}
+
+func (s *RESTSuite) TestInvites(c *C) {
+	var (
+		tokenUUID = uuid.NewV4()
+		adminKey  = auth.Token(tokenUUID[:])
+		api       = &rest.Admin{Token: adminKey, DB: s.db}
+		r         = htr.New()
+		srv, tkns = prepAdminAPI(c, r, api, "bob")
+	)
+	defer srv.Close()
+	defer cleanupAdminAPI(c, api)
+
+	c.Assert(rest.Invite{DB: s.db, Price: 10}.Bind(r), IsNil)
+	c.Assert(rest.Incept{DB: s.db}.Bind(r), IsNil)
+
+	post := func(token auth.Token) *htt.ResponseRecorder {
+		req := htt.NewRequest("POST", "/invites", nil)
+		req.Header = sgt.Bearer(token)
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	c.Log("bob can't afford an invite")
+	w := post(tkns["bob"])
+	c.Check(w.Code, Equals, http.StatusPaymentRequired)
+	c.Check(w.Body.String(), Equals, "user `bob` has 0 coin, needs 10\n")
+
+	c.Assert(s.db.Update(users.AddCoin(&users.User{Name: "bob"}, 15)), IsNil)
+
+	c.Log("bob pays for an invite")
+	w = post(tkns["bob"])
+	c.Assert(w.Code, Equals, http.StatusOK)
+	inv := new(incept.Invite)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), inv), IsNil)
+	c.Check(inv.Inviter, Equals, "bob")
+	c.Check(inv.Price, Equals, int64(10))
+
+	c.Log("a second invite is too expensive")
+	c.Check(post(tkns["bob"]).Code, Equals, http.StatusPaymentRequired)
+
+	c.Log("amy uses bob's invite")
+	c.Check(sgt.ExpectResponse(r, "/incept/"+inv.Ticket.String(), "POST",
+		&auth.Login{
+			User:   users.User{Name: "amy"},
+			PWHash: sgt.Sha256("password"),
+		},
+		new(users.User), &users.User{Name: "amy"}, http.StatusOK,
+		nil,
+	), IsNil)
+
+	c.Log("bob sees his invite was redeemed")
+	var invs []*incept.Invite
+	req := htt.NewRequest("GET", "/invites", nil)
+	req.Header = sgt.Bearer(tkns["bob"])
+	w = htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &invs), IsNil)
+	c.Assert(invs, HasLen, 1)
+	c.Check(invs[0].Invitee, Equals, "amy")
+
+	c.Log("an admin can see the invite tree")
+	c.Check(sgt.ExpectResponse(r, "/admin/invites", "GET", nil,
+		&[]*incept.InviteNode{}, &[]*incept.InviteNode{{
+			Name:    "bob",
+			Invited: []*incept.InviteNode{{Name: "amy"}},
+		}}, http.StatusOK,
+		sgt.Admin(adminKey),
+	), IsNil)
+
+	c.Log("an admin can revoke amy's unused invites")
+	c.Assert(s.db.Update(users.AddCoin(&users.User{Name: "amy"}, 10)), IsNil)
+	amySesh := new(auth.Session)
+	c.Assert(sgt.GetSession("amy", amySesh, s.db), IsNil)
+	w = post(amySesh.Token)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), inv), IsNil)
+
+	var revoked []*incept.Invite
+	req = htt.NewRequest("DELETE", "/admin/invites/amy", nil)
+	req.Header = sgt.Admin(adminKey)
+	w = htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &revoked), IsNil)
+	c.Assert(revoked, HasLen, 1)
+	c.Check(revoked[0].Ticket, Equals, inv.Ticket)
+
+	c.Check(sgt.ExpectResponse(r, "/admin/invites/amy", "GET", nil,
+		&[]*incept.Invite{}, &[]*incept.Invite{}, http.StatusOK,
+		sgt.Admin(adminKey),
+	), IsNil)
+}
@@ -0,0 +1,65 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/task"
+
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// bindLabels binds the label colour endpoints of the Task API.
+func (t *Task) bindLabels(r *htr.Router) {
+	r.GET("/profile/labels", t.AuthUser(
+		t.GetColours,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+
+	r.PUT("/profile/labels", t.AuthUser(
+		t.PutColours,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+}
+
+// GetColours is a Handle which returns the user's task.Colours.
+func (t *Task) GetColours(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	var cs task.Colours
+	if err := t.View(task.GetColours(mw.CtxGetUserID(r), &cs)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get label colours",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(cs)
+}
+
+// PutColours is a Handle which sets the user's task.Colours.
+func (t *Task) PutColours(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	var cs task.Colours
+	if err := json.NewDecoder(r.Body).Decode(&cs); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "bad body",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+	cs, err := cs.Normalize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := t.Update(task.SetColours(mw.CtxGetUserID(r), cs)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to set label colours",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(cs)
+}
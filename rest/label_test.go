@@ -0,0 +1,116 @@
+package rest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/rest"
+	"github.com/synapse-garden/sg-proto/task"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	htr "github.com/julienschmidt/httprouter"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RESTSuite) TestTaskLabels(c *C) {
+	var (
+		r   = htr.New()
+		now = time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+		api = &rest.Task{DB: s.db, Timer: sgt.Timer(now)}
+
+		srv, tokens = prepTaskAPI(c, r, api, "bodie", "bob")
+	)
+	defer srv.Close()
+	defer cleanupTaskAPI(c, api)
+
+	send := func(method, path, user string, body interface{}) *htt.ResponseRecorder {
+		bs, err := json.Marshal(body)
+		c.Assert(err, IsNil)
+		req := htt.NewRequest(method, path, bytes.NewBuffer(bs))
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	names := func(query string) []string {
+		w := send("GET", "/tasks?"+query, "bob", nil)
+		c.Assert(w.Code, Equals, http.StatusOK, Commentf(query))
+		var ts []*task.Task
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &ts), IsNil)
+		result := []string{}
+		for _, t := range ts {
+			result = append(result, t.Name)
+		}
+		return result
+	}
+
+	group := users.Group{
+		Owner:   "bodie",
+		Readers: map[string]bool{"bob": true},
+		Writers: map[string]bool{"bob": true},
+	}
+	var created []*task.Task
+	for _, t := range []*task.Task{
+		{Group: group, Name: "ship", Labels: []string{" Release", "ops"},
+			Priority: task.Urgent},
+		{Group: group, Name: "notes", Labels: []string{"release"},
+			Priority: task.Low},
+		{Group: group, Name: "lunch"},
+	} {
+		w := send("POST", "/tasks", "bodie", t)
+		c.Assert(w.Code, Equals, http.StatusOK)
+		got := new(task.Task)
+		c.Assert(json.Unmarshal(w.Body.Bytes(), got), IsNil)
+		created = append(created, got)
+	}
+
+	c.Log("labels are normalized")
+	c.Check(created[0].Labels, DeepEquals, []string{"ops", "release"})
+	c.Check(created[0].Priority, Equals, task.Urgent)
+
+	c.Log("tasks can be found by label and priority")
+	c.Check(names("label=RELEASE&sort=name"), DeepEquals,
+		[]string{"notes", "ship"})
+	c.Check(names("label=release&sort=-priority"), DeepEquals,
+		[]string{"ship", "notes"})
+	c.Check(names("q=priority>low"), DeepEquals, []string{"ship"})
+	c.Check(names("label=nope"), DeepEquals, []string{})
+
+	c.Log("writers can change labels and priority")
+	lunch := created[2]
+	lunch.Labels, lunch.Priority = []string{"release"}, task.High
+	w := send("PUT", "/tasks/"+lunch.ID.String(), "bob", lunch)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Check(names("label=release&sort=-priority"), DeepEquals,
+		[]string{"ship", "lunch", "notes"})
+
+	c.Log("bad labels and priorities are rejected")
+	lunch.Labels = []string{"a b"}
+	w = send("PUT", "/tasks/"+lunch.ID.String(), "bob", lunch)
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Equals,
+		`invalid label "a b": must not contain ' '`+"\n")
+	w = send("POST", "/tasks", "bodie", map[string]interface{}{
+		"owner": "bodie", "name": "x", "priority": "asap",
+	})
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	w = send("GET", "/tasks?label=a+b", "bob", nil)
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+
+	c.Log("users set their own label colours")
+	w = send("GET", "/profile/labels", "bob", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Check(w.Body.String(), Equals, "{}\n")
+	w = send("PUT", "/profile/labels", "bob", task.Colours{"Release": "#F00"})
+	c.Assert(w.Code, Equals, http.StatusOK)
+	w = send("GET", "/profile/labels", "bob", nil)
+	c.Check(w.Body.String(), Equals, `{"release":"#f00"}`+"\n")
+	w = send("GET", "/profile/labels", "bodie", nil)
+	c.Check(w.Body.String(), Equals, "{}\n")
+	w = send("PUT", "/profile/labels", "bob", task.Colours{"release": "red"})
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+}
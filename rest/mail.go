@@ -0,0 +1,186 @@
+package rest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/mail"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// Mail implements API.  It handles users' mail settings and password
+// resets by mail.
+type Mail struct {
+	*bolt.DB
+	mw.Sessions
+	*mail.Mailer
+}
+
+// ResetRequest is the body of a POST /resets.
+type ResetRequest struct {
+	Name string `json:"name"`
+}
+
+// NewPassword is the body of a PUT /resets/:token.
+type NewPassword struct {
+	PWHash []byte `json:"pwhash"`
+}
+
+// Bind implements API.Bind on Mail.
+func (m Mail) Bind(r *htr.Router) error {
+	db := m.DB
+	switch {
+	case db == nil:
+		return errors.New("Mail DB handle must not be nil")
+	case m.Mailer == nil:
+		return errors.New("Mail Mailer must not be nil")
+	}
+
+	r.GET("/profile/mail", m.AuthUser(m.GetAddress, db, mw.CtxSetUserID))
+	r.PUT("/profile/mail", m.AuthUser(m.PutAddress, db, mw.CtxSetUserID))
+	r.POST("/resets", m.NewReset)
+	r.PUT("/resets/:token", m.UseReset)
+
+	return nil
+}
+
+// GetAddress is a Handle which returns the user's mail.Address.
+func (m Mail) GetAddress(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	a := new(mail.Address)
+	if err := m.View(mail.GetAddress(mw.CtxGetUserID(r), a)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get mail address",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(a); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write mail address",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// PutAddress is a Handle which sets the user's mail.Address.  An empty
+// email deletes it.
+func (m Mail) PutAddress(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	a := new(mail.Address)
+	if err := json.NewDecoder(r.Body).Decode(a); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to decode mail address",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+	if a.Email != "" {
+		if err := mail.ValidAddress(a.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err := m.Update(mail.SetAddress(mw.CtxGetUserID(r), a)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to set mail address",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(a); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write mail address",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// NewReset is a Handle which mails a password reset link to the named
+// user, if they have a mail.Address.  The response is 202 Accepted
+// either way, so it can't be used to find out who has one.
+func (m Mail) NewReset(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	req := new(ResetRequest)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to decode reset request",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	err := m.Update(func(tx *bolt.Tx) error {
+		a := new(mail.Address)
+		if err := mail.GetAddress(req.Name, a)(tx); err != nil {
+			return err
+		}
+		if a.Email == "" {
+			return nil
+		}
+
+		var token string
+		err := auth.NewReset(req.Name, now, &token)(tx)
+		switch err.(type) {
+		case nil:
+		case auth.ErrMissing, auth.ErrDisabled:
+			return nil
+		default:
+			return err
+		}
+
+		return m.Reset(a.Email, req.Name, token,
+			now.Add(auth.ResetExpiration), now)(tx)
+	})
+	if err != nil {
+		log.Printf("ERROR: failed to make password reset for %#q: %s",
+			req.Name, err.Error())
+		http.Error(w, "failed to make password reset",
+			http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// UseReset is a Handle which sets a new password using a reset token.
+// All of the user's sessions are ended.
+func (m Mail) UseReset(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	pw := new(NewPassword)
+	if err := json.NewDecoder(r.Body).Decode(pw); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to decode new password",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+	if len(pw.PWHash) != sha256.Size {
+		http.Error(w, "invalid SHA-256 pwhash", http.StatusBadRequest)
+		return
+	}
+
+	reset := new(auth.Reset)
+	err := m.Update(store.Wrap(
+		auth.UseReset(ps.ByName("token"), pw.PWHash, time.Now(), reset),
+		func(tx *bolt.Tx) error {
+			return revokeSigned(m.Keys, reset.UserID)(tx)
+		},
+	))
+	switch err.(type) {
+	case nil:
+	case auth.ErrResetMissing:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case auth.ErrMissing, auth.ErrDisabled:
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	default:
+		http.Error(w, errors.Wrap(
+			err, "failed to reset password",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,141 @@
+package rest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"regexp"
+	"strings"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/mail"
+	"github.com/synapse-garden/sg-proto/rest"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	htr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RESTSuite) TestMailBind(c *C) {
+	r := htr.New()
+	c.Check(rest.Mail{}.Bind(r), ErrorMatches, ".*DB handle must not be nil")
+	c.Check(rest.Mail{DB: s.db}.Bind(r), ErrorMatches, ".*Mailer must not be nil")
+	c.Check(rest.Mail{DB: s.db, Mailer: new(mail.Mailer)}.Bind(r), IsNil)
+}
+
+func (s *RESTSuite) getQueue(c *C) []*mail.Message {
+	var queue []*mail.Message
+	c.Assert(s.db.View(mail.GetQueue(&queue)), IsNil)
+	return queue
+}
+
+func (s *RESTSuite) TestMail(c *C) {
+	var (
+		tokenUUID = uuid.NewV4()
+		adminKey  = auth.Token(tokenUUID[:])
+		mailer    = &mail.Mailer{BaseURL: "https://sg.example.com"}
+		api       = &rest.Admin{Token: adminKey, DB: s.db, Mailer: mailer}
+		r         = htr.New()
+		srv, tkns = prepAdminAPI(c, r, api, "bob")
+	)
+	defer srv.Close()
+	defer cleanupAdminAPI(c, api)
+
+	c.Assert(rest.Mail{DB: s.db, Mailer: mailer}.Bind(r), IsNil)
+
+	do := func(method, path string, body interface{}, hdr http.Header) *htt.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			c.Assert(json.NewEncoder(&buf).Encode(body), IsNil)
+		}
+		req := htt.NewRequest(method, path, &buf)
+		req.Header = hdr
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	c.Log("bob has no address yet")
+	c.Check(sgt.ExpectResponse(r, "/profile/mail", "GET", nil,
+		new(mail.Address), &mail.Address{}, http.StatusOK,
+		sgt.Bearer(tkns["bob"]),
+	), IsNil)
+
+	c.Log("a reset for bob is accepted, but nothing is mailed")
+	w := do("POST", "/resets", &rest.ResetRequest{Name: "bob"}, nil)
+	c.Check(w.Code, Equals, http.StatusAccepted)
+	c.Check(s.getQueue(c), HasLen, 0)
+
+	c.Log("bob can't set an invalid address")
+	w = do("PUT", "/profile/mail", &mail.Address{Email: "bob"},
+		sgt.Bearer(tkns["bob"]))
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+
+	c.Log("bob sets his address")
+	addr := &mail.Address{Email: "bob@example.com"}
+	c.Check(sgt.ExpectResponse(r, "/profile/mail", "PUT", addr,
+		new(mail.Address), addr, http.StatusOK,
+		sgt.Bearer(tkns["bob"]),
+	), IsNil)
+
+	c.Log("a reset for an unknown user looks the same")
+	w = do("POST", "/resets", &rest.ResetRequest{Name: "alice"}, nil)
+	c.Check(w.Code, Equals, http.StatusAccepted)
+	c.Check(s.getQueue(c), HasLen, 0)
+
+	c.Log("a reset for bob is mailed to him")
+	w = do("POST", "/resets", &rest.ResetRequest{Name: "bob"}, nil)
+	c.Check(w.Code, Equals, http.StatusAccepted)
+	queue := s.getQueue(c)
+	c.Assert(queue, HasLen, 1)
+	c.Check(queue[0].To, DeepEquals, []string{"bob@example.com"})
+	link := regexp.MustCompile(
+		`https://sg\.example\.com/resets/(\S+)`,
+	).FindStringSubmatch(queue[0].Body)
+	c.Assert(link, HasLen, 2)
+	token := link[1]
+
+	c.Log("a bad token or password is rejected")
+	w = do("PUT", "/resets/bogus",
+		&rest.NewPassword{PWHash: sgt.Sha256("new")}, nil)
+	c.Check(w.Code, Equals, http.StatusNotFound)
+	w = do("PUT", "/resets/"+token,
+		&rest.NewPassword{PWHash: []byte("short")}, nil)
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+
+	c.Log("bob resets his password, ending his session")
+	w = do("PUT", "/resets/"+token,
+		&rest.NewPassword{PWHash: sgt.Sha256("new")}, nil)
+	c.Check(w.Code, Equals, http.StatusOK)
+	c.Check(s.db.View(auth.Check(&auth.Login{
+		User:   users.User{Name: "bob"},
+		PWHash: sgt.Sha256("new"),
+	})), IsNil)
+	w = do("GET", "/profile/mail", nil, sgt.Bearer(tkns["bob"]))
+	c.Check(w.Code, Equals, http.StatusUnauthorized)
+
+	c.Log("the token can't be used again")
+	w = do("PUT", "/resets/"+token,
+		&rest.NewPassword{PWHash: sgt.Sha256("newer")}, nil)
+	c.Check(w.Code, Equals, http.StatusNotFound)
+
+	c.Log("an admin can mail one ticket")
+	w = do("POST", "/admin/tickets?count=2&email=amy@example.com", nil,
+		sgt.Admin(adminKey))
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	w = do("POST", "/admin/tickets?email=amy@example.com", nil,
+		sgt.Admin(adminKey))
+	c.Assert(w.Code, Equals, http.StatusOK)
+	var tkts []string
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &tkts), IsNil)
+	c.Assert(tkts, HasLen, 1)
+
+	queue = s.getQueue(c)
+	c.Assert(queue, HasLen, 2)
+	c.Check(queue[1].To, DeepEquals, []string{"amy@example.com"})
+	c.Check(strings.Contains(queue[1].Body,
+		"https://sg.example.com/incept/"+tkts[0]), Equals, true)
+}
@@ -3,6 +3,7 @@ package middleware
 import (
 	"encoding/base64"
 	"log"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/boltdb/bolt"
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
+	"golang.org/x/net/context"
 )
 
 type Header string
@@ -23,8 +25,26 @@ const (
 	RefreshHeader Header = "X-Auth-Refresh"
 
 	WSProtocolsHeader Header = "Sec-WebSocket-Protocol"
+
+	// TokenHeader carries a new signed Bearer token in the response
+	// when an expired one was refreshed.
+	TokenHeader Header = "X-Auth-Token"
 )
 
+// Sessions configures the sessions AuthUser and AuthWSUser accept
+// besides stored Bearer tokens.  The zero value accepts only those.
+type Sessions struct {
+	// Keys verifies signed Bearer tokens without a database lookup.
+	// It is nil unless stateless sessions are enabled.
+	Keys *auth.KeyRing
+
+	// Cookies lets browser clients keep their session in cookies
+	// rather than in JavaScript.  If it is set, the session and
+	// refresh cookies are accepted when no token is sent in the
+	// headers.
+	Cookies bool
+}
+
 func GetToken(kind auth.TokenType, from string) ([]byte, error) {
 	// Token is expected to be base64 encoded byte slice.
 	// Kind is assumed to be valid.
@@ -70,18 +90,61 @@ func GetWSToken(protocols string, kind auth.TokenType) ([]byte, error) {
 	)
 }
 
+// ClientAddr returns the host part of the request's remote address, for
+// tracking failed attempts by client.
+func ClientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AuthUser only allows requests with a valid stored session token, and
+// applies the given Contexters.
 func AuthUser(h httprouter.Handle, db *bolt.DB, ctrs ...Contexter) httprouter.Handle {
+	return Sessions{}.AuthUser(h, db, ctrs...)
+}
+
+// AuthUser only allows requests with a session the Sessions accept, and
+// applies the given Contexters.
+func (s Sessions) AuthUser(h httprouter.Handle, db *bolt.DB, ctrs ...Contexter) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		// Is an authorized key in the header?
 		bearerToken, err := GetToken(
 			auth.BearerType,
 			r.Header.Get(string(AuthHeader)),
 		)
+		getRefresh := func() (auth.Token, error) {
+			return GetToken(
+				auth.RefreshType,
+				r.Header.Get(string(RefreshHeader)),
+			)
+		}
+		fromCookie := false
+		if err != nil {
+			// Is there a session cookie instead?
+			switch t, cErr := s.CookieToken(r); {
+			case cErr == nil:
+				bearerToken, err, fromCookie = t, nil, true
+				getRefresh = func() (auth.Token, error) {
+					return cookieToken(r, RefreshCookie)
+				}
+			case IsCSRF(cErr):
+				http.Error(w, cErr.Error(), http.StatusForbidden)
+				return
+			}
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		if auth.IsSigned(bearerToken) {
+			authSigned(w, r, ps, h, db, s.Keys, bearerToken, getRefresh, fromCookie, ctrs)
+			return
+		}
+
 		// TODO: Split into my two functions.
 		// Check whether the session token is valid
 		err = db.View(auth.CheckToken(bearerToken))
@@ -125,10 +188,7 @@ func AuthUser(h httprouter.Handle, db *bolt.DB, ctrs ...Contexter) httprouter.Ha
 			)
 			return
 		case auth.IsTokenExpired(err):
-			rToken, err := GetToken(
-				auth.RefreshType,
-				r.Header.Get(string(RefreshHeader)),
-			)
+			rToken, err := getRefresh()
 			if err != nil {
 				http.Error(w, errors.Wrap(
 					err, "invalid refresh token",
@@ -240,18 +300,51 @@ func AuthUser(h httprouter.Handle, db *bolt.DB, ctrs ...Contexter) httprouter.Ha
 	}
 }
 
+// AuthWSUser is like AuthUser, but also looks for the token in the
+// websocket subprotocols.
 func AuthWSUser(h httprouter.Handle, db *bolt.DB, ctrs ...Contexter) httprouter.Handle {
+	return Sessions{}.AuthWSUser(h, db, ctrs...)
+}
+
+// AuthWSUser is like Sessions.AuthUser, but also looks for the token in
+// the websocket subprotocols.
+func (s Sessions) AuthWSUser(h httprouter.Handle, db *bolt.DB, ctrs ...Contexter) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		// Is an authorized key in the header?
 		token, err := GetWSToken(
 			r.Header.Get(string(WSProtocolsHeader)),
 			auth.BearerType,
 		)
+		getRefresh := func() (auth.Token, error) {
+			return GetWSToken(
+				r.Header.Get(string(WSProtocolsHeader)),
+				auth.RefreshType,
+			)
+		}
+		fromCookie := false
+		if err != nil {
+			// Is there a session cookie instead?
+			switch t, cErr := s.CookieToken(r); {
+			case cErr == nil:
+				token, err, fromCookie = t, nil, true
+				getRefresh = func() (auth.Token, error) {
+					return cookieToken(r, RefreshCookie)
+				}
+			case IsCSRF(cErr):
+				http.Error(w, cErr.Error(), http.StatusForbidden)
+				return
+			}
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		if auth.IsSigned(token) {
+			authSigned(w, r, ps, h, db, s.Keys, token, getRefresh, fromCookie, ctrs)
+			return
+		}
+
 		// TODO: Split into my two functions.
 		// Check whether the session token is valid
 		err = db.View(auth.CheckToken(token))
@@ -293,10 +386,7 @@ func AuthWSUser(h httprouter.Handle, db *bolt.DB, ctrs ...Contexter) httprouter.
 				http.StatusUnauthorized)
 			return
 		case auth.IsTokenExpired(err):
-			rToken, err := GetWSToken(
-				r.Header.Get(string(WSProtocolsHeader)),
-				auth.RefreshType,
-			)
+			rToken, err := getRefresh()
 			if err != nil {
 				http.Error(w, errors.Wrap(err,
 					"invalid refresh token",
@@ -411,7 +501,109 @@ func AuthWSUser(h httprouter.Handle, db *bolt.DB, ctrs ...Contexter) httprouter.
 	}
 }
 
-func AuthAdmin(h httprouter.Handle, db *bolt.DB) httprouter.Handle {
+// authSigned verifies a signed Bearer token using keys and applies the
+// given Contexters.  The database is only used if the token expired and
+// must be exchanged using the refresh token; the new token is then sent
+// back in TokenHeader, or in the session cookie if it came from one.
+// Tokens without auth.UserScope are forbidden.
+func authSigned(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps httprouter.Params,
+	h httprouter.Handle,
+	db *bolt.DB,
+	keys *auth.KeyRing,
+	token auth.Token,
+	getRefresh func() (auth.Token, error),
+	fromCookie bool,
+	ctrs []Contexter,
+) {
+	if keys == nil {
+		http.Error(w, "invalid session token", http.StatusUnauthorized)
+		return
+	}
+
+	now := time.Now()
+	claims, err := keys.Verify(token, now)
+	switch {
+	case err == nil:
+	case auth.IsMissingSession(err):
+		http.Error(w, "invalid session token", http.StatusUnauthorized)
+		return
+	case auth.IsTokenExpired(err):
+		rToken, err := getRefresh()
+		if err != nil {
+			http.Error(w, errors.Wrap(
+				err, "invalid refresh token",
+			).Error(), http.StatusUnauthorized)
+			return
+		}
+
+		sess := &auth.Session{RefreshToken: rToken}
+		err = db.Update(auth.RefreshSigned(keys, sess,
+			now.Add(auth.Expiration),
+			auth.Expiration,
+		))
+		switch {
+		case auth.IsMissingSession(err):
+			http.Error(w,
+				"invalid refresh token",
+				http.StatusUnauthorized,
+			)
+			return
+		case err != nil:
+			http.Error(w, errors.Wrap(err,
+				"failed to refresh session",
+			).Error(), http.StatusInternalServerError)
+			log.Printf("Failed to refresh signed "+
+				"session: %#v", err)
+			return
+		}
+
+		if claims, err = keys.Verify(sess.Token, now); err != nil {
+			http.Error(w, errors.Wrap(err,
+				"failed to verify session "+
+					"token after refresh",
+			).Error(), http.StatusInternalServerError)
+			log.Printf("Failed to verify signed session "+
+				"token after refresh: %#v", err)
+			return
+		}
+
+		token = sess.Token
+		if fromCookie {
+			setSessionCookie(w, token)
+		} else {
+			w.Header().Set(string(TokenHeader),
+				auth.BearerType.String()+" "+token.String(),
+			)
+		}
+	default:
+		http.Error(w, errors.Wrap(
+			err, "unexpected server error",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !claims.HasScope(auth.UserScope) {
+		http.Error(w,
+			"session token does not have the user scope",
+			http.StatusForbidden,
+		)
+		return
+	}
+
+	ctx := &auth.Context{Token: token, UserID: claims.UserID}
+	for _, ctr := range ctrs {
+		r = ctr(r, ctx)
+	}
+	h(w, r, ps)
+}
+
+// AuthAdmin only allows requests with an admin Credential that has the
+// given Role.  The Credential's name is put in the request context, and
+// can be retrieved using CtxGetAdmin.
+func AuthAdmin(h httprouter.Handle, db *bolt.DB, role admin.Role) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		// Is an authorized key in the header?
 		token, err := GetToken(
@@ -422,11 +614,15 @@ func AuthAdmin(h httprouter.Handle, db *bolt.DB) httprouter.Handle {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		err = db.View(admin.CheckToken(token))
+		cred := new(admin.Credential)
+		err = db.View(admin.CheckToken(token, role, time.Now(), cred))
 		switch {
 		case admin.IsNotFound(err):
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
+		case admin.IsForbidden(err):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
 		case err != nil:
 			http.Error(w, errors.Wrap(err,
 				"error authorizing admin API key",
@@ -434,6 +630,8 @@ func AuthAdmin(h httprouter.Handle, db *bolt.DB) httprouter.Handle {
 			return
 		}
 
-		h(w, r, ps)
+		h(w, r.WithContext(context.WithValue(
+			r.Context(), auth.CtxAdmin, cred.Name,
+		)), ps)
 	}
 }
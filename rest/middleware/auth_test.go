@@ -41,6 +41,8 @@ func (s *MiddlewareSuite) SetUpTest(c *C) {
 			auth.SessionBucket,
 			auth.RefreshBucket,
 			auth.ContextBucket,
+			auth.SigningKeyBucket,
+			auth.RevokedBucket,
 		),
 	)), IsNil)
 	s.db, s.tmpDir = db, tmpDir
@@ -94,6 +96,75 @@ func (s *MiddlewareSuite) TestAuthUser(c *C) {
 	c.Check(w.Body.String(), Equals, "invalid session token\n")
 }
 
+func (s *MiddlewareSuite) TestAuthUserSigned(c *C) {
+	keys := auth.NewKeyRing()
+	sessions := middleware.Sessions{Keys: keys}
+
+	now := time.Now()
+	sess := &auth.Session{}
+	c.Assert(s.db.Update(keys.Rotate(now)), IsNil)
+	c.Assert(s.db.Update(auth.NewSignedSession(
+		keys,
+		sess,
+		now.Add(-time.Second),
+		time.Hour,
+		auth.NewToken(auth.RefreshType),
+		"friendo",
+	)), IsNil)
+
+	bearer := fmt.Sprintf("%s %s", auth.BearerType, sess.Token)
+	refresh := fmt.Sprintf("%s %s", auth.RefreshType, sess.RefreshToken)
+	h := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		c.Check(middleware.CtxGetUserID(r), Equals, "friendo")
+		w.Write([]byte("ok"))
+	}
+
+	c.Log("An expired signed token without a refresh token is rejected.")
+	r := htt.NewRequest("GET", "/foo", nil)
+	r.Header.Set(string(middleware.AuthHeader), bearer)
+	w := htt.NewRecorder()
+	sessions.AuthUser(h, s.db, middleware.CtxSetUserID)(w, r, nil)
+	c.Check(w.Code, Equals, http.StatusUnauthorized)
+
+	c.Log("With a refresh token, a new signed token is returned.")
+	r.Header.Set(string(middleware.RefreshHeader), refresh)
+	w = htt.NewRecorder()
+	sessions.AuthUser(h, s.db, middleware.CtxSetUserID)(w, r, nil)
+	c.Check(w.Body.String(), Equals, "ok")
+	newBearer := w.Header().Get(string(middleware.TokenHeader))
+	c.Assert(newBearer, Not(Equals), "")
+
+	c.Log("The new token is accepted with no stored session.")
+	r = htt.NewRequest("GET", "/foo", nil)
+	r.Header.Set(string(middleware.AuthHeader), newBearer)
+	w = htt.NewRecorder()
+	sessions.AuthUser(h, s.db, middleware.CtxSetUserID)(w, r, nil)
+	c.Check(w.Body.String(), Equals, "ok")
+	c.Check(w.Header().Get(string(middleware.TokenHeader)), Equals, "")
+
+	c.Log("A token without the user scope is forbidden.")
+	unscoped, err := keys.Sign(&auth.Claims{
+		SessionID: "other", UserID: "friendo", Expires: now.Add(time.Hour),
+	})
+	c.Assert(err, IsNil)
+	r2 := htt.NewRequest("GET", "/foo", nil)
+	r2.Header.Set(string(middleware.AuthHeader),
+		fmt.Sprintf("%s %s", auth.BearerType, unscoped),
+	)
+	w = htt.NewRecorder()
+	sessions.AuthUser(h, s.db, middleware.CtxSetUserID)(w, r2, nil)
+	c.Check(w.Code, Equals, http.StatusForbidden)
+	c.Check(w.Body.String(), Equals, "session token does not have the user scope\n")
+
+	c.Log("Once the user's sessions are revoked, it is rejected.")
+	c.Assert(s.db.Update(keys.RevokeUser(
+		"friendo", now.Add(time.Hour),
+	)), IsNil)
+	w = htt.NewRecorder()
+	sessions.AuthUser(h, s.db, middleware.CtxSetUserID)(w, r, nil)
+	c.Check(w.Body.String(), Equals, "invalid session token\n")
+}
+
 func (s *MiddlewareSuite) TestAuthWS(c *C) {
 	sess := &auth.Session{}
 	c.Assert(s.db.Update(auth.NewSession(
@@ -186,3 +257,94 @@ func (s *MiddlewareSuite) TestGetToken(c *C) {
 		c.Check(got, DeepEquals, test.expect)
 	}
 }
+
+func (s *MiddlewareSuite) TestAuthUserCookie(c *C) {
+	sessions := middleware.Sessions{Cookies: true}
+
+	sess := &auth.Session{}
+	c.Assert(s.db.Update(auth.NewSession(
+		sess,
+		time.Now().Add(time.Hour),
+		time.Hour,
+		auth.NewToken(auth.BearerType),
+		auth.NewToken(auth.RefreshType),
+		"friendo",
+	)), IsNil)
+
+	w := htt.NewRecorder()
+	csrf, err := middleware.SetSessionCookies(w, sess)
+	c.Assert(err, IsNil)
+	cookies := w.Result().Cookies()
+	c.Assert(cookies, HasLen, 3)
+	for _, ck := range cookies {
+		c.Check(ck.Secure, Equals, true)
+		c.Check(ck.SameSite, Equals, http.SameSiteStrictMode)
+		c.Check(ck.HttpOnly, Equals, ck.Name != middleware.CSRFCookie)
+	}
+
+	h := func(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+		c.Check(middleware.CtxGetUserID(r), Equals, "friendo")
+		w.Write([]byte("ok"))
+	}
+	withCookies := func(method string, hdr http.Header) *http.Request {
+		r := htt.NewRequest(method, "http://example.com/foo", nil)
+		for k, vs := range hdr {
+			r.Header[k] = vs
+		}
+		for _, ck := range cookies {
+			r.AddCookie(ck)
+		}
+		return r
+	}
+
+	for i, test := range []struct {
+		should string
+		method string
+		header http.Header
+		expect string
+		code   int
+	}{{
+		should: "accept a GET with only cookies",
+		method: "GET",
+		expect: "ok",
+		code:   http.StatusOK,
+	}, {
+		should: "reject a POST without the CSRF header",
+		method: "POST",
+		expect: "CSRF check failed: CSRF header does not match cookie\n",
+		code:   http.StatusForbidden,
+	}, {
+		should: "reject a POST with the wrong CSRF header",
+		method: "POST",
+		header: http.Header{"X-Csrf-Token": {"nope"}},
+		expect: "CSRF check failed: CSRF header does not match cookie\n",
+		code:   http.StatusForbidden,
+	}, {
+		should: "accept a POST with the CSRF header",
+		method: "POST",
+		header: http.Header{"X-Csrf-Token": {csrf}},
+		expect: "ok",
+		code:   http.StatusOK,
+	}, {
+		should: "reject a cross-origin request",
+		method: "GET",
+		header: http.Header{"Origin": {"https://evil.example.com"}},
+		expect: "CSRF check failed: cross-origin request\n",
+		code:   http.StatusForbidden,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		w := htt.NewRecorder()
+		sessions.AuthUser(h, s.db, middleware.CtxSetUserID)(
+			w, withCookies(test.method, test.header), nil,
+		)
+		c.Check(w.Code, Equals, test.code)
+		c.Check(w.Body.String(), Equals, test.expect)
+	}
+
+	c.Log("Cookies are ignored unless the Sessions accept them.")
+	w = htt.NewRecorder()
+	middleware.AuthUser(h, s.db, middleware.CtxSetUserID)(
+		w, withCookies("GET", nil), nil,
+	)
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+}
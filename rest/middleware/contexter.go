@@ -49,3 +49,9 @@ func CtxGetToken(r *http.Request) auth.Token {
 func CtxGetRefreshToken(r *http.Request) auth.Token {
 	return r.Context().Value(auth.CtxRefreshToken).(auth.Token)
 }
+
+// CtxGetAdmin returns the name of the admin Credential which authorized
+// the request.
+func CtxGetAdmin(r *http.Request) string {
+	return r.Context().Value(auth.CtxAdmin).(string)
+}
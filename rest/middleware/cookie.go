@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+
+	"github.com/synapse-garden/sg-proto/auth"
+
+	"github.com/pkg/errors"
+)
+
+// Session cookie names.  SessionCookie and RefreshCookie are HttpOnly.
+// CSRFCookie is readable by scripts, which must echo it in CSRFHeader
+// for any request which is not GET, HEAD or OPTIONS.
+const (
+	SessionCookie = "sg_session"
+	RefreshCookie = "sg_refresh"
+	CSRFCookie    = "sg_csrf"
+
+	CSRFHeader Header = "X-CSRF-Token"
+)
+
+// LoginStateCookie holds the state of an external login which was begun
+// by this client.  It is HttpOnly, and only sent to /tokens.
+const LoginStateCookie = "sg_login_state"
+
+// ErrCSRF is returned when a cookie-authenticated request fails its
+// CSRF check.
+type ErrCSRF string
+
+func (e ErrCSRF) Error() string { return "CSRF check failed: " + string(e) }
+
+// IsCSRF indicates whether the given error is an ErrCSRF.
+func IsCSRF(err error) bool {
+	if err == nil {
+		return false
+	}
+	_, ok := err.(ErrCSRF)
+	return ok
+}
+
+// errNoCookie means there was no session cookie to use.
+var errNoCookie = errors.New("no session cookie")
+
+func safeMethod(m string) bool {
+	switch m {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// cookieToken returns the decoded value of the named cookie.
+func cookieToken(r *http.Request, name string) (auth.Token, error) {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return nil, errors.Errorf("no %#q cookie", name)
+	}
+	return auth.DecodeToken(c.Value)
+}
+
+// CookieToken returns the session token from the request's cookies, if
+// the Sessions accept Cookies and the session cookie is set.  Requests
+// using unsafe methods must send the CSRF cookie's value in CSRFHeader,
+// and websocket handshakes must come from the same origin; otherwise
+// CookieToken returns ErrCSRF.
+func (s Sessions) CookieToken(r *http.Request) (auth.Token, error) {
+	if !s.Cookies {
+		return nil, errNoCookie
+	}
+	if _, err := r.Cookie(SessionCookie); err != nil {
+		return nil, errNoCookie
+	}
+
+	if !safeMethod(r.Method) {
+		c, err := r.Cookie(CSRFCookie)
+		if err != nil || c.Value == "" {
+			return nil, ErrCSRF("no CSRF cookie")
+		}
+		sent := r.Header.Get(string(CSRFHeader))
+		if subtle.ConstantTimeCompare([]byte(sent), []byte(c.Value)) != 1 {
+			return nil, ErrCSRF("CSRF header does not match cookie")
+		}
+	}
+
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return nil, ErrCSRF("cross-origin request")
+		}
+	}
+
+	return cookieToken(r, SessionCookie)
+}
+
+// SetSessionCookies sets the session, refresh and CSRF cookies for the
+// given Session on the response, and returns the CSRF token.  The
+// cookies last as long as the browser session; an expired session
+// token is refreshed using the refresh cookie.
+func SetSessionCookies(w http.ResponseWriter, s *auth.Session) (string, error) {
+	csrf := make([]byte, 32)
+	if _, err := rand.Read(csrf); err != nil {
+		return "", errors.Wrap(err, "failed to make CSRF token")
+	}
+	csrfToken := base64.RawURLEncoding.EncodeToString(csrf)
+
+	setSessionCookie(w, s.Token)
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshCookie,
+		Value:    s.RefreshToken.String(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    csrfToken,
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return csrfToken, nil
+}
+
+func setSessionCookie(w http.ResponseWriter, t auth.Token) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookie,
+		Value:    t.String(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// ClearSessionCookies tells the browser to delete its session cookies.
+func ClearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{SessionCookie, RefreshCookie, CSRFCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Value:    "",
+			Path:     "/",
+			MaxAge:   -1,
+			Secure:   true,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// SetLoginStateCookie binds an external login's state to the client.
+// It is Lax so that it survives the redirect back from the provider.
+func SetLoginStateCookie(w http.ResponseWriter, state string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     LoginStateCookie,
+		Value:    state,
+		Path:     "/tokens",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// CheckLoginState clears the login state cookie, and returns ErrCSRF
+// unless it held the given state.
+func CheckLoginState(w http.ResponseWriter, r *http.Request, state string) error {
+	http.SetCookie(w, &http.Cookie{
+		Name:     LoginStateCookie,
+		Value:    "",
+		Path:     "/tokens",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	c, err := r.Cookie(LoginStateCookie)
+	if err != nil || c.Value == "" {
+		return ErrCSRF("no login state cookie")
+	}
+	if subtle.ConstantTimeCompare([]byte(state), []byte(c.Value)) != 1 {
+		return ErrCSRF("login state does not match cookie")
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"github.com/synapse-garden/sg-proto/notif"
+	"github.com/synapse-garden/sg-proto/presence"
 	mw "github.com/synapse-garden/sg-proto/rest/middleware"
 	"github.com/synapse-garden/sg-proto/rest/ws"
 	"github.com/synapse-garden/sg-proto/store"
@@ -24,6 +25,10 @@ const NotifStream = "notifs"
 // websocket when an API publishes a notification event to its Pub.
 type Notif struct {
 	*bolt.DB
+	mw.Sessions
+
+	// Presence, if set, tracks who is connected to their notifs.
+	Presence *presence.Tracker
 }
 
 // Bind implements API.Bind on Notif.
@@ -32,7 +37,7 @@ func (n Notif) Bind(r *htr.Router) error {
 		return errors.New("Notif DB handle must not be nil")
 	}
 	// When a client wants to connect to notifs, use stream.NewSub.
-	r.GET("/notifs", mw.AuthWSUser(n.Connect, n.DB, mw.CtxSetUserID))
+	r.GET("/notifs", n.AuthWSUser(n.Connect, n.DB, mw.CtxSetUserID))
 	return nil
 }
 
@@ -89,10 +94,14 @@ func (n Notif) Connect(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		errCh <- river.AwaitHangup(h)
 	}()
 
+	untrack := track(n.Presence, userID, presence.Location{
+		Kind: presence.Notifs,
+	})
 	xws.Server{
 		Handshake: ws.Check,
 		Handler:   ws.BindRead(h.Recver()),
 	}.ServeHTTP(w, r)
+	untrack()
 
 	err = n.Update(func(tx *bolt.Tx) error {
 		read.Close()
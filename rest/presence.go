@@ -0,0 +1,231 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/notif"
+	"github.com/synapse-garden/sg-proto/presence"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/rest/ws"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/stream"
+	"github.com/synapse-garden/sg-proto/stream/river"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	xws "golang.org/x/net/websocket"
+)
+
+const PresenceNotifs = "presence"
+
+// MaxPresenceUsers is the most users whose presence.Presence can be
+// asked for at once.
+const MaxPresenceUsers = 100
+
+// Presence implements API.  It lets users see whether the users they
+// share a Stream, Convo or Task with are around, and notifies them when
+// that changes.  Bind sets the Tracker's OnChange.
+type Presence struct {
+	*bolt.DB
+	mw.Sessions
+	river.Pub
+	*presence.Tracker
+}
+
+// Bind implements API.Bind on Presence.
+func (p *Presence) Bind(r *htr.Router) error {
+	switch {
+	case p.DB == nil:
+		return errors.New("Presence DB handle must not be nil")
+	case p.Tracker == nil:
+		return errors.New("Presence Tracker must not be nil")
+	}
+
+	err := p.Update(func(tx *bolt.Tx) (e error) {
+		p.Pub, e = river.NewPub(PresenceNotifs, NotifStream, tx)
+		return
+	})
+	if err != nil {
+		return err
+	}
+	p.OnChange = p.notify
+
+	r.GET("/presence", p.AuthUser(p.Get, p.DB, mw.CtxSetUserID))
+
+	return nil
+}
+
+// track records that the user connected to the given Location in the
+// Tracker, if any, and returns a function which records that they left.
+func track(t *presence.Tracker, user string, loc presence.Location) func() {
+	if t == nil {
+		return func() {}
+	}
+	t.Connect(user, loc)
+	return func() { t.Disconnect(user, loc) }
+}
+
+// touching returns a ws.SocketReader which records activity by the user
+// in the Tracker, if any, each time read reads a message.
+func touching(t *presence.Tracker, user string, read ws.SocketReader) ws.SocketReader {
+	if t == nil {
+		return read
+	}
+	if read == nil {
+		read = ws.DefaultRead
+	}
+	return func(c *xws.Conn) ([]byte, bool, error) {
+		bs, ok, err := read(c)
+		if err == nil {
+			t.Touch(user)
+		}
+		return bs, ok, err
+	}
+}
+
+// visible returns a function which sets *into to a copy of the given
+// Presence, leaving out the Streams and Convos the viewer is not in.
+func visible(
+	viewer string,
+	of *presence.Presence,
+	into **presence.Presence,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		result := *of
+		result.Streams, result.Convos = nil, nil
+
+		for _, id := range of.Streams {
+			str := new(stream.Stream)
+			err := stream.Get(str, id)(tx)
+			switch {
+			case stream.IsMissing(err):
+				continue
+			case err != nil:
+				return err
+			}
+			if users.AllUsers(str.Group)[viewer] {
+				result.Streams = append(result.Streams, id)
+			}
+		}
+
+		for _, id := range of.Convos {
+			conv := new(convo.Convo)
+			err := convo.Get(conv, id)(tx)
+			switch {
+			case convo.IsMissing(err):
+				continue
+			case err != nil:
+				return err
+			}
+			if users.AllUsers(conv.Group)[viewer] {
+				result.Convos = append(result.Convos, id)
+			}
+		}
+
+		*into = &result
+		return nil
+	}
+}
+
+// notify notifies the users who share a group with the user of the
+// given Presence.
+func (p *Presence) notify(of *presence.Presence) {
+	peers := make(map[string]bool)
+	if err := p.View(groupPeers(of.User, peers)); err != nil {
+		log.Printf("failed to get peers of user %q for presence: %s",
+			of.User, err.Error())
+		return
+	}
+
+	for peer := range peers {
+		var seen *presence.Presence
+		if err := p.View(visible(peer, of, &seen)); err != nil {
+			log.Printf("failed to get presence for user %q: %s",
+				peer, err.Error())
+			continue
+		}
+		err := notif.Encode(p.Pub, seen, notif.MakeUserTopic(peer))
+		if err != nil {
+			log.Printf("failed to notify user %q of presence", peer)
+		}
+	}
+}
+
+// Get is a Handle which writes the presence.Presence of each of the
+// users in the comma-separated "users" parameter, in order.  The user
+// may only ask about themselves and users who share a group with them.
+func (p *Presence) Get(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	userID := mw.CtxGetUserID(r)
+
+	var names []string
+	for _, name := range strings.Split(r.FormValue("users"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	switch {
+	case len(names) == 0:
+		http.Error(w, `"users" must not be empty`, http.StatusBadRequest)
+		return
+	case len(names) > MaxPresenceUsers:
+		http.Error(w, fmt.Sprintf(
+			`"users" must have at most %d users`, MaxPresenceUsers,
+		), http.StatusBadRequest)
+		return
+	}
+
+	peers := make(map[string]bool)
+	err := p.View(store.Wrap(
+		users.CheckUsersExist(names...),
+		groupPeers(userID, peers),
+	))
+	switch {
+	case users.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to check users",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for _, name := range names {
+		if name != userID && !peers[name] {
+			http.Error(w, fmt.Sprintf(
+				"user %#q does not share a group with user %#q",
+				name, userID,
+			), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	result := p.Tracker.Get(names...)
+	err = p.View(func(tx *bolt.Tx) error {
+		for i, of := range result {
+			if err := visible(userID, of, &result[i])(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get presence",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write presence",
+		).Error(), http.StatusInternalServerError)
+	}
+}
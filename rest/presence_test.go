@@ -0,0 +1,151 @@
+package rest_test
+
+import (
+	"net/http"
+	htt "net/http/httptest"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/presence"
+	"github.com/synapse-garden/sg-proto/rest"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/stream"
+	"github.com/synapse-garden/sg-proto/stream/river"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func cleanupPresenceAPI(c *C, api *rest.Presence) {
+	c.Assert(api.Pub.Close(), IsNil)
+	c.Assert(api.Update(func(tx *bolt.Tx) error {
+		return river.DeletePub(rest.PresenceNotifs, rest.NotifStream, tx)
+	}), IsNil)
+}
+
+func (s *RESTSuite) TestPresenceBind(c *C) {
+	r := htr.New()
+	c.Check(new(rest.Presence).Bind(r), ErrorMatches,
+		"Presence DB handle must not be nil")
+	c.Check((&rest.Presence{DB: s.db}).Bind(r), ErrorMatches,
+		"Presence Tracker must not be nil")
+
+	api := &rest.Presence{
+		DB:      s.db,
+		Tracker: presence.NewTracker(nil, presence.DefaultIdle),
+	}
+	c.Assert(api.Bind(r), IsNil)
+	c.Check(api.OnChange, NotNil)
+	cleanupPresenceAPI(c, api)
+}
+
+func (s *RESTSuite) TestPresenceGet(c *C) {
+	var (
+		now    = time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+		trk    = presence.NewTracker(sgt.Timer(now), presence.DefaultIdle)
+		api    = &rest.Presence{DB: s.db, Tracker: trk}
+		r      = htr.New()
+		tokens = make(map[string]auth.Token)
+
+		shared, private = uuid.NewV4().String(), uuid.NewV4().String()
+	)
+	for _, user := range []string{"bob", "alice", "carol"} {
+		_, err := sgt.MakeLogin(user, "some-password", s.db)
+		c.Assert(err, IsNil)
+		sesh := new(auth.Session)
+		c.Assert(sgt.GetSession(user, sesh, s.db), IsNil)
+		tokens[user] = sesh.Token
+	}
+	c.Assert(api.Bind(r), IsNil)
+	defer cleanupPresenceAPI(c, api)
+
+	c.Log("bob shares a convo with alice, and has a stream of his own")
+	c.Assert(s.db.Update(store.Wrap(
+		convo.Upsert(&convo.Convo{ID: shared, Group: users.Group{
+			Owner:   "bob",
+			Readers: map[string]bool{"alice": true},
+		}}),
+		stream.Upsert(&stream.Stream{ID: private, Group: users.Group{
+			Owner: "bob",
+		}}),
+	)), IsNil)
+
+	seen := observeNotifs()
+	defer seen.Close()
+
+	c.Log("bob connects to both")
+	trk.Connect("bob", presence.Location{Kind: presence.Convo, ID: shared})
+	trk.Connect("bob", presence.Location{Kind: presence.Stream, ID: private})
+
+	c.Log("only alice is notified, and can't see bob's stream")
+	toAlice := &presence.Presence{
+		User: "bob", Status: presence.Online, LastActive: &now,
+		Convos: []string{shared},
+	}
+	c.Check(seen.take("alice"), DeepEquals, []store.Resourcer{
+		toAlice, toAlice,
+	})
+	c.Check(seen.take("carol"), HasLen, 0)
+	c.Check(seen.take("bob"), HasLen, 0)
+
+	for i, test := range []struct {
+		should     string
+		user       string
+		query      string
+		expect     []*presence.Presence
+		expectCode int
+	}{{
+		should:     "reject an empty query",
+		user:       "alice",
+		query:      "%20,%20",
+		expectCode: http.StatusBadRequest,
+	}, {
+		should:     "reject unknown users",
+		user:       "alice",
+		query:      "bob,dave",
+		expectCode: http.StatusNotFound,
+	}, {
+		should:     "refuse users who share no group",
+		user:       "carol",
+		query:      "bob",
+		expectCode: http.StatusUnauthorized,
+	}, {
+		should: "show alice bob's convo, and herself offline",
+		user:   "alice",
+		query:  "bob,%20alice",
+		expect: []*presence.Presence{toAlice, {
+			User: "alice", Status: presence.Offline,
+		}},
+		expectCode: http.StatusOK,
+	}, {
+		should: "show bob everything of his own",
+		user:   "bob",
+		query:  "bob",
+		expect: []*presence.Presence{{
+			User: "bob", Status: presence.Online, LastActive: &now,
+			Streams: []string{private},
+			Convos:  []string{shared},
+		}},
+		expectCode: http.StatusOK,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		if test.expectCode != http.StatusOK {
+			req := htt.NewRequest("GET", "/presence?users="+test.query, nil)
+			req.Header = sgt.Bearer(tokens[test.user])
+			w := htt.NewRecorder()
+			r.ServeHTTP(w, req)
+			c.Check(w.Code, Equals, test.expectCode)
+			continue
+		}
+		c.Check(sgt.ExpectResponse(r,
+			"/presence?users="+test.query, "GET", nil,
+			new([]*presence.Presence), &test.expect, test.expectCode,
+			sgt.Bearer(tokens[test.user]),
+		), IsNil)
+	}
+}
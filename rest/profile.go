@@ -3,16 +3,20 @@ package rest
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/synapse-garden/sg-proto/auth"
 	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/notif"
 	mw "github.com/synapse-garden/sg-proto/rest/middleware"
 	"github.com/synapse-garden/sg-proto/store"
 	"github.com/synapse-garden/sg-proto/stream"
 	"github.com/synapse-garden/sg-proto/stream/river"
+	"github.com/synapse-garden/sg-proto/task"
 	"github.com/synapse-garden/sg-proto/users"
 
 	"github.com/boltdb/bolt"
@@ -20,9 +24,17 @@ import (
 	"github.com/pkg/errors"
 )
 
+// ProfileNotifs is the ID of the Profile notif Pub.
+const ProfileNotifs = "profiles"
+
 // Profile implements API.  It handles user profiles.
 type Profile struct {
 	*bolt.DB
+	mw.Sessions
+
+	// Pub, if set, is used to notify users when profiles they can see
+	// are changed.
+	river.Pub
 }
 
 // Bind implements API.Bind on Profile.
@@ -31,8 +43,16 @@ func (p Profile) Bind(r *htr.Router) error {
 	if db == nil {
 		return errors.New("Profile DB handle must not be nil")
 	}
-	r.GET("/profile", mw.AuthUser(p.Get, db, mw.CtxSetUserID))
-	r.DELETE("/profile", mw.AuthUser(p.Delete, db, mw.CtxSetUserID))
+	r.GET("/profile", p.AuthUser(p.Get, db, mw.CtxSetUserID))
+	r.PUT("/profile", p.AuthUser(p.Put, db, mw.CtxSetUserID))
+	r.DELETE("/profile", p.AuthUser(p.Delete, db, mw.CtxSetUserID))
+
+	r.PUT("/profile/avatar", p.AuthUser(p.PutAvatar, db, mw.CtxSetUserID))
+	r.DELETE("/profile/avatar", p.AuthUser(p.DeleteAvatar, db, mw.CtxSetUserID))
+	r.GET("/avatars/:user_id", p.AuthUser(p.GetAvatar, db, mw.CtxSetUserID))
+
+	r.GET("/profile/ledger", p.AuthUser(p.GetLedger, db, mw.CtxSetUserID))
+	r.POST("/transfers", p.AuthUser(p.Transfer, db, mw.CtxSetUserID))
 
 	return nil
 }
@@ -59,6 +79,252 @@ func (p Profile) Get(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 	}
 }
 
+// Put is a Handle which validates and sets the user's users.Profile.
+// Its Avatar can only be changed using PUT /profile/avatar.  Users who
+// share a Stream, Convo or Task with the user are notified.
+func (p Profile) Put(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	prof := new(users.Profile)
+	if err := json.NewDecoder(r.Body).Decode(prof); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to decode profile",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+	if err := prof.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u := &users.User{Name: mw.CtxGetUserID(r)}
+	p.update(w, users.SetProfile(u, *prof), u)
+}
+
+// PutAvatar is a Handle which sets the user's users.Avatar to the
+// request body.  Its content type is sniffed, and must be one of
+// users.AvatarTypes.
+func (p Profile) PutAvatar(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, users.MaxAvatarSize+1))
+	switch {
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to read avatar",
+		).Error(), http.StatusBadRequest)
+		return
+	case len(data) > users.MaxAvatarSize:
+		http.Error(w, fmt.Sprintf(
+			"avatar must be at most %d bytes", users.MaxAvatarSize,
+		), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	a := &users.Avatar{
+		ContentType: http.DetectContentType(data),
+		Data:        data,
+	}
+	switch err := a.Validate(); {
+	case err == nil:
+	case len(data) > 0 && !users.AvatarTypes[a.ContentType]:
+		http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+		return
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	u := &users.User{Name: mw.CtxGetUserID(r)}
+	p.update(w, users.SetAvatar(u, a), u)
+}
+
+// DeleteAvatar is a Handle which deletes the user's users.Avatar.
+func (p Profile) DeleteAvatar(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	u := &users.User{Name: mw.CtxGetUserID(r)}
+	p.update(w, users.DeleteAvatar(u), u)
+}
+
+// update applies the given change to u, notifies u and the users who
+// can see u, and writes u to the response.
+func (p Profile) update(w http.ResponseWriter, change store.Mutation, u *users.User) {
+	peers := make(map[string]bool)
+	err := p.Update(store.Wrap(change, groupPeers(u.Name, peers)))
+	switch {
+	case users.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to update profile",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if p.Pub != nil {
+		notif.Encode(p.Pub, u, notif.MakeUserTopic(u.Name))
+		pub := u.Public()
+		for peer := range peers {
+			notif.Encode(p.Pub, pub, notif.MakeUserTopic(peer))
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(u); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write profile",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// GetAvatar is a Handle which serves the given user's users.Avatar.
+// Its ETag is the Avatar's hash.
+func (p Profile) GetAvatar(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	a := new(users.Avatar)
+	err := p.View(users.GetAvatar(ps.ByName("user_id"), a))
+	switch {
+	case users.IsNoAvatar(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to get avatar",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag := `"` + a.Hash() + `"`
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Write(a.Data)
+}
+
+// GetLedger is a Handle which writes the user's users.Ledger.
+func (p Profile) GetLedger(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	l := new(users.Ledger)
+	if err := p.View(users.GetLedger(mw.CtxGetUserID(r), l)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get ledger",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(l); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write ledger",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// Transfer is a Handle which sends coin from the user to the user To
+// of the given users.Transaction, with an optional Memo.  The user
+// cannot send more coin than they have, or send it to a user who
+// blocked them.  Both users are notified with their new users.User, and
+// the recipient with the Transaction.
+func (p Profile) Transfer(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	t := new(users.Transaction)
+	if err := json.NewDecoder(r.Body).Decode(t); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to decode transfer",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := mw.CtxGetUserID(r)
+	*t = users.Transaction{
+		From:   userID,
+		To:     t.To,
+		Amount: t.Amount,
+		Reason: users.Transfer,
+		Memo:   t.Memo,
+		Time:   time.Now(),
+	}
+	if t.To == users.Mint {
+		http.Error(w, "transfer must have a recipient", http.StatusBadRequest)
+		return
+	}
+	if err := t.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	from, to := &users.User{Name: t.From}, &users.User{Name: t.To}
+	err := p.Update(store.Wrap(
+		users.CheckUsersExist(t.To),
+		users.CheckNotBlocked(t.From, t.To),
+		users.Spend(t),
+		loadUsers(from, to),
+	))
+	switch {
+	case users.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case users.IsBlocked(err):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case users.IsInsufficientCoin(err):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to transfer coin",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if p.Pub != nil {
+		notif.Encode(p.Pub, from, notif.MakeUserTopic(from.Name))
+		notif.Encode(p.Pub, to, notif.MakeUserTopic(to.Name))
+		notif.Encode(p.Pub, t, notif.MakeUserTopic(to.Name))
+	}
+
+	if err := json.NewEncoder(w).Encode(t); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write transfer",
+		).Error(), http.StatusInternalServerError)
+	}
+}
+
+// groupPeers returns a function which sets a key in into for each
+// other user who shares a Stream, Convo or Task with the given user.
+func groupPeers(userID string, into map[string]bool) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var groups []users.Group
+
+		strs, err := stream.GetAll(userID)(tx)
+		if err != nil {
+			return err
+		}
+		for _, s := range strs {
+			groups = append(groups, s.Group)
+		}
+
+		convos, err := convo.GetAll(userID)(tx)
+		if err != nil {
+			return err
+		}
+		for _, c := range convos {
+			groups = append(groups, c.Group)
+		}
+
+		tasks, err := task.GetAll(userID)(tx)
+		if err != nil {
+			return err
+		}
+		for _, t := range tasks {
+			groups = append(groups, t.Group)
+		}
+
+		for _, g := range groups {
+			for u := range users.AllUsers(g) {
+				if u != userID {
+					into[u] = true
+				}
+			}
+		}
+		return nil
+	}
+}
+
 // Delete deletes the User by ID from the UserBucket, disables the Login
 // but retains it, and deletes all of the user's Sessions, Contexts, and
 // Tokens.  It also hangs up all of the user's connected rivers.
@@ -221,8 +487,10 @@ hangups:
 	}
 
 	err = p.Update(store.Wrap(
+		store.Delete(users.AvatarBucket, []byte(userID)),
 		users.Delete(userID),
 		auth.Disable(userID),
+		revokeSigned(p.Keys, userID),
 	))
 
 	if err != nil {
@@ -4,19 +4,25 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
 	htt "net/http/httptest"
+	"strings"
 
 	"github.com/synapse-garden/sg-proto/auth"
 	"github.com/synapse-garden/sg-proto/convo"
 	"github.com/synapse-garden/sg-proto/incept"
+	"github.com/synapse-garden/sg-proto/notif"
 	"github.com/synapse-garden/sg-proto/rest"
 	"github.com/synapse-garden/sg-proto/store"
 	"github.com/synapse-garden/sg-proto/stream"
+	"github.com/synapse-garden/sg-proto/stream/river"
+	"github.com/synapse-garden/sg-proto/task"
 	sgt "github.com/synapse-garden/sg-proto/testing"
 	"github.com/synapse-garden/sg-proto/users"
 
+	"github.com/boltdb/bolt"
 	htr "github.com/julienschmidt/httprouter"
 	uuid "github.com/satori/go.uuid"
 	ws "golang.org/x/net/websocket"
@@ -308,6 +314,287 @@ func (s *RESTSuite) TestProfileOptions(c *C) {
 		new(string), "",
 		http.StatusOK,
 		nil,
-		sgt.Options("GET", "DELETE", "OPTIONS"),
+		sgt.Options("GET", "PUT", "DELETE", "OPTIONS"),
 	), IsNil)
 }
+
+func (s *RESTSuite) TestProfilePut(c *C) {
+	var pub river.Pub
+	c.Assert(s.db.Update(func(tx *bolt.Tx) (e error) {
+		pub, e = river.NewPub(rest.ProfileNotifs, rest.NotifStream, tx)
+		return
+	}), IsNil)
+	defer func() {
+		c.Assert(pub.Close(), IsNil)
+		c.Assert(s.db.Update(func(tx *bolt.Tx) error {
+			return river.DeletePub(rest.ProfileNotifs, rest.NotifStream, tx)
+		}), IsNil)
+	}()
+
+	var (
+		api    = rest.Profile{DB: s.db, Pub: pub}
+		r      = htr.New()
+		tokens = make(map[string]auth.Token)
+	)
+	for _, user := range []string{"bob", "alice", "carol", "dave"} {
+		_, err := sgt.MakeLogin(user, "some-password", s.db)
+		c.Assert(err, IsNil)
+		sesh := new(auth.Session)
+		c.Assert(sgt.GetSession(user, sesh, s.db), IsNil)
+		tokens[user] = sesh.Token
+	}
+	c.Assert(api.Bind(r), IsNil)
+
+	c.Log("bob shares a stream with alice and a task with carol")
+	tID := task.ID(uuid.NewV4())
+	c.Assert(s.db.Update(store.Wrap(
+		stream.Upsert(&stream.Stream{
+			ID: uuid.NewV4().String(),
+			Group: users.Group{
+				Owner:   "alice",
+				Readers: map[string]bool{"bob": true},
+			},
+		}),
+		tID.Store(&task.Task{Group: users.Group{
+			Owner:   "carol",
+			Writers: map[string]bool{"bob": true},
+		}}),
+	)), IsNil)
+
+	seen := observeNotifs()
+	defer seen.Close()
+
+	send := func(method, path string, body io.Reader, user string) *htt.ResponseRecorder {
+		req := htt.NewRequest(method, path, body)
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	putProfile := func(p *users.Profile) *htt.ResponseRecorder {
+		bs, err := json.Marshal(p)
+		c.Assert(err, IsNil)
+		return send("PUT", "/profile", bytes.NewReader(bs), "bob")
+	}
+
+	for i, test := range []struct {
+		should       string
+		given        *users.Profile
+		expectStatus int
+		expectBody   string
+	}{{
+		should:       "reject an unknown timezone",
+		given:        &users.Profile{Timezone: "Mars/Olympus"},
+		expectStatus: http.StatusBadRequest,
+		expectBody:   "unknown timezone `Mars/Olympus`\n",
+	}, {
+		should: "reject a long status",
+		given: &users.Profile{
+			Status: string(make([]byte, users.MaxStatus+1)),
+		},
+		expectStatus: http.StatusBadRequest,
+		expectBody:   "status must be at most 140 characters\n",
+	}} {
+		c.Logf("test %d: PUT /profile should %s", i, test.should)
+		w := putProfile(test.given)
+		c.Check(w.Code, Equals, test.expectStatus)
+		c.Check(w.Body.String(), Equals, test.expectBody)
+	}
+	c.Check(seen.takeAll(), HasLen, 0)
+
+	c.Log("bob sets his profile")
+	prof := users.Profile{
+		DisplayName: "Bob",
+		Bio:         "likes tests",
+		Status:      "testing",
+		Timezone:    "America/Chicago",
+	}
+	w := putProfile(&prof)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	expect := &users.User{Name: "bob", Profile: prof}
+	got := new(users.User)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), got), IsNil)
+	c.Check(got, DeepEquals, expect)
+
+	c.Check(sgt.ExpectResponse(r,
+		"/profile", "GET", nil,
+		new(users.User), expect, http.StatusOK,
+		sgt.Bearer(tokens["bob"]),
+	), IsNil)
+
+	c.Log("bob, alice and carol are notified, but not dave")
+	c.Check(seen.takeAll(), DeepEquals, map[notif.UserTopic][]store.Resourcer{
+		notif.MakeUserTopic("bob"):   {expect},
+		notif.MakeUserTopic("alice"): {expect.Public()},
+		notif.MakeUserTopic("carol"): {expect.Public()},
+	})
+
+	c.Log("bob can't upload a non-image avatar")
+	w = send("PUT", "/profile/avatar", strings.NewReader("hello"), "bob")
+	c.Check(w.Code, Equals, http.StatusUnsupportedMediaType)
+	w = send("PUT", "/profile/avatar", bytes.NewReader(
+		make([]byte, users.MaxAvatarSize+1),
+	), "bob")
+	c.Check(w.Code, Equals, http.StatusRequestEntityTooLarge)
+
+	c.Log("bob uploads an avatar")
+	gif := []byte("GIF89a some image")
+	w = send("PUT", "/profile/avatar", bytes.NewReader(gif), "bob")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), got), IsNil)
+	hash := (&users.Avatar{Data: gif}).Hash()
+	c.Check(got.Avatar, Equals, hash)
+	c.Check(got.DisplayName, Equals, "Bob")
+	c.Check(seen.takeAll(), HasLen, 3)
+
+	c.Log("dave can see bob's avatar")
+	w = send("GET", "/avatars/bob", nil, "dave")
+	c.Check(w.Code, Equals, http.StatusOK)
+	c.Check(w.Header().Get("Content-Type"), Equals, "image/gif")
+	c.Check(w.Header().Get("ETag"), Equals, `"`+hash+`"`)
+	c.Check(w.Body.Bytes(), DeepEquals, gif)
+
+	c.Log("PUT /profile doesn't change the avatar")
+	c.Assert(putProfile(&users.Profile{}).Code, Equals, http.StatusOK)
+	w = send("GET", "/avatars/bob", nil, "dave")
+	c.Check(w.Code, Equals, http.StatusOK)
+
+	c.Log("bob deletes his avatar")
+	w = send("DELETE", "/profile/avatar", nil, "bob")
+	c.Assert(w.Code, Equals, http.StatusOK)
+	w = send("GET", "/avatars/bob", nil, "dave")
+	c.Check(w.Code, Equals, http.StatusNotFound)
+}
+
+func (s *RESTSuite) TestProfileTransfer(c *C) {
+	var pub river.Pub
+	c.Assert(s.db.Update(func(tx *bolt.Tx) (e error) {
+		pub, e = river.NewPub(rest.ProfileNotifs, rest.NotifStream, tx)
+		return
+	}), IsNil)
+	defer func() {
+		c.Assert(pub.Close(), IsNil)
+		c.Assert(s.db.Update(func(tx *bolt.Tx) error {
+			return river.DeletePub(rest.ProfileNotifs, rest.NotifStream, tx)
+		}), IsNil)
+	}()
+
+	var (
+		api    = rest.Profile{DB: s.db, Pub: pub}
+		r      = htr.New()
+		tokens = make(map[string]auth.Token)
+	)
+	for _, user := range []string{"bob", "alice", "carol"} {
+		_, err := sgt.MakeLogin(user, "some-password", s.db)
+		c.Assert(err, IsNil)
+		sesh := new(auth.Session)
+		c.Assert(sgt.GetSession(user, sesh, s.db), IsNil)
+		tokens[user] = sesh.Token
+	}
+	c.Assert(api.Bind(r), IsNil)
+	c.Assert(s.db.Update(store.Wrap(
+		users.Post(&users.Transaction{
+			To: "bob", Amount: 10, Reason: users.Grant,
+		}),
+		users.SetBlocked("carol", "bob", true, new(users.Blocks)),
+	)), IsNil)
+
+	seen := observeNotifs()
+	defer seen.Close()
+
+	transfer := func(to string, amount int64) *htt.ResponseRecorder {
+		bs, err := json.Marshal(&users.Transaction{
+			From: "alice", To: to, Amount: amount, Memo: "for lunch",
+		})
+		c.Assert(err, IsNil)
+		req := htt.NewRequest("POST", "/transfers", bytes.NewReader(bs))
+		req.Header = sgt.Bearer(tokens["bob"])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, test := range []struct {
+		should       string
+		to           string
+		amount       int64
+		expectStatus int
+		expectBody   string
+	}{{
+		should:       "reject a transfer to nobody",
+		amount:       1,
+		expectStatus: http.StatusBadRequest,
+		expectBody:   "transfer must have a recipient\n",
+	}, {
+		should:       "reject a transfer to himself",
+		to:           "bob",
+		amount:       1,
+		expectStatus: http.StatusBadRequest,
+		expectBody:   "cannot transfer coin to the same account\n",
+	}, {
+		should:       "reject a negative transfer",
+		to:           "alice",
+		amount:       -1,
+		expectStatus: http.StatusBadRequest,
+		expectBody:   "amount must be positive\n",
+	}, {
+		should:       "reject an unknown user",
+		to:           "dave",
+		amount:       1,
+		expectStatus: http.StatusNotFound,
+		expectBody:   "user `dave` not found\n",
+	}, {
+		should:       "reject a user who blocked bob",
+		to:           "carol",
+		amount:       1,
+		expectStatus: http.StatusForbidden,
+		expectBody:   "user `bob` cannot add user `carol`\n",
+	}, {
+		should:       "reject an overdraft",
+		to:           "alice",
+		amount:       11,
+		expectStatus: http.StatusPaymentRequired,
+		expectBody:   "user `bob` has 10 coin, needs 11\n",
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		w := transfer(test.to, test.amount)
+		c.Check(w.Code, Equals, test.expectStatus)
+		c.Check(w.Body.String(), Equals, test.expectBody)
+	}
+	c.Check(seen.takeAll(), HasLen, 0)
+
+	c.Log("bob sends alice 4 coin")
+	w := transfer("alice", 4)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	t := new(users.Transaction)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), t), IsNil)
+	c.Check(t.From, Equals, "bob")
+	c.Check(t.Reason, Equals, users.Transfer)
+	c.Check(t.Memo, Equals, "for lunch")
+
+	c.Check(seen.take("bob"), DeepEquals, []store.Resourcer{
+		&users.User{Name: "bob", Coin: 6},
+	})
+	toAlice := seen.take("alice")
+	c.Assert(toAlice, HasLen, 2)
+	c.Check(toAlice[0], DeepEquals, &users.User{Name: "alice", Coin: 4})
+	c.Assert(toAlice[1], FitsTypeOf, t)
+	sent := *toAlice[1].(*users.Transaction)
+	c.Check(sent.Time.Equal(t.Time), Equals, true)
+	sent.Time = t.Time
+	c.Check(&sent, DeepEquals, t)
+
+	c.Log("both see it in their ledgers")
+	for user, balance := range map[string]int64{"bob": 6, "alice": 4} {
+		l := new(users.Ledger)
+		req := htt.NewRequest("GET", "/profile/ledger", nil)
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		c.Assert(w.Code, Equals, http.StatusOK)
+		c.Assert(json.Unmarshal(w.Body.Bytes(), l), IsNil)
+		c.Check(l.Balance, Equals, balance)
+		c.Check(l.Transactions[len(l.Transactions)-1], DeepEquals, t)
+		c.Check(s.db.View(users.CheckBalance(user)), IsNil)
+	}
+}
@@ -0,0 +1,508 @@
+package rest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/notif"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// bindProjects binds the Project board endpoints of the Task API.
+func (t *Task) bindProjects(r *htr.Router) {
+	r.GET("/projects", t.AuthUser(t.GetProjects, t.DB, mw.CtxSetUserID))
+	r.POST("/projects", t.AuthUser(t.CreateProject, t.DB, mw.CtxSetUserID))
+	r.GET("/projects/:id", t.AuthUser(t.GetProject, t.DB, mw.CtxSetUserID))
+	r.PUT("/projects/:id", t.AuthUser(t.PutProject, t.DB, mw.CtxSetUserID))
+	r.DELETE("/projects/:id", t.AuthUser(t.DeleteProject, t.DB, mw.CtxSetUserID))
+	r.PUT("/projects/:id/tasks/:task_id", t.AuthUser(
+		t.PlaceTask,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+	r.DELETE("/projects/:id/tasks/:task_id", t.AuthUser(
+		t.UnplaceTask,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+}
+
+// notifyProject notifies the Project's users of it, and tells the users
+// mapped to false in diff that they were removed from it.
+func (t *Task) notifyProject(p *task.Project, diff map[string]bool) {
+	for u := range users.AllUsers(p.Group) {
+		notif.Encode(t.Pub, p, notif.MakeUserTopic(u))
+	}
+	for u, ok := range diff {
+		if !ok {
+			notif.Encode(t.Pub, task.ProjectRemoved(p.ID), notif.MakeUserTopic(u))
+		}
+	}
+}
+
+// notifyTasks notifies the users of each Task, and tells the given
+// former users who can no longer see a Task that they were removed.
+func (t *Task) notifyTasks(ts []*task.Task, former map[string]bool) {
+	for _, tsk := range ts {
+		now := users.AllUsers(tsk.Group)
+		for u := range now {
+			notif.Encode(t.Pub, tsk, notif.MakeUserTopic(u))
+		}
+		for u := range former {
+			if !now[u] {
+				notif.Encode(t.Pub, task.Removed(tsk.ID), notif.MakeUserTopic(u))
+			}
+		}
+	}
+}
+
+// loadOnProject returns a function which loads each Task on the Project
+// into into by its ID, so changes to them can be logged.
+func loadOnProject(p *task.Project, into map[task.ID]*task.Task) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		for _, c := range p.Columns {
+			for _, id := range c.Tasks {
+				tsk := new(task.Task)
+				err := id.Load(tsk)(tx)
+				switch {
+				case store.IsMissing(err):
+					continue
+				case err != nil:
+					return err
+				}
+				tsk.ID = id
+				into[id] = tsk
+			}
+		}
+		return nil
+	}
+}
+
+// logSynced returns a function which logs an Activity by the given user
+// on each of the synced Tasks, with its changes from the Task of the
+// same ID in before.  The Activities are appended to acts in the same
+// order, so they can be notified once the transaction is committed.
+func logSynced(
+	by string,
+	now time.Time,
+	before map[task.ID]*task.Task,
+	synced *[]*task.Task,
+	acts *[]*task.Activity,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		for _, tsk := range *synced {
+			old, ok := before[tsk.ID]
+			if !ok {
+				old = tsk
+			}
+			act := &task.Activity{By: by, Time: now}
+			if err := logChanges(act, old, tsk)(tx); err != nil {
+				return err
+			}
+			*acts = append(*acts, act)
+		}
+		return nil
+	}
+}
+
+// readProject reads a Project from the request body, checking it is
+// owned by the user, and that its users exist and have not blocked the
+// user.  It writes an error and returns false if the Project is bad.
+func (t *Task) readProject(w http.ResponseWriter, r *http.Request) (*task.Project, bool) {
+	userID := mw.CtxGetUserID(r)
+	p := new(task.Project)
+	if err := json.NewDecoder(r.Body).Decode(p); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "bad body",
+		).Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	switch {
+	case p.Owner != userID:
+		http.Error(w, errors.Errorf(
+			"invalid project: user %#q cannot make project "+
+				"for user %#q",
+			userID, p.Owner,
+		).Error(), http.StatusBadRequest)
+		return nil, false
+	case len(p.ReadCircles) > 0, len(p.WriteCircles) > 0:
+		http.Error(w, "projects cannot refer to circles", http.StatusBadRequest)
+		return nil, false
+	}
+	if err := p.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	// Make sure the Owner is in the readers / writers, and writers
+	// are also readers.
+	if p.Readers == nil {
+		p.Readers = make(map[string]bool)
+	}
+	if p.Writers == nil {
+		p.Writers = make(map[string]bool)
+	}
+	p.Readers[userID], p.Writers[userID] = true, true
+	for u := range p.Writers {
+		p.Readers[u] = true
+	}
+	p.CircleReaders, p.CircleWriters = nil, nil
+
+	var all []string
+	for u := range users.AllUsers(p.Group) {
+		all = append(all, u)
+	}
+	err := t.View(store.Wrap(
+		users.CheckUsersExist(all...),
+		users.CheckNotBlocked(userID, all...),
+	))
+	switch {
+	case users.IsMissing(err):
+		http.Error(w, errors.Wrap(
+			err, "failed to check project",
+		).Error(), http.StatusNotFound)
+		return nil, false
+	case users.IsBlocked(err):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return nil, false
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to check project",
+		).Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return p, true
+}
+
+// loadProject loads the Project with the ID in the request path.  It
+// writes an error and returns false if it does not exist, or if the
+// user is not in it.
+func (t *Task) loadProject(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps htr.Params,
+) (*task.Project, bool) {
+	pUUID, err := uuid.FromString(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "invalid project ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	p := new(task.Project)
+	err = t.View(task.GetProject(task.ProjectID(pUUID), p))
+	switch {
+	case task.IsProjectMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to find project",
+		).Error(), http.StatusInternalServerError)
+		return nil, false
+	case !users.AllUsers(p.Group)[mw.CtxGetUserID(r)]:
+		code := http.StatusUnauthorized
+		http.Error(w, http.StatusText(code), code)
+		return nil, false
+	}
+
+	return p, true
+}
+
+// GetProjects is a Handle which writes the user's Projects, by name.
+func (t *Task) GetProjects(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	var ps []*task.Project
+	err := t.View(func(tx *bolt.Tx) (e error) {
+		ps, e = task.GetProjects(mw.CtxGetUserID(r))(tx)
+		return
+	})
+	if err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get projects",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[i].Name < ps[j].Name
+	})
+	if ps == nil {
+		ps = []*task.Project{}
+	}
+	json.NewEncoder(w).Encode(ps)
+}
+
+// CreateProject is a Handle which creates the POSTed Project, owned by
+// the user.  Its Columns start empty; Tasks are added using PlaceTask.
+func (t *Task) CreateProject(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	p, ok := t.readProject(w, r)
+	if !ok {
+		return
+	}
+
+	p.ID = task.ProjectID(uuid.NewV4())
+	for _, c := range p.Columns {
+		c.Tasks = nil
+	}
+	if err := t.Update(task.PutProject(p)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to store project",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t.notifyProject(p, nil)
+	json.NewEncoder(w).Encode(p)
+}
+
+// GetProject is a Handle which writes the Project by ID.
+func (t *Task) GetProject(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	p, ok := t.loadProject(w, r, ps)
+	if !ok {
+		return
+	}
+
+	json.NewEncoder(w).Encode(p)
+}
+
+// PutProject is a Handle which lets the Project's Owner change its
+// name, users and Columns.  Tasks stay in the Columns of the same name,
+// and Columns with Tasks can't be removed.  The Tasks on the Project
+// inherit its new users.
+func (t *Task) PutProject(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	old, ok := t.loadProject(w, r, ps)
+	if !ok {
+		return
+	}
+	if old.Owner != mw.CtxGetUserID(r) {
+		code := http.StatusUnauthorized
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	p, ok := t.readProject(w, r)
+	if !ok {
+		return
+	}
+	cols := p.Columns
+	p.ID, p.Columns = old.ID, old.Columns
+	if err := p.Recolumn(cols); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		synced []*task.Task
+		acts   []*task.Activity
+		before = make(map[task.ID]*task.Task)
+	)
+	ops := []func(*bolt.Tx) error{task.PutProject(p)}
+	if !reflect.DeepEqual(old.Group, p.Group) {
+		ops = append(ops,
+			loadOnProject(p, before),
+			task.SyncProject(p, &synced),
+			logSynced(mw.CtxGetUserID(r), t.Now().UTC(),
+				before, &synced, &acts,
+			),
+		)
+	}
+	if err := t.Update(store.Wrap(ops...)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to update project",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t.notifyProject(p, users.DiffGroups(old.Group, p.Group))
+	t.notifyTasks(synced, users.AllUsers(old.Group))
+	for i, tsk := range synced {
+		t.notifyEntry(tsk, acts[i])
+	}
+	json.NewEncoder(w).Encode(p)
+}
+
+// DeleteProject is a Handle which lets the Project's Owner delete it.
+// Its Tasks are taken off it first.
+func (t *Task) DeleteProject(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	p, ok := t.loadProject(w, r, ps)
+	if !ok {
+		return
+	}
+	if p.Owner != mw.CtxGetUserID(r) {
+		code := http.StatusUnauthorized
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	var (
+		freed  []*task.Task
+		acts   []*task.Activity
+		before = make(map[task.ID]*task.Task)
+	)
+	if err := t.Update(store.Wrap(
+		loadOnProject(p, before),
+		task.DeleteProject(p, &freed),
+		logSynced(p.Owner, t.Now().UTC(), before, &freed, &acts),
+	)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to delete project",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for u := range users.AllUsers(p.Group) {
+		notif.Encode(t.Pub, task.ProjectDeleted(p.ID), notif.MakeUserTopic(u))
+	}
+	t.notifyTasks(freed, users.AllUsers(p.Group))
+	for i, tsk := range freed {
+		t.notifyEntry(tsk, acts[i])
+	}
+}
+
+// loadPlacement loads the Project and the Task with the IDs in the
+// request path.  It writes an error and returns false if either does not
+// exist, or if the user is not in the Project.
+func (t *Task) loadPlacement(
+	w http.ResponseWriter,
+	r *http.Request,
+	ps htr.Params,
+) (*task.Project, *task.Task, bool) {
+	p, ok := t.loadProject(w, r, ps)
+	if !ok {
+		return nil, nil, false
+	}
+
+	tUUID, err := uuid.FromString(ps.ByName("task_id"))
+	if err != nil {
+		http.Error(w, "invalid task ID", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	tsk := new(task.Task)
+	err = t.View(task.ID(tUUID).Load(tsk))
+	switch {
+	case store.IsMissing(err):
+		http.Error(w, "no such task", http.StatusNotFound)
+		return nil, nil, false
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to find task",
+		).Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	tsk.ID = task.ID(tUUID)
+
+	return p, tsk, true
+}
+
+// PlaceTask is a Handle which moves a Task to a {"column", "position"}
+// of the Project, as in task.Project.Move.  The Project's Owner and
+// Writers can move Tasks around it, but only a Task's Owner can add it
+// to a Project.  The Task's Group includes the Project's users while it
+// is on the Project.
+func (t *Task) PlaceTask(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	var body struct {
+		Column   string `json:"column"`
+		Position *int   `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+		http.Error(w, errors.Wrap(
+			err, "bad body",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	p, tsk, ok := t.loadPlacement(w, r, ps)
+	if !ok {
+		return
+	}
+	userID := mw.CtxGetUserID(r)
+	onProject := tsk.Project != nil && *tsk.Project == p.ID
+	if p.Owner != userID && !p.Writers[userID] ||
+		!onProject && tsk.Owner != userID {
+		code := http.StatusUnauthorized
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	pos := -1
+	if body.Position != nil {
+		pos = *body.Position
+	}
+	former := users.AllUsers(tsk.Group)
+	before := *tsk
+	act := &task.Activity{By: userID, Time: t.Now().UTC()}
+	err := t.Update(store.Wrap(
+		task.Place(p, tsk, body.Column, pos),
+		logChanges(act, &before, tsk),
+	))
+	switch {
+	case task.IsColumn(err):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case task.IsOnProject(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to place task",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t.notifyProject(p, nil)
+	t.notifyTasks([]*task.Task{tsk}, former)
+	t.notifyEntry(tsk, act)
+	json.NewEncoder(w).Encode(p)
+}
+
+// UnplaceTask is a Handle which takes a Task off the Project.  The
+// Project's Owner and Writers, and the Task's Owner, can do this.  The
+// Task loses the users it only had because of the Project.
+func (t *Task) UnplaceTask(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	p, tsk, ok := t.loadPlacement(w, r, ps)
+	if !ok {
+		return
+	}
+	userID := mw.CtxGetUserID(r)
+	if p.Owner != userID && !p.Writers[userID] && tsk.Owner != userID {
+		code := http.StatusUnauthorized
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	former := users.AllUsers(tsk.Group)
+	before := *tsk
+	act := &task.Activity{By: userID, Time: t.Now().UTC()}
+	err := t.Update(store.Wrap(
+		task.Unplace(p, tsk),
+		logChanges(act, &before, tsk),
+	))
+	switch {
+	case task.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to take task off project",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	t.notifyProject(p, nil)
+	t.notifyTasks([]*task.Task{tsk}, former)
+	t.notifyEntry(tsk, act)
+	json.NewEncoder(w).Encode(p)
+}
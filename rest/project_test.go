@@ -0,0 +1,246 @@
+package rest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/rest"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	htr "github.com/julienschmidt/httprouter"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RESTSuite) TestTaskProjects(c *C) {
+	var (
+		r   = htr.New()
+		now = time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+		api = &rest.Task{DB: s.db, Timer: sgt.Timer(now)}
+
+		srv, tokens = prepTaskAPI(c, r, api, "bodie", "bob", "alice")
+	)
+	defer srv.Close()
+	defer cleanupTaskAPI(c, api)
+
+	seen := observeNotifs()
+	defer seen.Close()
+
+	send := func(method, path, user string, body interface{}) *htt.ResponseRecorder {
+		bs, err := json.Marshal(body)
+		c.Assert(err, IsNil)
+		req := htt.NewRequest(method, path, bytes.NewBuffer(bs))
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	getTask := func(id task.ID, user string) (*task.Task, int) {
+		w := send("GET", "/tasks/"+id.String(), user, nil)
+		got := new(task.Task)
+		if w.Code == http.StatusOK {
+			c.Assert(json.Unmarshal(w.Body.Bytes(), got), IsNil)
+		}
+		return got, w.Code
+	}
+	board := func(w *htt.ResponseRecorder) map[string][]task.ID {
+		c.Assert(w.Code, Equals, http.StatusOK, Commentf(w.Body.String()))
+		p := new(task.Project)
+		c.Assert(json.Unmarshal(w.Body.Bytes(), p), IsNil)
+		result := make(map[string][]task.ID)
+		for _, col := range p.Columns {
+			result[col.Name] = col.Tasks
+		}
+		return result
+	}
+
+	c.Log("bodie makes a board which bob can write and alice can read")
+	w := send("POST", "/projects", "bodie", map[string]interface{}{
+		"owner":   "bodie",
+		"name":    "launch",
+		"readers": map[string]bool{"alice": true},
+		"writers": map[string]bool{"bob": true},
+		"columns": []map[string]string{
+			{"name": "todo"}, {"name": "doing"}, {"name": "done"},
+		},
+	})
+	c.Assert(w.Code, Equals, http.StatusOK)
+	proj := new(task.Project)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), proj), IsNil)
+	path := "/projects/" + proj.ID.String()
+	c.Check(seen.takeResources("alice"), DeepEquals, []store.Resource{"projects"})
+
+	w = send("GET", "/projects", "alice", nil)
+	var ps []*task.Project
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &ps), IsNil)
+	c.Assert(ps, HasLen, 1)
+	c.Check(ps[0].Name, Equals, "launch")
+
+	c.Log("bad boards are rejected")
+	w = send("POST", "/projects", "bodie", map[string]interface{}{
+		"owner": "bodie", "name": "x",
+		"columns": []map[string]string{{"name": "a"}, {"name": "a"}},
+	})
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Equals, `column "a" given more than once`+"\n")
+
+	var ids []task.ID
+	for _, name := range []string{"write", "test", "ship"} {
+		w := send("POST", "/tasks", "bodie", &task.Task{
+			Group: users.Group{
+				Owner:   "bodie",
+				Readers: map[string]bool{},
+				Writers: map[string]bool{},
+			},
+			Name: name,
+		})
+		c.Assert(w.Code, Equals, http.StatusOK)
+		t := new(task.Task)
+		c.Assert(json.Unmarshal(w.Body.Bytes(), t), IsNil)
+		ids = append(ids, t.ID)
+	}
+	seen.take("bodie")
+
+	c.Log("only the task's owner can put it on the board")
+	tPath := func(id task.ID) string { return path + "/tasks/" + id.String() }
+	w = send("PUT", tPath(ids[0]), "bob", map[string]string{"column": "todo"})
+	c.Check(w.Code, Equals, http.StatusUnauthorized)
+	for _, id := range ids {
+		w = send("PUT", tPath(id), "bodie", map[string]string{"column": "todo"})
+	}
+	c.Check(board(w), DeepEquals, map[string][]task.ID{
+		"todo": ids, "doing": nil, "done": nil,
+	})
+	c.Check(seen.takeResources("alice"), DeepEquals, []store.Resource{
+		"projects", "tasks", "task-activity",
+		"projects", "tasks", "task-activity",
+		"projects", "tasks", "task-activity",
+	})
+
+	c.Log("tasks on the board inherit its users")
+	got, code := getTask(ids[0], "alice")
+	c.Assert(code, Equals, http.StatusOK)
+	c.Check(got.Writers["bob"], Equals, true)
+	c.Check(got.ProjectReaders, DeepEquals, map[string]bool{
+		"alice": true, "bob": true,
+	})
+
+	c.Log("writers move tasks between columns and reorder them")
+	w = send("PUT", tPath(ids[2]), "bob", map[string]interface{}{
+		"column": "doing",
+	})
+	c.Check(board(w), DeepEquals, map[string][]task.ID{
+		"todo": ids[:2], "doing": {ids[2]}, "done": nil,
+	})
+	w = send("PUT", tPath(ids[1]), "bob", map[string]interface{}{
+		"column": "todo", "position": 0,
+	})
+	c.Check(board(w), DeepEquals, map[string][]task.ID{
+		"todo": {ids[1], ids[0]}, "doing": {ids[2]}, "done": nil,
+	})
+	c.Check(seen.takeResources("alice"), DeepEquals, []store.Resource{
+		"projects", "tasks", "projects", "tasks",
+	})
+	w = send("PUT", tPath(ids[1]), "alice", map[string]string{"column": "done"})
+	c.Check(w.Code, Equals, http.StatusUnauthorized)
+	w = send("PUT", tPath(ids[1]), "bob", map[string]string{"column": "nope"})
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Equals, `column "nope" not found`+"\n")
+
+	c.Log("writers can edit tasks on the board")
+	got, _ = getTask(ids[0], "bob")
+	got.Notes = []string{"draft done"}
+	w = send("PUT", "/tasks/"+ids[0].String(), "bob", got)
+	c.Assert(w.Code, Equals, http.StatusOK, Commentf(w.Body.String()))
+	got, _ = getTask(ids[0], "bodie")
+	c.Check(got.Notes, DeepEquals, []string{"draft done"})
+	c.Check(got.ProjectWriters, DeepEquals, map[string]bool{"bob": true})
+
+	c.Log("columns with tasks can't be removed")
+	w = send("PUT", path, "bodie", map[string]interface{}{
+		"owner": "bodie", "name": "launch",
+		"readers": map[string]bool{"alice": true},
+		"writers": map[string]bool{"bob": true},
+		"columns": []map[string]string{{"name": "todo"}, {"name": "done"}},
+	})
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Equals, `column "doing" still has tasks`+"\n")
+
+	c.Log("removing users from the board removes them from its tasks")
+	seen.take("alice")
+	seen.take("bob")
+	w = send("PUT", path, "bodie", map[string]interface{}{
+		"owner": "bodie", "name": "launch",
+		"writers": map[string]bool{"bob": true},
+		"columns": []map[string]string{
+			{"name": "todo"}, {"name": "doing"}, {"name": "review"},
+			{"name": "done"},
+		},
+	})
+	c.Check(board(w), DeepEquals, map[string][]task.ID{
+		"todo": {ids[1], ids[0]}, "doing": {ids[2]},
+		"review": nil, "done": nil,
+	})
+	c.Check(seen.takeResources("alice"), DeepEquals, []store.Resource{
+		"project-removed", "task-removed", "task-removed", "task-removed",
+	})
+	c.Check(seen.takeResources("bob"), DeepEquals, []store.Resource{
+		"projects", "tasks", "tasks", "tasks",
+		"task-activity", "task-activity", "task-activity",
+	})
+	var timeline []*task.Entry
+	c.Assert(s.db.View(task.GetTimeline(ids[0], &timeline)), IsNil)
+	last := timeline[len(timeline)-1].Activity
+	c.Assert(last, NotNil)
+	c.Check(last.By, Equals, "bodie")
+	c.Check(last.Changes, HasLen, 1)
+	c.Check(last.Changes[0].Field, Equals, "readers")
+	_, code = getTask(ids[0], "alice")
+	c.Check(code, Equals, http.StatusUnauthorized)
+	c.Check(send("GET", path, "alice", nil).Code, Equals, http.StatusUnauthorized)
+
+	c.Log("tasks can be found by board")
+	w = send("GET", "/tasks?project="+proj.ID.String(), "bob", nil)
+	var ts []*task.Task
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &ts), IsNil)
+	c.Check(ts, HasLen, 3)
+
+	c.Log("taking a task off the board takes away its users")
+	w = send("DELETE", tPath(ids[2]), "bob", nil)
+	c.Check(board(w)["doing"], HasLen, 0)
+	_, code = getTask(ids[2], "bob")
+	c.Check(code, Equals, http.StatusUnauthorized)
+	w = send("DELETE", tPath(ids[2]), "bodie", nil)
+	c.Check(w.Code, Equals, http.StatusNotFound)
+
+	c.Log("deleting a task takes it off the board")
+	seen.take("bob")
+	w = send("DELETE", "/tasks/"+ids[1].String(), "bodie", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Check(board(send("GET", path, "bob", nil))["todo"], DeepEquals,
+		[]task.ID{ids[0]})
+	c.Check(seen.takeResources("bob"), DeepEquals, []store.Resource{
+		"task-deleted", "projects",
+	})
+
+	c.Log("only the owner can delete the board")
+	c.Check(send("DELETE", path, "bob", nil).Code, Equals, http.StatusUnauthorized)
+	seen.take("bodie")
+	w = send("DELETE", path, "bodie", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Check(seen.takeResources("bob"), DeepEquals, []store.Resource{
+		"project-deleted", "task-removed",
+	})
+	c.Check(seen.takeResources("bodie"), DeepEquals, []store.Resource{
+		"project-deleted", "tasks", "task-activity",
+	})
+	got, _ = getTask(ids[0], "bodie")
+	c.Check(got.Project, IsNil)
+	c.Check(got.Writers, DeepEquals, map[string]bool{"bodie": true})
+	c.Check(send("GET", path, "bodie", nil).Code, Equals, http.StatusNotFound)
+}
@@ -0,0 +1,58 @@
+package rest
+
+import (
+	"log"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/notif"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+)
+
+// Run makes the next instance of each recurring Task whose period has
+// rolled over, or which was completed, and sends any due reminders,
+// every interval until stop is closed.  Bind must be called first.
+func (t *Task) Run(interval time.Duration, stop <-chan struct{}) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			t.Renew()
+			t.Remind()
+		}
+	}
+}
+
+// Renew makes the next instance of each recurring Task which is due to
+// be renewed, and notifies its users.
+func (t *Task) Renew() {
+	var renewed []*task.Task
+	if err := t.Update(task.Schedule(t.Now().UTC(), &renewed)); err != nil {
+		log.Printf("ERROR: failed to renew tasks: %s", err.Error())
+		return
+	}
+	t.notifyRenewed(renewed)
+}
+
+// notifyRenewed notifies the users of each new instance of a recurring
+// Task, and its Owner of their escrowed bounty.
+func (t *Task) notifyRenewed(ts []*task.Task) {
+	for _, tsk := range ts {
+		for u := range users.AllUsers(tsk.Group) {
+			notif.Encode(t.Pub, tsk, notif.MakeUserTopic(u))
+		}
+		if tsk.Bounty <= 0 {
+			continue
+		}
+		own := &users.User{Name: tsk.Owner}
+		if err := t.View(loadUsers(own)); err != nil {
+			log.Printf("ERROR: failed to load task owner: %s", err.Error())
+			continue
+		}
+		notif.Encode(t.Pub, own, notif.MakeUserTopic(own.Name))
+	}
+}
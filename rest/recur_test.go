@@ -0,0 +1,147 @@
+package rest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/rest"
+	"github.com/synapse-garden/sg-proto/task"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	htr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RESTSuite) TestTaskRecur(c *C) {
+	var (
+		r   = htr.New()
+		now = time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+		api = &rest.Task{DB: s.db, Timer: sgt.Timer(now)}
+
+		srv, tokens = prepTaskAPI(c, r, api, "bodie", "bob")
+	)
+	defer srv.Close()
+	defer cleanupTaskAPI(c, api)
+
+	seen := observeNotifs()
+	defer seen.Close()
+	takeSeen := func(user string) []*task.Task {
+		var vals []*task.Task
+		for _, val := range seen.take(user) {
+			if tsk, ok := val.(*task.Task); ok {
+				vals = append(vals, tsk)
+			}
+		}
+		return vals
+	}
+
+	c.Assert(s.db.Update(users.Post(&users.Transaction{
+		To: "bodie", Amount: 2, Reason: users.Grant, Time: now,
+	})), IsNil)
+
+	send := func(method, path, user string, body interface{}) *htt.ResponseRecorder {
+		bs, err := json.Marshal(body)
+		c.Assert(err, IsNil)
+		req := htt.NewRequest(method, path, bytes.NewBuffer(bs))
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	group := users.Group{
+		Owner:   "bodie",
+		Readers: map[string]bool{"bob": true},
+		Writers: map[string]bool{"bob": true},
+	}
+	weekly := &task.Rule{Freq: task.Weekly, Interval: 1}
+
+	c.Log("calendar rules need a due date")
+	w := send("POST", "/tasks", "bodie", &task.Task{
+		Group: group, Name: "chore", Recur: weekly,
+	})
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Equals, "recurring task must have a due date\n")
+
+	c.Log("bad rules are rejected")
+	w = send("POST", "/tasks", "bodie", map[string]interface{}{
+		"owner": "bodie", "name": "chore", "recur": "FREQ=HOURLY",
+	})
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Matches, `bad body: invalid recurrence rule.*\n`)
+
+	due := now.Add(24 * time.Hour)
+	w = send("POST", "/tasks", "bodie", &task.Task{
+		Group: group, Name: "chore", Recur: weekly, Due: &due,
+		Bounty: 1, Notes: []string{"sweep"},
+	})
+	c.Assert(w.Code, Equals, http.StatusOK)
+	chore := new(task.Task)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), chore), IsNil)
+	c.Check(chore.Recur, DeepEquals, weekly)
+	path := "/tasks/" + uuid.UUID(chore.ID).String()
+	takeSeen("bob")
+
+	c.Log("only the owner can change how a task recurs")
+	changed := *chore
+	changed.Recur = &task.Rule{Freq: task.Daily, Interval: 1}
+	w = send("PUT", path, "bob", &changed)
+	c.Check(w.Code, Equals, http.StatusUnauthorized)
+
+	c.Log("accepting a recurring task makes its next instance")
+	c.Assert(send("POST", path+"/claim", "bob", nil).Code, Equals, http.StatusOK)
+	c.Assert(send("POST", path+"/submit", "bob", nil).Code, Equals, http.StatusOK)
+	takeSeen("bob")
+	w = send("POST", path+"/accept", "bodie", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	accepted := new(task.Task)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), accepted), IsNil)
+	c.Assert(accepted.Next, NotNil)
+
+	bobSaw := takeSeen("bob")
+	c.Assert(bobSaw, HasLen, 2)
+	c.Check(bobSaw[0].ID, Equals, chore.ID)
+	next := bobSaw[1]
+	nextDue := due.AddDate(0, 0, 7)
+	c.Check(next, DeepEquals, &task.Task{
+		Group: chore.Group, ID: *accepted.Next, Name: "chore", Bounty: 1,
+		Recur: weekly, State: task.Open, Due: &nextDue,
+		Notes: []string{"sweep"},
+	})
+
+	c.Log("its bounty was paid out and escrowed again")
+	var held int64
+	c.Assert(s.db.View(task.Escrowed(next.ID, &held)), IsNil)
+	c.Check(held, Equals, int64(1))
+
+	c.Log("the next instance can't be set by hand")
+	accepted.Next = nil
+	w = send("PUT", path, "bodie", accepted)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	got := new(task.Task)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), got), IsNil)
+	c.Check(got.Next, DeepEquals, &next.ID)
+
+	c.Log("renewing does nothing until a task is past due")
+	takeSeen("bodie")
+	api.Renew()
+	c.Check(takeSeen("bodie"), HasLen, 0)
+
+	late := now.Add(-time.Hour)
+	w = send("POST", "/tasks", "bodie", &task.Task{
+		Group: group, Name: "late", Recur: weekly, Due: &late,
+	})
+	c.Assert(w.Code, Equals, http.StatusOK)
+	takeSeen("bodie")
+
+	c.Log("past-due tasks are renewed")
+	api.Renew()
+	bodieSaw := takeSeen("bodie")
+	c.Assert(bodieSaw, HasLen, 1)
+	c.Check(bodieSaw[0].Name, Equals, "late")
+	c.Check(*bodieSaw[0].Due, Equals, late.AddDate(0, 0, 7))
+}
@@ -0,0 +1,82 @@
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/synapse-garden/sg-proto/notif"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/task"
+
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// bindReminders binds the reminder preference endpoints of the Task API.
+func (t *Task) bindReminders(r *htr.Router) {
+	r.GET("/profile/reminders", t.AuthUser(
+		t.GetReminders,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+
+	r.PUT("/profile/reminders", t.AuthUser(
+		t.PutReminders,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+}
+
+// GetReminders is a Handle which returns the user's task.Reminders.
+func (t *Task) GetReminders(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	rs := new(task.Reminders)
+	if err := t.View(task.GetReminders(mw.CtxGetUserID(r), rs)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to get reminders",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(rs)
+}
+
+// PutReminders is a Handle which sets the user's task.Reminders.
+func (t *Task) PutReminders(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	rs := new(task.Reminders)
+	if err := json.NewDecoder(r.Body).Decode(rs); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "bad body",
+		).Error(), http.StatusBadRequest)
+		return
+	}
+	if rs.Before == nil {
+		rs.Before = []task.Offset{}
+	}
+	if err := rs.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := t.Update(task.SetReminders(mw.CtxGetUserID(r), rs)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to set reminders",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(rs)
+}
+
+// Remind sends the task.Reminders and overdue escalations which are due
+// to the users of each Task.
+func (t *Task) Remind() {
+	var rs []*task.Reminder
+	if err := t.Update(task.Remind(t.Now().UTC(), &rs)); err != nil {
+		log.Printf("ERROR: failed to send task reminders: %s", err.Error())
+		return
+	}
+	for _, rm := range rs {
+		notif.Encode(t.Pub, rm, notif.MakeUserTopic(rm.To))
+	}
+}
@@ -0,0 +1,108 @@
+package rest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/rest"
+	"github.com/synapse-garden/sg-proto/task"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	htr "github.com/julienschmidt/httprouter"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RESTSuite) TestTaskRemind(c *C) {
+	var (
+		r   = htr.New()
+		now = time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+		api = &rest.Task{DB: s.db, Timer: sgt.Timer(now)}
+
+		srv, tokens = prepTaskAPI(c, r, api, "bodie", "bob")
+	)
+	defer srv.Close()
+	defer cleanupTaskAPI(c, api)
+
+	seen := observeNotifs()
+	defer seen.Close()
+	takeSeen := func(user string) []*task.Reminder {
+		var vals []*task.Reminder
+		for _, val := range seen.take(user) {
+			if rm, ok := val.(*task.Reminder); ok {
+				vals = append(vals, rm)
+			}
+		}
+		return vals
+	}
+
+	send := func(method, path, user string, body interface{}) *htt.ResponseRecorder {
+		bs, err := json.Marshal(body)
+		c.Assert(err, IsNil)
+		req := htt.NewRequest(method, path, bytes.NewBuffer(bs))
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	c.Log("users start with the default reminders")
+	w := send("GET", "/profile/reminders", "bob", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Check(w.Body.String(), Equals,
+		`{"before":["24h0m0s","1h0m0s"],"overdue":true}`+"\n")
+
+	c.Log("bad reminders are rejected")
+	w = send("PUT", "/profile/reminders", "bob", map[string]interface{}{
+		"before": []string{"-5m"},
+	})
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Equals,
+		"reminder -5m0s must be before the due date\n")
+	w = send("PUT", "/profile/reminders", "bob", map[string]interface{}{
+		"before": []string{"soon"},
+	})
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+
+	c.Log("bob only wants to hear about overdue tasks")
+	w = send("PUT", "/profile/reminders", "bob", map[string]interface{}{
+		"overdue": true,
+	})
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Check(w.Body.String(), Equals, `{"before":[],"overdue":true}`+"\n")
+
+	due := now.Add(30 * time.Minute)
+	w = send("POST", "/tasks", "bodie", &task.Task{
+		Group: users.Group{
+			Owner:   "bodie",
+			Readers: map[string]bool{"bob": true},
+			Writers: map[string]bool{"bob": true},
+		},
+		Name: "soon", Due: &due,
+	})
+	c.Assert(w.Code, Equals, http.StatusOK)
+	tsk := new(task.Task)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), tsk), IsNil)
+
+	c.Log("bodie is reminded an hour before")
+	api.Remind()
+	c.Check(takeSeen("bodie"), DeepEquals, []*task.Reminder{{
+		To: "bodie", Task: tsk.ID, Name: "soon", Due: due,
+		Before: task.Offset(time.Hour),
+	}})
+	c.Check(takeSeen("bob"), HasLen, 0)
+
+	c.Log("both are told once it is overdue")
+	api.Timer = sgt.Timer(due.Add(time.Minute))
+	api.Remind()
+	api.Remind()
+	for _, u := range []string{"bodie", "bob"} {
+		c.Check(takeSeen(u), DeepEquals, []*task.Reminder{{
+			To: u, Task: tsk.ID, Name: "soon", Due: due,
+			Overdue: true,
+		}})
+	}
+}
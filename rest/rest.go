@@ -1,16 +1,23 @@
 package rest
 
 import (
+	"time"
+
 	"github.com/synapse-garden/sg-proto/admin"
 	"github.com/synapse-garden/sg-proto/auth"
 	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/export"
 	"github.com/synapse-garden/sg-proto/incept"
+	"github.com/synapse-garden/sg-proto/mail"
+	"github.com/synapse-garden/sg-proto/presence"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
 	"github.com/synapse-garden/sg-proto/store"
 	"github.com/synapse-garden/sg-proto/stream"
 	"github.com/synapse-garden/sg-proto/stream/river"
 	"github.com/synapse-garden/sg-proto/task"
 	"github.com/synapse-garden/sg-proto/text"
 	"github.com/synapse-garden/sg-proto/users"
+	"github.com/synapse-garden/sg-proto/util"
 
 	"github.com/boltdb/bolt"
 	"github.com/julienschmidt/httprouter"
@@ -21,7 +28,9 @@ import (
 // Admin:
 //  - Admin auth middleware
 //  - POST /admin/tickets (optionally ?count=n)
-//  - GET /admin/tickets
+//  - GET /admin/tickets (optionally ?status=s&creator=c&inviter=i)
+//  - GET /admin/tickets/:credential
+//  - DELETE /admin/tickets (by the same filters as GET, or ?all=true)
 //  - DELETE /admin/tickets/:credential
 //
 // Create a new user:
@@ -32,7 +41,18 @@ import (
 //
 // User account
 //  - GET  /profile (user ID inferred) => /users/:id
-//  - PUT  /profile (nothing to update yet)
+//  - PUT  /profile users.Profile (display name, bio, status, timezone)
+//  - PUT  /profile/avatar, DELETE /profile/avatar, GET /avatars/:user_id
+//  - GET  /profile/ledger => coin balance and users.Transaction history
+//  - POST /transfers users.Transaction {to, amount, memo}
+//  - GET  /users?q=bo&page=0&per_page=20 => public profiles, unless unlisted
+//  - GET, POST /circles, GET, PUT, DELETE /circles/:circle_id => named sets
+//    of users which Streams, Convos and Tasks can grant read or write to
+//  - GET  /blocks, PUT, DELETE /blocks/:user_id, /mutes/:user_id
+//  - GET  /presence?users=bob,alice => online / idle / offline, and which
+//    Streams and Convos, of users sharing a group
+//  - POST /profile/export => build a zip of the user's data in the
+//    background; notif with the link when ready, GET /exports/:token
 //  - DELETE /profile => delete user account and any logins
 //
 // Open a new chat socket
@@ -41,6 +61,28 @@ import (
 // TODOs / tasks
 //  - POST /todo {bounty, due}
 //  - POST /todo/:id/complete => Get bounty if before due
+//  - POST /tasks/:id/claim, unclaim, submit, accept, reject, reopen =>
+//    review a Task; accepting it pays its bounty to the claimant
+//  - GET  /tasks/:id/history => task.Transitions of its review
+//  - GET  /tasks?blocked=true&ready=true&parent=:id => by dependencies
+//  - GET  /tasks?q=owner:bob and (due<2017-02-01 or bounty>=5)&sort=-bounty,due
+//    => tasks matching a task.ParseQuery, sorted by task.ParseOrders
+//  - GET  /tasks?label=release => tasks with the label, using its index
+//  - GET, PUT /profile/labels => task.Colours of the user's labels
+//  - GET, POST /projects; GET, PUT, DELETE /projects/:id => task.Projects,
+//    kanban boards whose users are inherited by the tasks on them
+//  - PUT /projects/:id/tasks/:task_id {column, position} => move a task
+//    onto or around the board; DELETE takes it off
+//  - GET  /tasks?project=:id => tasks on the board
+//  - GET  /tasks/:id/subtree => task.Tree of the Task and its subtasks
+//  - Recurring tasks: {"recur": "FREQ=WEEKLY;BYDAY=MO,TH"} makes a new
+//    instance when the task is accepted or its due date passes
+//  - GET, PUT /profile/reminders => task.Reminders before due dates,
+//    sent as "task-reminder" and "task-overdue" notifs
+//  - GET  /tasks/:id/timeline => task.Entries of its task.Activity log
+//    (changes, with who made them and when) and task.Comments
+//  - GET, POST /tasks/:id/comments {text, parent} => threaded comments;
+//    new entries are "task-activity" and "task-comment" notifs
 
 // API is a transform on an httprouter.Router, passing a DB for passing
 // on to httprouter.Handles.
@@ -48,53 +90,194 @@ type API interface {
 	Bind(*httprouter.Router) error
 }
 
+// Config holds optional settings for Bind.
+type Config struct {
+	// Stateless makes POST /tokens issue signed Bearer tokens which
+	// are verified without a database lookup.
+	Stateless bool
+
+	// Cookies lets browsers ask POST /tokens?cookie=true for their
+	// session in HttpOnly cookies, protected from CSRF by a
+	// double-submitted token.
+	Cookies bool
+
+	// Authenticators are external identity providers users may log
+	// in with at POST /tokens, besides their Logins.
+	Authenticators []auth.Authenticator
+
+	// InvitePrice is the coin a user pays for each invite they make
+	// at POST /invites.
+	InvitePrice int64
+
+	// Mail, if set, is used to mail invites and password resets.
+	// Messages are only queued; something such as a mail.Service
+	// must deliver them.
+	Mail *mail.Mailer
+
+	// Presence tracks who is connected.  If it is nil, a Tracker
+	// with presence.DefaultIdle is used, but nothing Sweeps it, so
+	// users are never noticed becoming Idle.
+	Presence *presence.Tracker
+
+	// TaskInterval is how often recurring Tasks whose period has
+	// rolled over are renewed, and due reminders are sent.  If it is
+	// 0, Tasks are only renewed when they are accepted, and no
+	// reminders are sent.
+	TaskInterval time.Duration
+
+	// Stop, when closed, stops renewing Tasks and sending reminders,
+	// and stops dropping notifs for blocked users.  If it is nil, they
+	// run until the process exits.
+	Stop <-chan struct{}
+}
+
 // Bind binds the API on the given DB.  It sets up REST endpoints as needed.
 func Bind(
 	db *bolt.DB,
 	source SourceInfo,
 	apiKey auth.Token,
+	cfg Config,
 ) (*httprouter.Router, error) {
 	if err := db.Update(store.Wrap(
 		store.Prep(
 			admin.AdminBucket,
+			admin.CredentialBucket,
 			incept.TicketBucket,
+			incept.InviteBucket,
+			mail.QueueBucket,
+			mail.AddressBucket,
 			users.UserBucket,
+			users.AvatarBucket,
+			users.CircleBucket,
+			users.BlockBucket,
+			users.LedgerBucket,
 			auth.LoginBucket,
 			auth.SessionBucket,
 			auth.RefreshBucket,
 			auth.ContextBucket,
+			auth.AttemptBucket,
+			auth.ExternalBucket,
+			auth.SigningKeyBucket,
+			auth.RevokedBucket,
+			auth.ResetBucket,
 			stream.StreamBucket,
 			river.RiverBucket,
 			convo.ConvoBucket,
 			convo.MessageBucket,
 			text.TextBucket,
 			task.TaskBucket,
+			task.HistoryBucket,
+			task.LabelBucket,
+			task.ColourBucket,
+			task.ProjectBucket,
+			task.ActivityBucket,
+			task.CommentBucket,
+			task.PrefsBucket,
+			task.RemindedBucket,
+			task.EscrowBucket,
+			export.ExportBucket,
+			export.ArchiveBucket,
 		),
 		auth.ClearSessions,
 		river.ClearRivers,
+		users.OpenBalances(time.Now()),
+		task.OpenEscrow(time.Now()),
 	)); err != nil {
 		return nil, err
 	}
 
-	htr := httprouter.New()
-	for _, api := range []API{
+	// sessions is given to every API which authenticates users.
+	sessions := mw.Sessions{Cookies: cfg.Cookies}
+	if cfg.Stateless {
+		keys := auth.NewKeyRing()
+		if err := db.Update(store.Wrap(
+			keys.Load,
+			keys.Rotate(time.Now()),
+		)); err != nil {
+			return nil, err
+		}
+		sessions.Keys = keys
+	}
+
+	var profiles river.Pub
+	if err := db.Update(func(tx *bolt.Tx) (e error) {
+		profiles, e = river.NewPub(ProfileNotifs, NotifStream, tx)
+		return
+	}); err != nil {
+		return nil, err
+	}
+
+	tracker := cfg.Presence
+	if tracker == nil {
+		tracker = presence.NewTracker(
+			util.SimpleTimer{}, presence.DefaultIdle,
+		)
+	}
+
+	tasks := &Task{DB: db, Sessions: sessions}
+	blocks := &Block{DB: db, Sessions: sessions}
+	apis := []API{
 		source,
 		Incept{DB: db},
-		Token{DB: db},
-		Profile{DB: db},
+		Token{
+			DB:             db,
+			Sessions:       sessions,
+			Authenticators: cfg.Authenticators,
+		},
+		Profile{DB: db, Sessions: sessions, Pub: profiles},
+		Users{DB: db, Sessions: sessions},
+		Invite{
+			DB:       db,
+			Sessions: sessions,
+			Price:    cfg.InvitePrice,
+			Mailer:   cfg.Mail,
+		},
 		// Note that notifying APIs must be references since the
 		// notif connect sets a Pub socket handle in the struct.
-		&Stream{DB: db},
-		&Convo{DB: db},
-		&Task{DB: db},
-		&Admin{Token: apiKey, DB: db},
-		// Connect Notif last so Pubs are already registered.
-		Notif{DB: db},
-	} {
+		&Stream{DB: db, Sessions: sessions, Presence: tracker},
+		&Convo{DB: db, Sessions: sessions, Presence: tracker},
+		tasks,
+		&Circle{DB: db, Sessions: sessions},
+		&Presence{DB: db, Sessions: sessions, Tracker: tracker},
+		blocks,
+		&Export{DB: db, Sessions: sessions},
+		&Admin{
+			Token:    apiKey,
+			DB:       db,
+			Sessions: sessions,
+			Mailer:   cfg.Mail,
+		},
+	}
+	if cfg.Mail != nil {
+		apis = append(apis, Mail{
+			DB:       db,
+			Sessions: sessions,
+			Mailer:   cfg.Mail,
+		})
+	}
+	// Connect Notif last so Pubs are already registered.
+	apis = append(apis, Notif{
+		DB:       db,
+		Sessions: sessions,
+		Presence: tracker,
+	})
+
+	htr := httprouter.New()
+	for _, api := range apis {
 		if err := api.Bind(htr); err != nil {
 			return nil, err
 		}
 	}
 
+	if cfg.TaskInterval > 0 {
+		go tasks.Run(cfg.TaskInterval, cfg.Stop)
+	}
+	if cfg.Stop != nil {
+		go func() {
+			<-cfg.Stop
+			blocks.Close()
+		}()
+	}
+
 	return htr, nil
 }
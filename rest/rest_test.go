@@ -3,13 +3,17 @@ package rest_test
 import (
 	"os"
 	"runtime"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/synapse-garden/sg-proto/admin"
 	"github.com/synapse-garden/sg-proto/auth"
 	"github.com/synapse-garden/sg-proto/convo"
+	"github.com/synapse-garden/sg-proto/export"
 	"github.com/synapse-garden/sg-proto/incept"
+	"github.com/synapse-garden/sg-proto/mail"
+	"github.com/synapse-garden/sg-proto/notif"
 	"github.com/synapse-garden/sg-proto/store"
 	"github.com/synapse-garden/sg-proto/stream"
 	"github.com/synapse-garden/sg-proto/stream/river"
@@ -40,12 +44,25 @@ func (s *RESTSuite) SetUpTest(c *C) {
 		store.Migrate(store.VerCurrent),
 		store.SetupBuckets(
 			admin.AdminBucket,
+			admin.CredentialBucket,
 			incept.TicketBucket,
+			incept.InviteBucket,
+			mail.QueueBucket,
+			mail.AddressBucket,
+			auth.ResetBucket,
 			users.UserBucket,
+			users.AvatarBucket,
+			users.CircleBucket,
+			users.BlockBucket,
+			users.LedgerBucket,
 			auth.LoginBucket,
 			auth.SessionBucket,
 			auth.RefreshBucket,
 			auth.ContextBucket,
+			auth.AttemptBucket,
+			auth.ExternalBucket,
+			auth.SigningKeyBucket,
+			auth.RevokedBucket,
 			stream.StreamBucket,
 			river.RiverBucket,
 			convo.ConvoBucket,
@@ -53,6 +70,17 @@ func (s *RESTSuite) SetUpTest(c *C) {
 			convo.ScribeBucket,
 			text.TextBucket,
 			task.TaskBucket,
+			task.HistoryBucket,
+			task.LabelBucket,
+			task.ColourBucket,
+			task.ProjectBucket,
+			task.ActivityBucket,
+			task.CommentBucket,
+			task.PrefsBucket,
+			task.RemindedBucket,
+			task.EscrowBucket,
+			export.ExportBucket,
+			export.ArchiveBucket,
 		),
 		auth.ClearSessions,
 		river.ClearRivers,
@@ -76,3 +104,89 @@ func (s *RESTSuite) TearDownTest(c *C) {
 		c.Assert(os.Remove(s.tmpDir), IsNil)
 	}
 }
+
+// seenNotifs records the notifs sent to each user while a test runs.
+type seenNotifs struct {
+	mu     sync.Mutex
+	seen   map[notif.UserTopic][]store.Resourcer
+	sent   chan struct{}
+	cancel func()
+}
+
+// observeNotifs starts recording every notif sent.  Close it when done.
+func observeNotifs() *seenNotifs {
+	n := &seenNotifs{
+		seen: make(map[notif.UserTopic][]store.Resourcer),
+		sent: make(chan struct{}, 1),
+	}
+	n.cancel = notif.Observe(func(t notif.UserTopic, val store.Resourcer) {
+		n.mu.Lock()
+		n.seen[t] = append(n.seen[t], val)
+		n.mu.Unlock()
+		select {
+		case n.sent <- struct{}{}:
+		default:
+		}
+	})
+	return n
+}
+
+// Close stops recording notifs.
+func (n *seenNotifs) Close() { n.cancel() }
+
+// take returns and forgets the notifs sent to the user so far.
+func (n *seenNotifs) take(user string) []store.Resourcer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	t := notif.MakeUserTopic(user)
+	vals := n.seen[t]
+	delete(n.seen, t)
+	return vals
+}
+
+// takeAll returns and forgets the notifs sent to every user so far.
+func (n *seenNotifs) takeAll() map[notif.UserTopic][]store.Resourcer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	all := n.seen
+	n.seen = make(map[notif.UserTopic][]store.Resourcer)
+	return all
+}
+
+// takeResources is like take, but returns only the kind of each notif.
+func (n *seenNotifs) takeResources(user string) []store.Resource {
+	var rs []store.Resource
+	for _, val := range n.take(user) {
+		rs = append(rs, val.Resource())
+	}
+	return rs
+}
+
+// await returns and forgets the first notif sent to the user which
+// matches, waiting up to the timeout for it.  It returns nil if none
+// was sent in time.
+func (n *seenNotifs) await(
+	user string,
+	match func(store.Resourcer) bool,
+	timeout time.Duration,
+) store.Resourcer {
+	t := notif.MakeUserTopic(user)
+	deadline := time.After(timeout)
+	for {
+		n.mu.Lock()
+		for i, val := range n.seen[t] {
+			if match(val) {
+				n.seen[t] = append(n.seen[t][:i:i], n.seen[t][i+1:]...)
+				n.mu.Unlock()
+				return val
+			}
+		}
+		n.mu.Unlock()
+
+		select {
+		case <-n.sent:
+		case <-deadline:
+			return nil
+		}
+	}
+}
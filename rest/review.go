@@ -0,0 +1,235 @@
+package rest
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/synapse-garden/sg-proto/notif"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// review is a task.Transition a user may make on a Task using
+// POST /tasks/:id/<name>, if may returns true for them.  If from is not
+// nil, the Task must be in one of its States.
+type review struct {
+	to   task.State
+	from map[task.State]bool
+	may  func(t *task.Task, user string) bool
+}
+
+func byOwner(t *task.Task, user string) bool    { return t.Owner == user }
+func byWriter(t *task.Task, user string) bool   { return t.Writers[user] }
+func byClaimant(t *task.Task, user string) bool { return t.Claimant == user }
+
+// reviews are the Transitions of a Task's review, by endpoint name.
+// Any writer may claim an open Task, and its claimant submits it.  Its
+// owner accepts or rejects the submission, and may reopen it once it is
+// accepted.  The claimant or owner may unclaim it before then.
+var reviews = map[string]review{
+	"claim":  {to: task.Claimed, may: byWriter},
+	"submit": {to: task.Submitted, may: byClaimant},
+	"accept": {to: task.Accepted, may: byOwner},
+	"reject": {to: task.Rejected, may: byOwner},
+	"reopen": {
+		to:   task.Open,
+		from: map[task.State]bool{task.Accepted: true},
+		may:  byOwner,
+	},
+	"unclaim": {
+		to: task.Open,
+		from: map[task.State]bool{
+			task.Claimed:  true,
+			task.Rejected: true,
+		},
+		may: func(t *task.Task, user string) bool {
+			return byOwner(t, user) || byClaimant(t, user)
+		},
+	},
+}
+
+// bindReviews binds the review endpoints of the Task API.
+func (t *Task) bindReviews(r *htr.Router) {
+	for name, rv := range reviews {
+		r.POST("/tasks/:id/"+name, t.AuthUser(
+			t.move(rv),
+			t.DB,
+			mw.CtxSetUserID,
+		))
+	}
+
+	r.GET("/tasks/:id/history", t.AuthUser(
+		t.GetHistory,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+}
+
+// move returns a Handle which makes the given review's Transition on
+// the Task, with an optional {"note"} body.  Accepting a Task pays its
+// escrowed bounty to its claimant and makes its next instance if it
+// recurs, and reopening it returns the bounty to escrow.  Every user of
+// the Task is notified.
+func (t *Task) move(rv review) htr.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+		userID := mw.CtxGetUserID(r)
+		tUUID, err := uuid.FromString(ps.ByName("id"))
+		if err != nil {
+			http.Error(w, "invalid task ID", http.StatusBadRequest)
+			return
+		}
+		tID := task.ID(tUUID)
+
+		var body struct {
+			Note string `json:"note"`
+		}
+		err = json.NewDecoder(r.Body).Decode(&body)
+		if err != nil && err != io.EOF {
+			http.Error(w, errors.Wrap(
+				err, "bad body",
+			).Error(), http.StatusBadRequest)
+			return
+		}
+
+		tsk := new(task.Task)
+		err = t.View(tID.Load(tsk))
+		switch {
+		case store.IsMissing(err):
+			http.Error(w, "no such task", http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, errors.Wrap(
+				err, "failed to find task",
+			).Error(), http.StatusInternalServerError)
+			return
+		case !rv.may(tsk, userID):
+			code := http.StatusUnauthorized
+			http.Error(w, http.StatusText(code), code)
+			return
+		case rv.from != nil && !rv.from[tsk.Status()]:
+			http.Error(w, task.ErrTransition{
+				From: tsk.Status(), To: rv.to,
+			}.Error(), http.StatusConflict)
+			return
+		}
+
+		now := t.Now().UTC()
+		completer := tsk.CompletedBy
+		before := *tsk
+		tr, err := tsk.Move(rv.to, userID, body.Note, now)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		changes, err := task.Diff(&before, tsk)
+		if err != nil {
+			http.Error(w, errors.Wrap(
+				err, "failed to diff task",
+			).Error(), http.StatusInternalServerError)
+			return
+		}
+		act := &task.Activity{By: userID, Time: now, Changes: changes}
+
+		notes := tsk.Notes
+		ops := []func(*bolt.Tx) error{
+			tID.Store(tsk),
+			task.Record(tID, tr),
+			task.RecordActivity(tID, act),
+		}
+		var (
+			payee              *users.User
+			unblocked, renewed []*task.Task
+		)
+		switch {
+		case tr.To == task.Accepted:
+			payee = &users.User{Name: tsk.Claimant}
+			ops = append(ops,
+				task.Payout(tsk, payee.Name, now),
+				task.Unblocks(tID, &unblocked),
+				task.Renew(tID, now, &renewed),
+			)
+		case tr.From == task.Accepted && completer != "":
+			payee = &users.User{Name: completer}
+			ops = append(ops, task.Reclaim(tsk, payee.Name, now))
+		}
+		if payee != nil {
+			ops = append(ops, loadUsers(payee))
+		}
+		err = t.Update(store.Wrap(ops...))
+		switch {
+		case users.IsMissing(err):
+			http.Error(w, errors.Wrap(
+				err, "failed to pay bounty",
+			).Error(), http.StatusNotFound)
+			return
+		case err != nil:
+			http.Error(w, errors.Wrap(
+				err, "failed to store Task",
+			).Error(), http.StatusInternalServerError)
+			return
+		}
+
+		tsk.Notes, tsk.Resources = notes, nil
+		if len(renewed) > 0 {
+			tsk.Next = &renewed[0].ID
+		}
+		for u := range users.AllUsers(tsk.Group) {
+			notif.Encode(t.Pub, tsk, notif.MakeUserTopic(u))
+		}
+		if payee != nil && tsk.Bounty > 0 {
+			// Notify the payee of their bounty update.
+			notif.Encode(t.Pub, payee, notif.MakeUserTopic(payee.Name))
+		}
+		for _, ub := range unblocked {
+			// Notify owners whose Tasks are now ready.
+			notif.Encode(t.Pub, task.Unblocked(ub.ID),
+				notif.MakeUserTopic(ub.Owner),
+			)
+		}
+		t.notifyRenewed(renewed)
+		t.notifyEntry(tsk, act)
+		json.NewEncoder(w).Encode(tsk)
+	}
+}
+
+// GetHistory returns the task.Transitions of the Task, oldest first.
+func (t *Task) GetHistory(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	userID := mw.CtxGetUserID(r)
+	tUUID, err := uuid.FromString(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "invalid task ID", http.StatusBadRequest)
+		return
+	}
+	tID := task.ID(tUUID)
+
+	tsk := new(task.Task)
+	var history []*task.Transition
+	err = t.View(store.Wrap(
+		store.Unmarshal(task.TaskBucket, tsk, tID[:]),
+		task.GetHistory(tID, &history),
+	))
+	switch {
+	case store.IsMissing(err):
+		http.Error(w, "no such task", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to get history",
+		).Error(), http.StatusInternalServerError)
+		return
+	case !users.AllUsers(tsk.Group)[userID]:
+		code := http.StatusUnauthorized
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	json.NewEncoder(w).Encode(history)
+}
@@ -0,0 +1,148 @@
+package rest_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/rest"
+	"github.com/synapse-garden/sg-proto/task"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	htr "github.com/julienschmidt/httprouter"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RESTSuite) TestTaskReview(c *C) {
+	var (
+		r   = htr.New()
+		now = time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+		api = &rest.Task{DB: s.db, Timer: sgt.Timer(now)}
+
+		srv, tokens = prepTaskAPI(c, r, api, "bodie", "bob", "alice")
+	)
+	defer srv.Close()
+	defer cleanupTaskAPI(c, api)
+
+	send := func(method, path, user string, body interface{}) *htt.ResponseRecorder {
+		bs, err := json.Marshal(body)
+		c.Assert(err, IsNil)
+		req := htt.NewRequest(method, path, bytes.NewBuffer(bs))
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	note := func(n string) interface{} {
+		return map[string]string{"note": n}
+	}
+
+	w := send("POST", "/tasks", "bodie", &task.Task{
+		Group: users.Group{
+			Owner:   "bodie",
+			Readers: map[string]bool{"alice": true},
+			Writers: map[string]bool{"bob": true},
+		},
+		Name: "review me",
+	})
+	c.Assert(w.Code, Equals, http.StatusOK)
+	tsk := new(task.Task)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), tsk), IsNil)
+	c.Check(tsk.State, Equals, task.Open)
+	path := "/tasks/" + uuid.UUID(tsk.ID).String()
+
+	c.Log("missing tasks can't be reviewed")
+	w = send("POST", "/tasks/"+uuid.Nil.String()+"/claim", "bob", nil)
+	c.Check(w.Code, Equals, http.StatusNotFound)
+
+	for i, test := range []struct {
+		should, path, user string
+		body               interface{}
+
+		expectStatus int
+		expectState  task.State
+	}{{
+		should:       "not let a reader claim it",
+		path:         "/claim",
+		user:         "alice",
+		expectStatus: http.StatusUnauthorized,
+	}, {
+		should:       "let bob claim it",
+		path:         "/claim",
+		user:         "bob",
+		body:         note("on it"),
+		expectStatus: http.StatusOK,
+		expectState:  task.Claimed,
+	}, {
+		should:       "not let it be claimed twice",
+		path:         "/claim",
+		user:         "bob",
+		expectStatus: http.StatusConflict,
+	}, {
+		should:       "let bob submit it",
+		path:         "/submit",
+		user:         "bob",
+		expectStatus: http.StatusOK,
+		expectState:  task.Submitted,
+	}, {
+		should:       "not let bob unclaim a submission",
+		path:         "/unclaim",
+		user:         "bob",
+		expectStatus: http.StatusConflict,
+	}, {
+		should:       "let bodie reject it",
+		path:         "/reject",
+		user:         "bodie",
+		body:         note("needs work"),
+		expectStatus: http.StatusOK,
+		expectState:  task.Rejected,
+	}, {
+		should:       "not reopen a rejected task",
+		path:         "/reopen",
+		user:         "bodie",
+		expectStatus: http.StatusConflict,
+	}, {
+		should:       "let bodie unclaim it from bob",
+		path:         "/unclaim",
+		user:         "bodie",
+		expectStatus: http.StatusOK,
+		expectState:  task.Open,
+	}, {
+		should:       "reject a bad body",
+		path:         "/claim",
+		user:         "bob",
+		body:         "on it",
+		expectStatus: http.StatusBadRequest,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		w := send("POST", path+test.path, test.user, test.body)
+		c.Assert(w.Code, Equals, test.expectStatus)
+		if test.expectStatus != http.StatusOK {
+			continue
+		}
+		got := new(task.Task)
+		c.Assert(json.Unmarshal(w.Body.Bytes(), got), IsNil)
+		c.Check(got.State, Equals, test.expectState)
+	}
+
+	c.Log("alice can read the history")
+	w = send("GET", path+"/history", "alice", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	var history []*task.Transition
+	c.Assert(json.Unmarshal(w.Body.Bytes(), &history), IsNil)
+	c.Check(history, DeepEquals, []*task.Transition{{
+		From: task.Open, To: task.Claimed, By: "bob",
+		Note: "on it", Time: now,
+	}, {
+		From: task.Claimed, To: task.Submitted, By: "bob", Time: now,
+	}, {
+		From: task.Submitted, To: task.Rejected, By: "bodie",
+		Note: "needs work", Time: now,
+	}, {
+		From: task.Rejected, To: task.Open, By: "bodie", Time: now,
+	}})
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/synapse-garden/sg-proto/notif"
+	"github.com/synapse-garden/sg-proto/presence"
 	mw "github.com/synapse-garden/sg-proto/rest/middleware"
 	"github.com/synapse-garden/sg-proto/rest/ws"
 	"github.com/synapse-garden/sg-proto/store"
@@ -28,7 +29,11 @@ const StreamNotifs = "streams"
 // to them.
 type Stream struct {
 	*bolt.DB
+	mw.Sessions
 	river.Pub
+
+	// Presence, if set, tracks who is connected to each Stream.
+	Presence *presence.Tracker
 }
 
 // Bind implements API.Bind on Stream.
@@ -80,32 +85,32 @@ func (s *Stream) Bind(r *htr.Router) error {
 	//   - Just slices of {username, timestamp, message}
 	//   - DELETE a stream I own
 
-	r.GET("/streams/:stream_id/start", mw.AuthWSUser(
+	r.GET("/streams/:stream_id/start", s.AuthWSUser(
 		s.Connect,
 		db, mw.CtxSetUserID,
 	))
 
-	r.POST("/streams", mw.AuthUser(
+	r.POST("/streams", s.AuthUser(
 		s.Create,
 		db, mw.CtxSetUserID,
 	))
 
-	r.GET("/streams", mw.AuthUser(
+	r.GET("/streams", s.AuthUser(
 		s.GetAll,
 		db, mw.CtxSetUserID,
 	))
 
-	r.GET("/streams/:stream_id", mw.AuthUser(
+	r.GET("/streams/:stream_id", s.AuthUser(
 		s.Get,
 		db, mw.CtxSetUserID,
 	))
 
-	r.PUT("/streams/:stream_id", mw.AuthUser(
+	r.PUT("/streams/:stream_id", s.AuthUser(
 		s.Put,
 		db, mw.CtxSetUserID,
 	))
 
-	r.DELETE("/streams/:stream_id", mw.AuthUser(
+	r.DELETE("/streams/:stream_id", s.AuthUser(
 		s.Delete,
 		db, mw.CtxSetUserID,
 	))
@@ -203,12 +208,16 @@ func (s Stream) Connect(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 		}
 	}
 
+	untrack := track(s.Presence, userID, presence.Location{
+		Kind: presence.Stream, ID: str.ID,
+	})
 	xws.Server{
 		Handshake: ws.Check,
 		// Use the HangupSender.Read to hang up the river if a
 		// hangup survey is received.
-		Handler: ws.Bind(rv, h.Read),
+		Handler: ws.Bind(rv, touching(s.Presence, userID, h.Read)),
 	}.ServeHTTP(w, r)
+	untrack()
 
 	err = s.Update(func(tx *bolt.Tx) (e error) {
 		eD := river.DeleteBus(userID, str.ID, rv.ID())(tx)
@@ -310,6 +319,8 @@ func (s Stream) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 	err = s.Update(store.Wrap(
 		stream.CheckNotExist(id),
 		users.CheckUsersExist(allUsers...),
+		users.CheckNotBlocked(userID, allUsers...),
+		users.ResolveCircles(&str.Group, nil),
 		stream.Upsert(str),
 	))
 	if err != nil {
@@ -318,8 +329,10 @@ func (s Stream) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		switch {
 		case stream.IsExists(err):
 			code = http.StatusConflict
-		case users.IsMissing(err):
+		case users.IsMissing(err), users.IsCircleMissing(err):
 			code = http.StatusNotFound
+		case users.IsBlocked(err):
+			code = http.StatusForbidden
 		default:
 			code = http.StatusInternalServerError
 		}
@@ -329,7 +342,7 @@ func (s Stream) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 
 	// Notify stream members that they have been added.
 	for u := range str.Readers {
-		err = notif.Encode(s.Pub, str, notif.MakeUserTopic(u))
+		err = notif.Encode(s.Pub, stream.Changed{Stream: str, By: userID}, notif.MakeUserTopic(u))
 		if err != nil {
 			log.Printf("failed to notify user %q of stream add", u)
 		}
@@ -420,6 +433,10 @@ func (s Stream) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	err = s.Update(store.Wrap(
 		stream.CheckExists(id),
 		users.CheckUsersExist(allUsers...),
+		users.CheckNotBlocked(userID,
+			users.Added(existing.Group, str.Group)...,
+		),
+		users.ResolveCircles(&str.Group, &existing.Group),
 		stream.Upsert(str),
 	))
 	if err != nil {
@@ -428,8 +445,11 @@ func (s Stream) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 		).Error()
 		var code int
 		switch {
-		case stream.IsMissing(err), users.IsMissing(err):
+		case stream.IsMissing(err), users.IsMissing(err),
+			users.IsCircleMissing(err):
 			code = http.StatusNotFound
+		case users.IsBlocked(err):
+			code = http.StatusForbidden
 		default:
 			code = http.StatusInternalServerError
 		}
@@ -444,6 +464,11 @@ func (s Stream) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 		return
 	}
 
+	// Readers granted by Circles are also updated.
+	for r := range str.CircleReaders {
+		updateUsers[r] = true
+	}
+
 	// Go through the old Readers.  If that user wasn't in the new
 	// users map, it gets inserted as a false value.
 	for r := range existing.Readers {
@@ -455,7 +480,7 @@ func (s Stream) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	for u, ok := range updateUsers {
 		topic := notif.MakeUserTopic(u)
 		if ok {
-			err = notif.Encode(s.Pub, str, topic)
+			err = notif.Encode(s.Pub, stream.Changed{Stream: str, By: userID}, topic)
 		} else {
 			err = notif.Encode(s.Pub, stream.Removed(str.ID), topic)
 		}
@@ -6,7 +6,6 @@ import (
 	"net/url"
 	"reflect"
 	"sort"
-	"time"
 
 	"github.com/synapse-garden/sg-proto/notif"
 	mw "github.com/synapse-garden/sg-proto/rest/middleware"
@@ -26,6 +25,7 @@ const TaskNotifs = "tasks"
 
 type Task struct {
 	*bolt.DB
+	mw.Sessions
 
 	river.Pub
 
@@ -36,6 +36,9 @@ func (t *Task) Bind(r *htr.Router) error {
 	if t.DB == nil {
 		return errors.New("Bind called with nil DB handle")
 	}
+	if t.Timer == nil {
+		t.Timer = util.SimpleTimer{}
+	}
 
 	err := t.Update(func(tx *bolt.Tx) (e error) {
 		t.Pub, e = river.NewPub(TaskNotifs, NotifStream, tx)
@@ -45,36 +48,48 @@ func (t *Task) Bind(r *htr.Router) error {
 		return err
 	}
 
-	r.GET("/tasks", mw.AuthUser(
+	r.GET("/tasks", t.AuthUser(
 		t.GetAll,
 		t.DB,
 		mw.CtxSetUserID,
 	))
 
-	r.POST("/tasks", mw.AuthUser(
+	r.POST("/tasks", t.AuthUser(
 		t.Create,
 		t.DB,
 		mw.CtxSetUserID,
 	))
 
-	r.GET("/tasks/:id", mw.AuthUser(
+	r.GET("/tasks/:id", t.AuthUser(
 		t.Get,
 		t.DB,
 		mw.CtxSetUserID,
 	))
 
-	r.DELETE("/tasks/:id", mw.AuthUser(
+	r.GET("/tasks/:id/subtree", t.AuthUser(
+		t.GetSubtree,
+		t.DB,
+		mw.CtxSetUserID,
+	))
+
+	r.DELETE("/tasks/:id", t.AuthUser(
 		t.Delete,
 		t.DB,
 		mw.CtxSetUserID,
 	))
 
-	r.PUT("/tasks/:id", mw.AuthUser(
+	r.PUT("/tasks/:id", t.AuthUser(
 		t.Put,
 		t.DB,
 		mw.CtxSetUserID,
 	))
 
+	t.bindReviews(r)
+	t.bindReminders(r)
+	t.bindLabels(r)
+	t.bindProjects(r)
+	t.bindActivity(r)
+
 	return nil
 }
 
@@ -87,7 +102,19 @@ func (t *Task) GetAll(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		return
 	}
 
-	now := time.Now()
+	now := t.Now()
+
+	// linked filters need the Pending blockers of the Tasks found, so
+	// they are applied once the others have been.
+	pending := make(task.Pending)
+	var linked []task.Filter
+
+	// orders sort the result, if given.  Otherwise it is sorted by
+	// due date.
+	var orders []task.Order
+
+	// label, if given, uses the label index to find the Tasks.
+	var label string
 
 	filters := []task.Filter{}
 	for k, v := range vals {
@@ -118,6 +145,65 @@ func (t *Task) GetAll(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 				).Error(), http.StatusBadRequest)
 				return
 			}
+		case k == "blocked" && len(v) > 0:
+			switch v[0] {
+			case "true":
+				linked = append(linked, task.Blocked(pending))
+			case "false":
+				linked = append(linked, task.NotBlocked(pending))
+			default:
+				http.Error(w, errors.Errorf(
+					`bad value %q for query `+
+						`parameter %q`, v[0], k,
+				).Error(), http.StatusBadRequest)
+				return
+			}
+		case k == "ready" && len(v) > 0:
+			if v[0] != "true" {
+				http.Error(w, errors.Errorf(
+					`bad value %q for query `+
+						`parameter %q`, v[0], k,
+				).Error(), http.StatusBadRequest)
+				return
+			}
+			linked = append(linked, task.Ready(pending))
+		case k == "parent" && len(v) > 0:
+			pUUID, err := uuid.FromString(v[0])
+			if err != nil {
+				http.Error(w, errors.Errorf(
+					`bad value %q for query `+
+						`parameter %q`, v[0], k,
+				).Error(), http.StatusBadRequest)
+				return
+			}
+			filters = append(filters, task.ChildOf(pUUID))
+		case k == "q" && len(v) > 0:
+			f, err := task.ParseQuery(v[0], now)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			filters = append(filters, f)
+		case k == "project" && len(v) > 0:
+			pUUID, err := uuid.FromString(v[0])
+			if err != nil {
+				http.Error(w, errors.Errorf(
+					`bad value %q for query `+
+						`parameter %q`, v[0], k,
+				).Error(), http.StatusBadRequest)
+				return
+			}
+			filters = append(filters, task.ByProject(pUUID))
+		case k == "label" && len(v) > 0:
+			if label, err = task.NormalizeLabel(v[0]); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case k == "sort" && len(v) > 0:
+			if orders, err = task.ParseOrders(v[0]); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
 		default:
 			http.Error(w, errors.Errorf(
 				"unknown query parameter %q", k,
@@ -128,10 +214,31 @@ func (t *Task) GetAll(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 
 	var ts []*task.Task
 	err = t.View(func(tx *bolt.Tx) (e error) {
-		ts, e = task.GetAll(
-			mw.CtxGetUserID(r),
-			filters...,
-		)(tx)
+		if label != "" {
+			ts, e = task.GetLabeled(
+				mw.CtxGetUserID(r), label,
+				filters...,
+			)(tx)
+		} else {
+			ts, e = task.GetAll(
+				mw.CtxGetUserID(r),
+				filters...,
+			)(tx)
+		}
+		if e != nil || len(linked) == 0 {
+			return
+		}
+
+		if e = task.GetPending(ts, pending)(tx); e != nil {
+			return
+		}
+		found := ts
+		ts = nil
+		for _, tsk := range found {
+			if task.MultiAnd(linked).Member(tsk) {
+				ts = append(ts, tsk)
+			}
+		}
 		return
 	})
 
@@ -142,7 +249,11 @@ func (t *Task) GetAll(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		return
 	}
 
-	sort.Sort(task.ByOldest(ts))
+	if orders != nil {
+		task.SortBy(ts, orders)
+	} else {
+		sort.Sort(task.ByOldest(ts))
+	}
 
 	json.NewEncoder(w).Encode(ts)
 }
@@ -167,11 +278,37 @@ func (t *Task) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		).Error(), http.StatusBadRequest)
 		return
 	}
+	if tsk.Bounty < 0 {
+		http.Error(w, "bounty must not be negative", http.StatusBadRequest)
+		return
+	}
+	if tsk.Recur != nil && tsk.Recur.NeedsDue() && tsk.Due == nil {
+		http.Error(w, "recurring task must have a due date", http.StatusBadRequest)
+		return
+	}
+	labels, err := task.NormalizeLabels(tsk.Labels)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tsk.Labels = labels
 
 	// Make sure the Owner is in the readers / writers.
 	tsk.Readers[userID] = true
 	tsk.Writers[userID] = true
 
+	// New Tasks are Open for review, unless they are already done,
+	// and have no next instance yet.  They are put on Projects using
+	// PlaceTask.  Only review sets who completed a Task and when,
+	// since reopening it reclaims their bounty.
+	tsk.State, tsk.Claimant = task.Open, ""
+	tsk.CompletedBy, tsk.CompletedAt = "", nil
+	tsk.Next = nil
+	tsk.Project, tsk.ProjectReaders, tsk.ProjectWriters = nil, nil, nil
+	if tsk.Completed {
+		tsk.State = task.Accepted
+	}
+
 	allUsers := make([]string, len(tsk.Readers)+len(tsk.Writers)+1)
 	allUsers[0] = userID
 	next := 1
@@ -184,7 +321,7 @@ func (t *Task) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		next++
 	}
 
-	err := t.View(users.CheckUsersExist(allUsers...))
+	err = t.View(users.CheckUsersExist(allUsers...))
 	if err != nil {
 		msg := errors.Wrap(
 			err, "failed to check Task",
@@ -203,17 +340,43 @@ func (t *Task) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 	// Store sets the ID and replaces the Task's Notes with
 	// Resources.  The user shouldn't see those resource IDs, so
 	// they should be cleared after Store.
+	//
+	// The Bounty of an incomplete Task is moved from its Owner into
+	// escrow in the same transaction.
 	notes := tsk.Notes
-	err = t.Update(store.Wrap(
+	own := &users.User{Name: userID}
+	tID := task.ID(uuid.NewV4())
+	act := &task.Activity{By: userID, Time: t.Now().UTC(), Created: true}
+	ops := []func(*bolt.Tx) error{
 		users.CheckUsersExist(allUsers...),
-		task.ID(uuid.NewV4()).Store(tsk),
-	))
+		users.CheckNotBlocked(userID, allUsers...),
+		users.ResolveCircles(&tsk.Group, nil),
+		tID.Store(tsk),
+		task.CheckLinks(tsk, userID),
+		task.RecordActivity(tID, act),
+	}
+	if !tsk.Completed {
+		ops = append(ops, task.Fund(tsk, t.Now()))
+	}
+	err = t.Update(store.Wrap(append(ops, loadUsers(own))...))
 	switch {
-	case users.IsMissing(err):
+	case users.IsMissing(err), users.IsCircleMissing(err):
 		http.Error(w, errors.Wrap(
 			err, "failed to check Task",
 		).Error(), http.StatusNotFound)
 		return
+	case users.IsBlocked(err):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case users.IsInsufficientCoin(err):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	case task.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case task.IsCycle(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
 	case err != nil:
 		http.Error(w, errors.Wrap(
 			err, "failed to store Task",
@@ -228,9 +391,17 @@ func (t *Task) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 	for _, u := range allUsers {
 		toUpdate[u] = struct{}{}
 	}
+	for u := range tsk.CircleReaders {
+		toUpdate[u] = struct{}{}
+	}
 	for u := range toUpdate {
 		notif.Encode(t.Pub, tsk, notif.MakeUserTopic(u))
 	}
+	if tsk.Bounty > 0 && !tsk.Completed {
+		// Notify the owner that the bounty was escrowed.
+		notif.Encode(t.Pub, own, notif.MakeUserTopic(own.Name))
+	}
+	t.notifyEntry(tsk, act)
 	json.NewEncoder(w).Encode(tsk)
 }
 
@@ -264,6 +435,32 @@ func (t *Task) Get(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	json.NewEncoder(w).Encode(tsk)
 }
 
+// GetSubtree returns the Task as a task.Tree of its subtasks, leaving
+// out any the user can't see.
+func (t *Task) GetSubtree(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	userID := mw.CtxGetUserID(r)
+	tUUID, err := uuid.FromString(ps.ByName("id"))
+	if err != nil {
+		http.Error(w, "invalid task ID", http.StatusBadRequest)
+		return
+	}
+
+	tree := new(task.Tree)
+	err = t.View(task.GetTree(task.ID(tUUID), userID, tree))
+	switch {
+	case task.IsMissing(err):
+		http.Error(w, "no such task", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to get subtree",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(tree)
+}
+
 func (t *Task) Delete(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	userID := mw.CtxGetUserID(r)
 	tIDString := ps.ByName("id")
@@ -292,7 +489,27 @@ func (t *Task) Delete(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 		return
 	}
 
-	if err := t.Update(tID.Delete); err != nil {
+	// An incomplete Task's escrowed Bounty is refunded to its Owner.
+	// Its subtasks and the Tasks it blocks are unlinked from it.
+	own := &users.User{Name: tsk.Owner}
+	tsk.ID = tID
+	var unlinked []*task.Task
+	ops := []func(*bolt.Tx) error{
+		tID.Delete,
+		task.DeleteHistory(tID),
+		task.DeleteReminded(tID),
+		task.DeleteActivity(tID),
+		task.Unlink(tID, &unlinked),
+	}
+	if !tsk.Completed {
+		ops = append(ops, task.Refund(tsk, t.Now()), loadUsers(own))
+	}
+	var proj *task.Project
+	if tsk.Project != nil {
+		proj = new(task.Project)
+		ops = append(ops, task.DropFromProject(*tsk.Project, tID, proj))
+	}
+	if err := t.Update(store.Wrap(ops...)); err != nil {
 		http.Error(w, errors.Wrap(
 			err, "failed to delete task",
 		).Error(), http.StatusInternalServerError)
@@ -311,6 +528,18 @@ func (t *Task) Delete(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	for u := range toUpdate {
 		notif.Encode(t.Pub, del, notif.MakeUserTopic(u))
 	}
+	for _, ul := range unlinked {
+		for u := range users.AllUsers(ul.Group) {
+			notif.Encode(t.Pub, ul, notif.MakeUserTopic(u))
+		}
+	}
+	if tsk.Bounty > 0 && !tsk.Completed {
+		// Notify the owner that the bounty was refunded.
+		notif.Encode(t.Pub, own, notif.MakeUserTopic(own.Name))
+	}
+	if proj != nil && proj.Owner != "" {
+		t.notifyProject(proj, nil)
+	}
 }
 
 func (t *Task) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
@@ -339,6 +568,19 @@ func (t *Task) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 		return
 	}
 
+	if sentTask.Bounty < 0 {
+		http.Error(w, "bounty must not be negative", http.StatusBadRequest)
+		return
+	}
+	if sentTask.Recur != nil && sentTask.Recur.NeedsDue() && sentTask.Due == nil {
+		http.Error(w, "recurring task must have a due date", http.StatusBadRequest)
+		return
+	}
+	if sentTask.Labels, err = task.NormalizeLabels(sentTask.Labels); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Make sure the Owner is in the readers / writers.
 	sentTask.Readers[userID] = true
 	sentTask.Writers[userID] = true
@@ -382,11 +624,52 @@ func (t *Task) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 		).Error(), http.StatusInternalServerError)
 		return
 	}
+	// A Task's Project, and the users it has because of it, can only
+	// be changed through the Project.
+	sentTask.Project = oldTask.Project
+	sentTask.ProjectReaders = oldTask.ProjectReaders
+	sentTask.ProjectWriters = oldTask.ProjectWriters
+	err = t.View(store.Wrap(
+		users.CheckNotBlocked(userID,
+			users.Added(oldTask.Group, sentTask.Group)...,
+		),
+		users.ResolveCircles(&sentTask.Group, &oldTask.Group),
+		task.ResolveProject(sentTask),
+	))
+	switch {
+	case users.IsCircleMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case users.IsBlocked(err):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to resolve circles",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The review State can only be changed using its endpoints, and
+	// the next instance of a recurring Task is only set by renewal.
+	sentTask.State, sentTask.Claimant = oldTask.State, oldTask.Claimant
+	sentTask.Next = oldTask.Next
+
+	changes, err := task.Diff(oldTask, sentTask)
+	if err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to diff task",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+	act := &task.Activity{By: userID, Time: t.Now().UTC(), Changes: changes}
+
 	var (
 		isOwner  = oldTask.Owner == userID
 		isWriter = oldTask.Writers[userID]
 
 		// Non-owners can only modify notes and completion.
+		isModifyOwner       = oldTask.Owner != sentTask.Owner
 		isModifyBounty      = oldTask.Bounty != sentTask.Bounty
 		isModifyCompletedBy = oldTask.CompletedBy != sentTask.CompletedBy
 		isModifyCompletedAt = func() bool {
@@ -408,9 +691,19 @@ func (t *Task) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 		isModifyGroup = !reflect.DeepEqual(
 			oldTask.Group, sentTask.Group,
 		)
+		isModifyLinks = !reflect.DeepEqual(
+			oldTask.Parent, sentTask.Parent,
+		) || !sameIDs(oldTask.BlockedBy, sentTask.BlockedBy)
+		isModifyRecur = !reflect.DeepEqual(
+			oldTask.Recur, sentTask.Recur,
+		)
 		isModifyCompletion = oldTask.Completed != sentTask.Completed
 	)
 	switch {
+	case isModifyOwner:
+		// The escrowed Bounty belongs to the Owner.
+		http.Error(w, "cannot change the owner of a task", http.StatusBadRequest)
+		return
 	case isModifyCompletedBy && sentTask.Completed:
 		http.Error(w, "cannot modify completed-by of complete task", http.StatusBadRequest)
 		return
@@ -423,41 +716,22 @@ func (t *Task) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	case isOwner && isModifyDueDate && oldTask.Completed:
 		http.Error(w, "cannot modify due date of complete task", http.StatusBadRequest)
 		return
-	case isOwner && isModifyCompletion && !sentTask.Completed:
-		// The owner un-completed the task.  This returns the
-		// bounty to the owner and un-sets the completion data.
-		t.uncompleteAsOwner(w, allUsers, oldTask, sentTask)
+	case isModifyCompletion, isModifyCompletedBy, isModifyCompletedAt:
+		// Only acceptance completes a Task.
+		http.Error(w, "task can only be completed or reopened "+
+			"by review", http.StatusBadRequest)
 		return
 	case isOwner:
-		// The owner updated (or completed) the task.  This does
-		// not distribute any bounty.
-		t.updateAsOwner(w, allUsers, oldTask, sentTask)
+		// The owner updated the task.  Changes to the bounty are
+		// escrowed.
+		t.updateAsOwner(w, allUsers, oldTask, sentTask, act)
 		return
-	case isModifyBounty, isModifyDueDate, isModifyGroup:
+	case isModifyBounty, isModifyDueDate, isModifyGroup, isModifyLinks,
+		isModifyRecur:
 		// Only the owner can do these things.  Unauthorized.
-	case isWriter && isModifyCompletion && sentTask.Completed:
-		// A writer completed the task.  The bounty is divested
-		// from the owner to the writer.  Both are notified.
-		t.completeAsWriter(w,
-			allUsers, userID,
-			oldTask, sentTask,
-			t.Now().UTC(),
-		)
-		return
-	case isWriter &&
-		isModifyCompletion && !sentTask.Completed &&
-		oldTask.CompletedBy == userID:
-		// A writer un-completed the task.  Only the person who
-		// completed the task can do this.  Owner and completer
-		// are notified of bounty changes.
-		t.uncompleteAsWriter(w,
-			allUsers, userID,
-			oldTask, sentTask,
-		)
-		return
 	case isWriter:
 		// The writer did something else (like adding notes.)
-		t.updateAsWriter(w, allUsers, oldTask, sentTask)
+		t.updateAsWriter(w, allUsers, oldTask, sentTask, act)
 		return
 	}
 	code := http.StatusUnauthorized
@@ -465,73 +739,41 @@ func (t *Task) Put(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	return
 }
 
-func (t Task) uncompleteAsOwner(
+func (t Task) updateAsOwner(
 	w http.ResponseWriter,
 	allUsers []string,
 	old, new *task.Task,
+	act *task.Activity,
 ) {
 	notes := new.Notes
-	comp := &users.User{Name: old.CompletedBy}
+	now := t.Now()
 	own := &users.User{Name: old.Owner}
-	err := t.Update(store.Wrap(
+	ops := []func(*bolt.Tx) error{
 		old.ID.Store(new),
-		users.AddCoin(own, old.Bounty),
-		users.AddCoin(comp, -old.Bounty),
 		users.CheckUsersExist(allUsers...),
-	))
-	switch {
-	case users.IsMissing(err):
-		http.Error(w, errors.Wrap(
-			err, "failed to check Task",
-		).Error(), http.StatusNotFound)
-		return
-	case err != nil:
-		http.Error(w, errors.Wrap(
-			err, "failed to store Task",
-		).Error(), http.StatusInternalServerError)
-		return
-	}
-
-	new.ID = old.ID
-	new.Notes = notes
-	new.Resources = nil
-
-	toUpdate := users.DiffGroups(old.Group, new.Group)
-	for u, ok := range toUpdate {
-		uTopic := notif.MakeUserTopic(u)
-		if ok {
-			notif.Encode(t.Pub, new, uTopic)
-		} else {
-			notif.Encode(t.Pub, task.Removed(new.ID), uTopic)
-		}
+		task.CheckLinks(new, new.Owner),
+		task.RecordActivity(old.ID, act),
 	}
-	notif.Encode(t.Pub, own, notif.MakeUserTopic(own.Name))
-	notif.Encode(t.Pub, comp, notif.MakeUserTopic(comp.Name))
-	json.NewEncoder(w).Encode(new)
-}
-
-func (t Task) updateAsOwner(
-	w http.ResponseWriter,
-	allUsers []string,
-	old, new *task.Task,
-) {
-	notes := new.Notes
-	if new.Completed && !old.Completed {
-		// The owner completed the task.  Don't divest bounty.
-		new.CompletedBy = new.Owner
-		now := t.Now()
-		new.CompletedAt = &now
+	if !old.Completed {
+		// Escrow any change to the bounty.
+		ops = append(ops, task.Fund(new, now))
 	}
-	err := t.Update(store.Wrap(
-		old.ID.Store(new),
-		users.CheckUsersExist(allUsers...),
-	))
+	err := t.Update(store.Wrap(append(ops, loadUsers(own))...))
 	switch {
 	case users.IsMissing(err):
 		http.Error(w, errors.Wrap(
 			err, "failed to check Task",
 		).Error(), http.StatusNotFound)
 		return
+	case users.IsInsufficientCoin(err):
+		http.Error(w, err.Error(), http.StatusPaymentRequired)
+		return
+	case task.IsMissing(err):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case task.IsCycle(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
 	case err != nil:
 		http.Error(w, errors.Wrap(
 			err, "failed to store Task",
@@ -552,73 +794,26 @@ func (t Task) updateAsOwner(
 			notif.Encode(t.Pub, task.Removed(new.ID), uTopic)
 		}
 	}
-
-	json.NewEncoder(w).Encode(new)
-}
-
-func (t Task) completeAsWriter(
-	w http.ResponseWriter,
-	allUsers []string,
-	u string,
-	old, new *task.Task,
-	now time.Time,
-) {
-	comp := &users.User{Name: u}
-	own := &users.User{Name: old.Owner}
-	notes := new.Notes
-	new.CompletedBy = u
-	new.CompletedAt = &now
-	err := t.Update(store.Wrap(
-		users.CheckUsersExist(allUsers...),
-		users.AddCoin(own, -new.Bounty),
-		users.AddCoin(comp, new.Bounty),
-		old.ID.Store(new),
-	))
-	switch {
-	case users.IsMissing(err):
-		http.Error(w, errors.Wrap(
-			err, "failed to check Task",
-		).Error(), http.StatusNotFound)
-		return
-	case err != nil:
-		http.Error(w, errors.Wrap(
-			err, "failed to store Task",
-		).Error(), http.StatusInternalServerError)
-		return
+	if old.Bounty != new.Bounty {
+		// Notify the owner of their escrow or refund.
+		notif.Encode(t.Pub, own, notif.MakeUserTopic(own.Name))
 	}
+	t.notifyEntry(new, act)
 
-	new.ID = old.ID
-	new.Notes = notes
-	new.Resources = nil
-
-	for up := range users.AllUsers(new.Group) {
-		// Notify each user of the change.
-		notif.Encode(t.Pub, new, notif.MakeUserTopic(up))
-	}
-	// Notify the owner that the bounty was divested
-	notif.Encode(t.Pub, own, notif.MakeUserTopic(own.Name))
-	// Notify the completing writer of his profile bounty update
-	notif.Encode(t.Pub, comp, notif.MakeUserTopic(comp.Name))
 	json.NewEncoder(w).Encode(new)
 }
 
-func (t Task) uncompleteAsWriter(
+func (t Task) updateAsWriter(
 	w http.ResponseWriter,
 	allUsers []string,
-	u string,
 	old, new *task.Task,
+	act *task.Activity,
 ) {
-	comp := &users.User{Name: u}
-	own := &users.User{Name: old.Owner}
-
 	notes := new.Notes
-	new.CompletedBy = ""
-	new.CompletedAt = nil
 	err := t.Update(store.Wrap(
 		users.CheckUsersExist(allUsers...),
-		users.AddCoin(own, new.Bounty),
-		users.AddCoin(comp, -new.Bounty),
 		old.ID.Store(new),
+		task.RecordActivity(old.ID, act),
 	))
 	switch {
 	case users.IsMissing(err):
@@ -641,43 +836,37 @@ func (t Task) uncompleteAsWriter(
 		// Notify each user of the change
 		notif.Encode(t.Pub, new, notif.MakeUserTopic(up))
 	}
-	// Notify the completing writer of his profile bounty update
-	notif.Encode(t.Pub, comp, notif.MakeUserTopic(comp.Name))
-	// Notify the owner his bounty was returned
-	notif.Encode(t.Pub, own, notif.MakeUserTopic(own.Name))
+	t.notifyEntry(new, act)
 	json.NewEncoder(w).Encode(new)
 }
 
-func (t Task) updateAsWriter(
-	w http.ResponseWriter,
-	allUsers []string,
-	old, new *task.Task,
-) {
-	notes := new.Notes
-	err := t.Update(store.Wrap(
-		users.CheckUsersExist(allUsers...),
-		old.ID.Store(new),
-	))
-	switch {
-	case users.IsMissing(err):
-		http.Error(w, errors.Wrap(
-			err, "failed to check Task",
-		).Error(), http.StatusNotFound)
-		return
-	case err != nil:
-		http.Error(w, errors.Wrap(
-			err, "failed to store Task",
-		).Error(), http.StatusInternalServerError)
-		return
+// loadUsers returns a function which loads each of the given Users by
+// Name.
+func loadUsers(us ...*users.User) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		for _, u := range us {
+			err := store.Unmarshal(users.UserBucket, u, []byte(u.Name))(tx)
+			switch {
+			case store.IsMissing(err):
+				return users.ErrMissing(u.Name)
+			case err != nil:
+				return err
+			}
+		}
+		return nil
 	}
+}
 
-	new.ID = old.ID
-	new.Notes = notes
-	new.Resources = nil
-
-	for up := range users.AllUsers(new.Group) {
-		// Notify each user of the change
-		notif.Encode(t.Pub, new, notif.MakeUserTopic(up))
+// sameIDs returns true if the given slices hold the same task.IDs in
+// the same order.  Nil and empty slices are the same.
+func sameIDs(a, b []task.ID) bool {
+	if len(a) != len(b) {
+		return false
 	}
-	json.NewEncoder(w).Encode(new)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
@@ -6,7 +6,9 @@ import (
 	"encoding/json"
 	"net/http"
 	htt "net/http/httptest"
+	"net/url"
 	"reflect"
+	"sort"
 	"time"
 
 	"github.com/synapse-garden/sg-proto/auth"
@@ -77,6 +79,10 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 
 	defer srv.Close()
 	c.Assert(notifErr, IsNil)
+	// bodie needs enough coin to escrow a bounty.
+	c.Assert(s.db.Update(users.Post(&users.Transaction{
+		To: "bodie", Amount: 5, Reason: users.Grant, Time: now,
+	})), IsNil)
 
 	// Get websocket connection for "bodie".
 	connBodie, err := sgt.GetWSClient(
@@ -195,6 +201,17 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 			},
 			"due":       someWhen.Format(time.RFC3339Nano),
 			"completed": false,
+			"state":     "open",
+		},
+	})
+	c.Assert(ws.JSON.Receive(connBodie, notif), IsNil)
+	c.Check(notif, DeepEquals, &store.ResourceBox{
+		Name: "task-activity",
+		Contents: map[string]interface{}{
+			"task":    uuid.UUID(got.ID).String(),
+			"by":      "bodie",
+			"time":    now.Format(time.RFC3339Nano),
+			"created": true,
 		},
 	})
 
@@ -206,6 +223,7 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 	gotOld := new(task.Task)
 	c.Assert(json.Unmarshal(w.Body.Bytes(), gotOld), IsNil)
 	c.Assert(ws.JSON.Receive(connBodie, x), IsNil)
+	c.Assert(ws.JSON.Receive(connBodie, x), IsNil)
 
 	w = htt.NewRecorder()
 	r.ServeHTTP(w, noDueReq)
@@ -213,6 +231,7 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 	gotNoDue := new(task.Task)
 	c.Assert(json.Unmarshal(w.Body.Bytes(), gotNoDue), IsNil)
 	c.Assert(ws.JSON.Receive(connBodie, x), IsNil)
+	c.Assert(ws.JSON.Receive(connBodie, x), IsNil)
 
 	w = htt.NewRecorder()
 	r.ServeHTTP(w, doneReq)
@@ -220,12 +239,14 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 	gotDone := new(task.Task)
 	c.Assert(json.Unmarshal(w.Body.Bytes(), gotDone), IsNil)
 	c.Assert(ws.JSON.Receive(connBodie, x), IsNil)
+	c.Assert(ws.JSON.Receive(connBodie, x), IsNil)
 
 	newGot := new(task.Task)
 
 	otherT := new(task.Task)
 	*otherT = *t
 	otherT.ID = got.ID
+	otherT.State = task.Open
 	otherT.Bounty = 5
 	otherT.Notes = append(otherT.Notes, "something else")
 	otherT.Readers = map[string]bool{"bodie": true, "bob": true}
@@ -235,12 +256,122 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 	*doneGot = *otherT
 	doneGot.Completed = true
 	doneGot.Notes = append(doneGot.Notes, "another thing entirely")
+	// otherT goes through review by bob.
+	claimed := new(task.Task)
+	*claimed = *otherT
+	claimed.State, claimed.Claimant = task.Claimed, "bob"
+	submitted := new(task.Task)
+	*submitted = *claimed
+	submitted.State = task.Submitted
 	doneDone := new(task.Task)
-	*doneDone = *doneGot
+	*doneDone = *submitted
+	doneDone.State = task.Accepted
+	doneDone.Completed = true
 	doneDone.CompletedBy = "bob"
 	doneDone.CompletedAt = new(time.Time)
 	*doneDone.CompletedAt = now.UTC()
 
+	completion := map[string]interface{}{
+		"completed":   true,
+		"completedBy": "bob",
+		"completedAt": now.UTC().Format(time.RFC3339Nano),
+	}
+	// reviewed is the notif of otherT in the given review state.
+	reviewed := func(state string, more map[string]interface{}) *store.ResourceBox {
+		contents := map[string]interface{}{
+			"owner":  "bodie",
+			"bounty": float64(5),
+			"readers": map[string]interface{}{
+				"bodie": true,
+				"bob":   true,
+			},
+			"writers": map[string]interface{}{
+				"bodie": true,
+				"bob":   true,
+			},
+			"id":   uuid.UUID(got.ID).String(),
+			"name": "V. Important Task",
+			"notes": []interface{}{
+				"hello world",
+				"goodbye world",
+				"something else",
+			},
+			"due":       someWhen.Format(time.RFC3339Nano),
+			"completed": false,
+			"state":     state,
+		}
+		if state != "open" {
+			contents["claimant"] = "bob"
+		}
+		for k, v := range more {
+			contents[k] = v
+		}
+		return &store.ResourceBox{Name: "tasks", Contents: contents}
+	}
+	// change is a change to a field of otherT; nil values are omitted.
+	change := func(field string, from, to interface{}) interface{} {
+		result := map[string]interface{}{"field": field}
+		if from != nil {
+			result["from"] = from
+		}
+		if to != nil {
+			result["to"] = to
+		}
+		return result
+	}
+	// changed is the notif of an Activity by the given user on otherT.
+	changed := func(by string, changes ...interface{}) *store.ResourceBox {
+		return &store.ResourceBox{
+			Name: "task-activity",
+			Contents: map[string]interface{}{
+				"task":    uuid.UUID(got.ID).String(),
+				"by":      by,
+				"time":    now.Format(time.RFC3339Nano),
+				"changes": changes,
+			},
+		}
+	}
+	var (
+		ownerPut = changed("bodie",
+			change("notes",
+				[]interface{}{"hello world", "goodbye world"},
+				[]interface{}{"hello world", "goodbye world", "something else"},
+			),
+			change("bounty", nil, float64(5)),
+			change("readers",
+				[]interface{}{"bodie"}, []interface{}{"bob", "bodie"},
+			),
+			change("writers",
+				[]interface{}{"bodie"}, []interface{}{"bob", "bodie"},
+			),
+		)
+		claim = changed("bob",
+			change("state", "open", "claimed"),
+			change("claimant", nil, "bob"),
+		)
+		submit = changed("bob", change("state", "claimed", "submitted"))
+		accept = changed("bodie",
+			change("state", "submitted", "accepted"),
+			change("completed", nil, true),
+			change("completedBy", nil, "bob"),
+			change("completedAt", nil, now.Format(time.RFC3339Nano)),
+		)
+		reopen = changed("bodie",
+			change("state", "accepted", "open"),
+			change("claimant", "bob", nil),
+			change("completed", true, nil),
+			change("completedBy", "bob", nil),
+			change("completedAt", now.Format(time.RFC3339Nano), nil),
+		)
+		writerPut = changed("bob", change("notes",
+			[]interface{}{"hello world", "goodbye world", "something else"},
+			[]interface{}{
+				"hello world", "goodbye world", "something else",
+				"boopy doopy",
+			},
+		))
+	)
+
 	badUsersT := new(task.Task)
 	*badUsersT = *t
 	badUsersT.Readers = map[string]bool{
@@ -274,12 +405,14 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 	multiNotifGot := new(task.Task)
 	c.Assert(json.Unmarshal(w.Body.Bytes(), multiNotifGot), IsNil)
 	c.Assert(uuid.Equal(uuid.UUID(multiNotifGot.ID), uuid.Nil), Equals, false)
-	sendMultiNotif.ID = multiNotifGot.ID
+	sendMultiNotif.ID, sendMultiNotif.State = multiNotifGot.ID, task.Open
 	c.Check(multiNotifGot, DeepEquals, sendMultiNotif)
-	c.Assert(ws.JSON.Receive(connBodie, x), IsNil)
-	c.Check((*x)["name"], Equals, "tasks")
-	c.Assert(ws.JSON.Receive(connBob, x), IsNil)
-	c.Check((*x)["name"], Equals, "tasks")
+	for _, conn := range []*ws.Conn{connBodie, connBob} {
+		c.Assert(ws.JSON.Receive(conn, x), IsNil)
+		c.Check((*x)["name"], Equals, "tasks")
+		c.Assert(ws.JSON.Receive(conn, x), IsNil)
+		c.Check((*x)["name"], Equals, "task-activity")
+	}
 	delReq := htt.NewRequest("DELETE", "/tasks/"+uuid.UUID(multiNotifGot.ID).String(), nil)
 	delReq.Header = sgt.Bearer(tokens["bodie"])
 	w = htt.NewRecorder()
@@ -456,7 +589,7 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 		body:         otherT,
 		into:         newGot,
 		expectResp:   otherT,
-		expectNotifs: map[*ws.Conn][]*store.ResourceBox{connBob: {{
+		expectNotifs: map[*ws.Conn][]*store.ResourceBox{connBob: {ownerPut, {
 			Name: "tasks",
 			Contents: map[string]interface{}{
 				"owner": "bodie",
@@ -478,8 +611,9 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 				"bounty":    float64(5),
 				"due":       someWhen.Format(time.RFC3339Nano),
 				"completed": false,
+				"state":     "open",
 			},
-		}}, connBodie: {{
+		}}, connBodie: {ownerPut, {
 			Name: "tasks",
 			Contents: map[string]interface{}{
 				"owner": "bodie",
@@ -501,82 +635,90 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 				"bounty":    float64(5),
 				"due":       someWhen.Format(time.RFC3339Nano),
 				"completed": false,
+				"state":     "open",
+			},
+		}, {
+			Name: "users",
+			Contents: map[string]interface{}{
+				"name": "bodie",
+				"coin": float64(0),
 			},
 		}}},
 	}, {
-		should:       "complete a task with a bounty as expected",
+		should:       "not let a writer complete a task with PUT",
 		verb:         "PUT",
 		path:         "/tasks/" + uuid.UUID(doneGot.ID).String(),
 		header:       sgt.Bearer(tokens["bob"]),
-		expectStatus: http.StatusOK,
+		expectStatus: http.StatusBadRequest,
 		body:         doneGot,
+		into:         new(string),
+		expectResp:   "task can only be completed or reopened by review\n",
+	}, {
+		should:       "not let bob submit a task he has not claimed",
+		verb:         "POST",
+		path:         "/tasks/" + uuid.UUID(got.ID).String() + "/submit",
+		header:       sgt.Bearer(tokens["bob"]),
+		expectStatus: http.StatusUnauthorized,
+		into:         new(string),
+		expectResp:   "Unauthorized\n",
+	}, {
+		should:       "let bob claim the task",
+		verb:         "POST",
+		path:         "/tasks/" + uuid.UUID(got.ID).String() + "/claim",
+		header:       sgt.Bearer(tokens["bob"]),
+		expectStatus: http.StatusOK,
+		into:         new(task.Task),
+		expectResp:   claimed,
+		expectNotifs: map[*ws.Conn][]*store.ResourceBox{
+			connBodie: {reviewed("claimed", nil), claim},
+			connBob:   {reviewed("claimed", nil), claim},
+		},
+	}, {
+		should:       "not let bodie accept a task before it is submitted",
+		verb:         "POST",
+		path:         "/tasks/" + uuid.UUID(got.ID).String() + "/accept",
+		header:       sgt.Bearer(tokens["bodie"]),
+		expectStatus: http.StatusConflict,
+		into:         new(string),
+		expectResp:   "cannot move task from claimed to accepted\n",
+	}, {
+		should:       "let bob submit the task",
+		verb:         "POST",
+		path:         "/tasks/" + uuid.UUID(got.ID).String() + "/submit",
+		header:       sgt.Bearer(tokens["bob"]),
+		expectStatus: http.StatusOK,
+		into:         new(task.Task),
+		expectResp:   submitted,
+		expectNotifs: map[*ws.Conn][]*store.ResourceBox{
+			connBodie: {reviewed("submitted", nil), submit},
+			connBob:   {reviewed("submitted", nil), submit},
+		},
+	}, {
+		should:       "not let bob accept his own submission",
+		verb:         "POST",
+		path:         "/tasks/" + uuid.UUID(got.ID).String() + "/accept",
+		header:       sgt.Bearer(tokens["bob"]),
+		expectStatus: http.StatusUnauthorized,
+		into:         new(string),
+		expectResp:   "Unauthorized\n",
+	}, {
+		should:       "let bodie accept the task, paying bob the bounty",
+		verb:         "POST",
+		path:         "/tasks/" + uuid.UUID(got.ID).String() + "/accept",
+		header:       sgt.Bearer(tokens["bodie"]),
+		expectStatus: http.StatusOK,
 		into:         new(task.Task),
 		expectResp:   doneDone,
-		expectNotifs: map[*ws.Conn][]*store.ResourceBox{connBodie: {{
-			Name: "tasks",
-			Contents: map[string]interface{}{
-				"owner":  "bodie",
-				"bounty": float64(5),
-				"readers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
+		expectNotifs: map[*ws.Conn][]*store.ResourceBox{
+			connBodie: {reviewed("accepted", completion), accept},
+			connBob: {reviewed("accepted", completion), accept, {
+				Name: "users",
+				Contents: map[string]interface{}{
+					"name": "bob",
+					"coin": float64(5),
 				},
-				"writers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
-				},
-				"id":   uuid.UUID(got.ID).String(),
-				"name": "V. Important Task",
-				"notes": []interface{}{
-					"hello world",
-					"goodbye world",
-					"something else",
-					"another thing entirely",
-				},
-				"due":         someWhen.Format(time.RFC3339Nano),
-				"completed":   true,
-				"completedBy": "bob",
-				"completedAt": now.UTC().Format(time.RFC3339Nano),
-			},
-		}, {
-			Name: "users",
-			Contents: map[string]interface{}{
-				"name": "bodie",
-				"coin": float64(-5),
-			},
-		}}, connBob: {{
-			Name: "tasks",
-			Contents: map[string]interface{}{
-				"owner":  "bodie",
-				"bounty": float64(5),
-				"readers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
-				},
-				"writers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
-				},
-				"id":   uuid.UUID(got.ID).String(),
-				"name": "V. Important Task",
-				"notes": []interface{}{
-					"hello world",
-					"goodbye world",
-					"something else",
-					"another thing entirely",
-				},
-				"due":         someWhen.Format(time.RFC3339Nano),
-				"completed":   true,
-				"completedBy": "bob",
-				"completedAt": now.UTC().Format(time.RFC3339Nano),
-			},
-		}, {
-			Name: "users",
-			Contents: map[string]interface{}{
-				"name": "bob",
-				"coin": float64(5),
-			},
-		}}},
+			}},
+		},
 	}, {
 		should: "not let anyone update bounty of completed tasks",
 		verb:   "PUT",
@@ -651,73 +793,47 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 		into:         new(task.Task),
 		expectResp:   doneDone,
 	}, {
-		should:       "uncomplete a task with a bounty as expected",
-		verb:         "PUT",
-		path:         "/tasks/" + uuid.UUID(doneGot.ID).String(),
+		should:       "not let bob reopen the task",
+		verb:         "POST",
+		path:         "/tasks/" + uuid.UUID(got.ID).String() + "/reopen",
 		header:       sgt.Bearer(tokens["bob"]),
+		expectStatus: http.StatusUnauthorized,
+		into:         new(string),
+		expectResp:   "Unauthorized\n",
+	}, {
+		should:       "let bodie reopen the task, reclaiming the bounty",
+		verb:         "POST",
+		path:         "/tasks/" + uuid.UUID(got.ID).String() + "/reopen",
+		header:       sgt.Bearer(tokens["bodie"]),
 		expectStatus: http.StatusOK,
-		body:         otherT,
 		into:         new(task.Task),
 		expectResp:   otherT,
-		expectNotifs: map[*ws.Conn][]*store.ResourceBox{connBodie: {{
-			Name: "tasks",
-			Contents: map[string]interface{}{
-				"owner":  "bodie",
-				"bounty": float64(5),
-				"readers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
+		expectNotifs: map[*ws.Conn][]*store.ResourceBox{
+			connBodie: {reviewed("open", nil), reopen},
+			connBob: {reviewed("open", nil), reopen, {
+				Name: "users",
+				Contents: map[string]interface{}{
+					"name": "bob",
+					"coin": float64(0),
 				},
-				"writers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
-				},
-				"id":   uuid.UUID(got.ID).String(),
-				"name": "V. Important Task",
-				"notes": []interface{}{
-					"hello world",
-					"goodbye world",
-					"something else",
-				},
-				"due":       someWhen.Format(time.RFC3339Nano),
-				"completed": false,
-			},
+			}},
+		},
+	}, {
+		should:       "get the history of the task's review",
+		verb:         "GET",
+		path:         "/tasks/" + uuid.UUID(got.ID).String() + "/history",
+		header:       sgt.Bearer(tokens["bob"]),
+		expectStatus: http.StatusOK,
+		into:         new([]*task.Transition),
+		expectResp: &[]*task.Transition{{
+			From: task.Open, To: task.Claimed, By: "bob", Time: now,
 		}, {
-			Name: "users",
-			Contents: map[string]interface{}{
-				"name": "bodie",
-				"coin": float64(0),
-			},
-		}}, connBob: {{
-			Name: "tasks",
-			Contents: map[string]interface{}{
-				"owner":  "bodie",
-				"bounty": float64(5),
-				"readers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
-				},
-				"writers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
-				},
-				"id":   uuid.UUID(got.ID).String(),
-				"name": "V. Important Task",
-				"notes": []interface{}{
-					"hello world",
-					"goodbye world",
-					"something else",
-				},
-				"due":       someWhen.Format(time.RFC3339Nano),
-				"completed": false,
-			},
+			From: task.Claimed, To: task.Submitted, By: "bob", Time: now,
 		}, {
-			Name: "users",
-			Contents: map[string]interface{}{
-				"name": "bob",
-				"coin": float64(0),
-			},
-		}}},
+			From: task.Submitted, To: task.Accepted, By: "bodie", Time: now,
+		}, {
+			From: task.Accepted, To: task.Open, By: "bodie", Time: now,
+		}},
 	}, {
 		should:       "update a task as a writer normally",
 		verb:         "PUT",
@@ -739,7 +855,7 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 			tt.Notes = append(tt.Notes, "boopy doopy")
 			return &tt
 		}(),
-		expectNotifs: map[*ws.Conn][]*store.ResourceBox{connBodie: {{
+		expectNotifs: map[*ws.Conn][]*store.ResourceBox{connBodie: {writerPut, {
 			Name: "tasks",
 			Contents: map[string]interface{}{
 				"owner":  "bodie",
@@ -762,8 +878,9 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 				},
 				"due":       someWhen.Format(time.RFC3339Nano),
 				"completed": false,
+				"state":     "open",
 			},
-		}}, connBob: {{
+		}}, connBob: {writerPut, {
 			Name: "tasks",
 			Contents: map[string]interface{}{
 				"owner":  "bodie",
@@ -786,78 +903,22 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 				},
 				"due":       someWhen.Format(time.RFC3339Nano),
 				"completed": false,
+				"state":     "open",
 			},
 		}}},
 	}, {
-		should:       "complete a task as an owner normally",
+		should:       "not let the owner complete a task with PUT",
 		verb:         "PUT",
 		path:         "/tasks/" + uuid.UUID(doneGot.ID).String(),
 		header:       sgt.Bearer(tokens["bodie"]),
-		expectStatus: http.StatusOK,
+		expectStatus: http.StatusBadRequest,
 		body: func() *task.Task {
 			tt := *otherT
 			tt.Completed = true
 			return &tt
 		}(),
-		into: new(task.Task),
-		expectResp: func() *task.Task {
-			tt := *otherT
-			tt.Completed = true
-			tt.CompletedAt = &now
-			tt.CompletedBy = "bodie"
-			return &tt
-		}(),
-		expectNotifs: map[*ws.Conn][]*store.ResourceBox{connBodie: {{
-			Name: "tasks",
-			Contents: map[string]interface{}{
-				"owner":  "bodie",
-				"bounty": float64(5),
-				"readers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
-				},
-				"writers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
-				},
-				"id":   uuid.UUID(got.ID).String(),
-				"name": "V. Important Task",
-				"notes": []interface{}{
-					"hello world",
-					"goodbye world",
-					"something else",
-				},
-				"due":         someWhen.Format(time.RFC3339Nano),
-				"completed":   true,
-				"completedBy": "bodie",
-				"completedAt": now.Format(time.RFC3339Nano),
-			},
-		}}, connBob: {{
-			Name: "tasks",
-			Contents: map[string]interface{}{
-				"owner":  "bodie",
-				"bounty": float64(5),
-				"readers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
-				},
-				"writers": map[string]interface{}{
-					"bodie": true,
-					"bob":   true,
-				},
-				"id":   uuid.UUID(got.ID).String(),
-				"name": "V. Important Task",
-				"notes": []interface{}{
-					"hello world",
-					"goodbye world",
-					"something else",
-				},
-				"due":         someWhen.Format(time.RFC3339Nano),
-				"completed":   true,
-				"completedBy": "bodie",
-				"completedAt": now.Format(time.RFC3339Nano),
-			},
-		}}},
+		into:       new(string),
+		expectResp: "task can only be completed or reopened by review\n",
 	}, {
 		should:       "return error on bad task ID",
 		verb:         "DELETE",
@@ -906,6 +967,14 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 		expectStatus: http.StatusNotFound,
 		into:         new(string),
 		expectResp:   "no such task\n",
+	}, {
+		should:       "not get the history of deleted tasks",
+		verb:         "GET",
+		path:         "/tasks/" + uuid.UUID(got.ID).String() + "/history",
+		header:       sgt.Bearer(tokens["bodie"]),
+		expectStatus: http.StatusNotFound,
+		into:         new(string),
+		expectResp:   "no such task\n",
 	}} {
 		c.Logf("test %d: %s on %s should %s", i,
 			test.verb, test.path,
@@ -966,3 +1035,314 @@ func (s *RESTSuite) TestTaskBind(c *C) {
 
 	cleanupTaskAPI(c, api)
 }
+
+func (s *RESTSuite) TestTaskEscrow(c *C) {
+	var (
+		r   = htr.New()
+		now = time.Now().UTC()
+		api = &rest.Task{DB: s.db, Timer: sgt.Timer(now)}
+
+		srv, tokens = prepTaskAPI(c, r, api, "bodie", "bob")
+	)
+	defer srv.Close()
+	defer cleanupTaskAPI(c, api)
+
+	c.Assert(s.db.Update(users.Post(&users.Transaction{
+		To: "bodie", Amount: 3, Reason: users.Grant, Time: now,
+	})), IsNil)
+
+	send := func(method, path, user string, body interface{}) *htt.ResponseRecorder {
+		bs, err := json.Marshal(body)
+		c.Assert(err, IsNil)
+		req := htt.NewRequest(method, path, bytes.NewBuffer(bs))
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	coin := func(user string) int64 {
+		u := new(users.User)
+		c.Assert(s.db.View(store.Wrap(
+			users.CheckBalance(user),
+			store.Unmarshal(users.UserBucket, u, []byte(user)),
+		)), IsNil)
+		return u.Coin
+	}
+
+	held := func(id task.ID) int64 {
+		var h int64
+		c.Assert(s.db.View(task.Escrowed(id, &h)), IsNil)
+		return h
+	}
+
+	tsk := &task.Task{
+		Group: users.Group{
+			Owner:   "bodie",
+			Readers: map[string]bool{"bob": true},
+			Writers: map[string]bool{"bob": true},
+		},
+		Name:   "V. Expensive Task",
+		Bounty: 5,
+	}
+
+	c.Log("bodie can't post a bounty he can't afford")
+	w := send("POST", "/tasks", "bodie", tsk)
+	c.Check(w.Code, Equals, http.StatusPaymentRequired)
+	c.Check(w.Body.String(), Equals, "user `bodie` has 3 coin, needs 5\n")
+	c.Check(coin("bodie"), Equals, int64(3))
+
+	c.Log("but he can post one he can afford")
+	tsk.Bounty = 2
+	w = send("POST", "/tasks", "bodie", tsk)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	got := new(task.Task)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), got), IsNil)
+	c.Check(coin("bodie"), Equals, int64(1))
+	c.Check(held(got.ID), Equals, int64(2))
+
+	c.Log("bodie can't make bob pay for it by giving it to him")
+	given := *got
+	given.Owner = "bob"
+	w = send("PUT", "/tasks/"+uuid.UUID(got.ID).String(), "bodie", &given)
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Equals, "cannot change the owner of a task\n")
+	c.Check(coin("bob"), Equals, int64(0))
+	c.Check(held(got.ID), Equals, int64(2))
+
+	c.Log("deleting it refunds the bounty")
+	w = send("DELETE", "/tasks/"+uuid.UUID(got.ID).String(), "bodie", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Check(coin("bodie"), Equals, int64(3))
+	c.Check(held(got.ID), Equals, int64(0))
+
+	c.Log("bob is paid the escrowed bounty when his work is accepted")
+	w = send("POST", "/tasks", "bodie", tsk)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), got), IsNil)
+	path := "/tasks/" + uuid.UUID(got.ID).String()
+	c.Assert(send("POST", path+"/claim", "bob", nil).Code, Equals, http.StatusOK)
+	c.Assert(send("POST", path+"/submit", "bob", nil).Code, Equals, http.StatusOK)
+	c.Check(coin("bob"), Equals, int64(0))
+	c.Check(held(got.ID), Equals, int64(2))
+	c.Assert(send("POST", path+"/accept", "bodie", nil).Code, Equals, http.StatusOK)
+	c.Check(coin("bodie"), Equals, int64(1))
+	c.Check(coin("bob"), Equals, int64(2))
+	c.Check(held(got.ID), Equals, int64(0))
+
+	c.Log("and it goes back into escrow if bodie reopens it")
+	c.Assert(send("POST", path+"/reopen", "bodie", nil).Code, Equals, http.StatusOK)
+	c.Check(coin("bob"), Equals, int64(0))
+	c.Check(held(got.ID), Equals, int64(2))
+
+	c.Log("a task can't be created as completed by someone else")
+	c.Assert(s.db.Update(users.Post(&users.Transaction{
+		To: "bob", Amount: 4, Reason: users.Grant, Time: now,
+	})), IsNil)
+	done := *tsk
+	done.Completed, done.CompletedBy = true, "bob"
+	w = send("POST", "/tasks", "bodie", &done)
+	c.Assert(w.Code, Equals, http.StatusOK, Commentf(w.Body.String()))
+	c.Assert(json.Unmarshal(w.Body.Bytes(), got), IsNil)
+	c.Check(got.CompletedBy, Equals, "")
+	c.Check(got.CompletedAt, IsNil)
+
+	c.Log("so reopening it takes nothing from bob")
+	path = "/tasks/" + uuid.UUID(got.ID).String()
+	c.Assert(send("POST", path+"/reopen", "bodie", nil).Code, Equals, http.StatusOK)
+	c.Check(coin("bob"), Equals, int64(4))
+	c.Check(coin("bodie"), Equals, int64(1))
+	c.Check(held(got.ID), Equals, int64(0))
+}
+
+func (s *RESTSuite) TestTaskLinks(c *C) {
+	var (
+		r   = htr.New()
+		api = &rest.Task{DB: s.db, Timer: sgt.Timer(time.Now())}
+
+		srv, tokens = prepTaskAPI(c, r, api, "bodie", "bob")
+	)
+	defer srv.Close()
+	defer cleanupTaskAPI(c, api)
+
+	seen := observeNotifs()
+	defer seen.Close()
+
+	send := func(method, path, user string, body interface{}) *htt.ResponseRecorder {
+		bs, err := json.Marshal(body)
+		c.Assert(err, IsNil)
+		req := htt.NewRequest(method, path, bytes.NewBuffer(bs))
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	create := func(t *task.Task) *task.Task {
+		w := send("POST", "/tasks", "bodie", t)
+		c.Assert(w.Code, Equals, http.StatusOK)
+		got := new(task.Task)
+		c.Assert(json.Unmarshal(w.Body.Bytes(), got), IsNil)
+		return got
+	}
+	group := users.Group{
+		Owner:   "bodie",
+		Readers: map[string]bool{"bob": true},
+		Writers: map[string]bool{"bob": true},
+	}
+
+	root := create(&task.Task{Group: group, Name: "root"})
+	dep := create(&task.Task{Group: group, Name: "dep"})
+	kid := create(&task.Task{
+		Group:     group,
+		Name:      "kid",
+		Parent:    &root.ID,
+		BlockedBy: []task.ID{dep.ID},
+	})
+
+	c.Log("links must be to tasks which exist")
+	nilID := task.ID(uuid.Nil)
+	w := send("POST", "/tasks", "bodie", &task.Task{
+		Group: group, Parent: &nilID,
+	})
+	c.Check(w.Code, Equals, http.StatusNotFound)
+
+	c.Log("links can't form cycles")
+	root.BlockedBy = []task.ID{kid.ID}
+	root.Parent = &kid.ID
+	w = send("PUT", "/tasks/"+uuid.UUID(root.ID).String(), "bodie", root)
+	c.Check(w.Code, Equals, http.StatusConflict)
+	root.BlockedBy, root.Parent = nil, nil
+
+	c.Log("only the owner can change links")
+	kid.Parent = nil
+	w = send("PUT", "/tasks/"+uuid.UUID(kid.ID).String(), "bob", kid)
+	c.Check(w.Code, Equals, http.StatusUnauthorized)
+	kid.Parent = &root.ID
+
+	c.Log("tasks can be filtered by links")
+	for _, test := range []struct {
+		query  string
+		expect []string
+	}{
+		{"blocked=true", []string{"kid"}},
+		{"ready=true", []string{"dep", "root"}},
+		{"parent=" + uuid.UUID(root.ID).String(), []string{"kid"}},
+	} {
+		w = send("GET", "/tasks?"+test.query, "bob", nil)
+		c.Assert(w.Code, Equals, http.StatusOK)
+		var ts []*task.Task
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &ts), IsNil)
+		var names []string
+		for _, t := range ts {
+			names = append(names, t.Name)
+		}
+		sort.Strings(names)
+		c.Check(names, DeepEquals, test.expect, Commentf(test.query))
+	}
+	c.Check(send("GET", "/tasks?ready=false", "bob", nil).Code,
+		Equals, http.StatusBadRequest)
+
+	c.Log("GET /tasks/:id/subtree returns the whole subtree")
+	w = send("GET", "/tasks/"+uuid.UUID(root.ID).String()+"/subtree", "bob", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	tree := new(task.Tree)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), tree), IsNil)
+	c.Check(tree.Name, Equals, "root")
+	c.Assert(tree.Children, HasLen, 1)
+	c.Check(tree.Children[0].Name, Equals, "kid")
+
+	c.Log("accepting the blocking task notifies bodie of the unblock")
+	depPath := "/tasks/" + uuid.UUID(dep.ID).String()
+	for _, step := range []struct{ path, user string }{
+		{"/claim", "bob"}, {"/submit", "bob"}, {"/accept", "bodie"},
+	} {
+		w = send("POST", depPath+step.path, step.user, nil)
+		c.Assert(w.Code, Equals, http.StatusOK)
+	}
+	u := seen.await("bodie", func(val store.Resourcer) bool {
+		_, ok := val.(task.Unblocked)
+		return ok
+	}, time.Second)
+	c.Assert(u, NotNil, Commentf("timed out waiting for unblock"))
+	c.Check(task.ID(u.(task.Unblocked)), Equals, kid.ID)
+
+	c.Log("deleting a parent unlinks its children")
+	w = send("DELETE", "/tasks/"+uuid.UUID(root.ID).String(), "bodie", nil)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	got := new(task.Task)
+	c.Assert(s.db.View(kid.ID.Load(got)), IsNil)
+	c.Check(got.Parent, IsNil)
+}
+
+func (s *RESTSuite) TestTaskQuery(c *C) {
+	var (
+		r   = htr.New()
+		now = time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+		api = &rest.Task{DB: s.db, Timer: sgt.Timer(now)}
+
+		srv, tokens = prepTaskAPI(c, r, api, "bodie", "bob")
+	)
+	defer srv.Close()
+	defer cleanupTaskAPI(c, api)
+
+	c.Assert(s.db.Update(users.Post(&users.Transaction{
+		To: "bodie", Amount: 10, Reason: users.Grant, Time: now,
+	})), IsNil)
+
+	send := func(method, path, user string, body interface{}) *htt.ResponseRecorder {
+		bs, err := json.Marshal(body)
+		c.Assert(err, IsNil)
+		req := htt.NewRequest(method, path, bytes.NewBuffer(bs))
+		req.Header = sgt.Bearer(tokens[user])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	group := users.Group{
+		Owner:   "bodie",
+		Readers: map[string]bool{"bob": true},
+		Writers: map[string]bool{"bob": true},
+	}
+	soon, later := now.Add(time.Hour), now.Add(48*time.Hour)
+	for _, t := range []*task.Task{
+		{Group: group, Name: "mow", Bounty: 3, Due: &later},
+		{Group: group, Name: "sweep", Bounty: 1, Due: &soon,
+			Notes: []string{"the porch too"}},
+		{Group: group, Name: "nap"},
+	} {
+		c.Assert(send("POST", "/tasks", "bodie", t).Code, Equals, http.StatusOK)
+	}
+
+	for _, test := range []struct {
+		query  string
+		expect []string
+	}{
+		{"", []string{"sweep", "mow", "nap"}},
+		{"sort=-bounty", []string{"mow", "sweep", "nap"}},
+		{"sort=-name", []string{"sweep", "nap", "mow"}},
+		{"q=porch", []string{"sweep"}},
+		{"q=" + url.QueryEscape("bounty>=1 and not due<2017-01-11"),
+			[]string{"mow"}},
+		{"q=" + url.QueryEscape("owner:bodie (nap or bounty=3)") +
+			"&sort=name", []string{"mow", "nap"}},
+	} {
+		w := send("GET", "/tasks?"+test.query, "bob", nil)
+		c.Assert(w.Code, Equals, http.StatusOK, Commentf(test.query))
+		var ts []*task.Task
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &ts), IsNil)
+		names := []string{}
+		for _, t := range ts {
+			names = append(names, t.Name)
+		}
+		c.Check(names, DeepEquals, test.expect, Commentf(test.query))
+	}
+
+	c.Log("bad queries and sorts say what is wrong")
+	w := send("GET", "/tasks?q="+url.QueryEscape("(owner:bob"), "bob", nil)
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Equals, "bad query at position 1: unclosed (\n")
+	w = send("GET", "/tasks?sort=size", "bob", nil)
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Equals,
+		"bad sort at position 1: unknown key \"size\"\n")
+}
@@ -2,12 +2,16 @@ package rest
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/synapse-garden/sg-proto/auth"
 	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
 
 	"github.com/boltdb/bolt"
 	htr "github.com/julienschmidt/httprouter"
@@ -15,7 +19,14 @@ import (
 )
 
 // Token implements API.  It handles creating and deleting login Tokens.
-type Token struct{ *bolt.DB }
+// Besides Logins, users may log in with any of the Authenticators,
+// which are chosen by the "provider" field of the POSTed Credentials.
+type Token struct {
+	*bolt.DB
+	mw.Sessions
+
+	Authenticators []auth.Authenticator
+}
 
 // Bind implements API.Bind on Token.
 func (t Token) Bind(r *htr.Router) error {
@@ -23,28 +34,65 @@ func (t Token) Bind(r *htr.Router) error {
 		return errors.New("Token DB handle must not be nil")
 	}
 	r.POST("/tokens", t.Create)
-	r.DELETE("/tokens", mw.AuthUser(t.Delete, t.DB, mw.CtxSetToken))
+	r.GET("/tokens/:provider", t.GetAuthURL)
+	r.DELETE("/tokens", t.AuthUser(t.Delete, t.DB, mw.CtxSetToken))
 
 	return nil
 }
 
 func (t Token) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to read request").Error(),
+			http.StatusBadRequest)
+		return
+	}
+
+	cookie := r.URL.Query().Get("cookie") == "true"
+	if cookie && !t.Cookies {
+		http.Error(w, "cookie sessions are not enabled", http.StatusBadRequest)
+		return
+	}
+
+	// If a provider was given, it's not a Login.
+	creds := new(auth.Credentials)
+	if json.Unmarshal(body, creds) == nil && creds.Provider != "" {
+		t.createExternal(w, r, creds, cookie)
+		return
+	}
+
 	// Unmarshal the Login from the Body
 	l := new(auth.Login)
-	if err := json.NewDecoder(r.Body).Decode(l); err != nil {
+	if err := json.Unmarshal(body, l); err != nil {
 		http.Error(w, errors.Wrap(
 			err, "failed to decode Login").Error(),
 			http.StatusBadRequest)
 		return
 	}
 
+	var (
+		now  = time.Now()
+		keys = []auth.AttemptKey{
+			auth.UserAttempts(l.Name),
+			auth.AddrAttempts(mw.ClientAddr(r)),
+		}
+	)
+
+	if !t.beginAttempt(w, now, keys) {
+		return
+	}
+
 	if err := t.View(auth.Check(l)); err != nil {
 		switch err.(type) {
 		case auth.ErrInvalid, auth.ErrMissing:
+			// The failure was already counted.
 			http.Error(w, err.Error(), http.StatusNotFound)
 		case auth.ErrDisabled:
+			forgiveAttempt(t.DB, keys...)
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 		default:
+			forgiveAttempt(t.DB, keys...)
 			http.Error(w, errors.Wrap(
 				err, "failed to compare logins",
 			).Error(), http.StatusInternalServerError)
@@ -52,15 +100,185 @@ func (t Token) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		return
 	}
 
+	// Forget the user's failures, and this attempt of the address.
+	t.createSession(w, now, l.Name, cookie, store.Wrap(
+		auth.ClearAttempts(keys[0]),
+		auth.ForgiveAttempt(keys[1]),
+	))
+}
+
+// createExternal logs in using the Authenticator for the Credentials'
+// provider.  The User is provisioned on first login.
+func (t Token) createExternal(
+	w http.ResponseWriter,
+	r *http.Request,
+	creds *auth.Credentials,
+	cookie bool,
+) {
+	a := t.authenticator(creds.Provider)
+	if a == nil {
+		http.Error(w, auth.ErrUnknownProvider(
+			creds.Provider,
+		).Error(), http.StatusBadRequest)
+		return
+	}
+
+	// A redirected login must come back to the client which began it.
+	if _, ok := a.(auth.Redirecter); ok {
+		if err := mw.CheckLoginState(w, r, creds.State); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	now := time.Now()
+	keys := []auth.AttemptKey{auth.AddrAttempts(mw.ClientAddr(r))}
+	if creds.Name != "" {
+		keys = append(keys, auth.UserAttempts(creds.Name))
+	}
+
+	if !t.beginAttempt(w, now, keys) {
+		return
+	}
+
+	id, err := a.Authenticate(creds)
+	switch err.(type) {
+	case nil:
+	case auth.ErrInvalid:
+		// The failure was already counted.
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	default:
+		forgiveAttempt(t.DB, keys...)
+		log.Printf("%s login failed: %#v", a.Name(), err)
+		http.Error(w, errors.Wrapf(
+			err, "failed to authenticate with %#q", a.Name(),
+		).Error(), http.StatusBadGateway)
+		return
+	}
+
+	var userID string
+	err = t.Update(auth.Provision(id, now, &userID))
+	if err != nil {
+		forgiveAttempt(t.DB, keys...)
+	}
+	switch {
+	case users.IsExists(err):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case auth.IsDisabled(err):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, errors.Wrap(
+			err, "failed to provision user",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Forget the user's failures, and this attempt of the address.
+	t.createSession(w, now, userID, cookie, store.Wrap(
+		auth.ClearAttempts(keys[1:]...),
+		auth.ForgiveAttempt(keys[0]),
+	))
+}
+
+// GetAuthURL returns the URL to send a user to in order to log in using
+// the named provider, if it is an auth.Redirecter.  The login's state is
+// set in a cookie, which must be sent back with it.
+func (t Token) GetAuthURL(w http.ResponseWriter, r *http.Request, ps htr.Params) {
+	provider := ps.ByName("provider")
+	red, ok := t.authenticator(provider).(auth.Redirecter)
+	if !ok {
+		http.Error(w, auth.ErrUnknownProvider(
+			provider,
+		).Error(), http.StatusNotFound)
+		return
+	}
+
+	u, state, err := red.AuthURL()
+	if err != nil {
+		http.Error(w, errors.Wrapf(
+			err, "failed to get %#q login URL", provider,
+		).Error(), http.StatusBadGateway)
+		return
+	}
+
+	mw.SetLoginStateCookie(w, state)
+	json.NewEncoder(w).Encode(u)
+}
+
+func (t Token) authenticator(provider string) auth.Authenticator {
+	for _, a := range t.Authenticators {
+		if a.Name() == provider {
+			return a
+		}
+	}
+	return nil
+}
+
+// beginAttempt counts an attempt for the given keys, as in
+// auth.BeginAttempt.  It writes an error response and returns false if
+// any of them is locked out.
+func (t Token) beginAttempt(
+	w http.ResponseWriter,
+	now time.Time,
+	keys []auth.AttemptKey,
+) bool {
+	err := t.Update(auth.BeginAttempt(now, keys...))
+	switch e := err.(type) {
+	case nil:
+		return true
+	case auth.ErrLockedOut:
+		lockedOut(w, e, now)
+	default:
+		http.Error(w, errors.Wrap(
+			err, "failed to check login attempts",
+		).Error(), http.StatusInternalServerError)
+	}
+	return false
+}
+
+// createSession creates and writes a new Session for the given user,
+// applying the given mutation in the same transaction.  If cookie is
+// set, the tokens are only sent in HttpOnly cookies, and the CSRF token
+// is sent in mw.CSRFHeader.
+func (t Token) createSession(
+	w http.ResponseWriter,
+	now time.Time,
+	userID string,
+	cookie bool,
+	also store.Mutation,
+) {
 	sesh := &auth.Session{}
-	if err := t.Update(auth.NewSession(
+	newSession := auth.NewSession(
 		sesh,
-		time.Now().Add(auth.Expiration),
+		now.Add(auth.Expiration),
 		auth.Expiration,
 		auth.NewToken(auth.BearerType),
 		auth.NewToken(auth.RefreshType),
-		l.Name,
-	)); err != nil {
+		userID,
+	)
+	if keys := t.Keys; keys != nil {
+		// The KeyRing only takes a new key once it is stored, so
+		// rotate before signing the new session's token.
+		if err := t.Update(keys.Rotate(now)); err != nil {
+			http.Error(w, errors.Wrap(
+				err, "failed to rotate signing key",
+			).Error(), http.StatusInternalServerError)
+			return
+		}
+		newSession = auth.NewSignedSession(
+			keys,
+			sesh,
+			now.Add(auth.Expiration),
+			auth.Expiration,
+			auth.NewToken(auth.RefreshType),
+			userID,
+		)
+	}
+
+	if err := t.Update(store.Wrap(also, newSession)); err != nil {
 		http.Error(w, errors.Wrap(
 			err, "failed to create new session",
 		).Error(), http.StatusInternalServerError)
@@ -68,6 +286,24 @@ func (t Token) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 		return
 	}
 
+	if cookie {
+		csrf, err := mw.SetSessionCookies(w, sesh)
+		if err != nil {
+			http.Error(w, errors.Wrap(
+				err, "failed to create new session",
+			).Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set(string(mw.CSRFHeader), csrf)
+
+		// Keep the tokens away from scripts.
+		sesh = &auth.Session{
+			ExpiresIn:  sesh.ExpiresIn,
+			Expiration: sesh.Expiration,
+			TokenType:  sesh.TokenType,
+		}
+	}
+
 	if err := json.NewEncoder(w).Encode(sesh); err != nil {
 		log.Printf("failed to create new session %#v: %#v", sesh, err)
 		http.Error(w, errors.Wrap(
@@ -81,6 +317,34 @@ func (t Token) Create(w http.ResponseWriter, r *http.Request, _ htr.Params) {
 func (t Token) Delete(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 	token := mw.CtxGetToken(r)
 
+	if _, err := r.Cookie(mw.SessionCookie); err == nil && t.Cookies {
+		// The browser should forget the session even if it was
+		// already gone.
+		mw.ClearSessionCookies(w)
+	}
+
+	if keys := t.Keys; keys != nil && auth.IsSigned(token) {
+		// The middleware already verified the token.  Revoke its
+		// session until none of its tokens could still be valid.
+		now := time.Now()
+		claims, err := keys.Verify(token, now)
+		if err != nil {
+			http.Error(w, "invalid session token", http.StatusNotFound)
+			return
+		}
+
+		err = t.Update(keys.Revoke(
+			claims.SessionID,
+			now.Add(auth.Expiration),
+		))
+		if err != nil {
+			http.Error(w, errors.Wrap(
+				err, "failed to revoke session",
+			).Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
 	// len == 0 case handled by other handler
 	if err := t.View(auth.CheckToken(token)); err != nil {
 		var code int
@@ -112,3 +376,28 @@ func (t Token) Delete(w http.ResponseWriter, r *http.Request, ps htr.Params) {
 		return
 	}
 }
+
+// revokeSigned returns a store.Mutation which revokes all of the given
+// user's signed sessions, if stateless sessions are enabled.
+func revokeSigned(keys *auth.KeyRing, userID string) store.Mutation {
+	if keys == nil {
+		return func(*bolt.Tx) error { return nil }
+	}
+	return keys.RevokeUser(userID, time.Now().Add(auth.Expiration))
+}
+
+// lockedOut writes a 429 Too Many Requests response for the given
+// ErrLockedOut, telling the client when it may try again.
+// forgiveAttempt undoes an attempt counted for the given keys which did
+// not fail because of the credentials given, as in auth.ForgiveAttempt.
+func forgiveAttempt(db *bolt.DB, keys ...auth.AttemptKey) {
+	if err := db.Update(auth.ForgiveAttempt(keys...)); err != nil {
+		log.Printf("failed to forgive attempt: %#v", err)
+	}
+}
+
+func lockedOut(w http.ResponseWriter, e auth.ErrLockedOut, now time.Time) {
+	wait := int(e.Until.Sub(now)/time.Second) + 1
+	w.Header().Set("Retry-After", strconv.Itoa(wait))
+	http.Error(w, e.Error(), http.StatusTooManyRequests)
+}
@@ -1,10 +1,21 @@
 package rest_test
 
 import (
-	// "github.com/synapse-garden/sg-proto/incept"
-	// "github.com/synapse-garden/sg-proto/users"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"sync"
 
-	// "github.com/boltdb/bolt"
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/auth/ldap"
+	"github.com/synapse-garden/sg-proto/auth/oidc"
+	"github.com/synapse-garden/sg-proto/rest"
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	htr "github.com/julienschmidt/httprouter"
 	. "gopkg.in/check.v1"
 )
 
@@ -30,3 +41,354 @@ func (s *RESTSuite) TestToken(c *C) {
 	// 	r := htt.NewRecorder()
 	// }
 }
+
+func (s *RESTSuite) TestTokenLockout(c *C) {
+	_, err := sgt.MakeLogin("bob", "some-password", s.db)
+	c.Assert(err, IsNil)
+
+	r := htr.New()
+	c.Assert(rest.Token{DB: s.db}.Bind(r), IsNil)
+
+	bad := &auth.Login{
+		User:   users.User{Name: "bob"},
+		PWHash: sgt.Sha256("wrong-password"),
+	}
+	good := &auth.Login{
+		User:   users.User{Name: "bob"},
+		PWHash: sgt.Sha256("some-password"),
+	}
+
+	c.Log("failed logins are rejected until the lockout")
+	for i := 0; i <= auth.FreeAttempts; i++ {
+		c.Assert(sgt.ExpectResponse(r,
+			"/tokens", "POST",
+			bad, new(string), "invalid login for user `bob`\n",
+			http.StatusNotFound,
+			nil,
+		), IsNil)
+	}
+
+	c.Log("then even correct logins are rejected while locked out")
+	req := htt.NewRequest("POST", "/tokens", loginBody(c, good))
+	w := htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Check(w.Code, Equals, http.StatusTooManyRequests)
+	c.Check(w.Header().Get("Retry-After"), Not(Equals), "")
+
+	c.Log("once the lockout is cleared, the user can log in")
+	c.Assert(s.db.Update(auth.ClearAttempts(
+		auth.UserAttempts("bob"),
+		auth.AddrAttempts("192.0.2.1"),
+	)), IsNil)
+	req = htt.NewRequest("POST", "/tokens", loginBody(c, good))
+	w = htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Check(w.Code, Equals, http.StatusOK)
+
+	c.Log("and a successful login resets the user's failures")
+	all := auth.Attempts{}
+	c.Assert(s.db.View(all.GetAll), IsNil)
+	c.Check(all, HasLen, 0)
+
+	c.Log("concurrent guesses can't get past the lockout")
+	var (
+		wg    sync.WaitGroup
+		codes = make(chan int, 4*auth.FreeAttempts)
+	)
+	for i := 0; i < cap(codes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := htt.NewRequest("POST", "/tokens", loginBody(c, bad))
+			w := htt.NewRecorder()
+			r.ServeHTTP(w, req)
+			codes <- w.Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+	guessed := 0
+	for code := range codes {
+		if code == http.StatusNotFound {
+			guessed++
+		}
+	}
+	c.Check(guessed, Equals, auth.FreeAttempts+1)
+}
+
+func (s *RESTSuite) TestTokenStateless(c *C) {
+	keys := auth.NewKeyRing()
+	sessions := mw.Sessions{Keys: keys}
+
+	_, err := sgt.MakeLogin("bob", "some-password", s.db)
+	c.Assert(err, IsNil)
+
+	r := htr.New()
+	c.Assert(rest.Token{DB: s.db, Sessions: sessions}.Bind(r), IsNil)
+	c.Assert(rest.Profile{DB: s.db, Sessions: sessions}.Bind(r), IsNil)
+
+	c.Log("POST /tokens returns a signed token")
+	sess := new(auth.Session)
+	req := htt.NewRequest("POST", "/tokens", loginBody(c, &auth.Login{
+		User:   users.User{Name: "bob"},
+		PWHash: sgt.Sha256("some-password"),
+	}))
+	w := htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), sess), IsNil)
+	c.Check(auth.IsSigned(sess.Token), Equals, true)
+
+	c.Log("it is not stored as a session")
+	c.Check(s.db.View(auth.CheckToken(sess.Token)), NotNil)
+
+	c.Log("but it is accepted")
+	c.Assert(sgt.ExpectResponse(r,
+		"/profile", "GET",
+		nil, new(users.User), &users.User{Name: "bob"},
+		http.StatusOK,
+		sgt.Bearer(sess.Token),
+	), IsNil)
+
+	c.Log("DELETE /tokens revokes it")
+	req = htt.NewRequest("DELETE", "/tokens", nil)
+	req.Header.Set("Authorization", "Bearer "+sess.Token.String())
+	w = htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Check(w.Code, Equals, http.StatusOK)
+
+	c.Assert(sgt.ExpectResponse(r,
+		"/profile", "GET",
+		nil, new(string), "invalid session token\n",
+		http.StatusUnauthorized,
+		sgt.Bearer(sess.Token),
+	), IsNil)
+}
+
+func loginBody(c *C, l *auth.Login) *bytes.Buffer {
+	bs, err := json.Marshal(l)
+	c.Assert(err, IsNil)
+	return bytes.NewBuffer(bs)
+}
+
+func (s *RESTSuite) TestTokenExternal(c *C) {
+	dir, err := sgt.NewLDAPServer(map[string]string{
+		"uid=bob,dc=example": "hunter2",
+	})
+	c.Assert(err, IsNil)
+	defer dir.Close()
+
+	op := sgt.NewOIDCProvider("sg", "secret")
+	defer op.Close()
+
+	r := htr.New()
+	c.Assert(rest.Token{
+		DB: s.db,
+		Authenticators: []auth.Authenticator{
+			&ldap.Authenticator{
+				Addr:       dir.Addr(),
+				DNTemplate: "uid=%s,dc=example",
+			},
+			&oidc.Authenticator{
+				Issuer:       op.Issuer(),
+				ClientID:     "sg",
+				ClientSecret: "secret",
+				RedirectURL:  "https://sg.example.com/login",
+			},
+		},
+	}.Bind(r), IsNil)
+	c.Assert(rest.Profile{DB: s.db}.Bind(r), IsNil)
+
+	c.Log("an unknown provider is rejected")
+	c.Assert(sgt.ExpectResponse(r,
+		"/tokens", "POST",
+		&auth.Credentials{Provider: "kerberos"},
+		new(string), "unknown login provider `kerberos`\n",
+		http.StatusBadRequest,
+		nil,
+	), IsNil)
+
+	c.Log("a wrong LDAP password is rejected")
+	c.Assert(sgt.ExpectResponse(r,
+		"/tokens", "POST",
+		&auth.Credentials{
+			Provider: "ldap",
+			Name:     "bob",
+			Password: "hunter3",
+		},
+		new(string), "invalid login for user `bob`\n",
+		http.StatusNotFound,
+		nil,
+	), IsNil)
+
+	c.Log("an LDAP login provisions the user")
+	sess := new(auth.Session)
+	req := htt.NewRequest("POST", "/tokens", bytes.NewBufferString(
+		`{"provider":"ldap","name":"bob","password":"hunter2"}`,
+	))
+	w := htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), sess), IsNil)
+	c.Assert(sgt.ExpectResponse(r,
+		"/profile", "GET",
+		nil, new(users.User), &users.User{Name: "bob"},
+		http.StatusOK,
+		sgt.Bearer(sess.Token),
+	), IsNil)
+
+	c.Log("only redirecting providers have login URLs")
+	c.Assert(sgt.ExpectResponse(r,
+		"/tokens/ldap", "GET",
+		nil, new(string), "unknown login provider `ldap`\n",
+		http.StatusNotFound,
+		nil,
+	), IsNil)
+
+	// beginOIDC gets a login URL, logs in at the provider as the given
+	// username, and returns the Credentials to POST with the state
+	// cookie.
+	beginOIDC := func(name string) (*auth.Credentials, *http.Cookie) {
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, htt.NewRequest("GET", "/tokens/oidc", nil))
+		c.Assert(w.Code, Equals, http.StatusOK)
+		var authURL string
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &authURL), IsNil)
+
+		var cookie *http.Cookie
+		for _, ck := range w.Result().Cookies() {
+			if ck.Name == mw.LoginStateCookie {
+				cookie = ck
+			}
+		}
+		c.Assert(cookie, NotNil)
+
+		code, state, err := op.Authorize(authURL, "some-subject",
+			map[string]interface{}{"preferred_username": name},
+		)
+		c.Assert(err, IsNil)
+		c.Assert(cookie.Value, Equals, state)
+		return &auth.Credentials{
+			Provider: "oidc",
+			Code:     code,
+			State:    state,
+		}, cookie
+	}
+	postOIDC := func(creds *auth.Credentials, cookie *http.Cookie) *htt.ResponseRecorder {
+		bs, err := json.Marshal(creds)
+		c.Assert(err, IsNil)
+		req := htt.NewRequest("POST", "/tokens", bytes.NewBuffer(bs))
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	c.Log("an OIDC login must come with its state cookie")
+	creds, _ := beginOIDC("bodie")
+	w = postOIDC(creds, nil)
+	c.Check(w.Code, Equals, http.StatusForbidden)
+	c.Check(w.Body.String(), Equals,
+		"CSRF check failed: no login state cookie\n")
+
+	c.Log("an OIDC login's state must match its cookie")
+	creds, _ = beginOIDC("bodie")
+	_, other := beginOIDC("bodie")
+	w = postOIDC(creds, other)
+	c.Check(w.Code, Equals, http.StatusForbidden)
+	c.Check(w.Body.String(), Equals,
+		"CSRF check failed: login state does not match cookie\n")
+
+	c.Log("an OIDC user cannot take over an existing user")
+	w = postOIDC(beginOIDC("bob"))
+	c.Check(w.Code, Equals, http.StatusConflict)
+	c.Check(w.Body.String(), Equals, "user `bob` already exists\n")
+
+	c.Log("an OIDC login provisions the user")
+	w = postOIDC(beginOIDC("bodie"))
+	c.Assert(w.Code, Equals, http.StatusOK)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), sess), IsNil)
+	c.Assert(sgt.ExpectResponse(r,
+		"/profile", "GET",
+		nil, new(users.User), &users.User{Name: "bodie"},
+		http.StatusOK,
+		sgt.Bearer(sess.Token),
+	), IsNil)
+}
+
+func (s *RESTSuite) TestTokenCookies(c *C) {
+	_, err := sgt.MakeLogin("bob", "some-password", s.db)
+	c.Assert(err, IsNil)
+
+	r := htr.New()
+	c.Assert(rest.Token{DB: s.db}.Bind(r), IsNil)
+	c.Assert(rest.Profile{DB: s.db}.Bind(r), IsNil)
+
+	good := &auth.Login{
+		User:   users.User{Name: "bob"},
+		PWHash: sgt.Sha256("some-password"),
+	}
+
+	c.Log("cookie sessions must be enabled")
+	req := htt.NewRequest("POST", "/tokens?cookie=true", loginBody(c, good))
+	w := htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Check(w.Code, Equals, http.StatusBadRequest)
+	c.Check(w.Body.String(), Equals, "cookie sessions are not enabled\n")
+
+	sessions := mw.Sessions{Cookies: true}
+	r = htr.New()
+	c.Assert(rest.Token{DB: s.db, Sessions: sessions}.Bind(r), IsNil)
+	c.Assert(rest.Profile{DB: s.db, Sessions: sessions}.Bind(r), IsNil)
+
+	c.Log("POST /tokens?cookie=true only sends tokens in cookies")
+	req = htt.NewRequest("POST", "/tokens?cookie=true", loginBody(c, good))
+	w = htt.NewRecorder()
+	r.ServeHTTP(w, req)
+	c.Assert(w.Code, Equals, http.StatusOK)
+	sess := new(auth.Session)
+	c.Assert(json.Unmarshal(w.Body.Bytes(), sess), IsNil)
+	c.Check(sess.Token, IsNil)
+	c.Check(sess.RefreshToken, IsNil)
+	c.Check(sess.TokenType, Equals, auth.BearerType)
+	csrf := w.Header().Get(string(mw.CSRFHeader))
+	c.Assert(csrf, Not(Equals), "")
+	cookies := w.Result().Cookies()
+	c.Assert(cookies, HasLen, 3)
+
+	send := func(method, path string, hdr http.Header) *htt.ResponseRecorder {
+		req := htt.NewRequest(method, path, nil)
+		for k, vs := range hdr {
+			req.Header.Set(k, vs[0])
+		}
+		for _, ck := range cookies {
+			req.AddCookie(ck)
+		}
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	c.Log("the cookies authorize requests")
+	w = send("GET", "/profile", nil)
+	c.Check(w.Code, Equals, http.StatusOK)
+	c.Check(w.Body.String(), Equals, `{"name":"bob","coin":0}`+"\n")
+
+	c.Log("logging out needs the CSRF token")
+	w = send("DELETE", "/tokens", nil)
+	c.Check(w.Code, Equals, http.StatusForbidden)
+
+	w = send("DELETE", "/tokens", http.Header{
+		string(mw.CSRFHeader): {csrf},
+	})
+	c.Check(w.Code, Equals, http.StatusOK)
+	for _, ck := range w.Result().Cookies() {
+		c.Check(ck.MaxAge, Equals, -1)
+	}
+
+	c.Log("then the session cookie no longer works")
+	w = send("GET", "/profile", nil)
+	c.Check(w.Code, Equals, http.StatusUnauthorized)
+}
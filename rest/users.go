@@ -0,0 +1,104 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	mw "github.com/synapse-garden/sg-proto/rest/middleware"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	htr "github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+)
+
+// Page sizes for paginated endpoints.
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
+)
+
+// Users implements API.  It lets users find each other.
+type Users struct {
+	*bolt.DB
+	mw.Sessions
+}
+
+// Bind implements API.Bind on Users.
+func (u Users) Bind(r *htr.Router) error {
+	if u.DB == nil {
+		return errors.New("Users DB handle must not be nil")
+	}
+
+	r.GET("/users", u.AuthUser(u.Search, u.DB, mw.CtxSetUserID))
+
+	return nil
+}
+
+// pagination parses the "page" and "per_page" parameters of the
+// request.  Pages start at 0.
+func pagination(r *http.Request) (page, perPage int, err error) {
+	perPage = DefaultPerPage
+	for _, p := range []struct {
+		name string
+		into *int
+	}{{"page", &page}, {"per_page", &perPage}} {
+		str := r.FormValue(p.name)
+		if str == "" {
+			continue
+		}
+		if *p.into, err = strconv.Atoi(str); err != nil {
+			return 0, 0, errors.Errorf("invalid %#q value %#q", p.name, str)
+		}
+	}
+
+	switch {
+	case page < 0:
+		return 0, 0, errors.New(`"page" must not be negative`)
+	case perPage < 1 || perPage > MaxPerPage:
+		return 0, 0, errors.Errorf(`"per_page" must be between 1 and %d`,
+			MaxPerPage)
+	}
+	return page, perPage, nil
+}
+
+// Search is a Handle which returns the users.Public profiles of users
+// matching the "q" parameter, best first, paginated by the "page" and
+// "per_page" parameters.  The X-Total-Count header is set to the total
+// number of matches.  Users who set Unlisted are never returned.
+func (u Users) Search(w http.ResponseWriter, r *http.Request, _ htr.Params) {
+	page, perPage, err := pagination(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	q := r.FormValue("q")
+	if err := users.ValidateQuery(q); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var found []*users.Public
+	if err := u.View(users.Search(q, &found)); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to search users",
+		).Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("X-Total-Count", fmt.Sprint(len(found)))
+	result := []*users.Public{}
+	if page < (len(found)+perPage-1)/perPage {
+		start := page * perPage
+		result = found[start:min(start+perPage, len(found))]
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, errors.Wrap(
+			err, "failed to write users",
+		).Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,122 @@
+package rest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"strings"
+
+	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/rest"
+	sgt "github.com/synapse-garden/sg-proto/testing"
+	"github.com/synapse-garden/sg-proto/users"
+
+	htr "github.com/julienschmidt/httprouter"
+	. "gopkg.in/check.v1"
+)
+
+func (s *RESTSuite) TestUsersBind(c *C) {
+	r := htr.New()
+	c.Check(rest.Users{}.Bind(r), ErrorMatches, ".*not be nil")
+	c.Check(rest.Users{DB: s.db}.Bind(r), IsNil)
+}
+
+func (s *RESTSuite) TestUsersSearch(c *C) {
+	r := htr.New()
+	c.Assert(rest.Users{DB: s.db}.Bind(r), IsNil)
+
+	tokens := make(map[string]auth.Token)
+	for _, user := range []string{"bob", "bobby", "bodie", "alice", "jim"} {
+		_, err := sgt.MakeLogin(user, "some-password", s.db)
+		c.Assert(err, IsNil)
+		sesh := new(auth.Session)
+		c.Assert(sgt.GetSession(user, sesh, s.db), IsNil)
+		tokens[user] = sesh.Token
+	}
+	c.Assert(s.db.Update(users.AddCoin(&users.User{Name: "bob"}, 5)), IsNil)
+	c.Assert(s.db.Update(users.SetProfile(&users.User{Name: "alice"},
+		users.Profile{DisplayName: "Bobo Peep"},
+	)), IsNil)
+	c.Assert(s.db.Update(users.SetProfile(&users.User{Name: "bodie"},
+		users.Profile{Unlisted: true},
+	)), IsNil)
+
+	search := func(query string) *htt.ResponseRecorder {
+		req := htt.NewRequest("GET", "/users"+query, nil)
+		req.Header = sgt.Bearer(tokens["jim"])
+		w := htt.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, test := range []struct {
+		should       string
+		query        string
+		expectStatus int
+		expectTotal  string
+		expectNames  []string
+		expectErr    string
+	}{{
+		should:       "list every listed user",
+		expectStatus: http.StatusOK,
+		expectTotal:  "4",
+		expectNames:  []string{"alice", "bob", "bobby", "jim"},
+	}, {
+		should:       "find by prefix and display name, but not unlisted users",
+		query:        "?q=bo",
+		expectStatus: http.StatusOK,
+		expectTotal:  "3",
+		expectNames:  []string{"bob", "bobby", "alice"},
+	}, {
+		should:       "find with a typo",
+		query:        "?q=bobbi",
+		expectStatus: http.StatusOK,
+		expectTotal:  "1",
+		expectNames:  []string{"bobby"},
+	}, {
+		should:       "paginate",
+		query:        "?q=bo&page=1&per_page=2",
+		expectStatus: http.StatusOK,
+		expectTotal:  "3",
+		expectNames:  []string{"alice"},
+	}, {
+		should:       "return an empty page past the end",
+		query:        "?q=bo&page=9",
+		expectStatus: http.StatusOK,
+		expectTotal:  "3",
+		expectNames:  []string{},
+	}, {
+		should:       "reject a bad page size",
+		query:        "?per_page=101",
+		expectStatus: http.StatusBadRequest,
+		expectErr:    `"per_page" must be between 1 and 100` + "\n",
+	}, {
+		should:       "reject a bad page",
+		query:        "?page=x",
+		expectStatus: http.StatusBadRequest,
+		expectErr:    "invalid `page` value `x`\n",
+	}, {
+		should:       "reject a long query",
+		query:        "?q=" + strings.Repeat("x", users.MaxQuery+1),
+		expectStatus: http.StatusBadRequest,
+		expectErr:    "query must be at most 64 characters\n",
+	}} {
+		c.Logf("test %d: GET /users%s should %s", i, test.query, test.should)
+		w := search(test.query)
+		c.Assert(w.Code, Equals, test.expectStatus)
+		if test.expectErr != "" {
+			c.Check(w.Body.String(), Equals, test.expectErr)
+			continue
+		}
+
+		c.Check(w.Header().Get("X-Total-Count"), Equals, test.expectTotal)
+		var found []map[string]interface{}
+		c.Assert(json.Unmarshal(w.Body.Bytes(), &found), IsNil)
+		names := []string{}
+		for _, p := range found {
+			c.Check(p["coin"], IsNil)
+			names = append(names, p["name"].(string))
+		}
+		c.Check(names, DeepEquals, test.expectNames)
+	}
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"crypto/tls"
 	"encoding/base64"
 	"flag"
 	"log"
+	"net"
+	"net/smtp"
+	"time"
 
 	"github.com/synapse-garden/sg-proto/auth"
+	"github.com/synapse-garden/sg-proto/auth/ldap"
+	"github.com/synapse-garden/sg-proto/auth/oidc"
+	"github.com/synapse-garden/sg-proto/mail"
+	"github.com/synapse-garden/sg-proto/notif"
+	"github.com/synapse-garden/sg-proto/presence"
 	"github.com/synapse-garden/sg-proto/rest"
 	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/util"
 
 	"github.com/boltdb/bolt"
 	uuid "github.com/satori/go.uuid"
@@ -28,8 +38,31 @@ var (
 		"where the source is hosted",
 	)
 
-	DevMode  = flag.Bool("dev", false, "start in developer mode")
-	RegenKey = flag.Bool("regen-key", false, "re-create the admin key")
+	DevMode   = flag.Bool("dev", false, "start in developer mode")
+	RegenKey  = flag.Bool("regen-key", false, "re-create the root superadmin key")
+	Stateless = flag.Bool("stateless", false, "issue signed session tokens")
+	Cookies   = flag.Bool("cookies", false, "allow browser sessions in cookies")
+
+	InvitePrice = flag.Int64("invite-price", 0, "the coin a user pays to invite someone")
+
+	SMTPAddr     = flag.String("smtp-addr", "", "the SMTP relay to send mail with, if any")
+	SMTPUser     = flag.String("smtp-user", "", "the SMTP relay username, if it needs one")
+	SMTPPassword = flag.String("smtp-password", "", "the SMTP relay password")
+	MailFrom     = flag.String("mail-from", "", "the address to send mail from")
+	MailBaseURL  = flag.String("mail-base-url", "", "the URL which links in mail are relative to")
+	MailDigest   = flag.Duration("mail-digest", 0, "how often to mail notif digests, if at all")
+
+	IdleAfter = flag.Duration("idle-after", presence.DefaultIdle, "how long a connected user may do nothing before they are idle")
+	TaskEvery = flag.Duration("task-every", time.Minute, "how often to renew recurring tasks and send due reminders")
+
+	LDAPAddr       = flag.String("ldap-addr", "", "the LDAP server to log users in with, if any")
+	LDAPDNTemplate = flag.String("ldap-dn", "uid=%s,ou=people", "the LDAP bind DN, with %s for the username")
+	LDAPTLS        = flag.Bool("ldap-tls", true, "connect to the LDAP server using LDAPS")
+
+	OIDCIssuer       = flag.String("oidc-issuer", "", "the OpenID Connect issuer to log users in with, if any")
+	OIDCClientID     = flag.String("oidc-client-id", "", "the OpenID Connect client ID")
+	OIDCClientSecret = flag.String("oidc-client-secret", "", "the OpenID Connect client secret")
+	OIDCRedirectURL  = flag.String("oidc-redirect", "", "where the OpenID Connect provider sends users back to")
 )
 
 // Source constants
@@ -41,11 +74,23 @@ const (
 func main() {
 	flag.Parse()
 
-	db, err := bolt.Open(*DBAddr, 0600, nil)
+	db, err := bolt.Open(*DBAddr, 0600, &bolt.Options{
+		Timeout: time.Second,
+	})
 	if err != nil {
 		log.Fatalf("unable to open Bolt database: %s", err.Error())
 	}
 
+	switch flag.Arg(0) {
+	case "":
+	case "tickets":
+		defer db.Close()
+		tickets(db, flag.Args()[1:])
+		return
+	default:
+		log.Fatalf("unknown command %#q", flag.Arg(0))
+	}
+
 	source := rest.SourceInfo{
 		Version:    store.VerCurrent,
 		Location:   *SourceLocation,
@@ -53,6 +98,43 @@ func main() {
 		LicensedTo: Licensee,
 	}
 
+	cfg := rest.Config{
+		Stateless: *Stateless,
+		Cookies:   *Cookies,
+
+		InvitePrice: *InvitePrice,
+	}
+	if *LDAPAddr != "" {
+		a := &ldap.Authenticator{
+			Addr:       *LDAPAddr,
+			DNTemplate: *LDAPDNTemplate,
+		}
+		if *LDAPTLS {
+			host, _, err := net.SplitHostPort(*LDAPAddr)
+			if err != nil {
+				log.Fatalf("invalid -ldap-addr: %s", err.Error())
+			}
+			a.TLS = &tls.Config{ServerName: host}
+		}
+		cfg.Authenticators = append(cfg.Authenticators, a)
+	}
+	if *OIDCIssuer != "" {
+		cfg.Authenticators = append(cfg.Authenticators, &oidc.Authenticator{
+			Issuer:       *OIDCIssuer,
+			ClientID:     *OIDCClientID,
+			ClientSecret: *OIDCClientSecret,
+			RedirectURL:  *OIDCRedirectURL,
+		})
+	}
+
+	if *SMTPAddr != "" {
+		cfg.Mail = startMail(db)
+	}
+
+	cfg.Presence = presence.NewTracker(util.SimpleTimer{}, *IdleAfter)
+	go cfg.Presence.Run(time.Minute, nil)
+	cfg.TaskInterval = *TaskEvery
+
 	var key auth.Token
 	if *RegenKey {
 		key = auth.Token(uuid.NewV4().Bytes())
@@ -63,12 +145,56 @@ func main() {
 	case *SourceLocation == "" && !*DevMode:
 		log.Fatal("must provide a source location using -source")
 	case *DevMode && *CertFile == "":
-		devServeInsecure(db, key, *Address, *Port, source)
+		devServeInsecure(db, key, *Address, *Port, source, cfg)
 	case *DevMode:
-		devServeSecure(db, key, *Address, *Port, *CertFile, *KeyFile, source)
+		devServeSecure(db, key, *Address, *Port, *CertFile, *KeyFile, source, cfg)
 	case *CertFile == "":
-		serveInsecure(db, key, *Address, *Port, source)
+		serveInsecure(db, key, *Address, *Port, source, cfg)
 	default:
-		serveSecure(db, key, *Address, *Port, *CertFile, *KeyFile, source)
+		serveSecure(db, key, *Address, *Port, *CertFile, *KeyFile, source, cfg)
+	}
+}
+
+// startMail starts delivering mail through the -smtp-addr relay, and
+// returns the Mailer to queue it with.
+func startMail(db *bolt.DB) *mail.Mailer {
+	host, _, err := net.SplitHostPort(*SMTPAddr)
+	switch {
+	case err != nil:
+		log.Fatalf("invalid -smtp-addr: %s", err.Error())
+	case *MailFrom == "":
+		log.Fatal("must provide a sender address using -mail-from")
+	case *MailBaseURL == "":
+		log.Fatal("must provide a base URL for links using -mail-base-url")
+	}
+
+	relay := &mail.Relay{Addr: *SMTPAddr, From: *MailFrom}
+	if *SMTPUser != "" {
+		relay.Auth = smtp.PlainAuth("", *SMTPUser, *SMTPPassword, host)
+	}
+
+	if err := db.Update(store.Prep(
+		mail.QueueBucket,
+		mail.AddressBucket,
+	)); err != nil {
+		log.Fatalf("failed to prepare mail buckets: %s", err.Error())
 	}
+
+	m := &mail.Mailer{BaseURL: *MailBaseURL}
+	svc := &mail.Service{
+		DB:       db,
+		Sender:   relay,
+		Interval: 30 * time.Second,
+	}
+	if *MailDigest > 0 {
+		d, err := mail.NewDigester(db, m, time.Now())
+		if err != nil {
+			log.Fatalf("failed to start mail digests: %s", err.Error())
+		}
+		notif.Observe(d.Observe)
+		svc.Digester, svc.DigestInterval = d, *MailDigest
+	}
+
+	go svc.Run(nil)
+	return m
 }
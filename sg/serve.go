@@ -15,8 +15,9 @@ func serveInsecure(
 	apiKey auth.Token,
 	addr, port string,
 	source rest.SourceInfo,
+	cfg rest.Config,
 ) {
-	router, err := rest.Bind(db, source, apiKey)
+	router, err := rest.Bind(db, source, apiKey, cfg)
 	if err != nil {
 		log.Fatalf("failed to bind on DB: %s", err.Error())
 	}
@@ -30,8 +31,9 @@ func serveSecure(
 	apiKey auth.Token,
 	addr, port, cert, key string,
 	source rest.SourceInfo,
+	cfg rest.Config,
 ) {
-	router, err := rest.Bind(db, source, apiKey)
+	router, err := rest.Bind(db, source, apiKey, cfg)
 	if err != nil {
 		log.Fatalf("failed to bind on DB: %s", err.Error())
 	}
@@ -49,8 +51,9 @@ func devServeInsecure(
 	apiKey auth.Token,
 	addr, port string,
 	source rest.SourceInfo,
+	cfg rest.Config,
 ) {
-	router, err := rest.Bind(db, source, apiKey)
+	router, err := rest.Bind(db, source, apiKey, cfg)
 	if err != nil {
 		log.Fatalf("failed to bind on DB: %s", err.Error())
 	}
@@ -65,8 +68,9 @@ func devServeSecure(
 	apiKey auth.Token,
 	addr, port, cert, key string,
 	source rest.SourceInfo,
+	cfg rest.Config,
 ) {
-	router, err := rest.Bind(db, source, apiKey)
+	router, err := rest.Bind(db, source, apiKey, cfg)
 	if err != nil {
 		log.Fatalf("failed to bind on DB: %s", err.Error())
 	}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/incept"
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+const ticketsUsage = `usage: sg [flags] tickets list|revoke [filters]
+
+Filters:
+`
+
+// tickets runs the "tickets" subcommand with the given arguments.  It
+// needs the database to itself, so the server must not be running.
+func tickets(db *bolt.DB, args []string) {
+	fs := flag.NewFlagSet("tickets", flag.ExitOnError)
+	var (
+		status  = fs.String("status", "", "only outstanding, expired or redeemed tickets")
+		creator = fs.String("creator", "", "only tickets issued by this admin credential")
+		inviter = fs.String("inviter", "", "only tickets issued by this user")
+		before  = fs.String("created-before", "", "only tickets created before this RFC 3339 time")
+		after   = fs.String("created-after", "", "only tickets created after this RFC 3339 time")
+		all     = fs.Bool("all", false, "revoke all outstanding and expired tickets")
+	)
+	fs.Usage = func() {
+		fmt.Fprint(os.Stderr, ticketsUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) < 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	cmd := args[0]
+	fs.Parse(args[1:])
+
+	f := &incept.TicketFilter{
+		Status:  incept.TicketStatus(*status),
+		Creator: *creator,
+		Inviter: *inviter,
+	}
+	if !f.Status.Valid() {
+		log.Fatalf("invalid -status %#q", *status)
+	}
+	for _, p := range []struct {
+		v    string
+		into **time.Time
+	}{
+		{*before, &f.CreatedBefore},
+		{*after, &f.CreatedAfter},
+	} {
+		if p.v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, p.v)
+		if err != nil {
+			log.Fatalf("invalid time %#q: %s", p.v, err.Error())
+		}
+		*p.into = &t
+	}
+
+	if err := db.Update(store.Prep(
+		incept.TicketBucket,
+		incept.InviteBucket,
+	)); err != nil {
+		log.Fatalf("failed to prepare DB: %s", err.Error())
+	}
+
+	var (
+		tkts []*incept.TicketRecord
+		now  = time.Now()
+		err  error
+	)
+	switch cmd {
+	case "list":
+		err = db.View(incept.GetTickets(f, now, &tkts))
+	case "revoke":
+		if f.Empty() && !*all {
+			log.Fatal("a filter or -all is required to revoke tickets")
+		}
+		err = db.Update(incept.RevokeTickets(f, now, &tkts))
+	default:
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("failed to %s tickets: %s", cmd, err.Error())
+	}
+
+	if err := printTickets(os.Stdout, tkts); err != nil {
+		log.Fatal(errors.Wrap(err, "failed to print tickets"))
+	}
+}
+
+// printTickets writes a table of the given TicketRecords.
+func printTickets(w io.Writer, tkts []*incept.TicketRecord) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TICKET\tSTATUS\tCREATED\tBY\tUSES\tEXPIRES\tREDEEMED BY")
+	for _, t := range tkts {
+		by := t.Creator
+		if t.Inviter != "" {
+			by = t.Inviter
+		}
+		expires := "never"
+		if t.Expires != nil {
+			expires = t.Expires.Format(time.RFC3339)
+		}
+		created := "-"
+		if !t.Created.IsZero() {
+			created = t.Created.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d/%d\t%s\t%v\n",
+			t.Ticket, t.Status, created, by,
+			t.Uses, t.Uses+t.Remaining(), expires, t.RedeemedBy,
+		)
+	}
+	return tw.Flush()
+}
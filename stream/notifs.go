@@ -10,6 +10,17 @@ type Removed string
 // Resource implements Resourcer.Resource on Removed.
 func (Removed) Resource() store.Resource { return "stream-removed" }
 
+// Changed is a notification Resourcer that sends a created or updated
+// Stream along with the user who changed it.
+type Changed struct {
+	*Stream
+
+	By string `json:"-"`
+}
+
+// Actor implements notif.Actor on Changed.
+func (c Changed) Actor() string { return c.By }
+
 // ConnectionNotif is a base for stream Resourcers to create notifs.
 // Implement store.Resourcer as a method on an alias of ConnectionNotif.
 type ConnectionNotif struct {
@@ -24,6 +35,9 @@ type Connected ConnectionNotif
 // Resource implements Resourcer.Resource on Connected.
 func (Connected) Resource() store.Resource { return "stream-connected" }
 
+// Actor implements notif.Actor on Connected.
+func (c Connected) Actor() string { return c.UserID }
+
 // Disconnected is a notification Resourcer that can inform a user
 // someone has left the Stream.
 type Disconnected ConnectionNotif
@@ -31,6 +45,9 @@ type Disconnected ConnectionNotif
 // Resource implements Resourcer.Resource on Disconnected.
 func (Disconnected) Resource() store.Resource { return "stream-disconnected" }
 
+// Actor implements notif.Actor on Disconnected.
+func (d Disconnected) Actor() string { return d.UserID }
+
 // Deleted is a notification Resourcer that notifies the user a resource
 // has been deleted.
 type Deleted string
@@ -1,6 +1,8 @@
 package stream_test
 
 import (
+	"encoding/json"
+
 	"github.com/synapse-garden/sg-proto/store"
 	"github.com/synapse-garden/sg-proto/stream"
 	"github.com/synapse-garden/sg-proto/users"
@@ -11,6 +13,7 @@ import (
 
 var _ = store.Resourcer(stream.Stream{})
 var _ = store.Resourcer(&stream.Stream{})
+var _ = store.Resourcer(stream.Changed{})
 
 func checkStreamMatch(
 	c *C,
@@ -235,3 +238,23 @@ func (s *StreamSuite) BenchmarkAllStreams(c *C) {
 	// Make 100, 1k, 10k, 100k entries with e.g. 10% overlap
 	// Scan under various search conditions
 }
+
+func (s *StreamSuite) TestChanged(c *C) {
+	str := &stream.Stream{
+		Group: users.Group{
+			Owner:   "bob",
+			Readers: map[string]bool{"bob": true, "bart": true},
+		},
+		ID: "x",
+	}
+	ch := stream.Changed{Stream: str, By: "bart"}
+	c.Check(ch.Resource(), Equals, str.Resource())
+	c.Check(ch.Actor(), Equals, "bart")
+
+	c.Log("Changed is sent as its Stream.")
+	want, err := json.Marshal(str)
+	c.Assert(err, IsNil)
+	got, err := json.Marshal(ch)
+	c.Assert(err, IsNil)
+	c.Check(string(got), Equals, string(want))
+}
@@ -0,0 +1,382 @@
+package task
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+)
+
+// ActivityBucket holds a nested Bucket of Activities for each Task, and
+// CommentBucket a nested Bucket of Comments for each Task, both keyed by
+// sequence number.
+var (
+	ActivityBucket = store.Bucket("task-activity")
+	CommentBucket  = store.Bucket("task-comments")
+)
+
+// MaxCommentLen is the most bytes a Comment's Text may have.
+const MaxCommentLen = 4096
+
+// Change is a change to one field of a Task, with its values as JSON.
+// Readers and Writers are given as sorted lists of users.
+type Change struct {
+	Field string          `json:"field"`
+	From  json.RawMessage `json:"from,omitempty"`
+	To    json.RawMessage `json:"to,omitempty"`
+}
+
+// Activity is a record of a user making or changing a Task.
+type Activity struct {
+	Task    ID        `json:"task"`
+	By      string    `json:"by"`
+	Time    time.Time `json:"time"`
+	Created bool      `json:"created,omitempty"`
+	Changes []Change  `json:"changes,omitempty"`
+}
+
+// Resource implements Resourcer on Activity.
+func (*Activity) Resource() store.Resource { return "task-activity" }
+
+// Actor implements notif.Actor on Activity.
+func (a *Activity) Actor() string { return a.By }
+
+// sortedUsers returns the users mapped to true, sorted.
+func sortedUsers(us map[string]bool) []string {
+	result := []string{}
+	for u, ok := range us {
+		if ok {
+			result = append(result, u)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// diffFields are the fields of a Task which Diff compares, in order.
+var diffFields = []struct {
+	name string
+	get  func(*Task) interface{}
+}{
+	{"name", func(t *Task) interface{} { return t.Name }},
+	{"notes", func(t *Task) interface{} { return t.Notes }},
+	{"bounty", func(t *Task) interface{} { return t.Bounty }},
+	{"due", func(t *Task) interface{} { return t.Due }},
+	{"labels", func(t *Task) interface{} { return t.Labels }},
+	{"priority", func(t *Task) interface{} { return t.Priority }},
+	{"parent", func(t *Task) interface{} { return t.Parent }},
+	{"blockedBy", func(t *Task) interface{} { return t.BlockedBy }},
+	{"recur", func(t *Task) interface{} { return t.Recur }},
+	{"state", func(t *Task) interface{} { return t.Status() }},
+	{"claimant", func(t *Task) interface{} { return t.Claimant }},
+	{"completed", func(t *Task) interface{} { return t.Completed }},
+	{"completedBy", func(t *Task) interface{} { return t.CompletedBy }},
+	{"completedAt", func(t *Task) interface{} { return t.CompletedAt }},
+	{"project", func(t *Task) interface{} { return t.Project }},
+	{"readers", func(t *Task) interface{} { return sortedUsers(t.Readers) }},
+	{"writers", func(t *Task) interface{} { return sortedUsers(t.Writers) }},
+}
+
+// empty returns nil for JSON values which mean nothing was set.
+func empty(bs []byte) json.RawMessage {
+	switch string(bs) {
+	case "null", `""`, "[]", "0", "false":
+		return nil
+	}
+	return bs
+}
+
+// Diff returns the Changes from old to new.  The Tasks' Notes must be
+// loaded.
+func Diff(old, new *Task) ([]Change, error) {
+	var result []Change
+	for _, f := range diffFields {
+		from, err := json.Marshal(f.get(old))
+		if err != nil {
+			return nil, err
+		}
+		to, err := json.Marshal(f.get(new))
+		if err != nil {
+			return nil, err
+		}
+		from, to = empty(from), empty(to)
+		if !bytes.Equal(from, to) {
+			result = append(result, Change{f.name, from, to})
+		}
+	}
+	return result, nil
+}
+
+// appendTo returns a function which appends the JSON of v to the nested
+// Bucket of the given Bucket for the Task with the given ID.
+func appendTo(b store.Bucket, id ID, v interface{}) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		nb, err := store.MakeNestedBucket(tx.Bucket(b), store.Bucket(id[:]))
+		if err != nil {
+			return err
+		}
+		seq, err := nb.NextSequence()
+		if err != nil {
+			return err
+		}
+		bs, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return nb.Put(key, bs)
+	}
+}
+
+// forEachIn returns a function which calls f with each value in the
+// nested Bucket of the given Bucket for the Task with the given ID, in
+// order.
+func forEachIn(b store.Bucket, id ID, f func(v []byte) error) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		nb, err := store.GetNestedBucket(tx.Bucket(b), store.Bucket(id[:]))
+		switch {
+		case store.IsMissingBucket(err):
+			return nil
+		case err != nil:
+			return err
+		}
+		return nb.ForEach(func(_, v []byte) error { return f(v) })
+	}
+}
+
+// deleteIn returns a function which deletes the nested Bucket of the
+// given Bucket for the Task with the given ID, if it has one.
+func deleteIn(b store.Bucket, id ID) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		err := tx.Bucket(b).DeleteBucket(id[:])
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	}
+}
+
+// RecordActivity returns a function which appends the Activity to the
+// log of the Task with the given ID.  Activities which neither create
+// the Task nor change it are not recorded.
+func RecordActivity(id ID, a *Activity) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if !a.Created && len(a.Changes) == 0 {
+			return nil
+		}
+		a.Task = id
+		return appendTo(ActivityBucket, id, a)(tx)
+	}
+}
+
+// GetActivity returns a function which loads the Activities of the Task
+// with the given ID into into, oldest first.
+func GetActivity(id ID, into *[]*Activity) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		*into = []*Activity{}
+		return forEachIn(ActivityBucket, id, func(v []byte) error {
+			a := new(Activity)
+			if err := json.Unmarshal(v, a); err != nil {
+				return err
+			}
+			*into = append(*into, a)
+			return nil
+		})(tx)
+	}
+}
+
+// Comment is a comment on a Task.  A Comment with a Parent is a reply
+// to the Comment with that ID.
+type Comment struct {
+	ID     uint64    `json:"id"`
+	Task   ID        `json:"task"`
+	Parent uint64    `json:"parent,omitempty"`
+	By     string    `json:"by"`
+	Text   string    `json:"text"`
+	Time   time.Time `json:"time"`
+}
+
+// Resource implements Resourcer on Comment.
+func (*Comment) Resource() store.Resource { return "task-comment" }
+
+// Actor implements notif.Actor on Comment.
+func (c *Comment) Actor() string { return c.By }
+
+// ErrComment is returned when a Comment is invalid.
+type ErrComment string
+
+func (e ErrComment) Error() string { return "invalid comment: " + string(e) }
+
+// IsComment returns true if the error is an ErrComment.
+func IsComment(err error) bool {
+	_, ok := err.(ErrComment)
+	return ok
+}
+
+// ErrCommentMissing is returned when a Comment replies to a Comment
+// which does not exist.
+type ErrCommentMissing uint64
+
+func (e ErrCommentMissing) Error() string {
+	return fmt.Sprintf("no such comment %d", uint64(e))
+}
+
+// IsCommentMissing returns true if the error is an ErrCommentMissing.
+func IsCommentMissing(err error) bool {
+	_, ok := err.(ErrCommentMissing)
+	return ok
+}
+
+// Validate trims the Comment's Text, and returns ErrComment if it is
+// empty or longer than MaxCommentLen.
+func (c *Comment) Validate() error {
+	c.Text = strings.TrimSpace(c.Text)
+	switch {
+	case c.Text == "":
+		return ErrComment("text must not be empty")
+	case len(c.Text) > MaxCommentLen:
+		return ErrComment(fmt.Sprintf(
+			"text must be at most %d bytes", MaxCommentLen,
+		))
+	}
+	return nil
+}
+
+// AddComment returns a function which validates the Comment and appends
+// it to the Comments of the Task with the given ID, setting its ID.  It
+// returns ErrCommentMissing if it replies to a Comment which does not
+// exist.
+func AddComment(id ID, c *Comment) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		if c.Parent != 0 {
+			nb, err := store.GetNestedBucket(
+				tx.Bucket(CommentBucket), store.Bucket(id[:]),
+			)
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, c.Parent)
+			switch {
+			case store.IsMissingBucket(err):
+				return ErrCommentMissing(c.Parent)
+			case err != nil:
+				return err
+			case nb.Get(key) == nil:
+				return ErrCommentMissing(c.Parent)
+			}
+		}
+
+		nb, err := store.MakeNestedBucket(
+			tx.Bucket(CommentBucket), store.Bucket(id[:]),
+		)
+		if err != nil {
+			return err
+		}
+		// The Comment's ID is its key, so it is known before it is
+		// marshaled.
+		if c.ID, err = nb.NextSequence(); err != nil {
+			return err
+		}
+		c.Task = id
+		bs, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, c.ID)
+		return nb.Put(key, bs)
+	}
+}
+
+// GetComments returns a function which loads the Comments of the Task
+// with the given ID into into, oldest first.
+func GetComments(id ID, into *[]*Comment) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		*into = []*Comment{}
+		return forEachIn(CommentBucket, id, func(v []byte) error {
+			c := new(Comment)
+			if err := json.Unmarshal(v, c); err != nil {
+				return err
+			}
+			*into = append(*into, c)
+			return nil
+		})(tx)
+	}
+}
+
+// Thread is a Comment and its replies, oldest first.
+type Thread struct {
+	*Comment
+	Replies []*Thread `json:"replies,omitempty"`
+}
+
+// Threads returns the given Comments, which must be oldest first, as
+// Threads of replies.
+func Threads(cs []*Comment) []*Thread {
+	result := []*Thread{}
+	byID := make(map[uint64]*Thread)
+	for _, c := range cs {
+		th := &Thread{Comment: c}
+		byID[c.ID] = th
+		if parent, ok := byID[c.Parent]; ok && c.Parent != 0 {
+			parent.Replies = append(parent.Replies, th)
+			continue
+		}
+		result = append(result, th)
+	}
+	return result
+}
+
+// Entry is an Activity or a Comment on a Task's timeline.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Activity *Activity `json:"activity,omitempty"`
+	Comment  *Comment  `json:"comment,omitempty"`
+}
+
+// GetTimeline returns a function which loads the Activities and
+// Comments of the Task with the given ID into into, oldest first.
+func GetTimeline(id ID, into *[]*Entry) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var (
+			as []*Activity
+			cs []*Comment
+		)
+		if err := store.Wrap(
+			GetActivity(id, &as),
+			GetComments(id, &cs),
+		)(tx); err != nil {
+			return err
+		}
+
+		*into = []*Entry{}
+		for _, a := range as {
+			*into = append(*into, &Entry{Time: a.Time, Activity: a})
+		}
+		for _, c := range cs {
+			*into = append(*into, &Entry{Time: c.Time, Comment: c})
+		}
+		sort.SliceStable(*into, func(i, j int) bool {
+			return (*into)[i].Time.Before((*into)[j].Time)
+		})
+		return nil
+	}
+}
+
+// DeleteActivity returns a function which deletes the Activities and
+// Comments of the Task with the given ID.
+func DeleteActivity(id ID) func(*bolt.Tx) error {
+	return store.Wrap(
+		deleteIn(ActivityBucket, id),
+		deleteIn(CommentBucket, id),
+	)
+}
@@ -0,0 +1,170 @@
+package task_test
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TaskSuite) TestDiff(c *C) {
+	base := func() *task.Task {
+		return &task.Task{
+			Group: users.Group{
+				Owner:   "bodie",
+				Readers: map[string]bool{"bodie": true},
+				Writers: map[string]bool{"bodie": true},
+			},
+			Name:  "chore",
+			Notes: []string{"sweep"},
+		}
+	}
+	for i, test := range []struct {
+		should string
+		change func(*task.Task)
+		expect []string
+	}{{
+		should: "find no changes in the same task",
+		change: func(*task.Task) {},
+		expect: nil,
+	}, {
+		should: "find changed fields in order",
+		change: func(t *task.Task) {
+			t.Bounty = 5
+			t.Name = "chores"
+			t.Notes = nil
+		},
+		expect: []string{
+			`name "chore" "chores"`,
+			`notes ["sweep"] `,
+			`bounty  5`,
+		},
+	}, {
+		should: "compare users as sorted lists",
+		change: func(t *task.Task) {
+			t.Readers["bob"] = true
+			t.Writers = map[string]bool{"bodie": true, "bob": false}
+		},
+		expect: []string{`readers ["bodie"] ["bob","bodie"]`},
+	}, {
+		should: "find review changes",
+		change: func(t *task.Task) {
+			t.State, t.Claimant = task.Claimed, "bob"
+		},
+		expect: []string{
+			`state "open" "claimed"`,
+			`claimant  "bob"`,
+		},
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		old, new := base(), base()
+		test.change(new)
+		changes, err := task.Diff(old, new)
+		c.Assert(err, IsNil)
+		var got []string
+		for _, ch := range changes {
+			got = append(got, strings.Join([]string{
+				ch.Field, string(ch.From), string(ch.To),
+			}, " "))
+		}
+		c.Check(got, DeepEquals, test.expect)
+	}
+}
+
+func (s *TaskSuite) TestActivity(c *C) {
+	var (
+		tID   = task.ID(uuid.NewV4())
+		other = task.ID(uuid.NewV4())
+		now   = time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+	)
+
+	c.Log("activities which change nothing are not recorded")
+	c.Assert(s.Update(store.Wrap(
+		task.RecordActivity(tID, &task.Activity{
+			By: "bodie", Time: now, Created: true,
+		}),
+		task.RecordActivity(tID, &task.Activity{
+			By: "bob", Time: now.Add(time.Minute),
+		}),
+		task.RecordActivity(tID, &task.Activity{
+			By: "bob", Time: now.Add(2 * time.Minute),
+			Changes: []task.Change{{
+				Field: "name",
+				From:  json.RawMessage(`"a"`),
+				To:    json.RawMessage(`"b"`),
+			}},
+		}),
+	)), IsNil)
+	var as []*task.Activity
+	c.Assert(s.View(task.GetActivity(tID, &as)), IsNil)
+	c.Assert(as, HasLen, 2)
+	c.Check(as[0].Created, Equals, true)
+	c.Check(as[1].By, Equals, "bob")
+	c.Check(as[1].Task, Equals, tID)
+
+	c.Log("comments may reply to comments which exist")
+	first := &task.Comment{By: "bob", Text: " hi ", Time: now.Add(time.Minute)}
+	c.Assert(s.Update(task.AddComment(tID, first)), IsNil)
+	c.Check(first.ID, Equals, uint64(1))
+	c.Check(first.Text, Equals, "hi")
+	reply := &task.Comment{
+		By: "bodie", Text: "hello", Parent: first.ID,
+		Time: now.Add(3 * time.Minute),
+	}
+	c.Assert(s.Update(task.AddComment(tID, reply)), IsNil)
+	second := &task.Comment{By: "bob", Text: "bye", Time: now.Add(4 * time.Minute)}
+	c.Assert(s.Update(task.AddComment(tID, second)), IsNil)
+
+	err := s.Update(task.AddComment(tID, &task.Comment{Text: "x", Parent: 9}))
+	c.Check(err, ErrorMatches, "no such comment 9")
+	c.Check(task.IsCommentMissing(err), Equals, true)
+	err = s.Update(task.AddComment(other, &task.Comment{Text: "x", Parent: 1}))
+	c.Check(task.IsCommentMissing(err), Equals, true)
+	err = s.Update(task.AddComment(tID, &task.Comment{Text: "  "}))
+	c.Check(err, ErrorMatches, "invalid comment: text must not be empty")
+	c.Check(task.IsComment(err), Equals, true)
+	err = s.Update(task.AddComment(tID, &task.Comment{
+		Text: strings.Repeat("x", task.MaxCommentLen+1),
+	}))
+	c.Check(err, ErrorMatches, "invalid comment: text must be at most 4096 bytes")
+
+	var cs []*task.Comment
+	c.Assert(s.View(task.GetComments(tID, &cs)), IsNil)
+	c.Check(task.Threads(cs), DeepEquals, []*task.Thread{{
+		Comment: first, Replies: []*task.Thread{{Comment: reply}},
+	}, {
+		Comment: second,
+	}})
+
+	c.Log("the timeline has activities and comments by time")
+	var timeline []*task.Entry
+	c.Assert(s.View(task.GetTimeline(tID, &timeline)), IsNil)
+	var got []string
+	for _, e := range timeline {
+		switch {
+		case e.Activity != nil:
+			got = append(got, "activity by "+e.Activity.By)
+		case e.Comment != nil:
+			got = append(got, "comment by "+e.Comment.By)
+		}
+	}
+	c.Check(got, DeepEquals, []string{
+		"activity by bodie",
+		"comment by bob",
+		"activity by bob",
+		"comment by bodie",
+		"comment by bob",
+	})
+
+	c.Log("deleting a task's activity deletes its comments")
+	c.Assert(s.Update(task.DeleteActivity(tID)), IsNil)
+	c.Assert(s.Update(task.DeleteActivity(other)), IsNil)
+	c.Assert(s.View(task.GetTimeline(tID, &timeline)), IsNil)
+	c.Check(timeline, HasLen, 0)
+}
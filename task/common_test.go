@@ -31,7 +31,17 @@ func (s *TaskSuite) SetUpTest(c *C) {
 		store.Migrate(store.VerCurrent),
 		store.SetupBuckets(
 			users.UserBucket,
+			users.LedgerBucket,
 			task.TaskBucket,
+			task.HistoryBucket,
+			task.LabelBucket,
+			task.ColourBucket,
+			task.ProjectBucket,
+			task.ActivityBucket,
+			task.CommentBucket,
+			task.PrefsBucket,
+			task.RemindedBucket,
+			task.EscrowBucket,
 			text.TextBucket,
 		),
 	)), IsNil)
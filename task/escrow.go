@@ -0,0 +1,348 @@
+package task
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// String returns the ID in the usual UUID form, as used for the Task
+// of a users.Transaction.
+func (i ID) String() string { return uuid.UUID(i).String() }
+
+// EscrowBucket holds the escrow account of each Task by its ID, so the
+// ledger need not be summed to find it.  OpenEscrow rebuilds it from
+// the ledger.
+var EscrowBucket = store.Bucket("task-escrow")
+
+// account is a Task's escrow as the ledger records it: the coin
+// users.Escrow holds for it, the net coin each user deposited, and the
+// net Bounty paid to each user.
+type account struct {
+	Held      int64            `json:"held"`
+	Deposited map[string]int64 `json:"deposited"`
+	Paid      map[string]int64 `json:"paid"`
+}
+
+func newAccount() *account {
+	return &account{
+		Deposited: make(map[string]int64),
+		Paid:      make(map[string]int64),
+	}
+}
+
+// add applies the given Transaction of the Task to the account.
+func (a *account) add(t *users.Transaction) {
+	switch {
+	case t.To == users.Escrow:
+		a.Held += t.Amount
+		switch t.Reason {
+		case users.Deposit:
+			a.Deposited[t.From] += t.Amount
+		case users.BountyReturn:
+			a.Paid[t.From] -= t.Amount
+		}
+	case t.From == users.Escrow:
+		a.Held -= t.Amount
+		switch t.Reason {
+		case users.Refund:
+			a.Deposited[t.To] -= t.Amount
+		case users.Bounty:
+			a.Paid[t.To] += t.Amount
+		}
+	}
+}
+
+// loadAccount returns a function which loads the escrow account of the
+// Task with the given ID into into.  A Task which never had anything
+// escrowed has an empty account.
+func loadAccount(id ID, into *account) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		a := newAccount()
+		if err := store.Unmarshal(EscrowBucket, a, id[:])(tx); err != nil &&
+			!store.IsMissing(err) {
+			return err
+		}
+		if a.Deposited == nil {
+			a.Deposited = make(map[string]int64)
+		}
+		if a.Paid == nil {
+			a.Paid = make(map[string]int64)
+		}
+		*into = *a
+		return nil
+	}
+}
+
+// indexEscrow rebuilds EscrowBucket from the ledger, in one pass.
+// Accounts of deleted Tasks are not kept.
+func indexEscrow(tx *bolt.Tx) error {
+	all := make(map[ID]*account)
+	err := store.ForEach(users.LedgerBucket, func(k, v []byte) error {
+		t := new(users.Transaction)
+		if err := json.Unmarshal(v, t); err != nil {
+			return errors.Wrapf(err,
+				"failed to unmarshal transaction %d",
+				binary.BigEndian.Uint64(k),
+			)
+		}
+		switch {
+		case t.Task == "":
+			return nil
+		case t.To != users.Escrow && t.From != users.Escrow:
+			return nil
+		}
+		id, err := uuid.FromString(t.Task)
+		if err != nil {
+			return errors.Wrapf(err,
+				"transaction %d has invalid task",
+				binary.BigEndian.Uint64(k),
+			)
+		}
+		a, ok := all[ID(id)]
+		if !ok {
+			a = newAccount()
+			all[ID(id)] = a
+		}
+		a.add(t)
+		return nil
+	})(tx)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.DeleteBucket(EscrowBucket); err != nil &&
+		err != bolt.ErrBucketNotFound {
+		return err
+	}
+	if _, err := tx.CreateBucket(EscrowBucket); err != nil {
+		return err
+	}
+	for id, a := range all {
+		if tx.Bucket(TaskBucket).Get(id[:]) == nil {
+			continue
+		}
+		if err := store.Marshal(EscrowBucket, a, id[:])(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Escrowed returns a function which loads the coin users.Escrow holds
+// for the Task with the given ID into into.
+func Escrowed(id ID, into *int64) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		a := new(account)
+		if err := loadAccount(id, a)(tx); err != nil {
+			return err
+		}
+		*into = a.Held
+		return nil
+	}
+}
+
+// escrow returns a function which moves amount of the Task's coin from
+// one account to another, if it is positive, using the given post func
+// such as users.Post or users.Spend, and updates the Task's account.
+func escrow(
+	t *Task,
+	post func(*users.Transaction) store.Mutation,
+	from, to string,
+	amount int64,
+	reason users.Reason,
+	now time.Time,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if amount <= 0 {
+			return nil
+		}
+		tr := &users.Transaction{
+			From:   from,
+			To:     to,
+			Amount: amount,
+			Reason: reason,
+			Task:   t.ID.String(),
+			Time:   now,
+		}
+		if err := post(tr)(tx); err != nil {
+			return err
+		}
+
+		a := new(account)
+		if err := loadAccount(t.ID, a)(tx); err != nil {
+			return err
+		}
+		a.add(tr)
+		return store.Marshal(EscrowBucket, a, t.ID[:])(tx)
+	}
+}
+
+// fund returns a function which makes users.Escrow hold the Task's
+// Bounty, given that it holds held, using post to deposit any shortfall
+// from its Owner.
+func fund(
+	t *Task,
+	post func(*users.Transaction) store.Mutation,
+	held int64,
+	now time.Time,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if t.Bounty < 0 {
+			return errors.New("bounty must not be negative")
+		}
+		return store.Wrap(
+			escrow(t, post,
+				t.Owner, users.Escrow,
+				t.Bounty-held, users.Deposit, now,
+			),
+			escrow(t, users.Post,
+				users.Escrow, t.Owner,
+				held-t.Bounty, users.Refund, now,
+			),
+		)(tx)
+	}
+}
+
+// Fund returns a function which deposits the Task's Bounty from its
+// Owner into users.Escrow, or refunds them the difference if it was
+// lowered.  If the Owner can't afford it, it returns
+// users.ErrInsufficientCoin.  It should only be used on incomplete
+// Tasks, and after the Task's ID is set.
+func Fund(t *Task, now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var held int64
+		if err := Escrowed(t.ID, &held)(tx); err != nil {
+			return err
+		}
+		return fund(t, users.Spend, held, now)(tx)
+	}
+}
+
+// Payout returns a function which pays whatever users.Escrow holds for
+// the Task to the given user, who completed it.
+func Payout(t *Task, to string, now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var held int64
+		if err := Escrowed(t.ID, &held)(tx); err != nil {
+			return err
+		}
+		return escrow(t, users.Post,
+			users.Escrow, to,
+			held, users.Bounty, now,
+		)(tx)
+	}
+}
+
+// Reclaim returns a function which takes back into users.Escrow the
+// Bounty the ledger shows was paid to the given user for the Task, when
+// it is un-completed.  A user who was never paid for it is not debited.
+// Like the payout, this may leave them with negative coin.
+func Reclaim(t *Task, from string, now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		a := new(account)
+		if err := loadAccount(t.ID, a)(tx); err != nil {
+			return err
+		}
+		return escrow(t, users.Post,
+			from, users.Escrow,
+			a.Paid[from], users.BountyReturn, now,
+		)(tx)
+	}
+}
+
+// Refund returns a function which gives whatever users.Escrow holds for
+// the Task back to the users who deposited it, such as when it is
+// deleted.  Anything left over goes to its Owner.
+func Refund(t *Task, now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		a := new(account)
+		if err := loadAccount(t.ID, a)(tx); err != nil {
+			return err
+		}
+
+		var depositors []string
+		for u, amount := range a.Deposited {
+			if amount > 0 {
+				depositors = append(depositors, u)
+			}
+		}
+		sort.Strings(depositors)
+
+		left := a.Held
+		var ops []func(*bolt.Tx) error
+		for _, u := range depositors {
+			amount := a.Deposited[u]
+			if amount > left {
+				amount = left
+			}
+			left -= amount
+			ops = append(ops, escrow(t, users.Post,
+				users.Escrow, u,
+				amount, users.Refund, now,
+			))
+		}
+		ops = append(ops,
+			escrow(t, users.Post,
+				users.Escrow, t.Owner,
+				left, users.Refund, now,
+			),
+			// Nothing is held for it any more.
+			store.Delete(EscrowBucket, t.ID[:]),
+		)
+		return store.Wrap(ops...)(tx)
+	}
+}
+
+// OpenEscrow returns a function which rebuilds EscrowBucket from the
+// ledger, then funds the Bounty of every incomplete Task whose Bounty
+// is not in users.Escrow, so bounties posted before escrow are paid out
+// like any other.  Owners may be
+// left with negative coin.  Tasks whose Owner was deleted are skipped.
+func OpenEscrow(now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var open []*Task
+		err := store.ForEach(TaskBucket, func(k, v []byte) error {
+			t := new(Task)
+			if err := json.Unmarshal(v, t); err != nil {
+				return err
+			}
+			if !t.Completed && t.Bounty > 0 {
+				open = append(open, t)
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		if err := indexEscrow(tx); err != nil {
+			return err
+		}
+		for _, t := range open {
+			var held int64
+			if err := Escrowed(t.ID, &held)(tx); err != nil {
+				return err
+			}
+			err := fund(t, users.Post, held, now)(tx)
+			switch {
+			case users.IsMissing(err):
+				log.Printf("not escrowing bounty of task %s: %s",
+					t.ID, err)
+			case err != nil:
+				return errors.Wrapf(err,
+					"failed to fund task %s", t.ID)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,208 @@
+package task_test
+
+import (
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TaskSuite) TestEscrow(c *C) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.Assert(s.Update(store.Wrap(
+		users.Create(&users.User{Name: "bodie"}),
+		users.Create(&users.User{Name: "bob"}),
+		users.Post(&users.Transaction{
+			To: "bodie", Amount: 10, Reason: users.Grant, Time: now,
+		}),
+	)), IsNil)
+
+	t := &task.Task{
+		ID:     task.ID(uuid.NewV4()),
+		Group:  users.Group{Owner: "bodie"},
+		Bounty: 4,
+	}
+
+	for i, test := range []struct {
+		should string
+		given  func(*task.Task) func(*bolt.Tx) error
+		bounty int64
+
+		expectErr   string
+		expectHeld  int64
+		expectBodie int64
+		expectBob   int64
+	}{{
+		should:      "deposit the bounty",
+		given:       func(t *task.Task) func(*bolt.Tx) error { return task.Fund(t, now) },
+		bounty:      4,
+		expectHeld:  4,
+		expectBodie: 6,
+	}, {
+		should:      "refund a lowered bounty",
+		given:       func(t *task.Task) func(*bolt.Tx) error { return task.Fund(t, now) },
+		bounty:      1,
+		expectHeld:  1,
+		expectBodie: 9,
+	}, {
+		should:      "reject a negative bounty",
+		given:       func(t *task.Task) func(*bolt.Tx) error { return task.Fund(t, now) },
+		bounty:      -1,
+		expectErr:   "bounty must not be negative",
+		expectHeld:  1,
+		expectBodie: 9,
+	}, {
+		should:      "not deposit more than the owner has",
+		given:       func(t *task.Task) func(*bolt.Tx) error { return task.Fund(t, now) },
+		bounty:      11,
+		expectErr:   "user `bodie` has 9 coin, needs 10",
+		expectHeld:  1,
+		expectBodie: 9,
+	}, {
+		should:      "deposit a raised bounty",
+		given:       func(t *task.Task) func(*bolt.Tx) error { return task.Fund(t, now) },
+		bounty:      3,
+		expectHeld:  3,
+		expectBodie: 7,
+	}, {
+		should: "pay out to the completer",
+		given: func(t *task.Task) func(*bolt.Tx) error {
+			return task.Payout(t, "bob", now)
+		},
+		bounty:      3,
+		expectBodie: 7,
+		expectBob:   3,
+	}, {
+		should: "not reclaim from a user who was not paid",
+		given: func(t *task.Task) func(*bolt.Tx) error {
+			return task.Reclaim(t, "bodie", now)
+		},
+		bounty:      3,
+		expectBodie: 7,
+		expectBob:   3,
+	}, {
+		should: "reclaim the bounty when un-completed",
+		given: func(t *task.Task) func(*bolt.Tx) error {
+			return task.Reclaim(t, "bob", now)
+		},
+		bounty:      3,
+		expectHeld:  3,
+		expectBodie: 7,
+	}, {
+		should:      "refund the owner",
+		given:       func(t *task.Task) func(*bolt.Tx) error { return task.Refund(t, now) },
+		bounty:      3,
+		expectBodie: 10,
+	}, {
+		should:      "do nothing when nothing is held",
+		given:       func(t *task.Task) func(*bolt.Tx) error { return task.Refund(t, now) },
+		bounty:      3,
+		expectBodie: 10,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		t.Bounty = test.bounty
+		err := s.Update(test.given(t))
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+		} else {
+			c.Check(err, IsNil)
+		}
+
+		var held int64
+		bodie, bob := new(users.User), new(users.User)
+		c.Assert(s.View(store.Wrap(
+			task.Escrowed(t.ID, &held),
+			users.CheckBalance("bodie"),
+			users.CheckBalance("bob"),
+			store.Unmarshal(users.UserBucket, bodie, []byte("bodie")),
+			store.Unmarshal(users.UserBucket, bob, []byte("bob")),
+		)), IsNil)
+		c.Check(held, Equals, test.expectHeld)
+		c.Check(bodie.Coin, Equals, test.expectBodie)
+		c.Check(bob.Coin, Equals, test.expectBob)
+	}
+}
+
+func (s *TaskSuite) TestOpenEscrow(c *C) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	open, done := task.ID(uuid.NewV4()), task.ID(uuid.NewV4())
+	orphan := task.ID(uuid.NewV4())
+	c.Assert(s.Update(store.Wrap(
+		users.Create(&users.User{Name: "bodie"}),
+		open.Store(&task.Task{
+			Group: users.Group{Owner: "bodie"}, Bounty: 5,
+		}),
+		done.Store(&task.Task{
+			Group: users.Group{Owner: "bodie"}, Bounty: 3,
+			Completed: true,
+		}),
+		orphan.Store(&task.Task{
+			Group: users.Group{Owner: "ghost"}, Bounty: 2,
+		}),
+	)), IsNil)
+
+	c.Assert(s.Update(task.OpenEscrow(now)), IsNil)
+	c.Log("escrowing again changes nothing")
+	c.Assert(s.Update(task.OpenEscrow(now)), IsNil)
+
+	var heldOpen, heldDone, heldOrphan int64
+	bodie := new(users.User)
+	c.Assert(s.View(store.Wrap(
+		task.Escrowed(open, &heldOpen),
+		task.Escrowed(done, &heldDone),
+		task.Escrowed(orphan, &heldOrphan),
+		users.CheckBalance("bodie"),
+		store.Unmarshal(users.UserBucket, bodie, []byte("bodie")),
+	)), IsNil)
+	c.Check(heldOpen, Equals, int64(5))
+	c.Check(heldDone, Equals, int64(0))
+	c.Check(bodie.Coin, Equals, int64(-5))
+
+	c.Log("a task whose owner was deleted is skipped")
+	c.Check(heldOrphan, Equals, int64(0))
+}
+
+func (s *TaskSuite) TestOpenEscrowIndex(c *C) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	kept, gone := task.ID(uuid.NewV4()), task.ID(uuid.NewV4())
+	c.Assert(s.Update(store.Wrap(
+		users.Create(&users.User{Name: "bodie"}),
+		kept.Store(&task.Task{
+			Group: users.Group{Owner: "bodie"}, Bounty: 3,
+		}),
+		// These are in the ledger, but not in EscrowBucket.
+		users.Post(&users.Transaction{
+			From: "bodie", To: users.Escrow, Amount: 3,
+			Reason: users.Deposit, Task: kept.String(), Time: now,
+		}),
+		users.Post(&users.Transaction{
+			From: "bodie", To: users.Escrow, Amount: 2,
+			Reason: users.Deposit, Task: gone.String(), Time: now,
+		}),
+	)), IsNil)
+
+	var held int64
+	c.Assert(s.View(task.Escrowed(kept, &held)), IsNil)
+	c.Check(held, Equals, int64(0))
+
+	c.Log("OpenEscrow rebuilds the accounts from the ledger")
+	c.Assert(s.Update(task.OpenEscrow(now)), IsNil)
+	c.Assert(s.View(task.Escrowed(kept, &held)), IsNil)
+	c.Check(held, Equals, int64(3))
+
+	c.Log("so the bounty is not deposited again")
+	bodie := new(users.User)
+	c.Assert(s.View(store.Unmarshal(
+		users.UserBucket, bodie, []byte("bodie"),
+	)), IsNil)
+	c.Check(bodie.Coin, Equals, int64(-5))
+
+	c.Log("the account of a deleted task is not kept")
+	err := s.View(store.CheckExists(task.EscrowBucket, gone[:]))
+	c.Check(store.IsMissing(err), Equals, true)
+}
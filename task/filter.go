@@ -1,6 +1,7 @@
 package task
 
 import (
+	"strings"
 	"time"
 
 	"github.com/synapse-garden/sg-proto/users"
@@ -61,9 +62,91 @@ func (dw DueWithin) Member(of *Task) bool {
 	return d.After(dw.From) && d.Before(dw.Til)
 }
 
+// Not is a Filter for Tasks which are not members of its Filter.
 type Not struct{ Filter }
 
-func (n Not) Member(of *Task) bool { return n.Filter.Member(of) }
+// Member implements Filter on Not.
+func (n Not) Member(of *Task) bool { return !n.Filter.Member(of) }
+
+// DueIs is a Filter for Tasks with a Due date which compares to Time by
+// Op, such as DueIs{Less, t} for Tasks due before t.
+type DueIs struct {
+	Op   Compare
+	Time time.Time
+}
+
+// Member implements Filter on DueIs.
+func (d DueIs) Member(of *Task) bool {
+	if of.Due == nil {
+		return false
+	}
+	sign, _, _ := compareTimes(of.Due, &d.Time)
+	return d.Op.compare(sign)
+}
+
+// BountyIs is a Filter for Tasks whose Bounty compares to its Bounty by
+// Op, such as BountyIs{GreaterEq, 5}.
+type BountyIs struct {
+	Op     Compare
+	Bounty int64
+}
+
+// Member implements Filter on BountyIs.
+func (b BountyIs) Member(of *Task) bool {
+	return b.Op.compare(sign(of.Bounty - b.Bounty))
+}
+
+// PriorityIs is a Filter for Tasks whose Priority compares to its
+// Priority by Op, such as PriorityIs{GreaterEq, High}.
+type PriorityIs struct {
+	Op       Compare
+	Priority Priority
+}
+
+// Member implements Filter on PriorityIs.
+func (p PriorityIs) Member(of *Task) bool {
+	return p.Op.compare(sign(int64(of.Priority - p.Priority)))
+}
+
+// ByLabel is a Filter for Tasks with the given label.
+type ByLabel string
+
+// Member implements Filter on ByLabel.
+func (b ByLabel) Member(of *Task) bool { return of.HasLabel(string(b)) }
+
+// ByProject is a Filter for Tasks on the Project with the given ID.
+type ByProject ProjectID
+
+// Member implements Filter on ByProject.
+func (b ByProject) Member(of *Task) bool {
+	return of.Project != nil && *of.Project == ProjectID(b)
+}
+
+// ByCompleter is a Filter for Tasks completed by the given user.
+type ByCompleter string
+
+// Member implements Filter on ByCompleter.
+func (b ByCompleter) Member(of *Task) bool {
+	return of.Completed && of.CompletedBy == string(b)
+}
+
+// ByText is a Filter for Tasks whose Name or Notes contain its text,
+// ignoring case.  The Task's Notes must be loaded.
+type ByText string
+
+// Member implements Filter on ByText.
+func (b ByText) Member(of *Task) bool {
+	text := strings.ToLower(string(b))
+	if strings.Contains(strings.ToLower(of.Name), text) {
+		return true
+	}
+	for _, n := range of.Notes {
+		if strings.Contains(strings.ToLower(n), text) {
+			return true
+		}
+	}
+	return false
+}
 
 type ByOwner users.ByOwner
 
@@ -87,6 +170,14 @@ func (b ByWriter) Member(of *Task) bool {
 	return users.ByWriter(b).Member(of.Group)
 }
 
+// ByCircle is a Filter for Tasks that refer to the given users.Circle.
+type ByCircle string
+
+// Member implements Filter on ByCircle.
+func (b ByCircle) Member(of *Task) bool {
+	return users.ByCircle(b).Member(of.Group)
+}
+
 // MultiAnd applies multiple Filters which all must be true.
 type MultiAnd []Filter
 
@@ -114,3 +205,27 @@ func (m MultiOr) Member(s *Task) bool {
 	// None passed.
 	return false
 }
+
+// needsNotes returns true if the Filter matches on a Task's Notes, such
+// as ByText, so they must be loaded before it is applied.
+func needsNotes(f Filter) bool {
+	switch f := f.(type) {
+	case ByText:
+		return true
+	case Not:
+		return needsNotes(f.Filter)
+	case MultiAnd:
+		for _, g := range f {
+			if needsNotes(g) {
+				return true
+			}
+		}
+	case MultiOr:
+		for _, g := range f {
+			if needsNotes(g) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -44,6 +44,11 @@ func (s *TaskSuite) TestFilters(c *C) {
 		given:  &task.Task{Completed: true},
 		filter: task.Completion(5),
 		expect: false,
+	}, {
+		should: "negate its filter",
+		given:  &task.Task{Completed: true},
+		filter: task.Not{task.Incomplete},
+		expect: true,
 	}, {
 		should: "return true for overdue tasks",
 		given:  &task.Task{Due: &beforeNow},
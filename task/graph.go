@@ -0,0 +1,392 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+)
+
+// ErrMissing is returned when a Task links to a Task which does not
+// exist, or which its user can't see.
+type ErrMissing ID
+
+func (e ErrMissing) Error() string {
+	return fmt.Sprintf("task %s not found", ID(e))
+}
+
+// IsMissing returns true if the error is an ErrMissing.
+func IsMissing(err error) bool {
+	_, ok := err.(ErrMissing)
+	return ok
+}
+
+// ErrCycle is returned when a Task's Parent or BlockedBy would link it
+// back to itself.  It holds the Tasks in the cycle, in order.
+type ErrCycle []ID
+
+func (e ErrCycle) Error() string {
+	ids := make([]string, len(e))
+	for i, id := range e {
+		ids[i] = id.String()
+	}
+	return "tasks form a cycle: " + strings.Join(ids, " -> ")
+}
+
+// IsCycle returns true if the error is an ErrCycle.
+func IsCycle(err error) bool {
+	_, ok := err.(ErrCycle)
+	return ok
+}
+
+// Unblocked is a Resourcer which notifies an owner that their Task is
+// no longer blocked by any incomplete Task.
+type Unblocked ID
+
+// Resource implements Resourcer on Unblocked.
+func (Unblocked) Resource() store.Resource { return "task-unblocked" }
+
+// graph returns every stored Task by ID, without their Notes.
+func graph(tx *bolt.Tx) (map[ID]*Task, error) {
+	all := make(map[ID]*Task)
+	err := store.ForEach(TaskBucket, func(k, v []byte) error {
+		t := new(Task)
+		if err := json.Unmarshal(v, t); err != nil {
+			return err
+		}
+		all[t.ID] = t
+		return nil
+	})(tx)
+	return all, err
+}
+
+// loader returns a func which loads Tasks from TaskBucket by ID,
+// without their Notes, as a walk of their links reaches them.  Each is
+// unmarshalled once, and given Tasks are used as they are.  A missing
+// Task is loaded as nil.
+func loader(tx *bolt.Tx, given ...*Task) func(ID) (*Task, error) {
+	b := tx.Bucket(TaskBucket)
+	loaded := make(map[ID]*Task)
+	for _, t := range given {
+		loaded[t.ID] = t
+	}
+	return func(id ID) (*Task, error) {
+		if t, ok := loaded[id]; ok {
+			return t, nil
+		}
+		var t *Task
+		if bs := b.Get(id[:]); bs != nil {
+			t = new(Task)
+			if err := json.Unmarshal(bs, t); err != nil {
+				return nil, err
+			}
+		}
+		loaded[id] = t
+		return t, nil
+	}
+}
+
+// CheckLinks returns a function which returns ErrMissing if the Task's
+// Parent or BlockedBy refer to a Task the given user can't see, or
+// ErrCycle if the Task would be its own ancestor or block itself.  Only
+// the Tasks reachable from its links are loaded.
+func CheckLinks(t *Task, user string) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if t.Parent == nil && len(t.BlockedBy) == 0 {
+			return nil
+		}
+		get := loader(tx, t)
+
+		links := t.BlockedBy
+		if t.Parent != nil {
+			links = append([]ID{*t.Parent}, links...)
+		}
+		for _, id := range links {
+			if id == t.ID {
+				return ErrCycle{t.ID, t.ID}
+			}
+			l, err := get(id)
+			switch {
+			case err != nil:
+				return err
+			case l == nil || !users.AllUsers(l.Group)[user]:
+				return ErrMissing(id)
+			}
+		}
+
+		// Walk up the Parents looking for t.
+		if t.Parent != nil {
+			path := []ID{t.ID}
+			seen := map[ID]bool{t.ID: true}
+			for p := t.Parent; p != nil; {
+				path = append(path, *p)
+				if *p == t.ID {
+					return ErrCycle(path)
+				}
+				next, err := get(*p)
+				if err != nil {
+					return err
+				}
+				if next == nil || seen[*p] {
+					break
+				}
+				seen[*p] = true
+				p = next.Parent
+			}
+		}
+
+		// Search the blockers of t's blockers for t.
+		seen := make(map[ID]bool)
+		var search func(path []ID) ([]ID, error)
+		search = func(path []ID) ([]ID, error) {
+			at, err := get(path[len(path)-1])
+			if at == nil || err != nil {
+				return nil, err
+			}
+			for _, id := range at.BlockedBy {
+				next := append(path[:len(path):len(path)], id)
+				if id == t.ID {
+					return next, nil
+				}
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				found, err := search(next)
+				if found != nil || err != nil {
+					return found, err
+				}
+			}
+			return nil, nil
+		}
+		cycle, err := search([]ID{t.ID})
+		if cycle != nil {
+			return ErrCycle(cycle)
+		}
+		return err
+	}
+}
+
+// Pending is the set of IDs of incomplete Tasks, which block the Tasks
+// they are in the BlockedBy of.
+type Pending map[ID]bool
+
+// GetPending returns a function which loads the IDs of the incomplete
+// Tasks blocking any of the given Tasks into the given Pending.  Only
+// those blockers are loaded.
+func GetPending(of []*Task, into Pending) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		b := tx.Bucket(TaskBucket)
+		checked := make(map[ID]bool)
+		for _, t := range of {
+			for _, id := range t.BlockedBy {
+				if checked[id] {
+					continue
+				}
+				checked[id] = true
+
+				bs := b.Get(id[:])
+				if bs == nil {
+					continue
+				}
+				blocker := new(Task)
+				if err := json.Unmarshal(bs, blocker); err != nil {
+					return err
+				}
+				if !blocker.Completed {
+					into[id] = true
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// Blocked is a Filter for Tasks which are blocked by a Pending Task.
+type Blocked Pending
+
+// Member implements Filter on Blocked.
+func (b Blocked) Member(of *Task) bool {
+	for _, id := range of.BlockedBy {
+		if b[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// NotBlocked is a Filter for Tasks which are not blocked by any Pending
+// Task.
+type NotBlocked Pending
+
+// Member implements Filter on NotBlocked.
+func (n NotBlocked) Member(of *Task) bool {
+	return !Blocked(n).Member(of)
+}
+
+// Ready is a Filter for incomplete Tasks which are not blocked by any
+// Pending Task, so they can be worked on now.
+type Ready Pending
+
+// Member implements Filter on Ready.
+func (r Ready) Member(of *Task) bool {
+	return !of.Completed && !Blocked(r).Member(of)
+}
+
+// ChildOf is a Filter for the subtasks of the Task with the given ID.
+type ChildOf ID
+
+// Member implements Filter on ChildOf.
+func (c ChildOf) Member(of *Task) bool {
+	return of.Parent != nil && *of.Parent == ID(c)
+}
+
+// Unblocks returns a function which loads the incomplete Tasks blocked
+// by the Task with the given ID into into, if no other incomplete Task
+// blocks them.  It should be used once the Task is completed.
+func Unblocks(id ID, into *[]*Task) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		all, err := graph(tx)
+		if err != nil {
+			return err
+		}
+		pending := make(Pending)
+		for tid, t := range all {
+			if !t.Completed && tid != id {
+				pending[tid] = true
+			}
+		}
+
+		*into = nil
+		for _, t := range all {
+			if t.Completed || !blockedBy(t, id) {
+				continue
+			}
+			if !Blocked(pending).Member(t) {
+				*into = append(*into, t)
+			}
+		}
+		return nil
+	}
+}
+
+// Unlink returns a function which removes the Task with the given ID
+// from the Parent and BlockedBy of every other Task, such as when it is
+// deleted.  The changed Tasks are loaded into into, with their Notes.
+func Unlink(id ID, into *[]*Task) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		all, err := graph(tx)
+		if err != nil {
+			return err
+		}
+
+		*into = nil
+		for tid, t := range all {
+			isChild := ChildOf(id).Member(t)
+			if !isChild && !blockedBy(t, id) {
+				continue
+			}
+			if isChild {
+				t.Parent = nil
+			}
+			var keep []ID
+			for _, b := range t.BlockedBy {
+				if b != id {
+					keep = append(keep, b)
+				}
+			}
+			t.BlockedBy = keep
+
+			err := store.Wrap(
+				store.Marshal(TaskBucket, t, tid[:]),
+				tid.Load(t),
+			)(tx)
+			if err != nil {
+				return err
+			}
+			*into = append(*into, t)
+		}
+		return nil
+	}
+}
+
+// Tree is a Task with its subtasks.
+type Tree struct {
+	*Task
+	Children []*Tree `json:"children"`
+}
+
+// GetTree returns a function which loads the Task with the given ID
+// into into, with the subtasks below it which the given user can see.
+// If the user can't see the Task, it returns ErrMissing.
+func GetTree(id ID, user string, into *Tree) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		all, err := graph(tx)
+		if err != nil {
+			return err
+		}
+		if t, ok := all[id]; !ok || !users.AllUsers(t.Group)[user] {
+			return ErrMissing(id)
+		}
+
+		children := make(map[ID][]ID)
+		for tid, t := range all {
+			if t.Parent != nil {
+				children[*t.Parent] = append(children[*t.Parent], tid)
+			}
+		}
+
+		seen := make(map[ID]bool)
+		var build func(ID) (*Tree, error)
+		build = func(tid ID) (*Tree, error) {
+			seen[tid] = true
+			t := all[tid]
+			if err := tid.Load(t)(tx); err != nil {
+				return nil, err
+			}
+			tree := &Tree{Task: t, Children: []*Tree{}}
+			kids := children[tid]
+			ts := make([]*Task, 0, len(kids))
+			for _, k := range kids {
+				if !seen[k] && users.AllUsers(all[k].Group)[user] {
+					ts = append(ts, all[k])
+				}
+			}
+			// Sort by due date, as GET /tasks does.
+			sort.Slice(ts, func(i, j int) bool {
+				return ts[i].ID.String() < ts[j].ID.String()
+			})
+			sort.Stable(ByOldest(ts))
+			for _, k := range ts {
+				sub, err := build(k.ID)
+				if err != nil {
+					return nil, err
+				}
+				tree.Children = append(tree.Children, sub)
+			}
+			return tree, nil
+		}
+
+		tree, err := build(id)
+		if err != nil {
+			return err
+		}
+		*into = *tree
+		return nil
+	}
+}
+
+// blockedBy returns true if the given Task is in t's BlockedBy.
+func blockedBy(t *Task, id ID) bool {
+	for _, b := range t.BlockedBy {
+		if b == id {
+			return true
+		}
+	}
+	return false
+}
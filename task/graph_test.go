@@ -0,0 +1,217 @@
+package task_test
+
+import (
+	"sort"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+// storeLinked stores a Task owned by bodie with the given ID, Parent and
+// BlockedBy.
+func (s *TaskSuite) storeLinked(
+	c *C,
+	id task.ID,
+	parent *task.ID,
+	blockers ...task.ID,
+) *task.Task {
+	t := &task.Task{
+		Group:     users.Group{Owner: "bodie"},
+		Name:      id.String(),
+		Parent:    parent,
+		BlockedBy: blockers,
+	}
+	c.Assert(s.Update(id.Store(t)), IsNil)
+	return t
+}
+
+func (s *TaskSuite) TestCheckLinks(c *C) {
+	a, b, d := task.ID(uuid.NewV4()), task.ID(uuid.NewV4()), task.ID(uuid.NewV4())
+	bobs := task.ID(uuid.NewV4())
+	s.storeLinked(c, a, nil)
+	s.storeLinked(c, b, &a, a)
+	s.storeLinked(c, d, &b, b)
+	c.Assert(s.Update(bobs.Store(&task.Task{
+		Group: users.Group{Owner: "bob"},
+	})), IsNil)
+
+	for i, test := range []struct {
+		should    string
+		given     *task.Task
+		expectErr string
+	}{{
+		should: "allow a task with no links",
+		given:  &task.Task{ID: a},
+	}, {
+		should: "allow a subtask of a subtask",
+		given:  &task.Task{ID: task.ID(uuid.NewV4()), Parent: &d},
+	}, {
+		should:    "not let a task be its own parent",
+		given:     &task.Task{ID: a, Parent: &a},
+		expectErr: "tasks form a cycle: " + a.String() + " -> " + a.String(),
+	}, {
+		should: "not let a task be its own ancestor",
+		given:  &task.Task{ID: a, Parent: &d},
+		expectErr: "tasks form a cycle: " + a.String() + " -> " +
+			d.String() + " -> " + b.String() + " -> " + a.String(),
+	}, {
+		should: "not let a task block one which blocks it",
+		given:  &task.Task{ID: a, BlockedBy: []task.ID{d}},
+		expectErr: "tasks form a cycle: " + a.String() + " -> " +
+			d.String() + " -> " + b.String() + " -> " + a.String(),
+	}, {
+		should: "not link to tasks which don't exist",
+		given: &task.Task{
+			ID: task.ID(uuid.NewV4()), BlockedBy: []task.ID{task.ID(uuid.Nil)},
+		},
+		expectErr: "task " + uuid.Nil.String() + " not found",
+	}, {
+		should: "not link to tasks bodie can't see",
+		given: &task.Task{
+			ID: task.ID(uuid.NewV4()), Parent: &bobs,
+		},
+		expectErr: "task " + bobs.String() + " not found",
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		err := s.View(task.CheckLinks(test.given, "bodie"))
+		switch {
+		case test.expectErr == "":
+			c.Check(err, IsNil)
+		default:
+			c.Check(err, ErrorMatches, test.expectErr)
+			c.Check(task.IsCycle(err) || task.IsMissing(err), Equals, true)
+		}
+	}
+}
+
+func (s *TaskSuite) TestGraphFilters(c *C) {
+	var (
+		done    = task.ID(uuid.NewV4())
+		open    = task.ID(uuid.NewV4())
+		blocked = task.ID(uuid.NewV4())
+		ready   = task.ID(uuid.NewV4())
+		pending = make(task.Pending)
+	)
+	c.Assert(s.Update(done.Store(&task.Task{
+		Group: users.Group{Owner: "bodie"}, Completed: true,
+	})), IsNil)
+	s.storeLinked(c, open, nil)
+	s.storeLinked(c, blocked, &open, done, open)
+	s.storeLinked(c, ready, &open, done)
+
+	c.Log("only the incomplete blockers of the given tasks are pending")
+	var all []*task.Task
+	c.Assert(s.View(func(tx *bolt.Tx) (err error) {
+		all, err = task.GetAll("bodie")(tx)
+		return
+	}), IsNil)
+	c.Assert(s.View(task.GetPending(all, pending)), IsNil)
+	c.Check(pending, DeepEquals, task.Pending{open: true})
+
+	names := func(fs ...task.Filter) []string {
+		var ts []*task.Task
+		c.Assert(s.View(func(tx *bolt.Tx) (err error) {
+			ts, err = task.GetAll("bodie", fs...)(tx)
+			return
+		}), IsNil)
+		var result []string
+		for _, t := range ts {
+			result = append(result, t.Name)
+		}
+		sort.Strings(result)
+		return result
+	}
+	sorted := func(ids ...task.ID) []string {
+		var result []string
+		for _, id := range ids {
+			result = append(result, id.String())
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	c.Check(names(task.Blocked(pending)), DeepEquals, sorted(blocked))
+	c.Check(names(task.NotBlocked(pending), task.Incomplete),
+		DeepEquals, sorted(open, ready))
+	c.Check(names(task.Ready(pending)), DeepEquals, sorted(open, ready))
+	c.Check(names(task.ChildOf(open)), DeepEquals, sorted(blocked, ready))
+}
+
+func (s *TaskSuite) TestUnblocksAndUnlink(c *C) {
+	var (
+		a, b  = task.ID(uuid.NewV4()), task.ID(uuid.NewV4())
+		both  = task.ID(uuid.NewV4())
+		onlyA = task.ID(uuid.NewV4())
+		ts    []*task.Task
+	)
+	s.storeLinked(c, a, nil)
+	s.storeLinked(c, b, nil)
+	s.storeLinked(c, both, &a, a, b)
+	s.storeLinked(c, onlyA, nil, a)
+
+	c.Log("completing a unblocks only the task it alone blocks")
+	c.Assert(s.Update(a.Store(&task.Task{
+		Group: users.Group{Owner: "bodie"}, Name: a.String(),
+		Completed: true,
+	})), IsNil)
+	c.Assert(s.View(task.Unblocks(a, &ts)), IsNil)
+	c.Assert(ts, HasLen, 1)
+	c.Check(ts[0].ID, Equals, onlyA)
+
+	c.Log("unlinking a clears its links")
+	c.Assert(s.Update(task.Unlink(a, &ts)), IsNil)
+	c.Check(ts, HasLen, 2)
+	got := new(task.Task)
+	c.Assert(s.View(both.Load(got)), IsNil)
+	c.Check(got.Parent, IsNil)
+	c.Check(got.BlockedBy, DeepEquals, []task.ID{b})
+	got = new(task.Task)
+	c.Assert(s.View(onlyA.Load(got)), IsNil)
+	c.Check(got.BlockedBy, HasLen, 0)
+}
+
+func (s *TaskSuite) TestGetTree(c *C) {
+	var (
+		now              = time.Now()
+		root, kid, other = task.ID(uuid.NewV4()), task.ID(uuid.NewV4()), task.ID(uuid.NewV4())
+		grandkid, hidden = task.ID(uuid.NewV4()), task.ID(uuid.NewV4())
+	)
+	s.storeLinked(c, root, nil)
+	s.storeLinked(c, other, &root)
+	c.Assert(s.Update(store.Wrap(
+		kid.Store(&task.Task{
+			Group:  users.Group{Owner: "bodie"},
+			Name:   kid.String(),
+			Parent: &root,
+			Due:    &now,
+			Notes:  []string{"first"},
+		}),
+		hidden.Store(&task.Task{
+			Group:  users.Group{Owner: "bob"},
+			Parent: &root,
+		}),
+	)), IsNil)
+	s.storeLinked(c, grandkid, &kid)
+
+	tree := new(task.Tree)
+	c.Assert(s.View(task.GetTree(root, "bodie", tree)), IsNil)
+	c.Check(tree.ID, Equals, root)
+	c.Assert(tree.Children, HasLen, 2)
+	c.Log("children are sorted by due date, and keep their notes")
+	c.Check(tree.Children[0].ID, Equals, kid)
+	c.Check(tree.Children[0].Notes, DeepEquals, []string{"first"})
+	c.Check(tree.Children[1].ID, Equals, other)
+	c.Assert(tree.Children[0].Children, HasLen, 1)
+	c.Check(tree.Children[0].Children[0].ID, Equals, grandkid)
+	c.Check(tree.Children[1].Children, HasLen, 0)
+
+	c.Log("users can't see the trees of tasks they can't see")
+	err := s.View(task.GetTree(hidden, "bodie", tree))
+	c.Check(err, Equals, task.ErrMissing(hidden))
+}
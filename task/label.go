@@ -0,0 +1,255 @@
+package task
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+)
+
+// LabelBucket indexes Tasks by label.  It holds a nested Bucket for
+// each label, keyed by the IDs of the Tasks which have it.
+// ColourBucket holds each user's label Colours by user ID.
+var (
+	LabelBucket  = store.Bucket("task-labels")
+	ColourBucket = store.Bucket("task-label-colours")
+)
+
+// Label limits.  A Task may have at most MaxLabels labels, each at most
+// MaxLabelLen bytes long.
+const (
+	MaxLabels   = 20
+	MaxLabelLen = 32
+)
+
+// ErrLabel is returned when a label is invalid.
+type ErrLabel struct{ Label, Reason string }
+
+func (e ErrLabel) Error() string {
+	return fmt.Sprintf("invalid label %q: %s", e.Label, e.Reason)
+}
+
+// IsLabel returns true if the error is an ErrLabel.
+func IsLabel(err error) bool {
+	_, ok := err.(ErrLabel)
+	return ok
+}
+
+// NormalizeLabel returns the label in lowercase without surrounding
+// space, or ErrLabel if it is empty, too long, or has characters other
+// than letters, digits, and "-", "_", "/", ":" or ".".
+func NormalizeLabel(l string) (string, error) {
+	n := strings.ToLower(strings.TrimSpace(l))
+	switch {
+	case n == "":
+		return "", ErrLabel{Label: l, Reason: "must not be empty"}
+	case len(n) > MaxLabelLen:
+		return "", ErrLabel{Label: l, Reason: fmt.Sprintf(
+			"must be at most %d bytes", MaxLabelLen,
+		)}
+	}
+	for _, c := range n {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+		case strings.ContainsRune("-_/:.", c):
+		default:
+			return "", ErrLabel{Label: l, Reason: fmt.Sprintf(
+				"must not contain %q", c,
+			)}
+		}
+	}
+	return n, nil
+}
+
+// NormalizeLabels normalizes each label using NormalizeLabel, and
+// returns them sorted and without repeats.  Too many labels is an
+// ErrLabel.
+func NormalizeLabels(ls []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var result []string
+	for _, l := range ls {
+		n, err := NormalizeLabel(l)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[n] {
+			seen[n] = true
+			result = append(result, n)
+		}
+	}
+	if len(result) > MaxLabels {
+		return nil, ErrLabel{
+			Label:  result[MaxLabels],
+			Reason: fmt.Sprintf("a task may have at most %d labels", MaxLabels),
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// HasLabel returns true if the Task has the given label.
+func (t *Task) HasLabel(l string) bool {
+	for _, tl := range t.Labels {
+		if tl == l {
+			return true
+		}
+	}
+	return false
+}
+
+// indexLabels returns a function which moves the Task with the given ID
+// from the index of each label in old to the index of each in new.
+func indexLabels(id ID, old, new func() []string) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		was, is := make(map[string]bool), make(map[string]bool)
+		for _, l := range old() {
+			was[l] = true
+		}
+		for _, l := range new() {
+			is[l] = true
+		}
+
+		index := tx.Bucket(LabelBucket)
+		for l := range was {
+			if is[l] {
+				continue
+			}
+			b, err := store.GetNestedBucket(index, store.Bucket(l))
+			switch {
+			case store.IsMissingBucket(err):
+				continue
+			case err != nil:
+				return err
+			}
+			if err := b.Delete(id[:]); err != nil {
+				return err
+			}
+			if k, _ := b.Cursor().First(); k == nil {
+				if err := index.DeleteBucket([]byte(l)); err != nil {
+					return err
+				}
+			}
+		}
+		for l := range is {
+			if was[l] {
+				continue
+			}
+			b, err := store.MakeNestedBucket(index, store.Bucket(l))
+			if err != nil {
+				return err
+			}
+			if err := b.Put(id[:], nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Labeled returns a function which loads the IDs of the Tasks with the
+// given label into into, using the label index.
+func Labeled(label string, into *[]ID) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		*into = nil
+		b, err := store.GetNestedBucket(
+			tx.Bucket(LabelBucket), store.Bucket(label),
+		)
+		switch {
+		case store.IsMissingBucket(err):
+			return nil
+		case err != nil:
+			return err
+		}
+		return b.ForEach(func(k, _ []byte) error {
+			var id ID
+			copy(id[:], k)
+			*into = append(*into, id)
+			return nil
+		})
+	}
+}
+
+// GetLabeled is like GetAll, but only looks at the Tasks with the given
+// label, using the label index.
+func GetLabeled(user, label string, filters ...Filter) func(*bolt.Tx) ([]*Task, error) {
+	return func(tx *bolt.Tx) ([]*Task, error) {
+		var ids []ID
+		if err := Labeled(label, &ids)(tx); err != nil {
+			return nil, err
+		}
+
+		var result []*Task
+		others := MultiAnd(filters)
+		for _, id := range ids {
+			next := new(Task)
+			err := id.Load(next)(tx)
+			switch {
+			case store.IsMissing(err):
+				continue
+			case err != nil:
+				return nil, err
+			case !users.AllUsers(next.Group)[user]:
+			case others.Member(next):
+				result = append(result, next)
+			}
+		}
+		return result, nil
+	}
+}
+
+// Colours are a user's colours for labels, such as "#ff0000", by label.
+type Colours map[string]string
+
+// validColour returns true if c is a colour such as "#f00" or "#ff0000".
+func validColour(c string) bool {
+	if len(c) != 4 && len(c) != 7 || c[0] != '#' {
+		return false
+	}
+	for _, h := range strings.ToLower(c[1:]) {
+		if !(h >= '0' && h <= '9' || h >= 'a' && h <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Normalize normalizes the Colours' labels and colours, returning an
+// ErrLabel if one is invalid.
+func (cs Colours) Normalize() (Colours, error) {
+	result := make(Colours, len(cs))
+	for l, c := range cs {
+		n, err := NormalizeLabel(l)
+		if err != nil {
+			return nil, err
+		}
+		if !validColour(c) {
+			return nil, ErrLabel{Label: l, Reason: fmt.Sprintf(
+				"colour %q is not #rgb or #rrggbb", c,
+			)}
+		}
+		result[n] = strings.ToLower(c)
+	}
+	return result, nil
+}
+
+// SetColours returns a function which sets the given user's Colours.
+func SetColours(user string, cs Colours) func(*bolt.Tx) error {
+	return store.Marshal(ColourBucket, cs, []byte(user))
+}
+
+// GetColours returns a function which loads the given user's Colours
+// into into.  They are empty if the user has set none.
+func GetColours(user string, into *Colours) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		*into = Colours{}
+		err := store.Unmarshal(ColourBucket, into, []byte(user))(tx)
+		if store.IsMissing(err) {
+			return nil
+		}
+		return err
+	}
+}
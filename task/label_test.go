@@ -0,0 +1,165 @@
+package task_test
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TaskSuite) TestNormalizeLabels(c *C) {
+	many := make([]string, task.MaxLabels+1)
+	for i := range many {
+		many[i] = strings.Repeat("x", i+1)
+	}
+
+	for i, test := range []struct {
+		should    string
+		given     []string
+		expect    []string
+		expectErr string
+	}{{
+		should: "lowercase, trim, sort and dedupe labels",
+		given:  []string{" Release ", "bug", "release", "area/ui"},
+		expect: []string{"area/ui", "bug", "release"},
+	}, {
+		should: "allow no labels",
+	}, {
+		should:    "not allow empty labels",
+		given:     []string{"bug", " "},
+		expectErr: `invalid label " ": must not be empty`,
+	}, {
+		should:    "not allow spaces",
+		given:     []string{"needs review"},
+		expectErr: `invalid label "needs review": must not contain ' '`,
+	}, {
+		should:    "not allow long labels",
+		given:     []string{strings.Repeat("a", task.MaxLabelLen+1)},
+		expectErr: `invalid label "a+": must be at most 32 bytes`,
+	}, {
+		should:    "not allow too many labels",
+		given:     many,
+		expectErr: `invalid label "x+": a task may have at most 20 labels`,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		got, err := task.NormalizeLabels(test.given)
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+			c.Check(task.IsLabel(err), Equals, true)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Check(got, DeepEquals, test.expect)
+	}
+}
+
+func (s *TaskSuite) TestPriorityJSON(c *C) {
+	bs, err := json.Marshal(&task.Task{Name: "x", Priority: task.High})
+	c.Assert(err, IsNil)
+	c.Check(string(bs), Matches, `.*"priority":"high".*`)
+
+	got := new(task.Task)
+	c.Assert(json.Unmarshal([]byte(`{"priority":"URGENT"}`), got), IsNil)
+	c.Check(got.Priority, Equals, task.Urgent)
+
+	err = json.Unmarshal([]byte(`{"priority":"asap"}`), got)
+	c.Check(err, ErrorMatches,
+		`unknown priority "asap"; use none, low, normal, high, urgent`)
+}
+
+func (s *TaskSuite) TestLabelIndex(c *C) {
+	var (
+		mine   = users.Group{Owner: "bodie"}
+		theirs = users.Group{Owner: "bob"}
+
+		a, b, d = task.ID(uuid.NewV4()), task.ID(uuid.NewV4()),
+			task.ID(uuid.NewV4())
+	)
+	c.Assert(s.Update(a.Store(&task.Task{
+		Group: mine, Name: "a", Labels: []string{"bug", "release"},
+	})), IsNil)
+	c.Assert(s.Update(b.Store(&task.Task{
+		Group: mine, Name: "b", Labels: []string{"release"},
+		Priority: task.High,
+	})), IsNil)
+	c.Assert(s.Update(d.Store(&task.Task{
+		Group: theirs, Name: "d", Labels: []string{"release"},
+	})), IsNil)
+
+	labeled := func(label string) map[task.ID]bool {
+		var ids []task.ID
+		c.Assert(s.View(task.Labeled(label, &ids)), IsNil)
+		result := make(map[task.ID]bool)
+		for _, id := range ids {
+			result[id] = true
+		}
+		return result
+	}
+	names := func(user, label string, fs ...task.Filter) []string {
+		var ts []*task.Task
+		var err error
+		c.Assert(s.View(func(tx *bolt.Tx) error {
+			ts, err = task.GetLabeled(user, label, fs...)(tx)
+			return err
+		}), IsNil)
+		result := []string{}
+		for _, t := range ts {
+			result = append(result, t.Name)
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	c.Log("tasks are indexed by each of their labels")
+	c.Check(labeled("release"), DeepEquals, map[task.ID]bool{
+		a: true, b: true, d: true,
+	})
+	c.Check(labeled("bug"), DeepEquals, map[task.ID]bool{a: true})
+	c.Check(labeled("nope"), DeepEquals, map[task.ID]bool{})
+
+	c.Log("users only get their own labeled tasks, filtered")
+	c.Check(names("bodie", "release"), DeepEquals, []string{"a", "b"})
+	c.Check(names("bodie", "release",
+		task.PriorityIs{Op: task.GreaterEq, Priority: task.Normal},
+	), DeepEquals, []string{"b"})
+	c.Check(names("bob", "bug"), DeepEquals, []string{})
+
+	c.Log("changing labels moves tasks in the index")
+	c.Assert(s.Update(a.Store(&task.Task{
+		Group: mine, Name: "a", Labels: []string{"docs"},
+	})), IsNil)
+	c.Check(labeled("bug"), DeepEquals, map[task.ID]bool{})
+	c.Check(labeled("docs"), DeepEquals, map[task.ID]bool{a: true})
+	c.Check(labeled("release"), DeepEquals, map[task.ID]bool{
+		b: true, d: true,
+	})
+
+	c.Log("deleting tasks removes them from the index")
+	c.Assert(s.Update(a.Delete), IsNil)
+	c.Check(labeled("docs"), DeepEquals, map[task.ID]bool{})
+	c.Check(names("bodie", "docs"), DeepEquals, []string{})
+}
+
+func (s *TaskSuite) TestColours(c *C) {
+	var got task.Colours
+	c.Assert(s.View(task.GetColours("bodie", &got)), IsNil)
+	c.Check(got, DeepEquals, task.Colours{})
+
+	_, err := task.Colours{"bug": "red"}.Normalize()
+	c.Check(err, ErrorMatches,
+		`invalid label "bug": colour "red" is not #rgb or #rrggbb`)
+
+	cs, err := task.Colours{"Bug": "#F00", "release": "#00ff00"}.Normalize()
+	c.Assert(err, IsNil)
+	c.Assert(s.Update(task.SetColours("bodie", cs)), IsNil)
+	c.Assert(s.View(task.GetColours("bodie", &got)), IsNil)
+	c.Check(got, DeepEquals, task.Colours{
+		"bug": "#f00", "release": "#00ff00",
+	})
+}
@@ -0,0 +1,67 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Priority is how urgent a Task is.  Higher Priorities are more urgent.
+// It is marshaled to JSON by name, such as "high".
+type Priority int
+
+// Priorities a Task can have.  A Task with NoPriority has not been
+// given one.
+const (
+	NoPriority Priority = iota
+	Low
+	Normal
+	High
+	Urgent
+)
+
+var priorityNames = []string{"none", "low", "normal", "high", "urgent"}
+
+// ParsePriority returns the Priority with the given name, ignoring case,
+// or an error if there is none.
+func ParsePriority(name string) (Priority, error) {
+	for i, n := range priorityNames {
+		if strings.EqualFold(name, n) {
+			return Priority(i), nil
+		}
+	}
+	return NoPriority, fmt.Errorf(
+		"unknown priority %q; use %s",
+		name, strings.Join(priorityNames, ", "),
+	)
+}
+
+// String implements fmt.Stringer on Priority.
+func (p Priority) String() string {
+	if p < NoPriority || p > Urgent {
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+	return priorityNames[p]
+}
+
+// MarshalJSON implements json.Marshaler on Priority.
+func (p Priority) MarshalJSON() ([]byte, error) {
+	if p < NoPriority || p > Urgent {
+		return nil, fmt.Errorf("invalid priority %d", int(p))
+	}
+	return json.Marshal(p.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler on Priority.
+func (p *Priority) UnmarshalJSON(from []byte) error {
+	var s string
+	if err := json.Unmarshal(from, &s); err != nil {
+		return err
+	}
+	pr, err := ParsePriority(s)
+	if err != nil {
+		return err
+	}
+	*p = pr
+	return nil
+}
@@ -0,0 +1,434 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ProjectBucket holds Projects by ID.
+var ProjectBucket = store.Bucket("task-projects")
+
+// ProjectID is the ID of a Project.
+type ProjectID store.ID
+
+// String implements fmt.Stringer on ProjectID.
+func (p ProjectID) String() string { return uuid.UUID(p).String() }
+
+// MarshalJSON implements json.Marshaler on ProjectID.
+func (p ProjectID) MarshalJSON() ([]byte, error) {
+	return store.ID(p).MarshalJSON()
+}
+
+// UnmarshalJSON implements json.Unmarshaler on ProjectID.
+func (p *ProjectID) UnmarshalJSON(from []byte) error {
+	into := new(store.ID)
+	if err := json.Unmarshal(from, into); err != nil {
+		return err
+	}
+	*p = ProjectID(*into)
+	return nil
+}
+
+// Project is a kanban board of Tasks in ordered Columns.  A Task can be
+// on one Project at a time, and its Group includes the Project's users
+// while it is there; see Task.Inherit.
+type Project struct {
+	users.Group
+
+	ID      ProjectID `json:"id"`
+	Name    string    `json:"name"`
+	Columns []*Column `json:"columns"`
+}
+
+// Column is a named, ordered list of the Tasks on a Project.
+type Column struct {
+	Name  string `json:"name"`
+	Tasks []ID   `json:"tasks"`
+}
+
+// Resource implements Resourcer on Project.
+func (*Project) Resource() store.Resource { return "projects" }
+
+// ProjectDeleted is a Resourcer which can notify that a Project has been
+// deleted.
+type ProjectDeleted ProjectID
+
+// Resource implements Resourcer on ProjectDeleted.
+func (ProjectDeleted) Resource() store.Resource { return "project-deleted" }
+
+// MarshalJSON implements json.Marshaler on ProjectDeleted.
+func (p ProjectDeleted) MarshalJSON() ([]byte, error) {
+	return ProjectID(p).MarshalJSON()
+}
+
+// ProjectRemoved is a Resourcer which can notify that the user has been
+// removed from a Project without showing them the Project itself.
+type ProjectRemoved ProjectID
+
+// Resource implements Resourcer on ProjectRemoved.
+func (ProjectRemoved) Resource() store.Resource { return "project-removed" }
+
+// MarshalJSON implements json.Marshaler on ProjectRemoved.
+func (p ProjectRemoved) MarshalJSON() ([]byte, error) {
+	return ProjectID(p).MarshalJSON()
+}
+
+// ErrProjectMissing is returned when a Project does not exist.
+type ErrProjectMissing ProjectID
+
+func (e ErrProjectMissing) Error() string {
+	return fmt.Sprintf("project %s not found", ProjectID(e))
+}
+
+// IsProjectMissing returns true if the error is an ErrProjectMissing.
+func IsProjectMissing(err error) bool {
+	_, ok := err.(ErrProjectMissing)
+	return ok
+}
+
+// ErrColumn is returned when a Project's Columns are invalid, or a Task
+// is moved to a Column which does not exist.
+type ErrColumn struct{ Column, Reason string }
+
+func (e ErrColumn) Error() string {
+	return fmt.Sprintf("column %q %s", e.Column, e.Reason)
+}
+
+// IsColumn returns true if the error is an ErrColumn.
+func IsColumn(err error) bool {
+	_, ok := err.(ErrColumn)
+	return ok
+}
+
+// ErrOnProject is returned when a Task can't be placed on a Project
+// because it is already on another.
+type ErrOnProject struct {
+	Task    ID
+	Project ProjectID
+}
+
+func (e ErrOnProject) Error() string {
+	return fmt.Sprintf("task %s is on project %s", e.Task, e.Project)
+}
+
+// IsOnProject returns true if the error is an ErrOnProject.
+func IsOnProject(err error) bool {
+	_, ok := err.(ErrOnProject)
+	return ok
+}
+
+// Validate returns an ErrColumn if the Project has no Columns, or if a
+// Column's name is empty or repeated.  Column names are trimmed.
+func (p *Project) Validate() error {
+	if len(p.Columns) == 0 {
+		return ErrColumn{Reason: "must be given; a project needs a column"}
+	}
+	seen := make(map[string]bool)
+	for _, col := range p.Columns {
+		col.Name = strings.TrimSpace(col.Name)
+		switch {
+		case col.Name == "":
+			return ErrColumn{Column: col.Name, Reason: "must have a name"}
+		case seen[col.Name]:
+			return ErrColumn{Column: col.Name, Reason: "given more than once"}
+		}
+		seen[col.Name] = true
+	}
+	return nil
+}
+
+// Find returns the index of the Column the Task with the given ID is in,
+// and its index in the Column, or -1, -1 if it is not on the Project.
+func (p *Project) Find(id ID) (col, pos int) {
+	for i, c := range p.Columns {
+		for j, t := range c.Tasks {
+			if t == id {
+				return i, j
+			}
+		}
+	}
+	return -1, -1
+}
+
+// remove removes the Task with the given ID from its Column, returning
+// false if it was not on the Project.
+func (p *Project) remove(id ID) bool {
+	col, pos := p.Find(id)
+	if col < 0 {
+		return false
+	}
+	c := p.Columns[col]
+	c.Tasks = append(c.Tasks[:pos], c.Tasks[pos+1:]...)
+	return true
+}
+
+// Move moves the Task with the given ID to the given index of the named
+// Column, adding it to the Project if it is not already on it.  If pos
+// is negative or past the end of the Column, it is moved to the end.
+// It returns ErrColumn if there is no such Column.
+func (p *Project) Move(id ID, column string, pos int) error {
+	var to *Column
+	for _, c := range p.Columns {
+		if c.Name == column {
+			to = c
+			break
+		}
+	}
+	if to == nil {
+		return ErrColumn{Column: column, Reason: "not found"}
+	}
+
+	p.remove(id)
+	if pos < 0 || pos > len(to.Tasks) {
+		pos = len(to.Tasks)
+	}
+	to.Tasks = append(to.Tasks, id)
+	copy(to.Tasks[pos+1:], to.Tasks[pos:])
+	to.Tasks[pos] = id
+	return nil
+}
+
+// Recolumn replaces the Project's Columns with the given ones, which
+// must be valid, keeping the Tasks of each Column by name.  It returns
+// ErrColumn if a removed Column still has Tasks.
+func (p *Project) Recolumn(columns []*Column) error {
+	tasks := make(map[string][]ID)
+	for _, c := range p.Columns {
+		tasks[c.Name] = c.Tasks
+	}
+	for _, c := range columns {
+		c.Tasks = tasks[c.Name]
+		delete(tasks, c.Name)
+	}
+	for name, ts := range tasks {
+		if len(ts) > 0 {
+			return ErrColumn{Column: name, Reason: "still has tasks"}
+		}
+	}
+	p.Columns = columns
+	return nil
+}
+
+// Inherit makes the Task's Readers and Writers include the users of the
+// given Project, and no longer include users it only had because of a
+// Project.  It sets the Task's Project to the given Project, or clears
+// it if the Project is nil.  The Task's maps are copied, not modified.
+func (t *Task) Inherit(p *Project) {
+	t.Readers = without(t.Readers, t.ProjectReaders)
+	t.Writers = without(t.Writers, t.ProjectWriters)
+	t.ProjectReaders, t.ProjectWriters = nil, nil
+	if p == nil {
+		t.Project = nil
+		return
+	}
+
+	id := p.ID
+	t.Project = &id
+	grant := func(u string, to map[string]bool, inherited *map[string]bool) {
+		if u == t.Owner || to[u] {
+			return
+		}
+		if *inherited == nil {
+			*inherited = make(map[string]bool)
+		}
+		to[u], (*inherited)[u] = true, true
+	}
+
+	if t.Readers == nil {
+		t.Readers = make(map[string]bool)
+	}
+	if t.Writers == nil {
+		t.Writers = make(map[string]bool)
+	}
+	// Writers are also Readers.
+	for u := range users.AllUsers(p.Group) {
+		grant(u, t.Readers, &t.ProjectReaders)
+	}
+	grant(p.Owner, t.Writers, &t.ProjectWriters)
+	for u, ok := range p.Writers {
+		if ok {
+			grant(u, t.Writers, &t.ProjectWriters)
+		}
+	}
+}
+
+// without returns a copy of the given users without those in drop, or
+// nil if users is nil.
+func without(users, drop map[string]bool) map[string]bool {
+	if users == nil {
+		return nil
+	}
+	result := make(map[string]bool, len(users))
+	for u, ok := range users {
+		if ok && !drop[u] {
+			result[u] = true
+		}
+	}
+	return result
+}
+
+// GetProject returns a function which loads the Project with the given
+// ID into into, or returns ErrProjectMissing.
+func GetProject(id ProjectID, into *Project) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		err := store.Unmarshal(ProjectBucket, into, id[:])(tx)
+		if store.IsMissing(err) {
+			return ErrProjectMissing(id)
+		}
+		return err
+	}
+}
+
+// PutProject returns a function which stores the Project by its ID.
+func PutProject(p *Project) func(*bolt.Tx) error {
+	return store.Marshal(ProjectBucket, p, p.ID[:])
+}
+
+// GetProjects returns a function which unmarshals all Projects the user
+// is in.
+func GetProjects(user string) func(*bolt.Tx) ([]*Project, error) {
+	return func(tx *bolt.Tx) ([]*Project, error) {
+		var result []*Project
+		err := store.ForEach(ProjectBucket, func(k, v []byte) error {
+			next := new(Project)
+			if err := json.Unmarshal(v, next); err != nil {
+				return err
+			}
+			if users.AllUsers(next.Group)[user] {
+				result = append(result, next)
+			}
+			return nil
+		})(tx)
+		return result, err
+	}
+}
+
+// ResolveProject returns a function which makes the Task Inherit the
+// users of the Project it is on, if it is on one.  If its Project no
+// longer exists, the Task is taken off it.
+func ResolveProject(t *Task) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if t.Project == nil {
+			t.Inherit(nil)
+			return nil
+		}
+		p := new(Project)
+		err := GetProject(*t.Project, p)(tx)
+		switch {
+		case IsProjectMissing(err):
+			t.Inherit(nil)
+			return nil
+		case err != nil:
+			return err
+		}
+		t.Inherit(p)
+		return nil
+	}
+}
+
+// storeTask stores the Task, keeping its Notes.
+func storeTask(t *Task) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		notes := t.Notes
+		err := t.ID.Store(t)(tx)
+		t.Notes, t.Resources = notes, nil
+		return err
+	}
+}
+
+// Place returns a function which moves the Task to the given index of
+// the named Column of the Project, as in Project.Move, and stores both.
+// The Task Inherits the Project's users.  It returns ErrOnProject if the
+// Task is on a different Project.
+func Place(p *Project, t *Task, column string, pos int) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if t.Project != nil && *t.Project != p.ID {
+			return ErrOnProject{Task: t.ID, Project: *t.Project}
+		}
+		if err := p.Move(t.ID, column, pos); err != nil {
+			return err
+		}
+		t.Inherit(p)
+		return store.Wrap(PutProject(p), storeTask(t))(tx)
+	}
+}
+
+// Unplace returns a function which takes the Task off the Project and
+// stores both.  The Task loses the users it had because of the Project.
+// It returns ErrMissing if the Task is not on the Project.
+func Unplace(p *Project, t *Task) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if !p.remove(t.ID) {
+			return ErrMissing(t.ID)
+		}
+		t.Inherit(nil)
+		return store.Wrap(PutProject(p), storeTask(t))(tx)
+	}
+}
+
+// SyncProject returns a function which makes each Task on the Project
+// Inherit its current users, and stores them.  The Tasks are appended
+// to into so their users can be notified.
+func SyncProject(p *Project, into *[]*Task) func(*bolt.Tx) error {
+	return eachOnProject(p, into, func(t *Task) { t.Inherit(p) })
+}
+
+// DeleteProject returns a function which takes every Task off the
+// Project and deletes it.  The Tasks are appended to into so their
+// users can be notified.
+func DeleteProject(p *Project, into *[]*Task) func(*bolt.Tx) error {
+	return store.Wrap(
+		eachOnProject(p, into, func(t *Task) { t.Inherit(nil) }),
+		store.Delete(ProjectBucket, p.ID[:]),
+	)
+}
+
+// eachOnProject returns a function which applies f to each Task on the
+// Project and stores it, appending it to into.
+func eachOnProject(p *Project, into *[]*Task, f func(*Task)) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		for _, c := range p.Columns {
+			for _, id := range c.Tasks {
+				t := new(Task)
+				err := id.Load(t)(tx)
+				switch {
+				case store.IsMissing(err):
+					continue
+				case err != nil:
+					return err
+				}
+				f(t)
+				if err := storeTask(t)(tx); err != nil {
+					return err
+				}
+				*into = append(*into, t)
+			}
+		}
+		return nil
+	}
+}
+
+// DropFromProject returns a function which removes the Task with the
+// given ID from the Project with the given ID, loading the Project into
+// into.  It is used when the Task is deleted.  If the Project does not
+// exist, into is left unchanged.
+func DropFromProject(pid ProjectID, id ID, into *Project) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		err := GetProject(pid, into)(tx)
+		switch {
+		case IsProjectMissing(err):
+			return nil
+		case err != nil:
+			return err
+		}
+		into.remove(id)
+		return PutProject(into)(tx)
+	}
+}
@@ -0,0 +1,193 @@
+package task_test
+
+import (
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TaskSuite) TestProjectMove(c *C) {
+	var (
+		a, b, d = task.ID(uuid.NewV4()), task.ID(uuid.NewV4()),
+			task.ID(uuid.NewV4())
+
+		p = &task.Project{Columns: []*task.Column{
+			{Name: "todo"}, {Name: "doing"}, {Name: "done"},
+		}}
+	)
+	board := func() [][]task.ID {
+		var result [][]task.ID
+		for _, col := range p.Columns {
+			result = append(result, col.Tasks)
+		}
+		return result
+	}
+
+	for i, test := range []struct {
+		should    string
+		id        task.ID
+		column    string
+		pos       int
+		expect    [][]task.ID
+		expectErr string
+	}{{
+		should: "add tasks at the end of a column",
+		id:     a, column: "todo", pos: -1,
+		expect: [][]task.ID{{a}, nil, nil},
+	}, {
+		should: "add tasks past the end at the end",
+		id:     b, column: "todo", pos: 5,
+		expect: [][]task.ID{{a, b}, nil, nil},
+	}, {
+		should: "add tasks at an index",
+		id:     d, column: "todo", pos: 0,
+		expect: [][]task.ID{{d, a, b}, nil, nil},
+	}, {
+		should: "reorder tasks in a column",
+		id:     b, column: "todo", pos: 1,
+		expect: [][]task.ID{{d, b, a}, nil, nil},
+	}, {
+		should: "move tasks between columns",
+		id:     d, column: "doing", pos: 0,
+		expect: [][]task.ID{{b, a}, {d}, nil},
+	}, {
+		should: "not move tasks to missing columns",
+		id:     a, column: "Done", pos: 0,
+		expectErr: `column "Done" not found`,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		err := p.Move(test.id, test.column, test.pos)
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+			c.Check(task.IsColumn(err), Equals, true)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Check(board(), DeepEquals, test.expect)
+	}
+
+	c.Log("columns keep their tasks when renamed or reordered")
+	err := p.Recolumn([]*task.Column{{Name: "doing"}, {Name: "done"}})
+	c.Check(err, ErrorMatches, `column "todo" still has tasks`)
+	c.Assert(p.Recolumn([]*task.Column{
+		{Name: "doing"}, {Name: "review"}, {Name: "todo"},
+	}), IsNil)
+	c.Check(board(), DeepEquals, [][]task.ID{{d}, nil, {b, a}})
+	col, pos := p.Find(a)
+	c.Check([]int{col, pos}, DeepEquals, []int{2, 1})
+}
+
+func (s *TaskSuite) TestProjectValidate(c *C) {
+	for i, test := range []struct {
+		given     []*task.Column
+		expectErr string
+	}{
+		{nil, `column "" must be given; a project needs a column`},
+		{[]*task.Column{{Name: " "}}, `column "" must have a name`},
+		{[]*task.Column{{Name: "a"}, {Name: "a "}}, `column "a" given more than once`},
+		{[]*task.Column{{Name: "a"}, {Name: "b"}}, ""},
+	} {
+		c.Logf("test %d", i)
+		err := (&task.Project{Columns: test.given}).Validate()
+		if test.expectErr == "" {
+			c.Check(err, IsNil)
+			continue
+		}
+		c.Check(err, ErrorMatches, test.expectErr)
+	}
+}
+
+func (s *TaskSuite) TestProjectInherit(c *C) {
+	var (
+		pid  = task.ProjectID(uuid.NewV4())
+		proj = &task.Project{
+			Group: users.Group{
+				Owner:   "bodie",
+				Readers: map[string]bool{"alice": true, "bob": true},
+				Writers: map[string]bool{"bob": true},
+			},
+			ID:      pid,
+			Columns: []*task.Column{{Name: "todo"}, {Name: "done"}},
+		}
+		tID = task.ID(uuid.NewV4())
+		tsk = &task.Task{
+			Group: users.Group{
+				Owner:   "bodie",
+				Readers: map[string]bool{"bodie": true, "alice": true},
+				Writers: map[string]bool{"bodie": true},
+			},
+			Name:  "chore",
+			Notes: []string{"sweep"},
+		}
+	)
+	c.Assert(s.Update(store.Wrap(
+		task.PutProject(proj),
+		tID.Store(tsk),
+	)), IsNil)
+	tsk.Notes = []string{"sweep"}
+
+	c.Log("tasks placed on a project get its users")
+	c.Assert(s.Update(task.Place(proj, tsk, "todo", -1)), IsNil)
+	c.Check(tsk.Notes, DeepEquals, []string{"sweep"})
+	got := new(task.Task)
+	c.Assert(s.View(tID.Load(got)), IsNil)
+	c.Check(*got.Project, Equals, pid)
+	c.Check(got.Readers, DeepEquals, map[string]bool{
+		"bodie": true, "alice": true, "bob": true,
+	})
+	c.Check(got.Writers, DeepEquals, map[string]bool{
+		"bodie": true, "bob": true,
+	})
+	c.Check(got.ProjectReaders, DeepEquals, map[string]bool{"bob": true})
+	c.Check(got.ProjectWriters, DeepEquals, map[string]bool{"bob": true})
+	c.Check(got.Notes, DeepEquals, []string{"sweep"})
+	gotP := new(task.Project)
+	c.Assert(s.View(task.GetProject(pid, gotP)), IsNil)
+	c.Check(gotP.Columns[0].Tasks, DeepEquals, []task.ID{tID})
+
+	c.Log("tasks can't be on two projects")
+	other := &task.Project{
+		ID: task.ProjectID(uuid.NewV4()), Columns: []*task.Column{{Name: "x"}},
+	}
+	err := s.Update(task.Place(other, tsk, "x", -1))
+	c.Check(task.IsOnProject(err), Equals, true)
+
+	c.Log("changing the project's users changes its tasks'")
+	delete(proj.Readers, "bob")
+	delete(proj.Writers, "bob")
+	proj.Readers["carol"] = true
+	var synced []*task.Task
+	c.Assert(s.Update(store.Wrap(
+		task.PutProject(proj),
+		task.SyncProject(proj, &synced),
+	)), IsNil)
+	c.Assert(synced, HasLen, 1)
+	c.Check(synced[0].Readers, DeepEquals, map[string]bool{
+		"bodie": true, "alice": true, "carol": true,
+	})
+	c.Check(synced[0].Writers, DeepEquals, map[string]bool{"bodie": true})
+
+	c.Log("tasks leave a project with only their own users")
+	c.Assert(s.Update(task.Unplace(proj, tsk)), IsNil)
+	c.Check(tsk.Project, IsNil)
+	c.Check(tsk.Readers, DeepEquals, map[string]bool{
+		"bodie": true, "alice": true,
+	})
+	c.Check(task.IsMissing(s.Update(task.Unplace(proj, tsk))), Equals, true)
+
+	c.Log("deleting a project takes its tasks off it")
+	c.Assert(s.Update(task.Place(proj, tsk, "done", -1)), IsNil)
+	var freed []*task.Task
+	c.Assert(s.Update(task.DeleteProject(proj, &freed)), IsNil)
+	c.Assert(freed, HasLen, 1)
+	c.Check(freed[0].Project, IsNil)
+	c.Check(freed[0].Readers, DeepEquals, map[string]bool{
+		"bodie": true, "alice": true,
+	})
+	err = s.View(task.GetProject(pid, gotP))
+	c.Check(err, ErrorMatches, "project .* not found")
+	c.Check(task.IsProjectMissing(err), Equals, true)
+}
@@ -0,0 +1,498 @@
+package task
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// ErrQuery is returned when a query or sort can't be parsed.  Pos is
+// the byte offset in it where the problem is.
+type ErrQuery struct {
+	Pos    int
+	Reason string
+
+	// Sort is true if the error is in sort keys, not a query.
+	Sort bool
+}
+
+func (e ErrQuery) Error() string {
+	of := "query"
+	if e.Sort {
+		of = "sort"
+	}
+	return fmt.Sprintf("bad %s at position %d: %s", of, e.Pos+1, e.Reason)
+}
+
+// IsQuery returns true if the error is an ErrQuery.
+func IsQuery(err error) bool {
+	_, ok := err.(ErrQuery)
+	return ok
+}
+
+// token is a word, quoted string or parenthesis of a query.
+type token struct {
+	text   string
+	pos    int
+	quoted bool
+}
+
+// lex splits a query into tokens.  Words end at spaces and parentheses,
+// and may contain quoted strings, such as in name:"weekly chores".
+func lex(q string) ([]token, error) {
+	var ts []token
+	for i := 0; i < len(q); {
+		switch c := q[i]; {
+		case unicode.IsSpace(rune(c)):
+			i++
+			continue
+		case c == '(' || c == ')':
+			ts = append(ts, token{text: q[i : i+1], pos: i})
+			i++
+			continue
+		}
+
+		start := i
+		var (
+			text   strings.Builder
+			quoted bool
+		)
+		for i < len(q) && !unicode.IsSpace(rune(q[i])) &&
+			q[i] != '(' && q[i] != ')' {
+			if q[i] != '"' {
+				text.WriteByte(q[i])
+				i++
+				continue
+			}
+			end := strings.IndexByte(q[i+1:], '"')
+			if end < 0 {
+				return nil, ErrQuery{Pos: i, Reason: "unterminated quote"}
+			}
+			text.WriteString(q[i+1 : i+1+end])
+			i += end + 2
+			quoted = true
+		}
+		ts = append(ts, token{text: text.String(), pos: start, quoted: quoted})
+	}
+	return ts, nil
+}
+
+// Compare is how a Filter such as DueIs compares a Task's value to its
+// own.
+type Compare string
+
+// Comparisons, from longest to shortest so they can be found in order.
+const (
+	LessEq    Compare = "<="
+	GreaterEq Compare = ">="
+	Less      Compare = "<"
+	Greater   Compare = ">"
+	Equal     Compare = "="
+	Has       Compare = ":"
+)
+
+var compares = []Compare{LessEq, GreaterEq, Less, Greater, Equal, Has}
+
+// compare returns the result of comparing a to b using c, given the
+// sign of a - b.
+func (c Compare) compare(sign int) bool {
+	switch c {
+	case LessEq:
+		return sign <= 0
+	case GreaterEq:
+		return sign >= 0
+	case Less:
+		return sign < 0
+	case Greater:
+		return sign > 0
+	case Equal, Has:
+		return sign == 0
+	}
+	return false
+}
+
+// field compiles a term of a query such as owner:bodie into a Filter.
+type field func(op Compare, val string, now time.Time) (Filter, error)
+
+// userField returns a field for Filters on a username.
+func userField(f func(string) Filter) field {
+	return func(_ Compare, val string, _ time.Time) (Filter, error) {
+		return f(val), nil
+	}
+}
+
+// ordered are the fields which can be compared using <, >, etc.  The
+// rest can only be matched, as in owner:bodie.
+var ordered = map[string]bool{"due": true, "bounty": true, "priority": true}
+
+// fields are the fields a query can have, by lowercase name.
+var fields = map[string]field{
+	"owner":       userField(func(u string) Filter { return ByOwner(u) }),
+	"reader":      userField(func(u string) Filter { return ByReader(u) }),
+	"writer":      userField(func(u string) Filter { return ByWriter(u) }),
+	"completedby": userField(func(u string) Filter { return ByCompleter(u) }),
+	"due": func(op Compare, val string, now time.Time) (Filter, error) {
+		if op == Has {
+			op = Equal
+		}
+		t, err := parseWhen(val, now)
+		if err != nil {
+			return nil, err
+		}
+		return DueIs{Op: op, Time: t}, nil
+	},
+	"bounty": func(op Compare, val string, _ time.Time) (Filter, error) {
+		if op == Has {
+			op = Equal
+		}
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bounty %q is not a number", val)
+		}
+		return BountyIs{Op: op, Bounty: n}, nil
+	},
+	"priority": func(op Compare, val string, _ time.Time) (Filter, error) {
+		if op == Has {
+			op = Equal
+		}
+		p, err := ParsePriority(val)
+		if err != nil {
+			return nil, err
+		}
+		return PriorityIs{Op: op, Priority: p}, nil
+	},
+	"label": func(_ Compare, val string, _ time.Time) (Filter, error) {
+		l, err := NormalizeLabel(val)
+		if err != nil {
+			return nil, err
+		}
+		return ByLabel(l), nil
+	},
+	"is": func(_ Compare, val string, now time.Time) (Filter, error) {
+		switch val {
+		case "complete":
+			return Complete, nil
+		case "incomplete":
+			return Incomplete, nil
+		case "overdue":
+			return Overdue(now), nil
+		}
+		return nil, fmt.Errorf(
+			"unknown is:%s; use complete, incomplete or overdue", val,
+		)
+	},
+}
+
+// parseWhen parses a due date in a query: "now", a date such as
+// 2017-01-31, or an RFC 3339 time.
+func parseWhen(val string, now time.Time) (time.Time, error) {
+	if val == "now" {
+		return now, nil
+	}
+	if t, err := time.Parse("2006-01-02", val); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return t, fmt.Errorf("due date %q is not now, YYYY-MM-DD or RFC 3339", val)
+	}
+	return t, nil
+}
+
+// parser parses a query's tokens.
+type parser struct {
+	query string
+	ts    []token
+	at    int
+	now   time.Time
+}
+
+func (p *parser) peek() *token {
+	if p.at < len(p.ts) {
+		return &p.ts[p.at]
+	}
+	return nil
+}
+
+// keyword returns true if the next token is the given unquoted keyword.
+func (p *parser) keyword(kw string) bool {
+	t := p.peek()
+	return t != nil && !t.quoted && strings.ToLower(t.text) == kw
+}
+
+// or = and { "or" and }
+func (p *parser) or() (Filter, error) {
+	f, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	any := MultiOr{f}
+	for p.keyword("or") {
+		p.at++
+		f, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		any = append(any, f)
+	}
+	if len(any) == 1 {
+		return any[0], nil
+	}
+	return any, nil
+}
+
+// and = unary { ["and"] unary }
+func (p *parser) and() (Filter, error) {
+	f, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	all := MultiAnd{f}
+	for {
+		t := p.peek()
+		switch {
+		case t == nil, t.text == ")" && !t.quoted, p.keyword("or"):
+			if len(all) == 1 {
+				return all[0], nil
+			}
+			return all, nil
+		case p.keyword("and"):
+			p.at++
+		}
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, f)
+	}
+}
+
+// unary = "not" unary | "(" or ")" | term
+func (p *parser) unary() (Filter, error) {
+	t := p.peek()
+	switch {
+	case t == nil:
+		return nil, ErrQuery{
+			Pos: len(p.query), Reason: "unexpected end of query",
+		}
+	case p.keyword("not"):
+		p.at++
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{f}, nil
+	case t.quoted:
+		p.at++
+		return p.term(t)
+	}
+
+	switch t.text {
+	case "(":
+		p.at++
+		f, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.peek(); c == nil || c.text != ")" || c.quoted {
+			return nil, ErrQuery{Pos: t.pos, Reason: "unclosed ("}
+		}
+		p.at++
+		return f, nil
+	case ")":
+		return nil, ErrQuery{Pos: t.pos, Reason: "unexpected )"}
+	}
+	switch strings.ToLower(t.text) {
+	case "and", "or":
+		return nil, ErrQuery{
+			Pos: t.pos, Reason: fmt.Sprintf("unexpected %q", t.text),
+		}
+	}
+	p.at++
+	return p.term(t)
+}
+
+// term compiles a field term such as bounty>=5, or text to match in
+// the name and notes of Tasks.
+func (p *parser) term(t *token) (Filter, error) {
+	raw := p.query[t.pos:]
+	for i, c := range raw {
+		if c == '"' || unicode.IsSpace(c) || c == '(' || c == ')' {
+			break
+		}
+		for _, op := range compares {
+			if !strings.HasPrefix(raw[i:], string(op)) {
+				continue
+			}
+			key := strings.ToLower(raw[:i])
+			f, ok := fields[key]
+			if !ok {
+				return nil, ErrQuery{
+					Pos:    t.pos,
+					Reason: fmt.Sprintf("unknown field %q", raw[:i]),
+				}
+			}
+			val := t.text[i+len(op):]
+			valPos := t.pos + i + len(op)
+			if val == "" {
+				return nil, ErrQuery{
+					Pos:    valPos,
+					Reason: fmt.Sprintf("%s needs a value", key),
+				}
+			}
+			if op != Has && !ordered[key] {
+				return nil, ErrQuery{
+					Pos: t.pos + i,
+					Reason: fmt.Sprintf(
+						"%s can only be matched, as in %s:value",
+						raw[:i], raw[:i],
+					),
+				}
+			}
+			filter, err := f(op, val, p.now)
+			if err != nil {
+				return nil, ErrQuery{Pos: valPos, Reason: err.Error()}
+			}
+			return filter, nil
+		}
+	}
+	return ByText(t.text), nil
+}
+
+// ParseQuery compiles a query into a Filter.  A query is made of terms
+// which must all match, unless joined by "or"; terms can be negated by
+// "not", joined by "and", and grouped by parentheses.  A term is a
+// field and value, such as:
+//
+//   - owner:bodie, reader:bob, writer:alice, completedBy:bob
+//   - due<2017-02-01, due>=now, with <, <=, =, >= or >
+//   - bounty>=5, with the same comparisons
+//   - priority>=high, with the same comparisons
+//   - label:release
+//   - is:complete, is:incomplete, is:overdue
+//
+// Any other word, or a "quoted string", matches Tasks whose name or
+// notes contain it, ignoring case.  Dates are in UTC unless an RFC 3339
+// time is given.  It returns ErrQuery if the query is invalid.
+func ParseQuery(q string, now time.Time) (Filter, error) {
+	ts, err := lex(q)
+	switch {
+	case err != nil:
+		return nil, err
+	case len(ts) == 0:
+		return MultiAnd{}, nil
+	}
+
+	p := &parser{query: q, ts: ts, now: now}
+	f, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t != nil {
+		return nil, ErrQuery{
+			Pos: t.pos, Reason: fmt.Sprintf("unexpected %q", t.text),
+		}
+	}
+	return f, nil
+}
+
+// Order is a key to sort Tasks by, and whether it is descending.
+type Order struct {
+	Key  string
+	Desc bool
+}
+
+// orders compare two Tasks by a key, returning the sign of a - b, and
+// whether each has a value for the key.
+var orders = map[string]func(a, b *Task) (sign int, hasA, hasB bool){
+	"due": func(a, b *Task) (int, bool, bool) {
+		return compareTimes(a.Due, b.Due)
+	},
+	"completedat": func(a, b *Task) (int, bool, bool) {
+		return compareTimes(a.CompletedAt, b.CompletedAt)
+	},
+	"bounty": func(a, b *Task) (int, bool, bool) {
+		return sign(a.Bounty - b.Bounty), true, true
+	},
+	"priority": func(a, b *Task) (int, bool, bool) {
+		return sign(int64(a.Priority - b.Priority)), true, true
+	},
+	"name": func(a, b *Task) (int, bool, bool) {
+		return strings.Compare(
+			strings.ToLower(a.Name), strings.ToLower(b.Name),
+		), true, true
+	},
+}
+
+func sign(n int64) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func compareTimes(a, b *time.Time) (int, bool, bool) {
+	if a == nil || b == nil {
+		return 0, a != nil, b != nil
+	}
+	switch {
+	case a.Before(*b):
+		return -1, true, true
+	case a.After(*b):
+		return 1, true, true
+	}
+	return 0, true, true
+}
+
+// ParseOrders parses sort keys separated by commas, such as
+// "-bounty,due".  A leading "-" sorts descending.  The keys are due,
+// completedAt, bounty, priority and name.  It returns ErrQuery if a key is
+// unknown.
+func ParseOrders(s string) ([]Order, error) {
+	var result []Order
+	pos := 0
+	for _, key := range strings.Split(s, ",") {
+		o := Order{Key: strings.ToLower(key)}
+		if strings.HasPrefix(o.Key, "-") {
+			o.Key, o.Desc = o.Key[1:], true
+		}
+		if _, ok := orders[o.Key]; !ok {
+			return nil, ErrQuery{
+				Pos:    pos,
+				Reason: fmt.Sprintf("unknown key %q", key),
+				Sort:   true,
+			}
+		}
+		result = append(result, o)
+		pos += len(key) + 1
+	}
+	return result, nil
+}
+
+// SortBy sorts the Tasks by the given Orders, stably.  Tasks with no
+// value for a key, such as no Due date, come after those with one
+// either way.
+func SortBy(ts []*Task, os []Order) {
+	sort.SliceStable(ts, func(i, j int) bool {
+		for _, o := range os {
+			sign, hasI, hasJ := orders[o.Key](ts[i], ts[j])
+			switch {
+			case hasI != hasJ:
+				return hasI
+			case sign == 0:
+				continue
+			case o.Desc:
+				return sign > 0
+			default:
+				return sign < 0
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,189 @@
+package task_test
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *TaskSuite) TestParseQuery(c *C) {
+	var (
+		now   = time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+		early = time.Date(2017, 1, 5, 0, 0, 0, 0, time.UTC)
+		late  = time.Date(2017, 2, 5, 0, 0, 0, 0, time.UTC)
+
+		chores = &task.Task{
+			Group: users.Group{
+				Owner:   "bodie",
+				Writers: map[string]bool{"bob": true},
+			},
+			Name:   "Weekly chores",
+			Notes:  []string{"sweep the porch"},
+			Bounty: 5,
+			Due:    &early,
+
+			Labels:   []string{"home"},
+			Priority: task.High,
+		}
+		report = &task.Task{
+			Group:       users.Group{Owner: "bob"},
+			Name:        "report",
+			Due:         &late,
+			Completed:   true,
+			CompletedBy: "alice",
+		}
+		idea = &task.Task{
+			Group:  users.Group{Owner: "alice"},
+			Name:   "idea",
+			Bounty: 1,
+
+			Priority: task.Low,
+		}
+		all = []*task.Task{chores, report, idea}
+	)
+
+	for i, test := range []struct {
+		should string
+		given  string
+		expect []*task.Task
+	}{{
+		should: "match everything for an empty query",
+		given:  "  ",
+		expect: all,
+	}, {
+		should: "match by user",
+		given:  "owner:bob or writer:bob",
+		expect: []*task.Task{chores, report},
+	}, {
+		should: "match who completed tasks",
+		given:  "completedBy:alice",
+		expect: []*task.Task{report},
+	}, {
+		should: "compare due dates, skipping tasks without one",
+		given:  "due<2017-01-10",
+		expect: []*task.Task{chores},
+	}, {
+		should: "compare due dates with now",
+		given:  "due>=now",
+		expect: []*task.Task{report},
+	}, {
+		should: "compare RFC 3339 due dates",
+		given:  "due=2017-02-05T00:00:00Z",
+		expect: []*task.Task{report},
+	}, {
+		should: "compare bounties",
+		given:  "bounty>=1 and bounty<5",
+		expect: []*task.Task{idea},
+	}, {
+		should: "compare priorities",
+		given:  "priority>=low priority<high",
+		expect: []*task.Task{idea},
+	}, {
+		should: "match labels, ignoring case",
+		given:  "label:HOME or priority:none",
+		expect: []*task.Task{chores, report},
+	}, {
+		should: "match text in names and notes, ignoring case",
+		given:  `PORCH or "ide"`,
+		expect: []*task.Task{chores, idea},
+	}, {
+		should: "match quoted values",
+		given:  `"weekly chores" owner:"bodie"`,
+		expect: []*task.Task{chores},
+	}, {
+		should: "match states",
+		given:  "is:incomplete not is:overdue",
+		expect: []*task.Task{idea},
+	}, {
+		should: "bind and tighter than or",
+		given:  "owner:alice or owner:bob is:incomplete",
+		expect: []*task.Task{idea},
+	}, {
+		should: "group with parentheses",
+		given:  "(owner:alice or owner:bob) is:complete",
+		expect: []*task.Task{report},
+	}, {
+		should: "negate groups",
+		given:  "not (owner:alice OR owner:bob)",
+		expect: []*task.Task{chores},
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		f, err := task.ParseQuery(test.given, now)
+		c.Assert(err, IsNil)
+		got := []*task.Task{}
+		for _, t := range all {
+			if f.Member(t) {
+				got = append(got, t)
+			}
+		}
+		c.Check(got, DeepEquals, test.expect)
+	}
+}
+
+func (s *TaskSuite) TestParseQueryErrors(c *C) {
+	for i, test := range []struct {
+		given     string
+		expectErr string
+	}{
+		{"owner:bob (", "bad query at position 12: unexpected end of query"},
+		{"(owner:bob", "bad query at position 1: unclosed ("},
+		{"owner:bob)", `bad query at position 10: unexpected ")"`},
+		{"owner:bob or", "bad query at position 13: unexpected end of query"},
+		{"and owner:bob", `bad query at position 1: unexpected "and"`},
+		{"not", "bad query at position 4: unexpected end of query"},
+		{`name:"chores`, "bad query at position 6: unterminated quote"},
+		{"colour:red", `bad query at position 1: unknown field "colour"`},
+		{"owner<bob", "bad query at position 6: owner can only be matched, as in owner:value"},
+		{"writer:", "bad query at position 8: writer needs a value"},
+		{"bounty>=lots", `bad query at position 9: bounty "lots" is not a number`},
+		{"x due<soon", `bad query at position 7: due date "soon" is not now, YYYY-MM-DD or RFC 3339`},
+		{"priority>soon", `bad query at position 10: unknown priority "soon"; use none, low, normal, high, urgent`},
+		{"label:a+b", `bad query at position 7: invalid label "a+b": must not contain '+'`},
+		{"is:late", "bad query at position 4: unknown is:late; use complete, incomplete or overdue"},
+	} {
+		c.Logf("test %d: %q", i, test.given)
+		_, err := task.ParseQuery(test.given, time.Now())
+		c.Check(err, ErrorMatches, regexp.QuoteMeta(test.expectErr))
+		c.Check(task.IsQuery(err), Equals, true)
+	}
+}
+
+func (s *TaskSuite) TestSortBy(c *C) {
+	var (
+		jan = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+		feb = time.Date(2017, 2, 1, 0, 0, 0, 0, time.UTC)
+
+		a = &task.Task{Name: "a", Bounty: 1, Due: &feb}
+		b = &task.Task{Name: "B", Bounty: 3}
+		d = &task.Task{Name: "d", Bounty: 3, Due: &jan, Priority: task.Urgent}
+	)
+
+	for i, test := range []struct {
+		given     string
+		expect    []*task.Task
+		expectErr string
+	}{
+		{given: "due", expect: []*task.Task{d, a, b}},
+		{given: "-due", expect: []*task.Task{a, d, b}},
+		{given: "-bounty,name", expect: []*task.Task{b, d, a}},
+		{given: "-bounty,-NAME", expect: []*task.Task{d, b, a}},
+		{given: "completedAt,name", expect: []*task.Task{a, b, d}},
+		{given: "-priority,name", expect: []*task.Task{d, a, b}},
+		{given: "due,size", expectErr: `bad sort at position 5: unknown key "size"`},
+	} {
+		c.Logf("test %d: %q", i, test.given)
+		orders, err := task.ParseOrders(test.given)
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+			continue
+		}
+		c.Assert(err, IsNil)
+		ts := []*task.Task{b, a, d}
+		task.SortBy(ts, orders)
+		c.Check(ts, DeepEquals, test.expect)
+	}
+}
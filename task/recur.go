@@ -0,0 +1,367 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	"github.com/boltdb/bolt"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Frequency is how often a Rule recurs.
+type Frequency string
+
+// Frequencies a Rule can have.
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// weekdays are the BYDAY names of each time.Weekday.
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Rule is how a Task recurs.  It is written like an iCalendar RRULE,
+// such as "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH", and is marshaled to
+// JSON in that form.
+//
+// The next instance of a Task is Due Interval periods after its own Due
+// date, on the given days of the week for a Weekly Rule.  A Daily Rule
+// with FROM=COMPLETION is instead Due Interval days after the Task was
+// completed, and its Tasks need no Due date.
+type Rule struct {
+	Freq           Frequency
+	Interval       int
+	ByDay          []time.Weekday
+	FromCompletion bool
+}
+
+// ErrRule is returned when a Rule can't be parsed.
+type ErrRule struct{ Rule, Reason string }
+
+func (e ErrRule) Error() string {
+	return fmt.Sprintf("invalid recurrence rule %q: %s", e.Rule, e.Reason)
+}
+
+// IsRule returns true if the error is an ErrRule.
+func IsRule(err error) bool {
+	_, ok := err.(ErrRule)
+	return ok
+}
+
+// ParseRule parses a Rule from its RRULE-like form.  FREQ is required,
+// and INTERVAL defaults to 1.
+func ParseRule(s string) (*Rule, error) {
+	bad := func(reason string, args ...interface{}) error {
+		return ErrRule{Rule: s, Reason: fmt.Sprintf(reason, args...)}
+	}
+
+	r := &Rule{Interval: 1}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, bad("%q is not KEY=VALUE", part)
+		}
+		key, val := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		if seen[key] {
+			return nil, bad("%s given more than once", key)
+		}
+		seen[key] = true
+
+		switch key {
+		case "FREQ":
+			switch f := Frequency(val); f {
+			case Daily, Weekly, Monthly:
+				r.Freq = f
+			default:
+				return nil, bad("unknown FREQ %s", val)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(val)
+			if err != nil || n < 1 {
+				return nil, bad("INTERVAL must be a positive number")
+			}
+			r.Interval = n
+		case "BYDAY":
+			for _, d := range strings.Split(val, ",") {
+				wd, ok := weekdays[d]
+				if !ok {
+					return nil, bad("unknown day %s", d)
+				}
+				r.ByDay = append(r.ByDay, wd)
+			}
+		case "FROM":
+			if val != "COMPLETION" {
+				return nil, bad("unknown FROM %s", val)
+			}
+			r.FromCompletion = true
+		default:
+			return nil, bad("unknown key %s", key)
+		}
+	}
+
+	switch {
+	case r.Freq == "":
+		return nil, bad("FREQ is required")
+	case len(r.ByDay) > 0 && r.Freq != Weekly:
+		return nil, bad("BYDAY is only for FREQ=WEEKLY")
+	case r.FromCompletion && r.Freq != Daily:
+		return nil, bad("FROM=COMPLETION is only for FREQ=DAILY")
+	}
+	sort.Slice(r.ByDay, func(i, j int) bool {
+		return mondayFirst(r.ByDay[i]) < mondayFirst(r.ByDay[j])
+	})
+	return r, nil
+}
+
+// String returns the Rule in the form ParseRule parses.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = strings.ToUpper(d.String()[:2])
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.FromCompletion {
+		parts = append(parts, "FROM=COMPLETION")
+	}
+	return strings.Join(parts, ";")
+}
+
+// MarshalJSON implements json.Marshaler on Rule.
+func (r Rule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler on Rule.
+func (r *Rule) UnmarshalJSON(from []byte) error {
+	var s string
+	if err := json.Unmarshal(from, &s); err != nil {
+		return err
+	}
+	parsed, err := ParseRule(s)
+	if err != nil {
+		return err
+	}
+	*r = *parsed
+	return nil
+}
+
+// NeedsDue returns true if Tasks with the Rule must have a Due date to
+// recur from.
+func (r Rule) NeedsDue() bool { return !r.FromCompletion }
+
+// Next returns when the instance after a Task with the given Due and
+// CompletedAt is Due.  Calendar Rules step on from due until they pass
+// now, so missed periods are skipped.  Monthly Rules keep due's day of
+// the month, or use the last day of shorter months.
+func (r Rule) Next(due, completedAt *time.Time, now time.Time) time.Time {
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	if r.FromCompletion || due == nil {
+		from := now
+		if completedAt != nil {
+			from = *completedAt
+		}
+		return from.AddDate(0, 0, interval)
+	}
+
+	if r.Freq == Weekly && len(r.ByDay) > 0 {
+		next := r.nextByDay(*due, interval)
+		for !next.After(now) {
+			next = r.nextByDay(next, interval)
+		}
+		return next
+	}
+
+	for n := interval; ; n += interval {
+		var next time.Time
+		switch r.Freq {
+		case Weekly:
+			next = due.AddDate(0, 0, 7*n)
+		case Monthly:
+			next = addMonths(*due, n)
+		default:
+			next = due.AddDate(0, 0, n)
+		}
+		if next.After(now) {
+			return next
+		}
+	}
+}
+
+// nextByDay returns the first day after from which is in the Rule's
+// ByDay, in a week which is a multiple of interval weeks after from's.
+func (r Rule) nextByDay(from time.Time, interval int) time.Time {
+	start := weekStart(from)
+	for d := 1; ; d++ {
+		next := from.AddDate(0, 0, d)
+		weeks := int(weekStart(next).Sub(start).Hours()/24+0.5) / 7
+		if weeks%interval != 0 {
+			continue
+		}
+		for _, wd := range r.ByDay {
+			if next.Weekday() == wd {
+				return next
+			}
+		}
+	}
+}
+
+// mondayFirst numbers the days of the week from Monday, as ISO weeks do.
+func mondayFirst(d time.Weekday) int { return (int(d) + 6) % 7 }
+
+// weekStart returns midnight of the Monday of t's week.
+func weekStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d-mondayFirst(t.Weekday()), 0, 0, 0, 0, t.Location())
+}
+
+// addMonths adds n months to t, clamping its day to the end of the
+// month.
+func addMonths(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	last := time.Date(y, m+time.Month(n)+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	if d > last {
+		d = last
+	}
+	return time.Date(y, m+time.Month(n), d,
+		t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// renewable returns true if the Task recurs, and its next instance is
+// due to be made: once it is Completed, or once its Due date passes for
+// a calendar Rule.
+func (t *Task) renewable(now time.Time) bool {
+	switch {
+	case t.Recur == nil, t.Next != nil:
+		return false
+	case t.Completed:
+		return true
+	}
+	return t.Recur.NeedsDue() && t.Due != nil && !now.Before(*t.Due)
+}
+
+// renew makes the next instance of the given Task, which must be as it
+// is stored, and marks the Task with its Next.  The new instance keeps
+// the Task's Group, Name, Notes, Bounty, Parent, Labels and Priority,
+// but not its Project, and its Bounty is escrowed from the Owner.  If
+// the Owner can't afford it, nothing is stored and
+// users.ErrInsufficientCoin is returned.
+func renew(tx *bolt.Tx, t *Task, now time.Time) (*Task, error) {
+	src := new(Task)
+	if err := t.ID.Load(src)(tx); err != nil {
+		return nil, err
+	}
+
+	due := t.Recur.Next(t.Due, t.CompletedAt, now)
+	next := &Task{
+		Group:  src.Group,
+		ID:     ID(uuid.NewV4()),
+		Name:   src.Name,
+		Bounty: src.Bounty,
+		Parent: src.Parent,
+		Recur:  src.Recur,
+		State:  Open,
+		Due:    &due,
+		Notes:  src.Notes,
+
+		Labels:   src.Labels,
+		Priority: src.Priority,
+
+		ProjectReaders: src.ProjectReaders,
+		ProjectWriters: src.ProjectWriters,
+	}
+	// New instances start off their Project.
+	next.Inherit(nil)
+	notes := next.Notes
+
+	t.Next = &next.ID
+	err := store.Wrap(
+		// Fund first, so nothing is stored if it fails.
+		Fund(next, now),
+		next.ID.Store(next),
+		store.Marshal(TaskBucket, t, t.ID[:]),
+	)(tx)
+	if err != nil {
+		t.Next = nil
+		return nil, err
+	}
+	next.Notes, next.Resources = notes, nil
+	return next, nil
+}
+
+// Renew returns a function which makes the next instance of the Task
+// with the given ID if it recurs and is due to be renewed, and appends
+// it to into.  If its Owner can't afford its Bounty, it is left for a
+// later Schedule.  If its Owner was deleted, it is not renewed.
+func Renew(id ID, now time.Time, into *[]*Task) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		t := new(Task)
+		if err := store.Unmarshal(TaskBucket, t, id[:])(tx); err != nil {
+			return err
+		}
+		if !t.renewable(now) {
+			return nil
+		}
+		next, err := renew(tx, t, now)
+		switch {
+		case users.IsInsufficientCoin(err):
+			return nil
+		case users.IsMissing(err):
+			log.Printf("not renewing task %s: %s", id, err)
+			return nil
+		case err != nil:
+			return err
+		}
+		*into = append(*into, next)
+		return nil
+	}
+}
+
+// Schedule returns a function which Renews every recurring Task which
+// is due to be renewed, and loads the new instances into into.
+func Schedule(now time.Time, into *[]*Task) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		all, err := graph(tx)
+		if err != nil {
+			return err
+		}
+
+		*into = nil
+		for id, t := range all {
+			if !t.renewable(now) {
+				continue
+			}
+			if err := Renew(id, now, into)(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
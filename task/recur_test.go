@@ -0,0 +1,281 @@
+package task_test
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TaskSuite) TestParseRule(c *C) {
+	for i, test := range []struct {
+		should    string
+		given     string
+		expect    *task.Rule
+		expectStr string
+		expectErr string
+	}{{
+		should:    "parse a daily rule",
+		given:     "FREQ=DAILY",
+		expect:    &task.Rule{Freq: task.Daily, Interval: 1},
+		expectStr: "FREQ=DAILY",
+	}, {
+		should: "parse weekly days in week order",
+		given:  "freq=weekly;interval=2;byday=TH,MO",
+		expect: &task.Rule{
+			Freq:     task.Weekly,
+			Interval: 2,
+			ByDay:    []time.Weekday{time.Monday, time.Thursday},
+		},
+		expectStr: "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH",
+	}, {
+		should: "parse a rule from completion",
+		given:  "FREQ=DAILY;INTERVAL=3;FROM=COMPLETION",
+		expect: &task.Rule{
+			Freq: task.Daily, Interval: 3, FromCompletion: true,
+		},
+		expectStr: "FREQ=DAILY;INTERVAL=3;FROM=COMPLETION",
+	}, {
+		should:    "require FREQ",
+		given:     "INTERVAL=2",
+		expectErr: `invalid recurrence rule "INTERVAL=2": FREQ is required`,
+	}, {
+		should:    "reject an unknown FREQ",
+		given:     "FREQ=HOURLY",
+		expectErr: `.*unknown FREQ HOURLY`,
+	}, {
+		should:    "reject a bad INTERVAL",
+		given:     "FREQ=DAILY;INTERVAL=0",
+		expectErr: `.*INTERVAL must be a positive number`,
+	}, {
+		should:    "reject an unknown day",
+		given:     "FREQ=WEEKLY;BYDAY=MO,XX",
+		expectErr: `.*unknown day XX`,
+	}, {
+		should:    "reject BYDAY on other frequencies",
+		given:     "FREQ=MONTHLY;BYDAY=MO",
+		expectErr: `.*BYDAY is only for FREQ=WEEKLY`,
+	}, {
+		should:    "reject FROM=COMPLETION on other frequencies",
+		given:     "FREQ=WEEKLY;FROM=COMPLETION",
+		expectErr: `.*FROM=COMPLETION is only for FREQ=DAILY`,
+	}, {
+		should:    "reject repeated keys",
+		given:     "FREQ=DAILY;FREQ=WEEKLY",
+		expectErr: `.*FREQ given more than once`,
+	}, {
+		should:    "reject parts which are not KEY=VALUE",
+		given:     "FREQ=DAILY;",
+		expectErr: `.*"" is not KEY=VALUE`,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		got, err := task.ParseRule(test.given)
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+			c.Check(task.IsRule(err), Equals, true)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Check(got, DeepEquals, test.expect)
+		c.Check(got.String(), Equals, test.expectStr)
+
+		c.Log("rules round-trip through JSON")
+		bs, err := json.Marshal(got)
+		c.Assert(err, IsNil)
+		c.Check(string(bs), Equals, `"`+test.expectStr+`"`)
+		back := new(task.Rule)
+		c.Assert(json.Unmarshal(bs, back), IsNil)
+		c.Check(back, DeepEquals, got)
+	}
+}
+
+func (s *TaskSuite) TestRuleNext(c *C) {
+	// 2017-01-02 is a Monday.
+	day := func(m time.Month, d int) time.Time {
+		return time.Date(2017, m, d, 9, 0, 0, 0, time.UTC)
+	}
+	at := func(t time.Time) *time.Time { return &t }
+	rule := func(s string) task.Rule {
+		r, err := task.ParseRule(s)
+		c.Assert(err, IsNil)
+		return *r
+	}
+
+	for i, test := range []struct {
+		should      string
+		rule        task.Rule
+		due, doneAt *time.Time
+		now         time.Time
+		expect      time.Time
+	}{{
+		should: "step a daily rule from its due date",
+		rule:   rule("FREQ=DAILY;INTERVAL=2"),
+		due:    at(day(1, 2)),
+		now:    day(1, 1),
+		expect: day(1, 4),
+	}, {
+		should: "skip periods which have passed",
+		rule:   rule("FREQ=DAILY;INTERVAL=2"),
+		due:    at(day(1, 2)),
+		now:    day(1, 7),
+		expect: day(1, 8),
+	}, {
+		should: "step a weekly rule by weeks",
+		rule:   rule("FREQ=WEEKLY"),
+		due:    at(day(1, 2)),
+		now:    day(1, 2),
+		expect: day(1, 9),
+	}, {
+		should: "find the next day in the same week",
+		rule:   rule("FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH"),
+		due:    at(day(1, 2)),
+		now:    day(1, 2),
+		expect: day(1, 5),
+	}, {
+		should: "skip weeks between intervals",
+		rule:   rule("FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,TH"),
+		due:    at(day(1, 5)),
+		now:    day(1, 5),
+		expect: day(1, 16),
+	}, {
+		should: "keep the day of the month",
+		rule:   rule("FREQ=MONTHLY"),
+		due:    at(day(1, 15)),
+		now:    day(1, 15),
+		expect: day(2, 15),
+	}, {
+		should: "clamp the day to the end of shorter months",
+		rule:   rule("FREQ=MONTHLY"),
+		due:    at(day(1, 31)),
+		now:    day(2, 1),
+		expect: day(2, 28),
+	}, {
+		should: "not drift after clamping",
+		rule:   rule("FREQ=MONTHLY"),
+		due:    at(day(1, 31)),
+		now:    day(3, 1),
+		expect: day(3, 31),
+	}, {
+		should: "step from completion",
+		rule:   rule("FREQ=DAILY;INTERVAL=3;FROM=COMPLETION"),
+		due:    at(day(1, 2)),
+		doneAt: at(day(1, 20)),
+		now:    day(1, 21),
+		expect: day(1, 23),
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		got := test.rule.Next(test.due, test.doneAt, test.now)
+		c.Check(got, Equals, test.expect)
+	}
+}
+
+func (s *TaskSuite) TestSchedule(c *C) {
+	var (
+		now     = time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+		due     = time.Date(2017, 1, 2, 9, 0, 0, 0, time.UTC)
+		later   = time.Date(2017, 1, 20, 9, 0, 0, 0, time.UTC)
+		doneAt  = time.Date(2017, 1, 9, 9, 0, 0, 0, time.UTC)
+		weekly  = &task.Rule{Freq: task.Weekly, Interval: 1}
+		fromEnd = &task.Rule{
+			Freq: task.Daily, Interval: 3, FromCompletion: true,
+		}
+		bodie = users.Group{
+			Owner:   "bodie",
+			Readers: map[string]bool{"bob": true},
+		}
+
+		chore, after  = task.ID(uuid.NewV4()), task.ID(uuid.NewV4())
+		notYet, once  = task.ID(uuid.NewV4()), task.ID(uuid.NewV4())
+		unaffordable  = task.ID(uuid.NewV4())
+		orphan        = task.ID(uuid.NewV4())
+		renewed, none []*task.Task
+	)
+	c.Assert(s.Update(store.Wrap(
+		users.Create(&users.User{Name: "bodie"}),
+		users.Post(&users.Transaction{
+			To: "bodie", Amount: 5, Reason: users.Grant, Time: now,
+		}),
+		chore.Store(&task.Task{
+			Group: bodie, Name: "chore", Bounty: 2,
+			Due: &due, Recur: weekly, Notes: []string{"sweep"},
+		}),
+		after.Store(&task.Task{
+			Group: bodie, Name: "after", Recur: fromEnd,
+			State: task.Accepted, Completed: true, CompletedAt: &doneAt,
+		}),
+		notYet.Store(&task.Task{
+			Group: bodie, Name: "not yet", Due: &later, Recur: weekly,
+		}),
+		once.Store(&task.Task{
+			Group: bodie, Name: "once", Due: &due, Completed: true,
+		}),
+		unaffordable.Store(&task.Task{
+			Group: bodie, Name: "unaffordable", Bounty: 10,
+			Due: &due, Recur: weekly,
+		}),
+		orphan.Store(&task.Task{
+			Group: users.Group{Owner: "ghost"}, Name: "orphan",
+			Bounty: 1, Due: &due, Recur: weekly,
+		}),
+	)), IsNil)
+
+	c.Assert(s.Update(task.Schedule(now, &renewed)), IsNil)
+	c.Assert(renewed, HasLen, 2)
+	sort.Slice(renewed, func(i, j int) bool {
+		return renewed[i].Name < renewed[j].Name
+	})
+
+	c.Log("a task completed by a rule from completion is renewed")
+	nextDue := doneAt.AddDate(0, 0, 3)
+	c.Check(renewed[0], DeepEquals, &task.Task{
+		Group: bodie, ID: renewed[0].ID, Name: "after",
+		Recur: fromEnd, State: task.Open, Due: &nextDue,
+	})
+
+	c.Log("a past-due chore is renewed with its notes and bounty")
+	nextDue = time.Date(2017, 1, 16, 9, 0, 0, 0, time.UTC)
+	c.Check(renewed[1], DeepEquals, &task.Task{
+		Group: bodie, ID: renewed[1].ID, Name: "chore", Bounty: 2,
+		Recur: weekly, State: task.Open, Due: &nextDue,
+		Notes: []string{"sweep"},
+	})
+
+	c.Log("the new instance is stored, and its bounty escrowed")
+	got := new(task.Task)
+	c.Assert(s.View(renewed[1].ID.Load(got)), IsNil)
+	c.Check(got.Notes, DeepEquals, []string{"sweep"})
+	var held int64
+	c.Assert(s.View(task.Escrowed(renewed[1].ID, &held)), IsNil)
+	c.Check(held, Equals, int64(2))
+	u := &users.User{Name: "bodie"}
+	c.Assert(s.View(store.Unmarshal(users.UserBucket, u, []byte("bodie"))), IsNil)
+	c.Check(u.Coin, Equals, int64(3))
+
+	c.Log("the old instance links to the new one")
+	got = new(task.Task)
+	c.Assert(s.View(chore.Load(got)), IsNil)
+	c.Check(got.Next, DeepEquals, &renewed[1].ID)
+	c.Check(got.Notes, DeepEquals, []string{"sweep"})
+	got = new(task.Task)
+	c.Assert(s.View(unaffordable.Load(got)), IsNil)
+	c.Check(got.Next, IsNil)
+
+	c.Log("a task whose owner was deleted is not renewed")
+	got = new(task.Task)
+	c.Assert(s.View(orphan.Load(got)), IsNil)
+	c.Check(got.Next, IsNil)
+
+	c.Log("tasks are only renewed once")
+	c.Assert(s.Update(task.Schedule(now, &none)), IsNil)
+	c.Check(none, HasLen, 0)
+
+	c.Log("Renew ignores tasks which are not due to be renewed")
+	c.Assert(s.Update(task.Renew(notYet, now, &none)), IsNil)
+	c.Check(none, HasLen, 0)
+}
@@ -0,0 +1,273 @@
+package task
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// PrefsBucket holds users' Reminders by user ID, and RemindedBucket
+// records which reminders were sent for each Task by Task ID.
+var (
+	PrefsBucket    = store.Bucket("task-reminder-prefs")
+	RemindedBucket = store.Bucket("task-reminded")
+)
+
+// MaxReminders is how many reminders a user may ask for before each
+// Task is Due.
+const MaxReminders = 8
+
+// Offset is how long before a Task is Due to remind its users.  It is
+// marshaled to JSON as a duration string such as "1h30m".
+type Offset time.Duration
+
+// String implements fmt.Stringer on Offset.
+func (o Offset) String() string { return time.Duration(o).String() }
+
+// MarshalJSON implements json.Marshaler on Offset.
+func (o Offset) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler on Offset.
+func (o *Offset) UnmarshalJSON(from []byte) error {
+	var s string
+	if err := json.Unmarshal(from, &s); err != nil {
+		return err
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*o = Offset(d)
+	return nil
+}
+
+// Reminders are a user's preferences for being reminded of the Due
+// dates of Tasks they own or write.
+type Reminders struct {
+	// Before are how long before a Task is Due to remind them.
+	Before []Offset `json:"before"`
+
+	// Overdue is true if they want to be told once a Task they own
+	// or write is overdue.
+	Overdue bool `json:"overdue"`
+}
+
+// DefaultReminders are the Reminders of users who have not set any.
+var DefaultReminders = Reminders{
+	Before:  []Offset{Offset(24 * time.Hour), Offset(time.Hour)},
+	Overdue: true,
+}
+
+// Validate returns an error if the Reminders have too many, repeated,
+// or non-positive Offsets.  It sorts them, furthest from Due first.
+func (r *Reminders) Validate() error {
+	if len(r.Before) > MaxReminders {
+		return errors.Errorf("at most %d reminders may be set", MaxReminders)
+	}
+	seen := make(map[Offset]bool)
+	for _, o := range r.Before {
+		switch {
+		case o <= 0:
+			return errors.Errorf("reminder %s must be before the due date", o)
+		case seen[o]:
+			return errors.Errorf("reminder %s given more than once", o)
+		}
+		seen[o] = true
+	}
+	sort.Slice(r.Before, func(i, j int) bool {
+		return r.Before[i] > r.Before[j]
+	})
+	return nil
+}
+
+// SetReminders returns a function which sets the given user's Reminders.
+func SetReminders(user string, r *Reminders) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+		return store.Marshal(PrefsBucket, r, []byte(user))(tx)
+	}
+}
+
+// GetReminders returns a function which loads the given user's
+// Reminders into into, or DefaultReminders if they have set none.
+func GetReminders(user string, into *Reminders) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		err := store.Unmarshal(PrefsBucket, into, []byte(user))(tx)
+		if store.IsMissing(err) {
+			*into = DefaultReminders
+			into.Before = append([]Offset(nil), DefaultReminders.Before...)
+			return nil
+		}
+		return err
+	}
+}
+
+// Reminder is a notif telling a user that a Task is Due soon, or, if
+// it is Overdue, that it has slipped.
+type Reminder struct {
+	To string `json:"-"`
+
+	Task    ID        `json:"task"`
+	Name    string    `json:"name"`
+	Due     time.Time `json:"due"`
+	Before  Offset    `json:"before,omitempty"`
+	Overdue bool      `json:"overdue,omitempty"`
+}
+
+// Resource implements Resourcer on Reminder.  Overdue escalations are a
+// different Resource from reminders.
+func (r Reminder) Resource() store.Resource {
+	if r.Overdue {
+		return "task-overdue"
+	}
+	return "task-reminder"
+}
+
+// reminded is the record of which reminders were sent for a Task, by
+// user and Offset, for the Due date they were sent for.  Overdue
+// escalations are recorded with a zero Offset.
+type reminded struct {
+	Due  time.Time                  `json:"due"`
+	Sent map[string]map[Offset]bool `json:"sent"`
+}
+
+// Remind returns a function which loads the reminders and escalations
+// which are due by now into into, and records them as sent.  The Owner
+// and Writers of each incomplete Task with a Due date are reminded
+// according to their Reminders, once for each Offset.  If several of a
+// user's Offsets have passed, such as for a Task made shortly before it
+// is Due, only the nearest is sent.  Changing a Task's Due date sends
+// its reminders again.
+func Remind(now time.Time, into *[]*Reminder) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		all, err := graph(tx)
+		if err != nil {
+			return err
+		}
+
+		*into = nil
+		prefs := make(map[string]*Reminders)
+		for id, t := range all {
+			if t.Completed || t.Due == nil {
+				continue
+			}
+			due := *t.Due
+
+			rec := new(reminded)
+			err := store.Unmarshal(RemindedBucket, rec, id[:])(tx)
+			switch {
+			case store.IsMissing(err):
+			case err != nil:
+				return err
+			}
+			if !rec.Due.Equal(due) || rec.Sent == nil {
+				rec.Due, rec.Sent = due, make(map[string]map[Offset]bool)
+			}
+
+			var sent []*Reminder
+			for u := range writers(t) {
+				p, err := reminders(tx, u, prefs)
+				if err != nil {
+					return err
+				}
+				if r := remind(t, u, p, rec, now); r != nil {
+					sent = append(sent, r)
+				}
+			}
+			if len(sent) == 0 {
+				continue
+			}
+			if err := store.Marshal(RemindedBucket, rec, id[:])(tx); err != nil {
+				return err
+			}
+			*into = append(*into, sent...)
+		}
+
+		sort.Slice(*into, func(i, j int) bool {
+			a, b := (*into)[i], (*into)[j]
+			if a.Task != b.Task {
+				return a.Task.String() < b.Task.String()
+			}
+			return a.To < b.To
+		})
+		return nil
+	}
+}
+
+// remind returns the reminder or escalation to send the given user
+// about t by now, if there is one, and marks it and any it supersedes
+// as sent in rec.
+func remind(t *Task, user string, p *Reminders, rec *reminded, now time.Time) *Reminder {
+	sent := rec.Sent[user]
+	if sent == nil {
+		sent = make(map[Offset]bool)
+		rec.Sent[user] = sent
+	}
+
+	r := &Reminder{To: user, Task: t.ID, Name: t.Name, Due: rec.Due}
+	if !now.Before(rec.Due) {
+		// Once a Task is overdue, its reminders are moot.
+		for _, o := range p.Before {
+			sent[o] = true
+		}
+		if !p.Overdue || sent[0] {
+			return nil
+		}
+		sent[0] = true
+		r.Overdue = true
+		return r
+	}
+
+	var nearest Offset
+	for _, o := range p.Before {
+		if now.Before(rec.Due.Add(-time.Duration(o))) || sent[o] {
+			continue
+		}
+		sent[o] = true
+		if nearest == 0 || o < nearest {
+			nearest = o
+		}
+	}
+	if nearest == 0 {
+		return nil
+	}
+	r.Before = nearest
+	return r
+}
+
+// writers returns the users who are reminded about t.
+func writers(t *Task) map[string]bool {
+	ws := map[string]bool{t.Owner: true}
+	for u := range t.Writers {
+		ws[u] = true
+	}
+	return ws
+}
+
+// reminders returns the given user's Reminders, caching them in cache.
+func reminders(tx *bolt.Tx, user string, cache map[string]*Reminders) (*Reminders, error) {
+	if p, ok := cache[user]; ok {
+		return p, nil
+	}
+	p := new(Reminders)
+	if err := GetReminders(user, p)(tx); err != nil {
+		return nil, err
+	}
+	cache[user] = p
+	return p, nil
+}
+
+// DeleteReminded returns a function which deletes the record of the
+// reminders sent for the Task with the given ID.
+func DeleteReminded(id ID) func(*bolt.Tx) error {
+	return store.Delete(RemindedBucket, id[:])
+}
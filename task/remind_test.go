@@ -0,0 +1,165 @@
+package task_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TaskSuite) TestReminders(c *C) {
+	got := new(task.Reminders)
+	c.Log("users who set no reminders get the defaults")
+	c.Assert(s.View(task.GetReminders("bodie", got)), IsNil)
+	c.Check(got, DeepEquals, &task.DefaultReminders)
+
+	for i, test := range []struct {
+		should    string
+		given     string
+		expect    *task.Reminders
+		expectErr string
+	}{{
+		should: "sort offsets, furthest first",
+		given:  `{"before": ["30m", "48h"], "overdue": false}`,
+		expect: &task.Reminders{
+			Before: []task.Offset{
+				task.Offset(48 * time.Hour),
+				task.Offset(30 * time.Minute),
+			},
+		},
+	}, {
+		should:    "not allow offsets after the due date",
+		given:     `{"before": ["-1h"]}`,
+		expectErr: "reminder -1h0m0s must be before the due date",
+	}, {
+		should:    "not allow repeated offsets",
+		given:     `{"before": ["1h", "60m"]}`,
+		expectErr: "reminder 1h0m0s given more than once",
+	}, {
+		should: "not allow too many offsets",
+		given: `{"before": ["1h", "2h", "3h", "4h", "5h", "6h", "7h",
+			"8h", "9h"]}`,
+		expectErr: "at most 8 reminders may be set",
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		rs := new(task.Reminders)
+		c.Assert(json.Unmarshal([]byte(test.given), rs), IsNil)
+		err := s.Update(task.SetReminders("bob", rs))
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+			continue
+		}
+		c.Assert(err, IsNil)
+		got := new(task.Reminders)
+		c.Assert(s.View(task.GetReminders("bob", got)), IsNil)
+		c.Check(got, DeepEquals, test.expect)
+	}
+}
+
+func (s *TaskSuite) TestRemind(c *C) {
+	var (
+		start = time.Date(2017, 1, 10, 12, 0, 0, 0, time.UTC)
+		due   = start.Add(48 * time.Hour)
+		soon  = start.Add(30 * time.Minute)
+		group = users.Group{
+			Owner:   "bodie",
+			Readers: map[string]bool{"alice": true},
+			Writers: map[string]bool{"bob": true},
+		}
+
+		chore, rush = task.ID(uuid.NewV4()), task.ID(uuid.NewV4())
+		done        = task.ID(uuid.NewV4())
+	)
+	c.Assert(s.Update(store.Wrap(
+		task.SetReminders("bob", &task.Reminders{
+			Before: []task.Offset{task.Offset(2 * time.Hour)},
+		}),
+		chore.Store(&task.Task{Group: group, Name: "chore", Due: &due}),
+		rush.Store(&task.Task{
+			Group: users.Group{Owner: "bodie"}, Name: "rush", Due: &soon,
+		}),
+		done.Store(&task.Task{
+			Group: group, Name: "done", Due: &soon, Completed: true,
+		}),
+	)), IsNil)
+
+	type sent struct {
+		to     string
+		task   task.ID
+		before time.Duration
+		over   bool
+	}
+	remind := func(at time.Time) []sent {
+		var rs []*task.Reminder
+		c.Assert(s.Update(task.Remind(at, &rs)), IsNil)
+		result := []sent{}
+		for _, r := range rs {
+			result = append(result, sent{
+				r.To, r.Task, time.Duration(r.Before), r.Overdue,
+			})
+		}
+		return result
+	}
+	byTask := func(ss []sent) map[task.ID][]sent {
+		m := make(map[task.ID][]sent)
+		for _, s := range ss {
+			m[s.task] = append(m[s.task], s)
+		}
+		return m
+	}
+
+	c.Log("only the nearest passed reminder is sent")
+	got := byTask(remind(start))
+	c.Check(got, DeepEquals, map[task.ID][]sent{
+		rush: {{"bodie", rush, time.Hour, false}},
+	})
+
+	c.Log("reminders name the task and when it is due")
+	var rs []*task.Reminder
+	c.Assert(s.Update(task.DeleteReminded(rush)), IsNil)
+	c.Assert(s.Update(task.Remind(start, &rs)), IsNil)
+	c.Check(rs, DeepEquals, []*task.Reminder{{
+		To: "bodie", Task: rush, Name: "rush", Due: soon,
+		Before: task.Offset(time.Hour),
+	}})
+	c.Check(rs[0].Resource(), Equals, store.Resource("task-reminder"))
+
+	c.Log("reminders are only sent once")
+	c.Check(remind(start), DeepEquals, []sent{})
+
+	c.Log("writers are reminded by their own preferences")
+	got = byTask(remind(due.Add(-20 * time.Hour)))
+	c.Check(got, DeepEquals, map[task.ID][]sent{
+		chore: {{"bodie", chore, 24 * time.Hour, false}},
+		rush:  {{"bodie", rush, 0, true}},
+	})
+	got = byTask(remind(due.Add(-90 * time.Minute)))
+	c.Check(got, DeepEquals, map[task.ID][]sent{
+		chore: {{"bob", chore, 2 * time.Hour, false}},
+	})
+
+	c.Log("overdue tasks are escalated once, to those who want it")
+	c.Check(remind(due), DeepEquals, []sent{
+		{"bodie", chore, 0, true},
+	})
+	c.Check(remind(due.Add(time.Hour)), DeepEquals, []sent{})
+
+	c.Log("moving the due date sends reminders again")
+	later := due.Add(time.Hour)
+	c.Assert(s.Update(chore.Store(&task.Task{
+		Group: group, Name: "chore", Due: &later,
+	})), IsNil)
+	c.Check(remind(due), DeepEquals, []sent{
+		{"bob", chore, 2 * time.Hour, false},
+		{"bodie", chore, time.Hour, false},
+	})
+
+	c.Log("deleting the record does too")
+	c.Assert(s.Update(task.DeleteReminded(chore)), IsNil)
+	c.Check(remind(due), HasLen, 2)
+}
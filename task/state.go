@@ -0,0 +1,170 @@
+package task
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+)
+
+// HistoryBucket holds a nested Bucket of Transitions for each Task,
+// keyed by sequence number.
+var HistoryBucket = store.Bucket("task-history")
+
+// State is where a Task is in its review.  A writer claims an Open Task
+// and submits it when they are done, and the owner accepts or rejects
+// the submission.  Only an Accepted Task is Completed.
+type State string
+
+// States a Task can be in.
+const (
+	Open      State = "open"
+	Claimed   State = "claimed"
+	Submitted State = "submitted"
+	Accepted  State = "accepted"
+	Rejected  State = "rejected"
+)
+
+// transitions are the States each State can move to.  A rejected
+// submission can be submitted again or given up, and an accepted Task
+// can be reopened.
+var transitions = map[State]map[State]bool{
+	Open:      {Claimed: true},
+	Claimed:   {Open: true, Submitted: true},
+	Submitted: {Accepted: true, Rejected: true},
+	Rejected:  {Open: true, Submitted: true},
+	Accepted:  {Open: true},
+}
+
+// Transition is a record of a Task moving from one State to another.
+type Transition struct {
+	From State     `json:"from"`
+	To   State     `json:"to"`
+	By   string    `json:"by"`
+	Note string    `json:"note,omitempty"`
+	Time time.Time `json:"time"`
+}
+
+// ErrTransition is returned when a Task can't move between the States.
+type ErrTransition struct{ From, To State }
+
+func (e ErrTransition) Error() string {
+	return fmt.Sprintf("cannot move task from %s to %s", e.From, e.To)
+}
+
+// IsTransition returns true if the error is an ErrTransition.
+func IsTransition(err error) bool {
+	_, ok := err.(ErrTransition)
+	return ok
+}
+
+// Status returns the Task's State.  Tasks from before States are
+// Accepted if they are Completed, and Open otherwise.
+func (t *Task) Status() State {
+	switch {
+	case t.State != "":
+		return t.State
+	case t.Completed:
+		return Accepted
+	}
+	return Open
+}
+
+// Move moves the Task to the given State on behalf of the given user,
+// and returns the Transition it made, or ErrTransition if it can't.
+// Claiming a Task makes the user its Claimant, accepting it completes it
+// by its Claimant, and opening it again clears both.  Move does not
+// check whether the user is allowed to make the Transition, and does
+// not store the Task.
+func (t *Task) Move(to State, by, note string, now time.Time) (*Transition, error) {
+	from := t.Status()
+	if !transitions[from][to] {
+		return nil, ErrTransition{From: from, To: to}
+	}
+
+	switch to {
+	case Open:
+		t.Claimant = ""
+		t.Completed, t.CompletedBy, t.CompletedAt = false, "", nil
+	case Claimed:
+		t.Claimant = by
+	case Accepted:
+		t.Completed, t.CompletedBy, t.CompletedAt = true, t.Claimant, &now
+	}
+	t.State = to
+
+	return &Transition{
+		From: from,
+		To:   to,
+		By:   by,
+		Note: note,
+		Time: now,
+	}, nil
+}
+
+// Record returns a function which appends the Transition to the history
+// of the Task with the given ID.
+func Record(id ID, tr *Transition) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		b, err := store.MakeNestedBucket(
+			tx.Bucket(HistoryBucket),
+			store.Bucket(id[:]),
+		)
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		bs, err := json.Marshal(tr)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+		return b.Put(key, bs)
+	}
+}
+
+// GetHistory returns a function which loads the Transitions of the Task
+// with the given ID into into, oldest first.
+func GetHistory(id ID, into *[]*Transition) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		*into = []*Transition{}
+		b, err := store.GetNestedBucket(
+			tx.Bucket(HistoryBucket),
+			store.Bucket(id[:]),
+		)
+		switch {
+		case store.IsMissingBucket(err):
+			return nil
+		case err != nil:
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			tr := new(Transition)
+			if err := json.Unmarshal(v, tr); err != nil {
+				return err
+			}
+			*into = append(*into, tr)
+			return nil
+		})
+	}
+}
+
+// DeleteHistory returns a function which deletes the history of the
+// Task with the given ID, if it has any.
+func DeleteHistory(id ID) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		err := tx.Bucket(HistoryBucket).DeleteBucket(id[:])
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	}
+}
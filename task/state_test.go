@@ -0,0 +1,141 @@
+package task_test
+
+import (
+	"time"
+
+	"github.com/synapse-garden/sg-proto/task"
+	"github.com/synapse-garden/sg-proto/users"
+
+	uuid "github.com/satori/go.uuid"
+	. "gopkg.in/check.v1"
+)
+
+func (s *TaskSuite) TestStatus(c *C) {
+	c.Check((&task.Task{}).Status(), Equals, task.Open)
+	c.Check((&task.Task{Completed: true}).Status(), Equals, task.Accepted)
+	c.Check((&task.Task{State: task.Claimed}).Status(), Equals, task.Claimed)
+}
+
+func (s *TaskSuite) TestMove(c *C) {
+	var (
+		now = time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+		t   = &task.Task{Group: users.Group{Owner: "bodie"}}
+	)
+
+	for i, test := range []struct {
+		should string
+		to     task.State
+		by     string
+
+		expectErr      string
+		expectState    task.State
+		expectClaimant string
+		expectDone     bool
+	}{{
+		should:      "not submit an open task",
+		to:          task.Submitted,
+		by:          "bob",
+		expectErr:   "cannot move task from open to submitted",
+		expectState: task.Open,
+	}, {
+		should:         "let bob claim it",
+		to:             task.Claimed,
+		by:             "bob",
+		expectState:    task.Claimed,
+		expectClaimant: "bob",
+	}, {
+		should:         "not accept a claimed task",
+		to:             task.Accepted,
+		by:             "bodie",
+		expectErr:      "cannot move task from claimed to accepted",
+		expectState:    task.Claimed,
+		expectClaimant: "bob",
+	}, {
+		should:         "submit it",
+		to:             task.Submitted,
+		by:             "bob",
+		expectState:    task.Submitted,
+		expectClaimant: "bob",
+	}, {
+		should:         "reject it",
+		to:             task.Rejected,
+		by:             "bodie",
+		expectState:    task.Rejected,
+		expectClaimant: "bob",
+	}, {
+		should:         "submit it again",
+		to:             task.Submitted,
+		by:             "bob",
+		expectState:    task.Submitted,
+		expectClaimant: "bob",
+	}, {
+		should:         "accept it, completing it by the claimant",
+		to:             task.Accepted,
+		by:             "bodie",
+		expectState:    task.Accepted,
+		expectClaimant: "bob",
+		expectDone:     true,
+	}, {
+		should:      "reopen it",
+		to:          task.Open,
+		by:          "bodie",
+		expectState: task.Open,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		tr, err := t.Move(test.to, test.by, "", now)
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+			c.Check(task.IsTransition(err), Equals, true)
+			c.Check(tr, IsNil)
+		} else {
+			c.Assert(err, IsNil)
+			c.Check(tr.To, Equals, test.to)
+			c.Check(tr.By, Equals, test.by)
+		}
+		c.Check(t.Status(), Equals, test.expectState)
+		c.Check(t.Claimant, Equals, test.expectClaimant)
+		c.Check(t.Completed, Equals, test.expectDone)
+		if test.expectDone {
+			c.Check(t.CompletedBy, Equals, test.expectClaimant)
+			c.Check(t.CompletedAt, DeepEquals, &now)
+		} else {
+			c.Check(t.CompletedBy, Equals, "")
+			c.Check(t.CompletedAt, IsNil)
+		}
+	}
+}
+
+func (s *TaskSuite) TestHistory(c *C) {
+	var (
+		now     = time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+		id      = task.ID(uuid.NewV4())
+		t       = &task.Task{Group: users.Group{Owner: "bodie"}}
+		history []*task.Transition
+	)
+
+	c.Log("a task with no history has an empty one")
+	c.Assert(s.View(task.GetHistory(id, &history)), IsNil)
+	c.Check(history, DeepEquals, []*task.Transition{})
+
+	claim, err := t.Move(task.Claimed, "bob", "mine", now)
+	c.Assert(err, IsNil)
+	submit, err := t.Move(task.Submitted, "bob", "", now.Add(time.Hour))
+	c.Assert(err, IsNil)
+	c.Assert(s.Update(task.Record(id, claim)), IsNil)
+	c.Assert(s.Update(task.Record(id, submit)), IsNil)
+
+	c.Assert(s.View(task.GetHistory(id, &history)), IsNil)
+	c.Check(history, DeepEquals, []*task.Transition{{
+		From: task.Open, To: task.Claimed,
+		By: "bob", Note: "mine", Time: now,
+	}, {
+		From: task.Claimed, To: task.Submitted,
+		By: "bob", Time: now.Add(time.Hour),
+	}})
+
+	c.Log("deleting it leaves it empty")
+	c.Assert(s.Update(task.DeleteHistory(id)), IsNil)
+	c.Assert(s.Update(task.DeleteHistory(id)), IsNil)
+	c.Assert(s.View(task.GetHistory(id, &history)), IsNil)
+	c.Check(history, HasLen, 0)
+}
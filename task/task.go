@@ -23,12 +23,38 @@ type Task struct {
 
 	Bounty int64 `json:"bounty,omitempty"`
 
+	// Labels are free-form tags such as "release", normalized using
+	// NormalizeLabels.  Tasks are indexed by label in LabelBucket.
+	Labels   []string `json:"labels,omitempty"`
+	Priority Priority `json:"priority,omitempty"`
+
+	// Parent is the Task this is a subtask of, and BlockedBy are the
+	// Tasks which must be completed before this one is Ready.
+	Parent    *ID  `json:"parent,omitempty"`
+	BlockedBy []ID `json:"blockedBy,omitempty"`
+
+	// State and Claimant may only be changed using Move.
+	State    State  `json:"state,omitempty"`
+	Claimant string `json:"claimant,omitempty"`
+
 	Completed   bool       `json:"completed"`
 	CompletedBy string     `json:"completedBy,omitempty"`
 	CompletedAt *time.Time `json:"completedAt,omitempty"`
 
 	Due *time.Time `json:"due,omitempty"`
 
+	// Project is the Project the Task is on, if any.  ProjectReaders
+	// and ProjectWriters are the Readers and Writers the Task only has
+	// because of it.  These may only be changed through the Project.
+	Project        *ProjectID      `json:"project,omitempty"`
+	ProjectReaders map[string]bool `json:"projectReaders,omitempty"`
+	ProjectWriters map[string]bool `json:"projectWriters,omitempty"`
+
+	// Recur is how the Task recurs, if it does.  Next is the ID of
+	// its next instance, once that has been made.
+	Recur *Rule `json:"recur,omitempty"`
+	Next  *ID   `json:"next,omitempty"`
+
 	// Resources are foreign keys into the Text bucket.  They should
 	// never be exposed to the user.
 	Resources []text.ID `json:"resources,omitempty"`
@@ -52,6 +78,7 @@ func GetAll(user string, filters ...Filter) func(*bolt.Tx) ([]*Task, error) {
 		ByWriter(user),
 	}
 	otherFilters := MultiAnd(filters)
+	textual := needsNotes(otherFilters)
 
 	return func(tx *bolt.Tx) ([]*Task, error) {
 		b := tx.Bucket(TaskBucket)
@@ -68,14 +95,19 @@ func GetAll(user string, filters ...Filter) func(*bolt.Tx) ([]*Task, error) {
 			switch {
 			case !defaultFilter.Member(next):
 				return nil
-			case !otherFilters.Member(next):
+			case !textual && !otherFilters.Member(next):
 				return nil
 			}
 
+			// Filters such as ByText need the Notes, so only
+			// they are applied after loading them.
 			if err := next.ID.Load(next)(tx); err != nil {
 				return err
 			}
 
+			if textual && !otherFilters.Member(next) {
+				return nil
+			}
 			result = append(result, next)
 			return nil
 		})
@@ -101,6 +133,10 @@ func (i ID) Store(what interface{}) func(*bolt.Tx) error {
 	return store.Wrap(
 		store.View(store.Unmarshal(TaskBucket, old, idBytes)).OrMissing,
 		tsk.StoreResources(store.ID(i), notes, old.Resources),
+		indexLabels(i,
+			func() []string { return old.Labels },
+			func() []string { return tsk.Labels },
+		),
 		store.Marshal(TaskBucket, tsk, idBytes),
 	)
 }
@@ -158,6 +194,10 @@ func (i ID) Delete(tx *bolt.Tx) error {
 	return store.Wrap(
 		store.View(store.Unmarshal(TaskBucket, tsk, idBytes)).OrMissing,
 		DeleteResources(tsk.Resources),
+		indexLabels(i,
+			func() []string { return tsk.Labels },
+			func() []string { return nil },
+		),
 		store.Delete(TaskBucket, i[:]),
 	)(tx)
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/synapse-garden/sg-proto/task"
 	"github.com/synapse-garden/sg-proto/users"
 
+	"github.com/boltdb/bolt"
 	uuid "github.com/satori/go.uuid"
 	. "gopkg.in/check.v1"
 )
@@ -107,3 +108,72 @@ func (s *TaskSuite) TestTaskStoreLoad(c *C) {
 		c.Check(got, DeepEquals, expect)
 	}
 }
+
+func (s *TaskSuite) TestGetAll(c *C) {
+	bodie := users.Group{
+		Owner:   "bodie",
+		Readers: map[string]bool{"bodie": true},
+		Writers: map[string]bool{"bodie": true},
+	}
+	shop, sweep := task.ID(uuid.NewV4()), task.ID(uuid.NewV4())
+	c.Assert(s.Update(func(tx *bolt.Tx) error {
+		if err := shop.Store(&task.Task{
+			Group: bodie, Name: "shop", Bounty: 2,
+			Notes: []string{"buy milk"},
+		})(tx); err != nil {
+			return err
+		}
+		return sweep.Store(&task.Task{
+			Group: bodie, Name: "sweep",
+			Notes: []string{"the porch"},
+		})(tx)
+	}), IsNil)
+
+	for i, test := range []struct {
+		should string
+		user   string
+		given  []task.Filter
+		expect map[string][]string
+	}{{
+		should: "get every task of the user with its notes",
+		user:   "bodie",
+		expect: map[string][]string{
+			"shop":  {"buy milk"},
+			"sweep": {"the porch"},
+		},
+	}, {
+		should: "get no tasks of other users",
+		user:   "bob",
+		expect: map[string][]string{},
+	}, {
+		should: "filter without the notes",
+		user:   "bodie",
+		given:  []task.Filter{task.BountyIs{Op: task.Greater, Bounty: 0}},
+		expect: map[string][]string{"shop": {"buy milk"}},
+	}, {
+		should: "match text in the notes",
+		user:   "bodie",
+		given:  []task.Filter{task.ByText("MILK")},
+		expect: map[string][]string{"shop": {"buy milk"}},
+	}, {
+		should: "match text nested in other filters",
+		user:   "bodie",
+		given: []task.Filter{task.MultiOr{
+			task.BountyIs{Op: task.Greater, Bounty: 5},
+			task.Not{task.ByText("milk")},
+		}},
+		expect: map[string][]string{"sweep": {"the porch"}},
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		var got []*task.Task
+		c.Assert(s.View(func(tx *bolt.Tx) (err error) {
+			got, err = task.GetAll(test.user, test.given...)(tx)
+			return
+		}), IsNil)
+		notes := make(map[string][]string)
+		for _, t := range got {
+			notes[t.Name] = t.Notes
+		}
+		c.Check(notes, DeepEquals, test.expect)
+	}
+}
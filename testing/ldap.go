@@ -0,0 +1,183 @@
+package testing
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// LDAPServer is a stub LDAP directory which only answers simple binds.
+type LDAPServer struct {
+	// Passwords maps bind DNs to their passwords.
+	Passwords map[string]string
+
+	ln net.Listener
+	wg sync.WaitGroup
+}
+
+// NewLDAPServer starts an LDAPServer on a local port with the given
+// passwords.  Close it when done.
+func NewLDAPServer(passwords map[string]string) (*LDAPServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &LDAPServer{Passwords: passwords, ln: ln}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr returns the LDAPServer's host:port.
+func (s *LDAPServer) Addr() string { return s.ln.Addr().String() }
+
+// Close stops the LDAPServer and waits for its connections to finish.
+func (s *LDAPServer) Close() error {
+	err := s.ln.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *LDAPServer) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+// BER tags and LDAP result codes the LDAPServer uses.
+const (
+	berInteger     byte = 0x02
+	berOctets      byte = 0x04
+	berEnumerated  byte = 0x0a
+	berSequence    byte = 0x30
+	ldapBind       byte = 0x60
+	ldapBindResult byte = 0x61
+	ldapSimpleAuth byte = 0x80
+
+	ldapSuccess            = 0
+	ldapInvalidCredentials = 49
+)
+
+func (s *LDAPServer) handle(conn net.Conn) {
+	r := bufio.NewReader(conn)
+	for {
+		// LDAPMessage ::= SEQUENCE { messageID, protocolOp }
+		tag, msg, err := readBER(r)
+		if err != nil || tag != berSequence {
+			return
+		}
+		msgs := bufio.NewReader(bytes.NewReader(msg))
+		_, id, err := readBER(msgs)
+		if err != nil {
+			return
+		}
+		tag, op, err := readBER(msgs)
+		if err != nil || tag != ldapBind {
+			// Unbind, or something this LDAPServer can't do.
+			return
+		}
+
+		// BindRequest ::= [APPLICATION 0] SEQUENCE {
+		//         version, name, simple [0] password }
+		ops := bufio.NewReader(bytes.NewReader(op))
+		_, _, err = readBER(ops)
+		if err != nil {
+			return
+		}
+		_, dn, err := readBER(ops)
+		if err != nil {
+			return
+		}
+		authTag, password, err := readBER(ops)
+		if err != nil {
+			return
+		}
+
+		code, diag := ldapSuccess, ""
+		want, ok := s.Passwords[string(dn)]
+		if authTag != ldapSimpleAuth || !ok ||
+			len(password) == 0 || string(password) != want {
+			code, diag = ldapInvalidCredentials, "invalid credentials"
+		}
+
+		resp := berTLV(berSequence,
+			berTLV(berInteger, id),
+			berTLV(ldapBindResult,
+				berTLV(berEnumerated, []byte{byte(code)}),
+				berTLV(berOctets, nil),
+				berTLV(berOctets, []byte(diag)),
+			),
+		)
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// readBER reads one BER element's tag and contents from r.
+func readBER(r *bufio.Reader) (byte, []byte, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	lb, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	n := int(lb)
+	if lb&0x80 != 0 {
+		count := int(lb &^ 0x80)
+		if count == 0 || count > 2 {
+			return 0, nil, errors.Errorf("unsupported BER length of %d bytes", count)
+		}
+		n = 0
+		for i := 0; i < count; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return 0, nil, err
+			}
+			n = n<<8 | int(b)
+		}
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, err
+	}
+	return tag, body, nil
+}
+
+// berTLV encodes a BER element with the given tag, made of the given
+// contents.
+func berTLV(tag byte, contents ...[]byte) []byte {
+	var body []byte
+	for _, c := range contents {
+		body = append(body, c...)
+	}
+
+	out := []byte{tag}
+	switch n := len(body); {
+	case n < 0x80:
+		out = append(out, byte(n))
+	default:
+		out = append(out, 0x82, byte(n>>8), byte(n))
+	}
+	return append(out, body...)
+}
@@ -0,0 +1,157 @@
+package testing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	htt "net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	uuid "github.com/satori/go.uuid"
+)
+
+// OIDCProvider is a stub OpenID Connect provider.  It serves discovery
+// and token endpoints; Authorize stands in for the user logging in on
+// the provider's authorization page.
+type OIDCProvider struct {
+	*htt.Server
+
+	ClientID, ClientSecret string
+
+	mu    sync.Mutex
+	codes map[string]oidcGrant
+}
+
+type oidcGrant struct {
+	redirect, nonce string
+	claims          map[string]interface{}
+}
+
+// NewOIDCProvider starts an OIDCProvider for the given client.  Close it
+// when done.
+func NewOIDCProvider(clientID, clientSecret string) *OIDCProvider {
+	p := &OIDCProvider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		codes:        make(map[string]oidcGrant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	mux.HandleFunc("/token", p.token)
+	p.Server = htt.NewServer(mux)
+	return p
+}
+
+// Issuer returns the OIDCProvider's issuer URL.
+func (p *OIDCProvider) Issuer() string { return p.URL }
+
+func (p *OIDCProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 p.URL,
+		"authorization_endpoint": p.URL + "/authorize",
+		"token_endpoint":         p.URL + "/token",
+	})
+}
+
+// Authorize logs in at the given authorization URL as the given subject
+// with the given extra ID token claims, such as "preferred_username".
+// It returns the code and state which the provider would redirect back
+// with.
+func (p *OIDCProvider) Authorize(
+	authURL, subject string,
+	claims map[string]interface{},
+) (code, state string, err error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+
+	q := u.Query()
+	switch {
+	case q.Get("response_type") != "code":
+		return "", "", errors.Errorf("bad response_type %#q", q.Get("response_type"))
+	case q.Get("client_id") != p.ClientID:
+		return "", "", errors.Errorf("bad client_id %#q", q.Get("client_id"))
+	}
+
+	all := map[string]interface{}{
+		"iss": p.URL,
+		"sub": subject,
+		"aud": p.ClientID,
+		"exp": time.Now().Add(time.Hour).Unix(),
+		"iat": time.Now().Unix(),
+	}
+	for k, v := range claims {
+		all[k] = v
+	}
+
+	code = uuid.NewV4().String()
+	p.mu.Lock()
+	p.codes[code] = oidcGrant{
+		redirect: q.Get("redirect_uri"),
+		nonce:    q.Get("nonce"),
+		claims:   all,
+	}
+	p.mu.Unlock()
+
+	return code, q.Get("state"), nil
+}
+
+func (p *OIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code int, kind string) {
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]string{"error": kind})
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		fail(http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if id != p.ClientID || secret != p.ClientSecret {
+		fail(http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	if r.FormValue("grant_type") != "authorization_code" {
+		fail(http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	code := r.FormValue("code")
+	p.mu.Lock()
+	grant, ok := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+	if !ok || grant.redirect != r.FormValue("redirect_uri") {
+		fail(http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	grant.claims["nonce"] = grant.nonce
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": uuid.NewV4().String(),
+		"token_type":   "Bearer",
+		"id_token":     p.sign(grant.claims),
+	})
+}
+
+// sign returns an HS256 JWT of the given claims.
+func (p *OIDCProvider) sign(claims map[string]interface{}) string {
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	body := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+
+	mac := hmac.New(sha256.New, []byte(p.ClientSecret))
+	mac.Write([]byte(body))
+	return body + "." + enc.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,166 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// BlockBucket holds users' Blocks by user ID.
+var BlockBucket = store.Bucket("blocks")
+
+// Blocks are the users a user has blocked or muted.  Blocked users
+// cannot add them to a Group, and their notifs and messages are dropped
+// before delivery.  Muted users keep their membership, but their notifs
+// are dropped.
+type Blocks struct {
+	Blocked map[string]bool `json:"blocked"`
+	Muted   map[string]bool `json:"muted"`
+}
+
+// Resource implements store.Resourcer on *Blocks.
+func (*Blocks) Resource() store.Resource { return "blocks" }
+
+// Silences returns true if notifs from the given user are dropped.
+func (b *Blocks) Silences(user string) bool {
+	return b.Blocked[user] || b.Muted[user]
+}
+
+// ErrBlocked is returned when a user tries to add someone who blocked
+// them to a Group.
+type ErrBlocked struct {
+	By, User string
+}
+
+func (e ErrBlocked) Error() string {
+	return fmt.Sprintf("user %#q cannot add user %#q", e.User, e.By)
+}
+
+// IsBlocked returns true if the error is an ErrBlocked.
+func IsBlocked(err error) bool {
+	_, ok := err.(ErrBlocked)
+	return ok
+}
+
+// GetBlocks returns a function which loads the given user's Blocks into
+// the given value.  A user who never blocked or muted anyone has empty
+// Blocks.
+func GetBlocks(user string, into *Blocks) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		err := store.Unmarshal(BlockBucket, into, []byte(user))(tx)
+		switch {
+		case store.IsMissing(err):
+			*into = Blocks{}
+		case err != nil:
+			return err
+		}
+		if into.Blocked == nil {
+			into.Blocked = make(map[string]bool)
+		}
+		if into.Muted == nil {
+			into.Muted = make(map[string]bool)
+		}
+		return nil
+	}
+}
+
+// GetAllBlocks returns a function which loads the Blocks of every user
+// who has any into the given map, by user ID.
+func GetAllBlocks(into map[string]*Blocks) func(*bolt.Tx) error {
+	return store.ForEach(BlockBucket, func(k, v []byte) error {
+		b := new(Blocks)
+		if err := json.Unmarshal(v, b); err != nil {
+			return errors.Wrapf(err,
+				"failed to unmarshal blocks of user %#q",
+				string(k),
+			)
+		}
+		into[string(k)] = b
+		return nil
+	})
+}
+
+// setBlock returns a function which sets whether the given user is in
+// the named list of the user's Blocks, storing the result in into.
+func setBlock(
+	user, other string,
+	list func(*Blocks) map[string]bool,
+	set bool,
+	into *Blocks,
+) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if user == other {
+			return errors.New("users cannot block or mute themselves")
+		}
+		if set {
+			if err := CheckUsersExist(other)(tx); err != nil {
+				return err
+			}
+		}
+		if err := GetBlocks(user, into)(tx); err != nil {
+			return err
+		}
+
+		if set {
+			list(into)[other] = true
+		} else {
+			delete(list(into), other)
+		}
+		if len(into.Blocked) == 0 && len(into.Muted) == 0 {
+			return store.Delete(BlockBucket, []byte(user))(tx)
+		}
+		return store.Marshal(BlockBucket, into, []byte(user))(tx)
+	}
+}
+
+func blocked(b *Blocks) map[string]bool { return b.Blocked }
+func muted(b *Blocks) map[string]bool   { return b.Muted }
+
+// SetBlocked returns a function which blocks or unblocks other for the
+// given user, loading the new Blocks into into.
+func SetBlocked(user, other string, block bool, into *Blocks) func(*bolt.Tx) error {
+	return setBlock(user, other, blocked, block, into)
+}
+
+// SetMuted returns a function which mutes or unmutes other for the
+// given user, loading the new Blocks into into.
+func SetMuted(user, other string, mute bool, into *Blocks) func(*bolt.Tx) error {
+	return setBlock(user, other, muted, mute, into)
+}
+
+// CheckNotBlocked returns a function which returns ErrBlocked if any of
+// the given users has blocked user.
+func CheckNotBlocked(user string, added ...string) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		b := new(Blocks)
+		for _, a := range added {
+			if a == user {
+				continue
+			}
+			if err := GetBlocks(a, b)(tx); err != nil {
+				return err
+			}
+			if b.Blocked[user] {
+				return ErrBlocked{By: a, User: user}
+			}
+		}
+		return nil
+	}
+}
+
+// Added returns the users of the new Group who were not in the old one,
+// for passing to CheckNotBlocked.
+func Added(old, new Group) []string {
+	was := AllUsers(old)
+	var added []string
+	for u := range AllUsers(new) {
+		if !was[u] {
+			added = append(added, u)
+		}
+	}
+	return added
+}
@@ -0,0 +1,83 @@
+package users_test
+
+import (
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *UsersSuite) TestBlocks(c *C) {
+	c.Assert(s.Update(store.Wrap(
+		users.Create(&users.User{Name: "bob"}),
+		users.Create(&users.User{Name: "alice"}),
+		users.Create(&users.User{Name: "carol"}),
+	)), IsNil)
+
+	c.Log("users start with no blocks")
+	bs := new(users.Blocks)
+	c.Assert(s.View(users.GetBlocks("bob", bs)), IsNil)
+	c.Check(bs, DeepEquals, &users.Blocks{
+		Blocked: map[string]bool{},
+		Muted:   map[string]bool{},
+	})
+
+	c.Log("users can't block themselves or unknown users")
+	c.Check(s.Update(users.SetBlocked("bob", "bob", true, bs)),
+		ErrorMatches, "users cannot block or mute themselves")
+	err := s.Update(users.SetMuted("bob", "dave", true, bs))
+	c.Check(users.IsMissing(err), Equals, true)
+
+	c.Log("bob blocks alice and mutes carol")
+	c.Assert(s.Update(users.SetBlocked("bob", "alice", true, bs)), IsNil)
+	c.Assert(s.Update(users.SetMuted("bob", "carol", true, bs)), IsNil)
+	c.Check(bs, DeepEquals, &users.Blocks{
+		Blocked: map[string]bool{"alice": true},
+		Muted:   map[string]bool{"carol": true},
+	})
+	c.Check(bs.Silences("alice"), Equals, true)
+	c.Check(bs.Silences("carol"), Equals, true)
+	c.Check(bs.Silences("dave"), Equals, false)
+
+	all := make(map[string]*users.Blocks)
+	c.Assert(s.View(users.GetAllBlocks(all)), IsNil)
+	c.Check(all, DeepEquals, map[string]*users.Blocks{"bob": bs})
+
+	c.Log("alice can't add bob, but carol can")
+	err = s.View(users.CheckNotBlocked("alice", "carol", "bob"))
+	c.Check(err, Equals, users.ErrBlocked{By: "bob", User: "alice"})
+	c.Check(err, ErrorMatches, "user `alice` cannot add user `bob`")
+	c.Check(s.View(users.CheckNotBlocked("carol", "bob")), IsNil)
+
+	c.Log("alice's circle doesn't add bob to her groups")
+	g := users.Group{
+		Owner:       "alice",
+		ReadCircles: map[string]bool{"friends": true},
+	}
+	c.Assert(s.Update(store.Wrap(
+		users.PutCircle(&users.Circle{
+			ID: "friends", Owner: "alice", Name: "friends",
+			Members: map[string]bool{"bob": true, "carol": true},
+		}),
+		users.ResolveCircles(&g, nil),
+	)), IsNil)
+	c.Check(g.Readers, DeepEquals, map[string]bool{"carol": true})
+
+	c.Log("once everything is removed, the blocks are deleted")
+	c.Assert(s.Update(users.SetBlocked("bob", "alice", false, bs)), IsNil)
+	c.Assert(s.Update(users.SetMuted("bob", "carol", false, bs)), IsNil)
+	all = make(map[string]*users.Blocks)
+	c.Assert(s.View(users.GetAllBlocks(all)), IsNil)
+	c.Check(all, HasLen, 0)
+	c.Check(s.View(users.CheckNotBlocked("alice", "bob")), IsNil)
+}
+
+func (s *UsersSuite) TestAdded(c *C) {
+	c.Check(users.Added(users.Group{
+		Owner:   "bob",
+		Readers: map[string]bool{"alice": true},
+	}, users.Group{
+		Owner:   "bob",
+		Readers: map[string]bool{"carol": true},
+	}), DeepEquals, []string{"carol"})
+}
@@ -0,0 +1,249 @@
+package users
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// CircleBucket holds Circles by ID.
+var CircleBucket = store.Bucket("circles")
+
+// MaxCircleName is the longest Circle name allowed, in characters.
+const MaxCircleName = 64
+
+// Circle is a named set of users, such as "backend team", owned by a
+// user.  A Group of the same owner can grant read or write access to
+// its Members by referring to it.
+type Circle struct {
+	ID    string `json:"id"`
+	Owner string `json:"owner"`
+	Name  string `json:"name"`
+
+	Members map[string]bool `json:"members"`
+}
+
+// Resource implements store.Resourcer on *Circle.
+func (*Circle) Resource() store.Resource { return "circles" }
+
+// ErrCircleMissing is returned when a Circle does not exist, or is not
+// owned by the user who asked for it.
+type ErrCircleMissing string
+
+func (e ErrCircleMissing) Error() string {
+	return fmt.Sprintf("circle %#q not found", string(e))
+}
+
+// IsCircleMissing returns true if the error is an ErrCircleMissing.
+func IsCircleMissing(err error) bool {
+	_, ok := err.(ErrCircleMissing)
+	return ok
+}
+
+// Validate returns an error if the Circle has no owner or an invalid
+// name.
+func (c *Circle) Validate() error {
+	switch {
+	case c.Owner == "":
+		return errors.New("circle must have an owner")
+	case c.Name == "":
+		return errors.New("circle name must not be blank")
+	}
+	return checkText("circle name", c.Name, MaxCircleName, false)
+}
+
+// PutCircle returns a function which validates and stores the given
+// Circle.  Its Members must exist.
+func PutCircle(c *Circle) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		members := make([]string, 0, len(c.Members))
+		for m, ok := range c.Members {
+			if !ok {
+				delete(c.Members, m)
+				continue
+			}
+			members = append(members, m)
+		}
+		if err := CheckUsersExist(members...)(tx); err != nil {
+			return err
+		}
+		return store.Marshal(CircleBucket, c, []byte(c.ID))(tx)
+	}
+}
+
+// GetCircle returns a function which loads the given Circle owned by
+// the given user into the given value.  If it does not exist, or the
+// user does not own it, it returns ErrCircleMissing.
+func GetCircle(owner, id string, into *Circle) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		err := store.Unmarshal(CircleBucket, into, []byte(id))(tx)
+		switch {
+		case store.IsMissing(err):
+			return ErrCircleMissing(id)
+		case err != nil:
+			return err
+		case into.Owner != owner:
+			*into = Circle{}
+			return ErrCircleMissing(id)
+		}
+		return nil
+	}
+}
+
+// GetCircles returns a function which loads all Circles owned by the
+// given user, by name.
+func GetCircles(owner string, into *[]*Circle) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		result := []*Circle{}
+		err := store.ForEach(CircleBucket, func(k, v []byte) error {
+			c := new(Circle)
+			if err := json.Unmarshal(v, c); err != nil {
+				return errors.Wrapf(err,
+					"failed to unmarshal circle %#q",
+					string(k),
+				)
+			}
+			if c.Owner == owner {
+				result = append(result, c)
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(result, func(i, j int) bool {
+			if result[i].Name != result[j].Name {
+				return result[i].Name < result[j].Name
+			}
+			return result[i].ID < result[j].ID
+		})
+		*into = result
+		return nil
+	}
+}
+
+// DeleteCircle returns a function which deletes the given Circle.
+func DeleteCircle(id string) func(*bolt.Tx) error {
+	return store.Delete(CircleBucket, []byte(id))
+}
+
+// ByCircle is a Filter for Groups that refer to the given Circle.
+type ByCircle string
+
+// Member implements Filter on ByCircle.
+func (b ByCircle) Member(g Group) bool {
+	return g.ReadCircles[string(b)] || g.WriteCircles[string(b)]
+}
+
+// SyncCircles makes the Group's Readers and Writers include the Members
+// of the given Circles it refers to, and no longer include users it
+// only had because of a Circle.  Circles the Group refers to which are
+// not given are dropped.
+func (g *Group) SyncCircles(circles map[string]*Circle) {
+	for u := range g.CircleReaders {
+		delete(g.Readers, u)
+	}
+	for u := range g.CircleWriters {
+		delete(g.Writers, u)
+	}
+	g.CircleReaders, g.CircleWriters = nil, nil
+
+	grant := func(ids map[string]bool, to, circled *map[string]bool) {
+		for id := range ids {
+			c, ok := circles[id]
+			if !ok {
+				delete(ids, id)
+				continue
+			}
+			for m := range c.Members {
+				if m == g.Owner || (*to)[m] {
+					continue
+				}
+				if *to == nil {
+					*to = make(map[string]bool)
+				}
+				if *circled == nil {
+					*circled = make(map[string]bool)
+				}
+				(*to)[m], (*circled)[m] = true, true
+			}
+		}
+	}
+
+	// Writers are also Readers.
+	grant(g.WriteCircles, &g.Writers, &g.CircleWriters)
+	grant(g.WriteCircles, &g.Readers, &g.CircleReaders)
+	grant(g.ReadCircles, &g.Readers, &g.CircleReaders)
+
+	if len(g.ReadCircles) == 0 {
+		g.ReadCircles = nil
+	}
+	if len(g.WriteCircles) == 0 {
+		g.WriteCircles = nil
+	}
+}
+
+// ResolveCircles returns a function which loads the Circles the Group
+// refers to and applies SyncCircles.  The users the Group has because
+// of Circles are taken from old, if given, rather than trusted from g.
+// Members who have blocked the Owner are left out.  If a Circle does
+// not exist or is not owned by the Group's Owner, it returns
+// ErrCircleMissing.
+func ResolveCircles(g, old *Group) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if old != nil {
+			g.CircleReaders = copyMap(old.CircleReaders)
+			g.CircleWriters = copyMap(old.CircleWriters)
+		}
+
+		circles := make(map[string]*Circle)
+		for _, ids := range []map[string]bool{g.ReadCircles, g.WriteCircles} {
+			for id, ok := range ids {
+				if !ok {
+					delete(ids, id)
+					continue
+				}
+				if circles[id] != nil {
+					continue
+				}
+				c := new(Circle)
+				if err := GetCircle(g.Owner, id, c)(tx); err != nil {
+					return err
+				}
+				for m := range c.Members {
+					b := new(Blocks)
+					if err := GetBlocks(m, b)(tx); err != nil {
+						return err
+					}
+					if b.Blocked[g.Owner] {
+						delete(c.Members, m)
+					}
+				}
+				circles[id] = c
+			}
+		}
+
+		g.SyncCircles(circles)
+		return nil
+	}
+}
+
+func copyMap(m map[string]bool) map[string]bool {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]bool, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,169 @@
+package users_test
+
+import (
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *UsersSuite) TestPutGetCircle(c *C) {
+	c.Assert(s.Update(store.Wrap(
+		users.Create(&users.User{Name: "bob"}),
+		users.Create(&users.User{Name: "alice"}),
+	)), IsNil)
+
+	c.Log("a Circle must have a name and existing members")
+	c.Check(s.Update(users.PutCircle(&users.Circle{
+		ID: "1", Owner: "bob",
+	})), ErrorMatches, "circle name must not be blank")
+	err := s.Update(users.PutCircle(&users.Circle{
+		ID: "1", Owner: "bob", Name: "team",
+		Members: map[string]bool{"carol": true},
+	}))
+	c.Check(users.IsMissing(err), Equals, true)
+
+	c.Log("false members are dropped")
+	team := &users.Circle{
+		ID: "1", Owner: "bob", Name: "team",
+		Members: map[string]bool{"alice": true, "carol": false},
+	}
+	c.Assert(s.Update(users.PutCircle(team)), IsNil)
+	c.Assert(s.Update(users.PutCircle(&users.Circle{
+		ID: "2", Owner: "bob", Name: "friends",
+	})), IsNil)
+	c.Assert(s.Update(users.PutCircle(&users.Circle{
+		ID: "3", Owner: "alice", Name: "alice's",
+	})), IsNil)
+
+	got := new(users.Circle)
+	c.Assert(s.View(users.GetCircle("bob", "1", got)), IsNil)
+	c.Check(got, DeepEquals, &users.Circle{
+		ID: "1", Owner: "bob", Name: "team",
+		Members: map[string]bool{"alice": true},
+	})
+
+	c.Log("only the owner can get a Circle")
+	err = s.View(users.GetCircle("alice", "1", got))
+	c.Check(err, Equals, users.ErrCircleMissing("1"))
+	c.Check(got, DeepEquals, &users.Circle{})
+	err = s.View(users.GetCircle("bob", "4", got))
+	c.Check(users.IsCircleMissing(err), Equals, true)
+
+	var all []*users.Circle
+	c.Assert(s.View(users.GetCircles("bob", &all)), IsNil)
+	c.Assert(all, HasLen, 2)
+	c.Check(all[0].Name, Equals, "friends")
+	c.Check(all[1].Name, Equals, "team")
+
+	c.Assert(s.Update(users.DeleteCircle("2")), IsNil)
+	c.Assert(s.View(users.GetCircles("carol", &all)), IsNil)
+	c.Check(all, DeepEquals, []*users.Circle{})
+}
+
+func (s *UsersSuite) TestResolveCircles(c *C) {
+	c.Assert(s.Update(store.Wrap(
+		users.Create(&users.User{Name: "bob"}),
+		users.Create(&users.User{Name: "alice"}),
+		users.Create(&users.User{Name: "carol"}),
+		users.PutCircle(&users.Circle{
+			ID: "team", Owner: "bob", Name: "team",
+			Members: map[string]bool{
+				"bob": true, "alice": true, "carol": true,
+			},
+		}),
+		users.PutCircle(&users.Circle{
+			ID: "theirs", Owner: "alice", Name: "theirs",
+		}),
+	)), IsNil)
+
+	for i, test := range []struct {
+		should    string
+		given     users.Group
+		old       *users.Group
+		expect    users.Group
+		expectErr string
+	}{{
+		should: "leave a Group without Circles alone",
+		given: users.Group{
+			Owner:   "bob",
+			Readers: map[string]bool{"alice": true},
+		},
+		expect: users.Group{
+			Owner:   "bob",
+			Readers: map[string]bool{"alice": true},
+		},
+	}, {
+		should: "grant read to Circle members besides the owner",
+		given: users.Group{
+			Owner:       "bob",
+			ReadCircles: map[string]bool{"team": true},
+		},
+		expect: users.Group{
+			Owner:         "bob",
+			Readers:       map[string]bool{"alice": true, "carol": true},
+			ReadCircles:   map[string]bool{"team": true},
+			CircleReaders: map[string]bool{"alice": true, "carol": true},
+		},
+	}, {
+		should: "grant write and read, not counting direct members",
+		given: users.Group{
+			Owner:        "bob",
+			Readers:      map[string]bool{"alice": true},
+			Writers:      map[string]bool{"alice": true},
+			WriteCircles: map[string]bool{"team": true},
+		},
+		expect: users.Group{
+			Owner:         "bob",
+			Readers:       map[string]bool{"alice": true, "carol": true},
+			Writers:       map[string]bool{"alice": true, "carol": true},
+			WriteCircles:  map[string]bool{"team": true},
+			CircleReaders: map[string]bool{"carol": true},
+			CircleWriters: map[string]bool{"carol": true},
+		},
+	}, {
+		should: "remove users only granted by a dropped Circle",
+		given: users.Group{
+			Owner:   "bob",
+			Readers: map[string]bool{"alice": true, "carol": true},
+		},
+		old: &users.Group{
+			Owner:         "bob",
+			Readers:       map[string]bool{"alice": true, "carol": true},
+			ReadCircles:   map[string]bool{"team": true},
+			CircleReaders: map[string]bool{"carol": true},
+		},
+		expect: users.Group{
+			Owner:   "bob",
+			Readers: map[string]bool{"alice": true},
+		},
+	}, {
+		should: "not trust the sent Group's circle users",
+		given: users.Group{
+			Owner:         "bob",
+			Readers:       map[string]bool{"alice": true},
+			CircleReaders: map[string]bool{"alice": true},
+		},
+		old: &users.Group{Owner: "bob"},
+		expect: users.Group{
+			Owner:   "bob",
+			Readers: map[string]bool{"alice": true},
+		},
+	}, {
+		should: "refuse another user's Circle",
+		given: users.Group{
+			Owner:       "bob",
+			ReadCircles: map[string]bool{"theirs": true},
+		},
+		expectErr: "circle `theirs` not found",
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		err := s.View(users.ResolveCircles(&test.given, test.old))
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+			continue
+		}
+		c.Assert(err, IsNil)
+		c.Check(test.given, DeepEquals, test.expect)
+	}
+}
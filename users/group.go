@@ -6,6 +6,16 @@ type Group struct {
 
 	Readers map[string]bool `json:"readers"`
 	Writers map[string]bool `json:"writers"`
+
+	// ReadCircles and WriteCircles grant read or write to the Members
+	// of the Owner's Circles, by ID.  See SyncCircles.
+	ReadCircles  map[string]bool `json:"readCircles,omitempty"`
+	WriteCircles map[string]bool `json:"writeCircles,omitempty"`
+
+	// CircleReaders and CircleWriters are the Readers and Writers the
+	// Group only has because of its Circles.
+	CircleReaders map[string]bool `json:"circleReaders,omitempty"`
+	CircleWriters map[string]bool `json:"circleWriters,omitempty"`
 }
 
 // DiffGroups returns a bool map where removed users' names are keys to
@@ -0,0 +1,291 @@
+package users
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// LedgerBucket holds every Transaction by its sequence number.
+// Transactions are never changed or deleted once stored.
+var LedgerBucket = store.Bucket("ledger")
+
+// Mint is the account coin comes from when it is granted, and goes to
+// when it is spent.  Escrow holds Task bounties until they are paid out
+// or refunded.  Neither is a user, and they have no Coin of their own.
+const (
+	Mint   = ""
+	Escrow = "@escrow"
+)
+
+// isSystem returns true if the account is the Mint or Escrow.
+func isSystem(account string) bool {
+	return account == Mint || account == Escrow
+}
+
+// Reason is why a Transaction was made.
+type Reason string
+
+// Reasons for Transactions.
+const (
+	// Opening records a balance a user had before the ledger.
+	Opening Reason = "opening"
+
+	// Grant is coin given or taken by an admin.
+	Grant Reason = "grant"
+
+	// Ticket is coin given to a new user by their incept Ticket.
+	Ticket Reason = "ticket"
+
+	// Invite is coin spent on an invite.
+	Invite Reason = "invite"
+
+	// Bounty is a Task's bounty paid from Escrow to the user who
+	// completed it, and BountyReturn is a paid bounty given back to
+	// Escrow when the Task is un-completed.
+	Bounty       Reason = "bounty"
+	BountyReturn Reason = "bounty-return"
+
+	// Deposit is a Task's bounty moved from its owner into Escrow, and
+	// Refund is escrowed bounty given back to the owner.
+	Deposit Reason = "deposit"
+	Refund  Reason = "refund"
+
+	// Transfer is coin sent from one user to another.
+	Transfer Reason = "transfer"
+)
+
+// MaxMemo is the most characters a Transaction's Memo may have.
+const MaxMemo = 140
+
+// Transaction is a movement of coin from one account to another.  Every
+// Transaction debits From and credits To by the same Amount, so the
+// balances of all accounts, including the Mint's, always sum to zero.
+type Transaction struct {
+	ID     uint64    `json:"id"`
+	From   string    `json:"from,omitempty"`
+	To     string    `json:"to,omitempty"`
+	Amount int64     `json:"amount"`
+	Reason Reason    `json:"reason"`
+	Memo   string    `json:"memo,omitempty"`
+	Task   string    `json:"task,omitempty"`
+	Time   time.Time `json:"time"`
+}
+
+// Resource implements store.Resourcer on *Transaction.
+func (*Transaction) Resource() store.Resource { return "transactions" }
+
+// Actor implements notif.Actor on *Transaction.
+func (t *Transaction) Actor() string { return t.From }
+
+// Validate returns an error if the Transaction moves no coin, or moves
+// it from an account to itself.
+func (t *Transaction) Validate() error {
+	switch {
+	case t.Amount <= 0:
+		return errors.New("amount must be positive")
+	case t.From == t.To:
+		return errors.New("cannot transfer coin to the same account")
+	}
+	return checkText("memo", t.Memo, MaxMemo, false)
+}
+
+// Ledger is a user's history of Transactions, oldest first, and the
+// balance they sum to.
+type Ledger struct {
+	Balance      int64          `json:"balance"`
+	Transactions []*Transaction `json:"transactions"`
+}
+
+// ErrUnbalanced is returned when a user's Coin does not match the sum
+// of their Transactions.
+type ErrUnbalanced struct {
+	Name         string
+	Coin, Ledger int64
+}
+
+func (e ErrUnbalanced) Error() string {
+	return fmt.Sprintf("user %#q has %d coin, but ledger sums to %d",
+		e.Name, e.Coin, e.Ledger)
+}
+
+// IsUnbalanced returns true if the error is an ErrUnbalanced.
+func IsUnbalanced(err error) bool {
+	_, ok := err.(ErrUnbalanced)
+	return ok
+}
+
+// Post returns a store.Mutation which validates and stores the given
+// Transaction, and moves its Amount between the Coin of its users.  t's
+// ID is set to its sequence number.  It does not check that From can
+// afford it; use Spend for that.
+func Post(t *Transaction) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		if err := t.Validate(); err != nil {
+			return err
+		}
+		if !isSystem(t.From) {
+			if err := AddCoin(&User{Name: t.From}, -t.Amount)(tx); err != nil {
+				return missing(t.From, err)
+			}
+		}
+		if !isSystem(t.To) {
+			if err := AddCoin(&User{Name: t.To}, t.Amount)(tx); err != nil {
+				return missing(t.To, err)
+			}
+		}
+
+		b := tx.Bucket(LedgerBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		t.ID = seq
+		return store.Marshal(LedgerBucket, t, ledgerKey(seq))(tx)
+	}
+}
+
+// Spend is like Post, but returns ErrInsufficientCoin if From has less
+// Coin than the Amount.
+func Spend(t *Transaction) store.Mutation {
+	return func(tx *bolt.Tx) error {
+		if !isSystem(t.From) {
+			u := new(User)
+			err := store.Unmarshal(UserBucket, u, []byte(t.From))(tx)
+			switch {
+			case store.IsMissing(err):
+				return ErrMissing(t.From)
+			case err != nil:
+				return err
+			case u.Coin < t.Amount:
+				return ErrInsufficientCoin{
+					Name: t.From,
+					Have: u.Coin,
+					Need: t.Amount,
+				}
+			}
+		}
+		return Post(t)(tx)
+	}
+}
+
+// GetLedger returns a function which loads the given user's
+// Transactions into the given Ledger, with the Balance they sum to.
+func GetLedger(user string, into *Ledger) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		*into = Ledger{Transactions: []*Transaction{}}
+		return store.ForEach(LedgerBucket, func(k, v []byte) error {
+			t := new(Transaction)
+			if err := json.Unmarshal(v, t); err != nil {
+				return errors.Wrapf(err,
+					"failed to unmarshal transaction %d",
+					binary.BigEndian.Uint64(k),
+				)
+			}
+			switch user {
+			case t.From:
+				into.Balance -= t.Amount
+			case t.To:
+				into.Balance += t.Amount
+			default:
+				return nil
+			}
+			into.Transactions = append(into.Transactions, t)
+			return nil
+		})(tx)
+	}
+}
+
+// CheckBalance returns a function which returns ErrUnbalanced if the
+// given user's Coin is not the sum of their Transactions.
+func CheckBalance(user string) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		u, l := new(User), new(Ledger)
+		err := store.Unmarshal(UserBucket, u, []byte(user))(tx)
+		switch {
+		case store.IsMissing(err):
+			return ErrMissing(user)
+		case err != nil:
+			return err
+		}
+		if err := GetLedger(user, l)(tx); err != nil {
+			return err
+		}
+		if u.Coin != l.Balance {
+			return ErrUnbalanced{Name: user, Coin: u.Coin, Ledger: l.Balance}
+		}
+		return nil
+	}
+}
+
+// OpenBalances returns a function which posts an Opening Transaction
+// for any user whose Coin is not the sum of their Transactions, so
+// balances from before the ledger are accounted for.
+func OpenBalances(now time.Time) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		var all Users
+		if err := all.GetAll(tx); err != nil {
+			return err
+		}
+
+		sums := make(map[string]int64)
+		if err := store.ForEach(LedgerBucket, func(k, v []byte) error {
+			t := new(Transaction)
+			if err := json.Unmarshal(v, t); err != nil {
+				return errors.Wrapf(err,
+					"failed to unmarshal transaction %d",
+					binary.BigEndian.Uint64(k),
+				)
+			}
+			sums[t.From] -= t.Amount
+			sums[t.To] += t.Amount
+			return nil
+		})(tx); err != nil {
+			return err
+		}
+
+		for _, u := range all {
+			diff := u.Coin - sums[u.Name]
+			if diff == 0 {
+				continue
+			}
+
+			t := &Transaction{To: u.Name, Amount: diff}
+			if diff < 0 {
+				t = &Transaction{From: u.Name, Amount: -diff}
+			}
+			t.Reason, t.Time = Opening, now
+			// The balance is already right, so only record it.
+			seq, err := tx.Bucket(LedgerBucket).NextSequence()
+			if err != nil {
+				return err
+			}
+			t.ID = seq
+			if err := store.Marshal(LedgerBucket, t, ledgerKey(seq))(tx); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func ledgerKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// missing converts a store.MissingError for the given user into an
+// ErrMissing.
+func missing(user string, err error) error {
+	if store.IsMissing(err) {
+		return ErrMissing(user)
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+package users_test
+
+import (
+	"time"
+
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *UsersSuite) TestLedger(c *C) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.Assert(s.Update(store.Wrap(
+		users.Create(&users.User{Name: "bob"}),
+		users.Create(&users.User{Name: "alice"}),
+	)), IsNil)
+
+	for i, test := range []struct {
+		should      string
+		given       *users.Transaction
+		spend       bool
+		expectErr   string
+		expectBob   int64
+		expectAlice int64
+	}{{
+		should:    "reject non-positive amounts",
+		given:     &users.Transaction{To: "bob"},
+		expectErr: "amount must be positive",
+	}, {
+		should: "reject transfers to the same account",
+		given: &users.Transaction{
+			From: "bob", To: "bob", Amount: 1,
+		},
+		expectErr: "cannot transfer coin to the same account",
+	}, {
+		should:    "reject missing users",
+		given:     &users.Transaction{To: "carol", Amount: 1},
+		expectErr: "user `carol` not found",
+	}, {
+		should: "grant coin from the Mint",
+		given: &users.Transaction{
+			To: "bob", Amount: 10, Reason: users.Grant,
+		},
+		expectBob: 10,
+	}, {
+		should: "not let bob overdraw with Spend",
+		given: &users.Transaction{
+			From: "bob", To: "alice", Amount: 11,
+			Reason: users.Transfer,
+		},
+		spend:     true,
+		expectErr: "user `bob` has 10 coin, needs 11",
+		expectBob: 10,
+	}, {
+		should: "let bob send alice some coin",
+		given: &users.Transaction{
+			From: "bob", To: "alice", Amount: 4,
+			Reason: users.Transfer, Memo: "thanks",
+		},
+		spend:       true,
+		expectBob:   6,
+		expectAlice: 4,
+	}, {
+		should: "let a bounty overdraw with Post",
+		given: &users.Transaction{
+			From: "alice", To: "bob", Amount: 5,
+			Reason: users.Bounty, Task: "some-task",
+		},
+		expectBob:   11,
+		expectAlice: -1,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		test.given.Time = now
+		post := users.Post(test.given)
+		if test.spend {
+			post = users.Spend(test.given)
+		}
+		err := s.Update(post)
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+		} else {
+			c.Check(err, IsNil)
+		}
+
+		bob, alice := new(users.Ledger), new(users.Ledger)
+		c.Assert(s.View(store.Wrap(
+			users.GetLedger("bob", bob),
+			users.GetLedger("alice", alice),
+			users.CheckBalance("bob"),
+			users.CheckBalance("alice"),
+		)), IsNil)
+		c.Check(bob.Balance, Equals, test.expectBob)
+		c.Check(alice.Balance, Equals, test.expectAlice)
+	}
+
+	c.Log("bob's history is in order")
+	l := new(users.Ledger)
+	c.Assert(s.View(users.GetLedger("bob", l)), IsNil)
+	c.Check(l, DeepEquals, &users.Ledger{
+		Balance: 11,
+		Transactions: []*users.Transaction{{
+			ID: 1, To: "bob", Amount: 10,
+			Reason: users.Grant, Time: now,
+		}, {
+			ID: 2, From: "bob", To: "alice", Amount: 4,
+			Reason: users.Transfer, Memo: "thanks", Time: now,
+		}, {
+			ID: 3, From: "alice", To: "bob", Amount: 5,
+			Reason: users.Bounty, Task: "some-task", Time: now,
+		}},
+	})
+}
+
+func (s *UsersSuite) TestOpenBalances(c *C) {
+	now := time.Date(2017, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.Assert(s.Update(store.Wrap(
+		users.Create(&users.User{Name: "bob"}),
+		users.Create(&users.User{Name: "alice"}),
+		users.Create(&users.User{Name: "carol"}),
+		users.AddCoin(&users.User{Name: "bob"}, 5),
+		users.AddCoin(&users.User{Name: "alice"}, -3),
+	)), IsNil)
+
+	err := s.View(users.CheckBalance("bob"))
+	c.Check(err, DeepEquals, users.ErrUnbalanced{
+		Name: "bob", Coin: 5, Ledger: 0,
+	})
+	c.Check(err, ErrorMatches, "user `bob` has 5 coin, but ledger sums to 0")
+	c.Check(users.IsUnbalanced(err), Equals, true)
+
+	c.Assert(s.Update(users.OpenBalances(now)), IsNil)
+	for _, u := range []string{"bob", "alice", "carol"} {
+		c.Check(s.View(users.CheckBalance(u)), IsNil)
+	}
+
+	l := new(users.Ledger)
+	c.Assert(s.View(users.GetLedger("alice", l)), IsNil)
+	c.Check(l.Transactions, DeepEquals, []*users.Transaction{{
+		ID: 1, From: "alice", Amount: 3,
+		Reason: users.Opening, Time: now,
+	}})
+
+	c.Log("opening balances again records nothing")
+	c.Assert(s.Update(users.OpenBalances(now)), IsNil)
+	c.Assert(s.View(users.GetLedger("carol", l)), IsNil)
+	c.Check(l.Transactions, HasLen, 0)
+	c.Assert(s.View(users.GetLedger("bob", l)), IsNil)
+	c.Check(l.Transactions, HasLen, 1)
+}
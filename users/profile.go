@@ -0,0 +1,227 @@
+package users
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// AvatarBucket holds users' Avatars by user ID.
+var AvatarBucket = store.Bucket("avatars")
+
+// Profile field limits, in characters.  Avatars may be at most
+// MaxAvatarSize bytes.
+const (
+	MaxDisplayName = 64
+	MaxBio         = 1024
+	MaxStatus      = 140
+	MaxAvatarSize  = 256 << 10
+)
+
+// AvatarTypes are the content types an Avatar may have.
+var AvatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// PublicResource is the name of the Resource for Public profiles.
+const PublicResource = store.Resource("profiles")
+
+// Profile is the part of a User which they can change themselves.
+type Profile struct {
+	DisplayName string `json:"displayName,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	Status      string `json:"status,omitempty"`
+
+	// Timezone is an IANA time zone name, such as "Europe/Berlin".
+	Timezone string `json:"timezone,omitempty"`
+
+	// Avatar is the hash of the user's Avatar, if they have one.  It
+	// is only changed by SetAvatar and DeleteAvatar.
+	Avatar string `json:"avatar,omitempty"`
+
+	// Unlisted users are left out of Search results.
+	Unlisted bool `json:"unlisted,omitempty"`
+}
+
+// Public is the part of a User which other users may see.
+type Public struct {
+	Name string `json:"name"`
+	Profile
+}
+
+// Resource implements store.Resourcer on *Public.
+func (*Public) Resource() store.Resource { return PublicResource }
+
+// Actor implements notif.Actor on *Public.
+func (p *Public) Actor() string { return p.Name }
+
+// Public returns the public part of the User.
+func (u *User) Public() *Public {
+	return &Public{Name: u.Name, Profile: u.Profile}
+}
+
+// Avatar is a user's avatar image.
+type Avatar struct {
+	ContentType string `json:"contentType"`
+	Data        []byte `json:"data"`
+}
+
+// Hash returns the hex SHA-256 hash of the Avatar's data.
+func (a *Avatar) Hash() string {
+	sum := sha256.Sum256(a.Data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Validate returns an error if the Avatar is too large or has a content
+// type not in AvatarTypes.
+func (a *Avatar) Validate() error {
+	switch {
+	case len(a.Data) == 0:
+		return errors.New("avatar must not be empty")
+	case len(a.Data) > MaxAvatarSize:
+		return errors.Errorf("avatar must be at most %d bytes", MaxAvatarSize)
+	case !AvatarTypes[a.ContentType]:
+		return errors.Errorf("avatar content type %#q not allowed", a.ContentType)
+	}
+	return nil
+}
+
+// checkText returns an error if the named field is longer than max
+// characters or contains control characters, besides newlines if
+// multiline is true.
+func checkText(field, s string, max int, multiline bool) error {
+	switch {
+	case !utf8.ValidString(s):
+		return errors.Errorf("%s must be valid UTF-8", field)
+	case utf8.RuneCountInString(s) > max:
+		return errors.Errorf("%s must be at most %d characters", field, max)
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) && !(multiline && r == '\n') {
+			return errors.Errorf("%s must not contain %U", field, r)
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if any of the Profile's fields are invalid.
+func (p *Profile) Validate() error {
+	for _, f := range []struct {
+		name, val string
+		max       int
+		multiline bool
+	}{
+		{"display name", p.DisplayName, MaxDisplayName, false},
+		{"bio", p.Bio, MaxBio, true},
+		{"status", p.Status, MaxStatus, false},
+	} {
+		if err := checkText(f.name, f.val, f.max, f.multiline); err != nil {
+			return err
+		}
+	}
+
+	switch tz := p.Timezone; tz {
+	case "":
+	case "Local":
+		return errors.New(`timezone must not be "Local"`)
+	default:
+		if _, err := time.LoadLocation(tz); err != nil {
+			return errors.Errorf("unknown timezone %#q", tz)
+		}
+	}
+
+	return nil
+}
+
+// updateUser returns a function which loads the given User, applies f
+// to it, and stores it.  u is set to the new value.
+func updateUser(u *User, f func(*bolt.Tx, *User) error) store.Mutation {
+	nbs := []byte(u.Name)
+	return func(tx *bolt.Tx) error {
+		into := new(User)
+		err := store.Unmarshal(UserBucket, into, nbs)(tx)
+		switch {
+		case store.IsMissing(err):
+			return ErrMissing(u.Name)
+		case err != nil:
+			return err
+		}
+
+		if err := f(tx, into); err != nil {
+			return err
+		}
+		*u = *into
+		return store.Marshal(UserBucket, into, nbs)(tx)
+	}
+}
+
+// SetProfile returns a store.Mutation which validates and sets the
+// given User's Profile, besides its Avatar.  u is set to the new value.
+func SetProfile(u *User, p Profile) store.Mutation {
+	return updateUser(u, func(_ *bolt.Tx, into *User) error {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		p.Avatar = into.Avatar
+		into.Profile = p
+		return nil
+	})
+}
+
+// SetAvatar returns a store.Mutation which validates and stores the
+// given User's Avatar, and sets their Profile's Avatar to its hash.  u
+// is set to the new value.
+func SetAvatar(u *User, a *Avatar) store.Mutation {
+	return updateUser(u, func(tx *bolt.Tx, into *User) error {
+		if err := a.Validate(); err != nil {
+			return err
+		}
+		into.Avatar = a.Hash()
+		return store.Marshal(AvatarBucket, a, []byte(into.Name))(tx)
+	})
+}
+
+// DeleteAvatar returns a store.Mutation which deletes the given User's
+// Avatar, if any.  u is set to the new value.
+func DeleteAvatar(u *User) store.Mutation {
+	return updateUser(u, func(tx *bolt.Tx, into *User) error {
+		into.Avatar = ""
+		return store.Delete(AvatarBucket, []byte(into.Name))(tx)
+	})
+}
+
+// ErrNoAvatar is returned when a user has no Avatar.
+type ErrNoAvatar string
+
+func (e ErrNoAvatar) Error() string {
+	return fmt.Sprintf("user %#q has no avatar", string(e))
+}
+
+// IsNoAvatar returns true if the error is an ErrNoAvatar.
+func IsNoAvatar(err error) bool {
+	_, ok := err.(ErrNoAvatar)
+	return ok
+}
+
+// GetAvatar returns a function which loads the given user's Avatar
+// into the given value.  If they have none, it returns ErrNoAvatar.
+func GetAvatar(userID string, into *Avatar) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		err := store.Unmarshal(AvatarBucket, into, []byte(userID))(tx)
+		if store.IsMissing(err) {
+			return ErrNoAvatar(userID)
+		}
+		return err
+	}
+}
@@ -0,0 +1,118 @@
+package users_test
+
+import (
+	"strings"
+
+	"github.com/synapse-garden/sg-proto/users"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *UsersSuite) TestProfileValidate(c *C) {
+	for i, test := range []struct {
+		should    string
+		given     users.Profile
+		expectErr string
+	}{{
+		should: "allow an empty profile",
+	}, {
+		should: "allow a full profile",
+		given: users.Profile{
+			DisplayName: "Bob ☃",
+			Bio:         "line one\nline two",
+			Status:      "out to lunch",
+			Timezone:    "Europe/Berlin",
+		},
+	}, {
+		should:    "reject a long display name",
+		given:     users.Profile{DisplayName: strings.Repeat("x", users.MaxDisplayName+1)},
+		expectErr: "display name must be at most 64 characters",
+	}, {
+		should:    "reject a newline in the status",
+		given:     users.Profile{Status: "a\nb"},
+		expectErr: "status must not contain U\\+000A",
+	}, {
+		should:    "reject a long bio",
+		given:     users.Profile{Bio: strings.Repeat("x", users.MaxBio+1)},
+		expectErr: "bio must be at most 1024 characters",
+	}, {
+		should:    "reject invalid UTF-8",
+		given:     users.Profile{DisplayName: "\xff"},
+		expectErr: "display name must be valid UTF-8",
+	}, {
+		should:    "reject an unknown timezone",
+		given:     users.Profile{Timezone: "Mars/Olympus"},
+		expectErr: "unknown timezone `Mars/Olympus`",
+	}, {
+		should:    "reject the Local timezone",
+		given:     users.Profile{Timezone: "Local"},
+		expectErr: `timezone must not be "Local"`,
+	}} {
+		c.Logf("test %d: should %s", i, test.should)
+		err := test.given.Validate()
+		if test.expectErr != "" {
+			c.Check(err, ErrorMatches, test.expectErr)
+		} else {
+			c.Check(err, IsNil)
+		}
+	}
+}
+
+func (s *UsersSuite) TestSetProfile(c *C) {
+	u := &users.User{Name: "bob"}
+	err := s.Update(users.SetProfile(u, users.Profile{}))
+	c.Check(err, FitsTypeOf, users.ErrMissing(""))
+
+	c.Assert(s.Update(users.Create(&users.User{Name: "bob", Coin: 5})), IsNil)
+
+	c.Log("An invalid profile is not stored")
+	err = s.Update(users.SetProfile(u, users.Profile{Timezone: "nowhere"}))
+	c.Check(err, ErrorMatches, "unknown timezone `nowhere`")
+
+	c.Log("SetProfile keeps the user's coin and avatar")
+	png := &users.Avatar{
+		ContentType: "image/png",
+		Data:        []byte("\x89PNG\r\n\x1a\nsome image"),
+	}
+	c.Assert(s.Update(users.SetAvatar(u, png)), IsNil)
+	c.Check(u.Avatar, Equals, png.Hash())
+
+	c.Assert(s.Update(users.SetProfile(u, users.Profile{
+		DisplayName: "Bob",
+		Avatar:      "something else",
+	})), IsNil)
+	c.Check(u, DeepEquals, &users.User{
+		Name: "bob",
+		Coin: 5,
+		Profile: users.Profile{
+			DisplayName: "Bob",
+			Avatar:      png.Hash(),
+		},
+	})
+	c.Check(u.Public(), DeepEquals, &users.Public{
+		Name:    "bob",
+		Profile: u.Profile,
+	})
+
+	got := new(users.Avatar)
+	c.Assert(s.View(users.GetAvatar("bob", got)), IsNil)
+	c.Check(got, DeepEquals, png)
+
+	c.Log("Invalid avatars are rejected")
+	err = s.Update(users.SetAvatar(u, &users.Avatar{
+		ContentType: "text/plain",
+		Data:        []byte("hi"),
+	}))
+	c.Check(err, ErrorMatches, "avatar content type `text/plain` not allowed")
+	err = s.Update(users.SetAvatar(u, &users.Avatar{
+		ContentType: "image/png",
+		Data:        make([]byte, users.MaxAvatarSize+1),
+	}))
+	c.Check(err, ErrorMatches, "avatar must be at most 262144 bytes")
+
+	c.Log("DeleteAvatar removes it")
+	c.Assert(s.Update(users.DeleteAvatar(u)), IsNil)
+	c.Check(u.Avatar, Equals, "")
+	err = s.View(users.GetAvatar("bob", got))
+	c.Check(users.IsNoAvatar(err), Equals, true)
+}
@@ -0,0 +1,168 @@
+package users
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/synapse-garden/sg-proto/store"
+
+	"github.com/boltdb/bolt"
+	"github.com/pkg/errors"
+)
+
+// Match scores, lower being better.  A query which is none of these for
+// a name, but is within a few typos of its prefix or is a subsequence
+// of it, is a fuzzy match.
+const (
+	MatchExact = iota
+	MatchPrefix
+	MatchSubstring
+	MatchFuzzy
+)
+
+// matchScore returns how well q matches s, which must both be lower
+// case, and false if it does not match.
+func matchScore(q, s string) (int, bool) {
+	switch {
+	case s == q:
+		return MatchExact, true
+	case strings.HasPrefix(s, q):
+		return MatchPrefix, true
+	case strings.Contains(s, q):
+		return MatchSubstring, true
+	}
+
+	qr, sr := []rune(q), []rune(s)
+	if typos := len(qr) / 3; typos > 0 {
+		pre := sr
+		if len(pre) > len(qr) {
+			pre = pre[:len(qr)]
+		}
+		if d := distance(qr, pre); d <= typos {
+			return MatchFuzzy + d, true
+		}
+	}
+
+	if subsequence(qr, sr) {
+		return MatchFuzzy + len(qr)/3 + 1, true
+	}
+	return 0, false
+}
+
+// distance returns the Levenshtein distance between a and b.
+func distance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	next := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := range a {
+		next[0] = i + 1
+		for j := range b {
+			cost := 1
+			if a[i] == b[j] {
+				cost = 0
+			}
+			next[j+1] = min(prev[j+1]+1, next[j]+1, prev[j]+cost)
+		}
+		prev, next = next, prev
+	}
+	return prev[len(b)]
+}
+
+// subsequence returns true if all of q appears in s, in order.
+func subsequence(q, s []rune) bool {
+	for _, r := range s {
+		if len(q) > 0 && q[0] == r {
+			q = q[1:]
+		}
+	}
+	return len(q) == 0
+}
+
+// Score returns how well the query matches the Public's name or display
+// name, and false if it does not match.  Matches on the display name
+// or its words score one worse than the same match on the name.
+func (p *Public) Score(q string) (int, bool) {
+	q = strings.ToLower(strings.TrimSpace(q))
+	if q == "" {
+		return MatchExact, true
+	}
+
+	best, ok := matchScore(q, strings.ToLower(p.Name))
+	display := strings.ToLower(p.DisplayName)
+	if display == "" {
+		return best, ok
+	}
+	for _, s := range append([]string{display}, strings.Fields(display)...) {
+		if score, match := matchScore(q, s); match && (!ok || score+1 < best) {
+			best, ok = score+1, true
+		}
+	}
+	return best, ok
+}
+
+// MaxQuery is the longest search query allowed, in characters.
+const MaxQuery = 64
+
+// ValidateQuery returns an error if the search query is too long.
+func ValidateQuery(q string) error {
+	if utf8.RuneCountInString(q) > MaxQuery {
+		return errors.Errorf("query must be at most %d characters", MaxQuery)
+	}
+	return nil
+}
+
+// Search returns a function which loads the Public profiles of listed
+// users matching the given query into the given slice, best matches
+// first, then by name.  An empty query matches every listed user.
+func Search(q string, into *[]*Public) func(*bolt.Tx) error {
+	return func(tx *bolt.Tx) error {
+		if err := ValidateQuery(q); err != nil {
+			return err
+		}
+
+		type scored struct {
+			*Public
+			score int
+		}
+		var result []scored
+		err := store.ForEach(UserBucket, func(k, v []byte) error {
+			u := new(User)
+			if err := json.Unmarshal(v, u); err != nil {
+				return errors.Wrapf(err,
+					"failed to unmarshal user %#q",
+					string(k),
+				)
+			}
+			if u.Unlisted {
+				return nil
+			}
+
+			p := u.Public()
+			if score, ok := p.Score(q); ok {
+				result = append(result, scored{p, score})
+			}
+			return nil
+		})(tx)
+		if err != nil {
+			return err
+		}
+
+		sort.Slice(result, func(i, j int) bool {
+			if result[i].score != result[j].score {
+				return result[i].score < result[j].score
+			}
+			return result[i].Name < result[j].Name
+		})
+
+		found := make([]*Public, len(result))
+		for i, r := range result {
+			found[i] = r.Public
+		}
+		*into = found
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package users_test
+
+import (
+	"github.com/synapse-garden/sg-proto/store"
+	"github.com/synapse-garden/sg-proto/users"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *UsersSuite) TestPublicScore(c *C) {
+	for i, test := range []struct {
+		given       users.Public
+		q           string
+		expectScore int
+		expectOK    bool
+	}{
+		{users.Public{Name: "bob"}, "", users.MatchExact, true},
+		{users.Public{Name: "bob"}, " BOB ", users.MatchExact, true},
+		{users.Public{Name: "bobby"}, "bob", users.MatchPrefix, true},
+		{users.Public{Name: "jimbob"}, "bob", users.MatchSubstring, true},
+		{users.Public{Name: "robert"}, "rbo", 0, false},
+		{users.Public{Name: "robert"}, "ro bert", users.MatchFuzzy + 1, true},
+		{users.Public{Name: "robert"}, "rbt", users.MatchFuzzy + 2, true},
+		{users.Public{Name: "robert"}, "rebert", users.MatchFuzzy + 1, true},
+		{users.Public{Name: "robert"}, "rxbxrt", users.MatchFuzzy + 2, true},
+		{users.Public{Name: "robert"}, "rxbxxt", 0, false},
+		{users.Public{
+			Name:    "rh",
+			Profile: users.Profile{DisplayName: "Robert Hall"},
+		}, "hall", users.MatchExact + 1, true},
+		{users.Public{
+			Name:    "hallie",
+			Profile: users.Profile{DisplayName: "Hall"},
+		}, "hall", users.MatchPrefix, true},
+	} {
+		c.Logf("test %d: %#q matching %+v", i, test.q, test.given)
+		score, ok := test.given.Score(test.q)
+		c.Check(ok, Equals, test.expectOK)
+		if test.expectOK {
+			c.Check(score, Equals, test.expectScore)
+		}
+	}
+}
+
+func (s *UsersSuite) TestSearch(c *C) {
+	for _, u := range []*users.User{
+		{Name: "bob", Coin: 10},
+		{Name: "bobby"},
+		{Name: "jimbob"},
+		{Name: "alice", Profile: users.Profile{DisplayName: "Bob's friend"}},
+		{Name: "bo", Profile: users.Profile{Unlisted: true}},
+		{Name: "carol"},
+	} {
+		c.Assert(s.Update(users.Create(u)), IsNil)
+	}
+
+	var found []*users.Public
+	c.Assert(s.View(users.Search("bob", &found)), IsNil)
+	c.Check(found, DeepEquals, []*users.Public{
+		{Name: "bob"},
+		{Name: "bobby"},
+		{Name: "alice", Profile: users.Profile{DisplayName: "Bob's friend"}},
+		{Name: "jimbob"},
+	})
+
+	c.Log("An empty query lists everyone who is listed")
+	c.Assert(s.View(users.Search("", &found)), IsNil)
+	var names []string
+	for _, p := range found {
+		names = append(names, p.Name)
+	}
+	c.Check(names, DeepEquals, []string{
+		"alice", "bob", "bobby", "carol", "jimbob",
+	})
+
+	c.Log("Long queries are rejected")
+	long := make([]byte, users.MaxQuery+1)
+	for i := range long {
+		long[i] = 'x'
+	}
+	err := s.View(users.Search(string(long), &found))
+	c.Check(err, ErrorMatches, "query must be at most 64 characters")
+	c.Check(store.IsMissing(err), Equals, false)
+}
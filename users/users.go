@@ -15,6 +15,8 @@ var UserBucket = []byte("users")
 type User struct {
 	Name string `json:"name,omitempty"`
 	Coin int64  `json:"coin"`
+
+	Profile
 }
 
 type ErrExists string
@@ -84,16 +86,20 @@ func ValidateNew(u *User) error {
 	switch {
 	case len(u.Name) == 0:
 		return errors.New("name must not be blank")
+	case isSystem(u.Name):
+		return errors.Errorf("name %#q is reserved", u.Name)
 	case u.Coin != 0:
 		return errors.New("user cannot be created with coin")
+	case u.Avatar != "":
+		return errors.New("user cannot be created with an avatar")
 	}
-	return nil
+	return u.Profile.Validate()
 }
 
 // AddCoin returns a store.Mutation which adds the given amount of coin
 // to the given user.  It presumes the user already exists in the DB and
 // has a valid Name set.  It sets the given User's Coin to the new value
-// in the DB.
+// in the DB.  It does not record a Transaction; use Post for that.
 func AddCoin(u *User, coin int64) store.Mutation {
 	nbs := []byte(u.Name)
 	return func(tx *bolt.Tx) error {
@@ -111,11 +117,29 @@ func AddCoin(u *User, coin int64) store.Mutation {
 	}
 }
 
+// ErrInsufficientCoin is returned when a User does not have enough Coin
+// to spend.
+type ErrInsufficientCoin struct {
+	Name       string
+	Have, Need int64
+}
+
+func (e ErrInsufficientCoin) Error() string {
+	return fmt.Sprintf("user %#q has %d coin, needs %d", e.Name, e.Have, e.Need)
+}
+
+// IsInsufficientCoin returns true if the error is an
+// ErrInsufficientCoin.
+func IsInsufficientCoin(err error) bool {
+	_, ok := err.(ErrInsufficientCoin)
+	return ok
+}
+
 type Users []User
 
 func (u *Users) GetAll(tx *bolt.Tx) error {
-	var next User
 	return store.ForEach(UserBucket, func(k, v []byte) error {
+		var next User
 		if err := json.Unmarshal(v, &next); err != nil {
 			return errors.Wrapf(err,
 				"failed to unmarshal user %#q",
@@ -29,6 +29,10 @@ func (s *UsersSuite) SetUpTest(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(s.Update(store.SetupBuckets(
 		users.UserBucket,
+		users.AvatarBucket,
+		users.CircleBucket,
+		users.BlockBucket,
+		users.LedgerBucket,
 	)), IsNil)
 }
 